@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/apitoken"
+)
+
+const cmdGenerateToken = "generate-token"
+
+// generateToken prints a new API token and its hash, which can be used as
+// hash of an API token in the config.
+func generateToken(args []string) error {
+	fs := flag.NewFlagSet(cmdGenerateToken, flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	token, hash, err := apitoken.Generate()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("token: %s\nhash:  %s\n", token, hash)
+	return nil
+}
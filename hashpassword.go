@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/password"
+)
+
+const cmdHashPassword = "hash-password"
+
+// hashPassword reads a password from stdin and prints its hash, which can be
+// used as password of a user in the config.
+func hashPassword(args []string) error {
+	fs := flag.NewFlagSet(cmdHashPassword, flag.ExitOnError)
+	useBcrypt := fs.Bool("bcrypt", false, "Generate a bcrypt instead of an argon2id hash")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pass, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if pass == "" {
+		return errors.New("password cannot be empty")
+	}
+
+	var hash string
+	if *useBcrypt {
+		hash, err = password.HashBcrypt(pass)
+	} else {
+		hash, err = password.Hash(pass)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(hash)
+	return nil
+}
+
+// readPassword prompts for the password twice if stdin is a terminal and
+// reads the first line of stdin otherwise.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd()) //nolint:gosec // file descriptors fit into int
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(pass) != string(confirm) {
+		return "", errors.New("passwords do not match")
+	}
+
+	return string(pass), nil
+}
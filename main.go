@@ -17,7 +17,21 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == cmdHashPassword {
+		if err := hashPassword(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == cmdGenerateToken {
+		if err := generateToken(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	configFile := flag.String("c", "", "Path to config file")
+	watch := flag.Duration("watch", 0, "Interval to check the config file for changes, 0 disables watching")
 	flag.Parse()
 
 	var (
@@ -34,15 +48,46 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("Enabled endpoints: %s", strings.Join(cfg.Endpoints.Enabled(), ", "))
+	log.Printf("Enabled endpoints: %s", enabledEndpoints(cfg))
 	log.Printf("Authorization method set to: %s", cfg.Auth.Method)
+	for _, p := range cfg.Projects {
+		log.Printf("Managing zones %s with the token of project %s", strings.Join(p.Zones, ", "), p.Name)
+	}
 	log.Printf("Starting hetzner-dnsapi-proxy, listening on %s", cfg.ListenAddr)
-	if err := runServer(cfg.ListenAddr, app.New(cfg)); err != nil {
+	a, err := app.NewApp(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case a.ACME != nil:
+		log.Printf("Serving HTTPS with a certificate for %s from %s",
+			strings.Join(cfg.TLS.ACME.Domains, ", "), cfg.TLS.ACME.DirectoryURL)
+	case a.TLSConfig != nil:
+		log.Printf("Serving HTTPS with the certificate from %s", cfg.TLS.CertFile)
+	}
+	if a.RFC2136 != nil {
+		log.Printf("Starting RFC 2136 listener on %s", cfg.RFC2136.ListenAddr)
+	}
+	if a.Metrics != nil {
+		log.Printf("Starting metrics listener on %s", cfg.Metrics.ListenAddr)
+	}
+	if a.Reaper != nil {
+		log.Printf("Removing challenge values after %d seconds, state file: %s",
+			cfg.ChallengeExpiry.LifetimeSeconds, cfg.ChallengeExpiry.StateFile)
+	}
+	if cfg.Ownership.Enabled {
+		log.Printf("Ownership mode enabled, rrsets not managed by the proxy are protected")
+	}
+	if err := runServer(cfg, a, *configFile, *watch); err != nil {
 		log.Fatal("Error running server:", err)
 	}
 }
 
-func runServer(listenAddr string, handler http.Handler) error {
+func enabledEndpoints(cfg *config.Config) string {
+	return strings.Join(cfg.Endpoints.Enabled(), ", ")
+}
+
+func runServer(cfg *config.Config, a *app.App, configFile string, watch time.Duration) error {
 	const (
 		readHeaderTimeout = 10
 		readTimeout       = 30
@@ -51,28 +96,91 @@ func runServer(listenAddr string, handler http.Handler) error {
 		shutdownTimeout   = 5
 	)
 
-	s := &http.Server{
-		Addr:              listenAddr,
-		Handler:           handler,
-		ReadHeaderTimeout: readHeaderTimeout * time.Second,
-		ReadTimeout:       readTimeout * time.Second,
-		WriteTimeout:      writeTimeout * time.Second,
-		IdleTimeout:       idleTimeout * time.Second,
+	newServer := func(addr string, handler http.Handler) *http.Server {
+		return &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: readHeaderTimeout * time.Second,
+			ReadTimeout:       readTimeout * time.Second,
+			WriteTimeout:      writeTimeout * time.Second,
+			IdleTimeout:       idleTimeout * time.Second,
+		}
 	}
-
-	go func() {
-		if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	serve := func(s *http.Server) {
+		var err error
+		if s.TLSConfig != nil {
+			err = s.ListenAndServeTLS("", "")
+		} else {
+			err = s.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal(err)
 		}
-	}()
+	}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	s := newServer(cfg.ListenAddr, a.Handler)
+	s.TLSConfig = a.TLSConfig
+	go serve(s)
+
+	var ms *http.Server
+	if a.Metrics != nil {
+		ms = newServer(cfg.Metrics.ListenAddr, a.Metrics)
+		go serve(ms)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	if a.Reaper != nil {
+		go a.Reaper.Run(ctx)
+	}
+	if a.ACME != nil {
+		go a.ACME.Run(ctx)
+	}
+
+	if a.RFC2136 != nil {
+		go func() {
+			if err := a.RFC2136.ListenAndServe(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	waitForShutdown(ctx, a, configFile, watch)
 	log.Println("Shutting down hetzner-dnsapi-proxy")
 
 	c, cancel := context.WithTimeout(context.Background(), shutdownTimeout*time.Second)
 	defer cancel()
 
-	return s.Shutdown(c)
+	errs := []error{s.Shutdown(c)}
+	if a.RFC2136 != nil {
+		errs = append(errs, a.RFC2136.Shutdown(c))
+	}
+	if ms != nil {
+		errs = append(errs, ms.Shutdown(c))
+	}
+	return errors.Join(errs...)
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM is received. The config file
+// is reloaded on SIGHUP and, if watch is set, when it changed.
+func waitForShutdown(ctx context.Context, a *app.App, configFile string, watch time.Duration) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	changed := make(chan struct{}, 1)
+	if watch > 0 && configFile != "" {
+		go watchConfig(ctx, configFile, watch, changed)
+	}
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-hup:
+			reloadConfig(a, configFile)
+		case <-changed:
+			reloadConfig(a, configFile)
+		}
+	}
 }
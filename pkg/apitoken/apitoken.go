@@ -0,0 +1,53 @@
+// Package apitoken generates and verifies opaque bearer tokens. Tokens are
+// random, so they are stored as SHA-256 hash instead of a slow password hash
+// and can be verified without a hash computation per configured token.
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const (
+	prefixToken  = "hdp_"
+	prefixSHA256 = "sha256:"
+	tokenLen     = 32
+)
+
+// Generate returns a new random token and its hash.
+func Generate() (token, hash string, err error) {
+	b := make([]byte, tokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = prefixToken + base64.RawURLEncoding.EncodeToString(b)
+	return token, Hash(token), nil
+}
+
+// Hash returns the hash of token as stored in the config.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return prefixSHA256 + hex.EncodeToString(sum[:])
+}
+
+// Validate returns an error if hash is not a hash returned by Hash.
+func Validate(hash string) error {
+	if !strings.HasPrefix(hash, prefixSHA256) {
+		return errors.New("hash must start with " + prefixSHA256)
+	}
+	if b, err := hex.DecodeString(hash[len(prefixSHA256):]); err != nil || len(b) != sha256.Size {
+		return errors.New("hash must be a hex encoded SHA-256 sum")
+	}
+	return nil
+}
+
+// Matches returns 1 if the hash hashed of a token as returned by Hash equals
+// stored, in constant time.
+func Matches(stored, hashed string) int {
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(hashed))
+}
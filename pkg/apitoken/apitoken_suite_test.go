@@ -0,0 +1,13 @@
+package apitoken_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAPIToken(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "apitoken test suite")
+}
@@ -0,0 +1,38 @@
+package apitoken_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/apitoken"
+)
+
+var _ = Describe("APIToken", func() {
+	It("should generate random tokens with matching hashes", func() {
+		token, hash, err := apitoken.Generate()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(HavePrefix("hdp_"))
+		Expect(hash).To(Equal(apitoken.Hash(token)))
+		Expect(apitoken.Validate(hash)).To(Succeed())
+		Expect(apitoken.Matches(hash, apitoken.Hash(token))).To(Equal(1))
+
+		other, _, err := apitoken.Generate()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(other).ToNot(Equal(token))
+		Expect(apitoken.Matches(hash, apitoken.Hash(other))).To(Equal(0))
+	})
+
+	It("should hash tokens with SHA-256", func() {
+		Expect(apitoken.Hash("token")).To(Equal("sha256:3c469e9d6c5875d37a43f353d4f88e61fcf812c66eee3457465a40b0da4153e0"))
+	})
+
+	DescribeTable("Validate should fail", func(hash, msg string) {
+		Expect(apitoken.Validate(hash)).To(MatchError(msg))
+	},
+		Entry("without prefix", strings.Repeat("a", 64), "hash must start with sha256:"),
+		Entry("with invalid hex", "sha256:"+strings.Repeat("x", 64), "hash must be a hex encoded SHA-256 sum"),
+		Entry("with wrong length", "sha256:abcd", "hash must be a hex encoded SHA-256 sum"),
+	)
+})
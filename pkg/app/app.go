@@ -1,21 +1,41 @@
 package app
 
 import (
+	"crypto/tls"
+	"fmt"
 	"log"
 	"net/http"
+	"reflect"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/certs"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/challenge"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/cloudflare"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/externaldns"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/lock"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware/clean"
+	cleancloud "github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware/clean/cloud"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware/update"
+	updatecloud "github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware/update/cloud"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/powerdns"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rfc2136"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
 )
 
+// acmeDNSValues is the number of TXT values kept per name on the acmedns
+// endpoint. Like acme-dns, only the latest values are kept, as its clients
+// never clean up their challenges.
+const acmeDNSValues = 2
+
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -26,56 +46,286 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
-func New(cfg *config.Config) http.Handler {
-	lockout := ratelimit.NewLockout(
+// App holds the HTTP handler, the optional RFC 2136 server and the optional
+// metrics handler. The HTTP handler and the RFC 2136 server share the same
+// lockout, rate limiter, update mutex and budgets of API tokens. If TLS is enabled, TLSConfig is the
+// config the HTTP handler is served with and ACME obtains its certificate if
+// ACME is enabled.
+type App struct {
+	Handler   http.Handler
+	TLSConfig *tls.Config
+	ACME      *certs.Manager
+	RFC2136   *rfc2136.Server
+	Metrics   http.Handler
+	Reaper    *challenge.Reaper
+
+	mu      sync.Mutex
+	cfg     *config.Config
+	mux     atomic.Pointer[http.ServeMux]
+	lockout *ratelimit.Lockout
+	limiter *ratelimit.Limiter
+	locks   *lock.Keyed
+	budgets *hetzner.Budgets
+}
+
+func NewApp(cfg *config.Config) (*App, error) {
+	a := &App{
+		cfg: cfg,
+		lockout: ratelimit.NewLockout(
+			cfg.Lockout.MaxAttempts,
+			time.Duration(cfg.Lockout.DurationSeconds)*time.Second,
+			time.Duration(cfg.Lockout.WindowSeconds)*time.Second,
+		),
+		limiter: ratelimit.NewLimiter(cfg.RateLimit.RPS, cfg.RateLimit.Burst, time.Duration(cfg.RateLimit.IdleSeconds)*time.Second),
+		locks:   lock.NewKeyed(),
+		budgets: hetzner.NewBudgets(),
+	}
+
+	projects := hetzner.NewProjects(cfg, a.budgets)
+	reaper, err := newReaper(cfg, projects, a.locks)
+	if err != nil {
+		return nil, err
+	}
+	a.Reaper = reaper
+	a.mux.Store(a.newMux(cfg, projects))
+	a.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mux.Load().ServeHTTP(w, r)
+	})
+
+	if cfg.TLS.Enabled {
+		if err := a.newTLS(cfg, projects); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RFC2136.Enabled {
+		a.RFC2136 = rfc2136.New(
+			cfg, a.limiter, a.lockout, updatecloud.New(cfg, projects, a.locks), cleancloud.New(cfg, projects, a.locks),
+		)
+	}
+	if cfg.Metrics.Enabled {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", metrics.Handler())
+		a.Metrics = metricsMux
+	}
+
+	return a, nil
+}
+
+// Reload applies cfg to the HTTP handler, the RFC 2136 server, the challenge
+// reaper and the ACME manager. Requests in flight finish with the previous
+// config, the state of the lockout, the rate limiter and the update locks is
+// kept. Budgets of API tokens that are not configured anymore are removed.
+// Settings that require a restart keep their previous values.
+func (a *App) Reload(cfg *config.Config) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, setting := range keepRestartSettings(a.cfg, cfg) {
+		log.Printf("Changing %s requires a restart, keeping the previous value", setting)
+	}
+	a.lockout.SetLimits(
 		cfg.Lockout.MaxAttempts,
 		time.Duration(cfg.Lockout.DurationSeconds)*time.Second,
 		time.Duration(cfg.Lockout.WindowSeconds)*time.Second,
 	)
-	authorizer := middleware.NewAuthorizer(cfg, lockout)
+	a.limiter.SetLimits(cfg.RateLimit.RPS, cfg.RateLimit.Burst, time.Duration(cfg.RateLimit.IdleSeconds)*time.Second)
+	a.budgets.Prune(cfg)
+	projects := hetzner.NewProjects(cfg, a.budgets)
+	a.mux.Store(a.newMux(cfg, projects))
+	if a.RFC2136 != nil {
+		a.RFC2136.Reload(cfg, updatecloud.New(cfg, projects, a.locks), cleancloud.New(cfg, projects, a.locks))
+	}
+	if a.Reaper != nil {
+		a.Reaper.Reload(cfg, cleancloud.New(cfg, projects, a.locks))
+	}
+	if a.ACME != nil {
+		a.ACME.Reload(updatecloud.New(cfg, projects, a.locks), cleancloud.New(cfg, projects, a.locks), newZoneCache(cfg, projects))
+	}
+	a.cfg = cfg
+}
+
+// keepRestartSettings copies the settings of listeners and background tasks
+// from prev to cfg, they are only applied on start. It returns the names of
+// the settings that differed.
+func keepRestartSettings(prev, cfg *config.Config) []string {
+	var changed []string
+	if cfg.ListenAddr != prev.ListenAddr {
+		changed = append(changed, "listenAddr")
+		cfg.ListenAddr = prev.ListenAddr
+	}
+	if cfg.Metrics != prev.Metrics {
+		changed = append(changed, "metrics")
+		cfg.Metrics = prev.Metrics
+	}
+	if !reflect.DeepEqual(cfg.RFC2136, prev.RFC2136) {
+		changed = append(changed, "rfc2136")
+		cfg.RFC2136 = prev.RFC2136
+	}
+	if !reflect.DeepEqual(cfg.TLS, prev.TLS) {
+		changed = append(changed, "tls")
+		cfg.TLS = prev.TLS
+		cfg.ClientCAs = prev.ClientCAs
+	}
+	if cfg.ChallengeExpiry != prev.ChallengeExpiry {
+		changed = append(changed, "challengeExpiry")
+		cfg.ChallengeExpiry = prev.ChallengeExpiry
+	}
+	return changed
+}
+
+// newMux returns the handlers of the enabled endpoints configured by cfg.
+// Requests to the API are made with the clients of projects.
+func (a *App) newMux(cfg *config.Config, projects *hetzner.Projects) *http.ServeMux {
+	authorizer := middleware.NewAuthorizer(cfg, a.lockout)
 
-	m := &sync.Mutex{}
-	updater := update.New(cfg, m)
-	cleaner := clean.New(cfg, m)
+	zones := newZoneCache(cfg, projects)
+	resolveZone := middleware.NewResolveZone(zones)
 
-	limiter := ratelimit.NewLimiter(cfg.RateLimit.RPS, cfg.RateLimit.Burst, time.Duration(cfg.RateLimit.IdleSeconds)*time.Second)
-	rl := middleware.NewRateLimit(limiter, middleware.RateLimitExceeded)
+	updater := update.New(cfg, projects, a.locks)
+	appender := update.NewAppend(cfg, projects, a.locks)
+	acmeDNSAppender := update.NewAppendLatest(cfg, projects, a.locks, acmeDNSValues)
+	cleaner := clean.New(cfg, projects, a.locks)
+	deleter := clean.NewDelete(cfg, projects, a.locks)
+
+	trackChallenge := middleware.NewTrackChallenge(a.Reaper)
+	forgetChallenge := middleware.NewForgetChallenge(a.Reaper)
+
+	rl := middleware.NewRateLimit(a.limiter, middleware.RateLimitExceeded)
 
 	mux := http.NewServeMux()
 	if cfg.Endpoints.Plain {
-		mux.Handle("GET /plain/update",
-			handle(cfg, rl, middleware.BindPlain, authorizer, updater, middleware.StatusOk))
+		mux.Handle("GET /plain/update", handle(
+			cfg, config.EndpointPlain, rl, middleware.LowPriority, middleware.BindPlain,
+			authorizer, resolveZone, updater, middleware.StatusOk,
+		))
 	}
 	if cfg.Endpoints.Nic {
 		mux.Handle("GET /nic/update", handle(
-			cfg, middleware.NewRateLimit(limiter, middleware.NicRateLimitExceeded), middleware.BindNicUpdate,
-			middleware.NicAuth(cfg, lockout), middleware.NicUpdate(updater), middleware.StatusOkNicUpdate,
+			cfg, config.EndpointNic, middleware.NewRateLimit(a.limiter, middleware.NicRateLimitExceeded), middleware.LowPriority,
+			middleware.NewNicUpdate(cfg, a.lockout, resolveZone, updater, deleter),
 		))
 	}
 	if cfg.Endpoints.AcmeDNS {
-		mux.Handle("POST /acmedns/update",
-			handle(cfg, rl, middleware.BindAcmeDNS, authorizer, updater, middleware.StatusOkAcmeDNS))
+		mux.Handle("POST /acmedns/update", handle(
+			cfg, config.EndpointAcmeDNS, rl, middleware.BindAcmeDNS,
+			authorizer, resolveZone, acmeDNSAppender, trackChallenge, middleware.StatusOkAcmeDNS,
+		))
 	}
 	if cfg.Endpoints.HTTPReq {
-		mux.Handle("POST /httpreq/present",
-			handle(cfg, rl, middleware.ContentTypeJSON, middleware.BindHTTPReq, authorizer, updater, middleware.StatusOk))
-		mux.Handle("POST /httpreq/cleanup",
-			handle(cfg, rl, middleware.ContentTypeJSON, middleware.BindHTTPReq, authorizer, cleaner, middleware.StatusOk))
+		mux.Handle("POST /httpreq/present", handle(
+			cfg, config.EndpointHTTPReq, rl, middleware.ContentTypeJSON, middleware.BindHTTPReq,
+			authorizer, resolveZone, appender, trackChallenge, middleware.StatusOk,
+		))
+		mux.Handle("POST /httpreq/cleanup", handle(
+			cfg, config.EndpointHTTPReq, rl, middleware.ContentTypeJSON, middleware.BindHTTPReq,
+			authorizer, resolveZone, cleaner, forgetChallenge, middleware.StatusOk,
+		))
 	}
 	if cfg.Endpoints.DirectAdmin {
 		mux.Handle("GET /directadmin/CMD_API_SHOW_DOMAINS",
-			handle(cfg, rl, middleware.NewShowDomainsDirectAdmin(cfg, lockout)))
+			handle(cfg, config.EndpointDirectAdmin, rl, middleware.NewShowDomainsDirectAdmin(cfg, a.lockout)))
 		mux.Handle("GET /directadmin/CMD_API_DOMAIN_POINTER",
-			handle(cfg, rl, middleware.StatusOk))
-		mux.Handle("GET /directadmin/CMD_API_DNS_CONTROL",
-			handle(cfg, rl, middleware.BindDirectAdmin, authorizer, updater, middleware.StatusOkDirectAdmin))
+			handle(cfg, config.EndpointDirectAdmin, rl, middleware.StatusOk))
+		mux.Handle("GET /directadmin/CMD_API_DNS_CONTROL", handle(
+			cfg, config.EndpointDirectAdmin, rl, middleware.BindDirectAdmin, middleware.LowPriorityAddresses,
+			authorizer, resolveZone, updater, middleware.StatusOkDirectAdmin,
+		))
+	}
+
+	recordUpdater, recordCleaner := updatecloud.New(cfg, projects, a.locks), cleancloud.New(cfg, projects, a.locks)
+	if cfg.Endpoints.ExternalDNS {
+		wh := externaldns.New(cfg, projects, a.lockout, recordUpdater, recordCleaner, zones)
+		handleExternalDNS(mux, cfg, rl, wh)
+	}
+	if cfg.Endpoints.Cloudflare {
+		handleCloudflare(mux, cfg, rl, cloudflare.New(cfg, projects, a.lockout, recordUpdater, recordCleaner))
+	}
+	if cfg.Endpoints.PowerDNS {
+		handlePowerDNS(mux, cfg, rl, powerdns.New(cfg, projects, a.lockout, recordUpdater, recordCleaner))
 	}
 
 	return mux
 }
 
-func handle(cfg *config.Config, handlers ...func(http.Handler) http.Handler) http.Handler {
+// newTLS creates the TLS config of the HTTP handler. The certificate is read
+// from the configured files or, if ACME is enabled, obtained by a manager
+// presenting its challenges like the challenges of clients.
+func (a *App) newTLS(cfg *config.Config, projects *hetzner.Projects) error {
+	var getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	if cfg.TLS.ACME.Enabled {
+		a.ACME = certs.NewManager(
+			cfg, updatecloud.New(cfg, projects, a.locks), cleancloud.New(cfg, projects, a.locks), newZoneCache(cfg, projects),
+		)
+		getCertificate = a.ACME.GetCertificate
+	} else {
+		f, err := certs.NewFile(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read certificate: %w", err)
+		}
+		getCertificate = f.GetCertificate
+	}
+
+	tlsConfig, err := certs.NewTLSConfig(&cfg.TLS, getCertificate)
+	if err != nil {
+		return err
+	}
+	a.TLSConfig = tlsConfig
+	return nil
+}
+
+// newZoneCache creates the cache of the zones discovered with projects if
+// zone discovery is enabled.
+func newZoneCache(cfg *config.Config, projects *hetzner.Projects) *hetzner.ZoneCache {
+	if !cfg.ZoneDiscovery.Enabled {
+		return nil
+	}
+	return hetzner.NewZoneCache(projects, time.Duration(cfg.ZoneDiscovery.RefreshSeconds)*time.Second)
+}
+
+// newReaper creates the reaper of expired challenge values if challenge
+// expiry is enabled.
+func newReaper(cfg *config.Config, projects *hetzner.Projects, locks *lock.Keyed) (*challenge.Reaper, error) {
+	if !cfg.ChallengeExpiry.Enabled {
+		return nil, nil
+	}
+	reaper, err := challenge.NewReaper(cfg, cleancloud.New(cfg, projects, locks))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read challenge state file: %w", err)
+	}
+	return reaper, nil
+}
+
+func handleExternalDNS(mux *http.ServeMux, cfg *config.Config, rl func(http.Handler) http.Handler, wh *externaldns.Webhook) {
+	mux.Handle("GET /externaldns", handle(cfg, config.EndpointExternalDNS, rl, wh.Negotiate))
+	mux.Handle("GET /externaldns/records", handle(cfg, config.EndpointExternalDNS, rl, middleware.LowPriority, wh.Records))
+	mux.Handle("POST /externaldns/records", handle(cfg, config.EndpointExternalDNS, rl, wh.ApplyChanges))
+	mux.Handle("POST /externaldns/adjustendpoints", handle(cfg, config.EndpointExternalDNS, rl, wh.AdjustEndpoints))
+}
+
+func handleCloudflare(mux *http.ServeMux, cfg *config.Config, rl func(http.Handler) http.Handler, cf *cloudflare.API) {
+	const records = "/client/v4/zones/{zone_id}/dns_records"
+	mux.Handle("GET /client/v4/zones", handle(cfg, config.EndpointCloudflare, rl, cf.Zones))
+	mux.Handle("GET "+records, handle(cfg, config.EndpointCloudflare, rl, cf.ListRecords))
+	mux.Handle("POST "+records, handle(cfg, config.EndpointCloudflare, rl, cf.CreateRecord))
+	mux.Handle("GET "+records+"/{record_id}", handle(cfg, config.EndpointCloudflare, rl, cf.GetRecord))
+	mux.Handle("PUT "+records+"/{record_id}", handle(cfg, config.EndpointCloudflare, rl, cf.UpdateRecord))
+	mux.Handle("PATCH "+records+"/{record_id}", handle(cfg, config.EndpointCloudflare, rl, cf.UpdateRecord))
+	mux.Handle("DELETE "+records+"/{record_id}", handle(cfg, config.EndpointCloudflare, rl, cf.DeleteRecord))
+}
+
+func handlePowerDNS(mux *http.ServeMux, cfg *config.Config, rl func(http.Handler) http.Handler, pdns *powerdns.API) {
+	const zones = "/api/v1/servers/localhost/zones"
+	mux.Handle("GET /api", handle(cfg, config.EndpointPowerDNS, rl, pdns.Versions))
+	mux.Handle("GET "+zones, handle(cfg, config.EndpointPowerDNS, rl, pdns.Zones))
+	mux.Handle("GET "+zones+"/{zone_id}", handle(cfg, config.EndpointPowerDNS, rl, pdns.Zone))
+	mux.Handle("PATCH "+zones+"/{zone_id}", handle(cfg, config.EndpointPowerDNS, rl, pdns.PatchZone))
+}
+
+// handle chains handlers after the common middlewares. endpoint is the
+// endpoint group of the handlers, requests are counted by it in metrics.
+func handle(cfg *config.Config, endpoint string, handlers ...func(http.Handler) http.Handler) http.Handler {
 	handlers = slices.Insert(handlers, 0, middleware.NewSetClientIP(cfg.TrustedProxyPrefixes))
+	handlers = slices.Insert(handlers, 0, middleware.NewSetClientCert(cfg.TrustedProxyPrefixes, cfg.Auth.ClientCertHeader, cfg.ClientCAs))
 	handlers = slices.Insert(handlers, 0, middleware.SecurityHeaders)
 	if cfg.Debug {
 		handlers = slices.Insert(handlers, 0, middleware.LogDebug)
@@ -84,6 +334,7 @@ func handle(cfg *config.Config, handlers ...func(http.Handler) http.Handler) htt
 		start := time.Now()
 		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 		chain(handlers).ServeHTTP(lrw, r)
+		metrics.ObserveHTTPRequest(endpoint, lrw.statusCode, time.Since(start))
 		logRequest(r, start, lrw.statusCode)
 	})
 }
@@ -0,0 +1,407 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+const (
+	accountKeyFile = "account.key"
+	certFile       = "cert.pem"
+	keyFile        = "key.pem"
+	cacheDirMode   = 0o700
+	cacheFileMode  = 0o600
+
+	challengeType   = "dns-01"
+	challengePrefix = "_acme-challenge."
+	recordTypeTXT   = "TXT"
+
+	// orderTimeout limits the time to obtain a certificate
+	orderTimeout = 10 * time.Minute
+	// checkInterval is the time between checks whether the certificate
+	// has to be renewed
+	checkInterval = 12 * time.Hour
+	// retryInterval is the time after which obtaining a certificate is
+	// retried if it failed, it keeps failed validations below the limits
+	// of Let's Encrypt
+	retryInterval = 15 * time.Minute
+)
+
+var errNoCertificate = errors.New("no certificate obtained yet")
+
+type Updater interface {
+	Append(ctx context.Context, reqData *data.ReqData) error
+}
+
+type Cleaner interface {
+	Clean(ctx context.Context, reqData *data.ReqData) error
+}
+
+// Manager obtains the certificate of the proxy from an ACME CA and renews it
+// before it expires. The DNS-01 challenges are presented and cleaned up with
+// the same updater and cleaner as challenges of clients.
+type Manager struct {
+	domains      []string
+	email        string
+	directoryURL string
+	cacheDir     string
+	renewBefore  time.Duration
+	propagation  time.Duration
+	now          func() time.Time
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	updater Updater
+	cleaner Cleaner
+	zones   *hetzner.ZoneCache
+}
+
+// NewManager creates a Manager for the ACME settings of cfg. zones is used to
+// determine the zones of the challenge records, it may be nil.
+func NewManager(cfg *config.Config, updater Updater, cleaner Cleaner, zones *hetzner.ZoneCache) *Manager {
+	const day = 24 * time.Hour
+	a := &cfg.TLS.ACME
+	return &Manager{
+		domains:      a.Domains,
+		email:        a.Email,
+		directoryURL: a.DirectoryURL,
+		cacheDir:     a.CacheDir,
+		renewBefore:  time.Duration(a.RenewBeforeDays) * day,
+		propagation:  time.Duration(a.PropagationSeconds) * time.Second,
+		updater:      updater,
+		cleaner:      cleaner,
+		zones:        zones,
+		now:          time.Now,
+	}
+}
+
+// Reload replaces the updater, cleaner and zone cache the challenges are
+// presented with. The ACME settings are only applied on start.
+func (m *Manager) Reload(updater Updater, cleaner Cleaner, zones *hetzner.ZoneCache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updater = updater
+	m.cleaner = cleaner
+	m.zones = zones
+}
+
+// clients returns the updater, cleaner and zone cache the challenges are
+// presented with.
+func (m *Manager) clients() (Updater, Cleaner, *hetzner.ZoneCache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.updater, m.cleaner, m.zones
+}
+
+// GetCertificate returns the current certificate. Handshakes fail until the
+// first certificate was obtained.
+func (m *Manager) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cert == nil {
+		return nil, errNoCertificate
+	}
+	return m.cert, nil
+}
+
+// Run renews the certificate whenever it is due until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	for {
+		wait := checkInterval
+		if err := m.Renew(ctx); err != nil {
+			log.Printf("Failed to obtain certificate for %s, retrying in %v: %v", strings.Join(m.domains, ", "), retryInterval, err)
+			wait = retryInterval
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Renew obtains a new certificate if there is none, if it does not cover the
+// configured domains or if it expires within the renewal period. A
+// certificate kept in the cache dir is used if it is still good.
+func (m *Manager) Renew(ctx context.Context) error {
+	m.mu.Lock()
+	cert := m.cert
+	m.mu.Unlock()
+
+	if cert == nil {
+		if cached, err := tls.LoadX509KeyPair(m.path(certFile), m.path(keyFile)); err == nil {
+			cert = &cached
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to load cached certificate: %v", err)
+		}
+	}
+	if cert == nil || !m.good(cert.Leaf) {
+		log.Printf("Obtaining certificate for %s from %s", strings.Join(m.domains, ", "), m.directoryURL)
+		obtained, err := m.obtain(ctx)
+		if err != nil {
+			return err
+		}
+		cert = obtained
+		log.Printf("Obtained certificate for %s, valid until %s", strings.Join(m.domains, ", "), cert.Leaf.NotAfter)
+	}
+
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+	return nil
+}
+
+// good returns true if leaf covers exactly the configured domains and does
+// not expire within the renewal period.
+func (m *Manager) good(leaf *x509.Certificate) bool {
+	if leaf == nil || m.now().Add(m.renewBefore).After(leaf.NotAfter) {
+		return false
+	}
+	names := slices.Clone(leaf.DNSNames)
+	domains := slices.Clone(m.domains)
+	slices.Sort(names)
+	slices.Sort(domains)
+	return slices.Equal(names, domains)
+}
+
+// obtain orders a certificate for the configured domains, fulfills the
+// authorizations of the order and stores the issued certificate in the
+// cache dir.
+func (m *Manager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, orderTimeout)
+	defer cancel()
+
+	client, err := m.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.domains...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+	for _, url := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, url); err != nil {
+			return nil, err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.domains}, key)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %w", err)
+	}
+	return m.store(der, key)
+}
+
+// authorize fulfills the authorization at url with a DNS-01 challenge. The
+// challenge record is removed again once the authorization is done.
+func (m *Manager) authorize(ctx context.Context, client *acme.Client, url string) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == challengeType {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no %s challenge offered for %s", challengeType, authz.Identifier.Value)
+	}
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	updater, cleaner, zones := m.clients()
+	reqData, err := challengeReqData(ctx, zones, authz.Identifier.Value, value)
+	if err != nil {
+		return err
+	}
+
+	if err := updater.Append(ctx, reqData); err != nil {
+		return fmt.Errorf("failed to present challenge for %s: %w", authz.Identifier.Value, err)
+	}
+	defer cleanUp(ctx, cleaner, reqData)
+
+	t := time.NewTimer(m.propagation)
+	select {
+	case <-ctx.Done():
+		t.Stop()
+		return ctx.Err()
+	case <-t.C:
+	}
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("failed to accept challenge for %s: %w", authz.Identifier.Value, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("failed to validate %s: %w", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+// cleanUp removes the challenge record of reqData with cleaner, even if ctx
+// is already done.
+func cleanUp(ctx context.Context, cleaner Cleaner, reqData *data.ReqData) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+	if err := cleaner.Clean(ctx, reqData); err != nil {
+		log.Printf("Failed to clean up challenge of '%s': %v", reqData.FullName, err)
+	}
+}
+
+// challengeReqData returns the TXT record holding value for the challenge
+// of domain. zones is used to determine the zone of the record, it may be
+// nil.
+func challengeReqData(ctx context.Context, zones *hetzner.ZoneCache, domain, value string) (*data.ReqData, error) {
+	fqdn := challengePrefix + domain
+	name, zone, ok := "", "", false
+	if zones != nil {
+		name, zone, ok = zones.Split(ctx, fqdn)
+	}
+	if !ok {
+		var err error
+		if name, zone, err = middleware.SplitFQDN(fqdn); err != nil {
+			return nil, err
+		}
+	}
+	return &data.ReqData{
+		FullName: fqdn,
+		Name:     name,
+		Zone:     zone,
+		Value:    value,
+		Type:     recordTypeTXT,
+	}, nil
+}
+
+// client returns an ACME client with the account key kept in the cache dir.
+// The key is created and registered if it does not exist yet.
+func (m *Manager) client(ctx context.Context) (*acme.Client, error) {
+	if err := os.MkdirAll(m.cacheDir, cacheDirMode); err != nil {
+		return nil, err
+	}
+	key, err := readKey(m.path(accountKeyFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		key, err = createKey(m.path(accountKeyFile))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account key: %w", err)
+	}
+
+	client := &acme.Client{Key: key, DirectoryURL: m.directoryURL}
+	account := &acme.Account{}
+	if m.email != "" {
+		account.Contact = []string{"mailto:" + m.email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register account: %w", err)
+	}
+	return client, nil
+}
+
+// store writes the certificate chain der and its key to the cache dir.
+func (m *Manager) store(der [][]byte, key *ecdsa.PrivateKey) (*tls.Certificate, error) {
+	var certPEM []byte
+	for _, b := range der {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate issued: %w", err)
+	}
+	if err := os.WriteFile(m.path(keyFile), keyPEM, cacheFileMode); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(m.path(certFile), certPEM, cacheFileMode); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (m *Manager) path(name string) string {
+	return filepath.Join(m.cacheDir, name)
+}
+
+func readKey(path string) (*ecdsa.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no PEM block", path)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// createKey generates a new key and writes it to path.
+func createKey(path string) (*ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, keyPEM, cacheFileMode); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), nil
+}
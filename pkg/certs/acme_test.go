@@ -0,0 +1,346 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+)
+
+// fakeDNS records the values presented by the manager. If drop is set,
+// presented values never become visible to the CA.
+type fakeDNS struct {
+	mu       sync.Mutex
+	drop     bool
+	records  map[string][]string
+	appended []data.ReqData
+	cleaned  []data.ReqData
+}
+
+func (d *fakeDNS) Append(_ context.Context, reqData *data.ReqData) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.appended = append(d.appended, *reqData)
+	if d.drop {
+		return nil
+	}
+	d.records[reqData.FullName] = append(d.records[reqData.FullName], reqData.Value)
+	return nil
+}
+
+func (d *fakeDNS) Clean(_ context.Context, reqData *data.ReqData) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cleaned = append(d.cleaned, *reqData)
+	d.records[reqData.FullName] = slices.DeleteFunc(d.records[reqData.FullName], func(v string) bool {
+		return v == reqData.Value
+	})
+	return nil
+}
+
+func (d *fakeDNS) present(fqdn string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.records[fqdn]) > 0
+}
+
+// fakeCA is a minimal ACME CA issuing certificates for a single order at a
+// time. Challenges are valid if a value is presented for the challenge
+// record when they are accepted. Signatures of requests are not verified.
+type fakeCA struct {
+	*httptest.Server
+	dns     *fakeDNS
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	orders  int
+	nonce   int
+	domains []string
+	status  map[string]string
+	issued  []byte
+}
+
+func newFakeCA(dns *fakeDNS) *fakeCA {
+	ca := &fakeCA{dns: dns}
+	ca.cert, ca.key = newCertificate(nil, time.Now().Add(time.Hour), nil, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /directory", func(w http.ResponseWriter, _ *http.Request) {
+		ca.reply(w, http.StatusOK, map[string]string{
+			"newNonce":   ca.URL + "/new-nonce",
+			"newAccount": ca.URL + "/new-account",
+			"newOrder":   ca.URL + "/new-order",
+		})
+	})
+	mux.HandleFunc("HEAD /new-nonce", func(w http.ResponseWriter, _ *http.Request) {
+		ca.reply(w, http.StatusOK, nil)
+	})
+	mux.HandleFunc("POST /new-account", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Location", ca.URL+"/account")
+		ca.reply(w, http.StatusCreated, map[string]string{"status": "valid"})
+	})
+	mux.HandleFunc("POST /new-order", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Identifiers []struct{ Value string }
+		}
+		payload(r, &req)
+		ca.orders++
+		ca.domains = nil
+		ca.status = map[string]string{}
+		ca.issued = nil
+		for _, id := range req.Identifiers {
+			ca.domains = append(ca.domains, id.Value)
+		}
+		w.Header().Set("Location", ca.URL+"/order")
+		ca.reply(w, http.StatusCreated, ca.order())
+	})
+	mux.HandleFunc("POST /order", func(w http.ResponseWriter, _ *http.Request) {
+		ca.reply(w, http.StatusOK, ca.order())
+	})
+	mux.HandleFunc("POST /authz/{i}", func(w http.ResponseWriter, r *http.Request) {
+		ca.reply(w, http.StatusOK, ca.authz(r.PathValue("i")))
+	})
+	mux.HandleFunc("POST /challenge/{i}", func(w http.ResponseWriter, r *http.Request) {
+		domain := ca.domains[index(r.PathValue("i"))]
+		ca.status[domain] = "invalid"
+		if ca.dns.present(challengePrefix + strings.TrimPrefix(domain, "*.")) {
+			ca.status[domain] = "valid"
+		}
+		ca.reply(w, http.StatusOK, map[string]string{"type": challengeType, "status": "processing"})
+	})
+	mux.HandleFunc("POST /finalize", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ CSR string }
+		payload(r, &req)
+		ca.issue(req.CSR)
+		ca.reply(w, http.StatusOK, ca.order())
+	})
+	mux.HandleFunc("POST /cert", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Header().Set("Replay-Nonce", ca.newNonce())
+		_, _ = w.Write(ca.issued)
+	})
+	ca.Server = httptest.NewServer(mux)
+	return ca
+}
+
+func (ca *fakeCA) order() map[string]any {
+	status := "ready"
+	var authzs []string
+	for i, domain := range ca.domains {
+		authzs = append(authzs, ca.URL+"/authz/"+strconv.Itoa(i))
+		switch ca.status[domain] {
+		case "invalid":
+			status = "invalid"
+		case "":
+			status = "pending"
+		}
+	}
+	order := map[string]any{"status": status, "authorizations": authzs, "finalize": ca.URL + "/finalize"}
+	if ca.issued != nil {
+		order["status"] = "valid"
+		order["certificate"] = ca.URL + "/cert"
+	}
+	return order
+}
+
+func (ca *fakeCA) authz(i string) map[string]any {
+	domain := ca.domains[index(i)]
+	status := ca.status[domain]
+	if status == "" {
+		status = "pending"
+	}
+	return map[string]any{
+		"status":     status,
+		"identifier": map[string]string{"type": "dns", "value": strings.TrimPrefix(domain, "*.")},
+		"wildcard":   strings.HasPrefix(domain, "*."),
+		"challenges": []map[string]string{
+			{"type": "http-01", "url": ca.URL + "/challenge/" + i, "token": "http" + i, "status": "pending"},
+			{"type": challengeType, "url": ca.URL + "/challenge/" + i, "token": "dns" + i, "status": "pending"},
+		},
+	}
+}
+
+// issue signs the certificate request csr valid for 90 days.
+func (ca *fakeCA) issue(csr string) {
+	der, err := base64.RawURLEncoding.DecodeString(csr)
+	Expect(err).ToNot(HaveOccurred())
+	req, err := x509.ParseCertificateRequest(der)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(req.DNSNames).To(ConsistOf(ca.domains))
+
+	const days = 90
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(ca.orders)),
+		DNSNames:     req.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(days * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, ca.cert, req.PublicKey, ca.key)
+	Expect(err).ToNot(HaveOccurred())
+	ca.issued = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+}
+
+func (ca *fakeCA) newNonce() string {
+	ca.nonce++
+	return strconv.Itoa(ca.nonce)
+}
+
+func (ca *fakeCA) reply(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Replay-Nonce", ca.newNonce())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		Expect(json.NewEncoder(w).Encode(body)).To(Succeed())
+	}
+}
+
+// payload decodes the payload of the JWS in the body of r into v.
+func payload(r *http.Request, v any) {
+	var jws struct{ Payload string }
+	Expect(json.NewDecoder(r.Body).Decode(&jws)).To(Succeed())
+	b, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(json.Unmarshal(b, v)).To(Succeed())
+}
+
+func index(s string) int {
+	i, err := strconv.Atoi(s)
+	Expect(err).ToNot(HaveOccurred())
+	return i
+}
+
+var _ = Describe("Manager", func() {
+	var (
+		dns      *fakeDNS
+		ca       *fakeCA
+		cfg      *config.Config
+		cacheDir string
+	)
+
+	newManager := func() *Manager {
+		return NewManager(cfg, dns, dns, nil)
+	}
+
+	BeforeEach(func() {
+		dns = &fakeDNS{records: map[string][]string{}}
+		ca = newFakeCA(dns)
+		cacheDir = filepath.Join(GinkgoT().TempDir(), "acme")
+		cfg = &config.Config{
+			TLS: config.TLS{
+				Enabled: true,
+				ACME: config.ACME{
+					Enabled:         true,
+					Domains:         []string{"proxy.test.tld", "*.test.tld"},
+					DirectoryURL:    ca.URL + "/directory",
+					CacheDir:        cacheDir,
+					RenewBeforeDays: 30,
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		ca.Close()
+	})
+
+	It("fails handshakes until a certificate was obtained", func() {
+		_, err := newManager().GetCertificate(nil)
+		Expect(err).To(MatchError(errNoCertificate))
+	})
+
+	It("obtains a certificate by presenting dns-01 challenges", func(ctx context.Context) {
+		m := newManager()
+		Expect(m.Renew(ctx)).To(Succeed())
+
+		cert, err := m.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.Leaf.DNSNames).To(ConsistOf("proxy.test.tld", "*.test.tld"))
+		Expect(cert.Certificate).To(HaveLen(2))
+
+		Expect(dns.appended).To(HaveLen(2))
+		Expect(dns.appended[0]).To(Equal(data.ReqData{
+			FullName: "_acme-challenge.proxy.test.tld",
+			Name:     "_acme-challenge.proxy",
+			Zone:     "test.tld",
+			Value:    dns.appended[0].Value,
+			Type:     "TXT",
+		}))
+		Expect(dns.appended[1].FullName).To(Equal("_acme-challenge.test.tld"))
+		Expect(dns.appended[1].Name).To(Equal("_acme-challenge"))
+		Expect(dns.cleaned).To(Equal(dns.appended))
+		Expect(dns.records).To(HaveEach(BeEmpty()))
+
+		for _, name := range []string{accountKeyFile, certFile, keyFile} {
+			info, err := os.Stat(filepath.Join(cacheDir, name))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(cacheFileMode)))
+		}
+	})
+
+	It("cleans up the challenge if the validation failed", func(ctx context.Context) {
+		dns.drop = true
+		m := newManager()
+		Expect(m.Renew(ctx)).To(MatchError(ContainSubstring("failed to validate proxy.test.tld")))
+		Expect(dns.appended).To(HaveLen(1))
+		Expect(dns.cleaned).To(Equal(dns.appended))
+		_, err := m.GetCertificate(nil)
+		Expect(err).To(MatchError(errNoCertificate))
+	})
+
+	It("uses the cached certificate while it is good", func(ctx context.Context) {
+		Expect(newManager().Renew(ctx)).To(Succeed())
+
+		m := newManager()
+		Expect(m.Renew(ctx)).To(Succeed())
+		Expect(ca.orders).To(Equal(1))
+		_, err := m.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("renews the certificate within the renewal period", func(ctx context.Context) {
+		m := newManager()
+		Expect(m.Renew(ctx)).To(Succeed())
+		first, err := m.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		m.now = func() time.Time { return first.Leaf.NotAfter.Add(-29 * 24 * time.Hour) }
+		Expect(m.Renew(ctx)).To(Succeed())
+		Expect(ca.orders).To(Equal(2))
+		second, err := m.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second.Leaf.SerialNumber).ToNot(Equal(first.Leaf.SerialNumber))
+	})
+
+	It("obtains a new certificate if the domains changed", func(ctx context.Context) {
+		Expect(newManager().Renew(ctx)).To(Succeed())
+
+		cfg.TLS.ACME.Domains = []string{"proxy.test.tld"}
+		m := newManager()
+		Expect(m.Renew(ctx)).To(Succeed())
+		Expect(ca.orders).To(Equal(2))
+		cert, err := m.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.Leaf.DNSNames).To(ConsistOf("proxy.test.tld"))
+	})
+})
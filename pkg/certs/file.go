@@ -0,0 +1,77 @@
+// Package certs provides the certificates the proxy serves HTTPS with. They
+// are either read from files or obtained from an ACME CA with DNS-01
+// challenges presented through the Hetzner API.
+package certs
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// File serves the certificate read from a cert and a key file. The files are
+// read again once their modification time changed, so renewed certificates
+// are served without a restart.
+type File struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// NewFile reads the certificate from certFile and keyFile.
+func NewFile(certFile, keyFile string) (*File, error) {
+	f := &File{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetCertificate returns the certificate, it is read again if the files
+// changed. If they cannot be read, the previous certificate is kept.
+func (f *File) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.reload(); err != nil {
+		log.Printf("Failed to reload certificate, keeping the current one: %v", err)
+	}
+	return f.cert, nil
+}
+
+// reload reads the certificate if the modification time of one of the files
+// differs from the last read.
+func (f *File) reload() error {
+	certInfo, err := os.Stat(f.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(f.keyFile)
+	if err != nil {
+		return err
+	}
+	if f.cert != nil && certInfo.ModTime().Equal(f.certModTime) && keyInfo.ModTime().Equal(f.keyModTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return err
+	}
+	if f.cert != nil {
+		log.Printf("Reloaded certificate from %s", f.certFile)
+	}
+	f.cert = &cert
+	f.certModTime = certInfo.ModTime()
+	f.keyModTime = keyInfo.ModTime()
+	return nil
+}
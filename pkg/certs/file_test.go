@@ -0,0 +1,157 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+)
+
+// newCertificate returns a certificate for dnsNames signed by parent and
+// parentKey, it is self-signed if parent is nil.
+func newCertificate(
+	dnsNames []string, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "test"},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	return cert, key
+}
+
+// writeCertificate writes cert and key PEM encoded to certFile and keyFile.
+func writeCertificate(certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	Expect(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600)).To(Succeed())
+	keyPEM, err := encodeKey(key)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(os.WriteFile(keyFile, keyPEM, 0o600)).To(Succeed())
+}
+
+var _ = Describe("File", func() {
+	var (
+		certPath string
+		keyPath  string
+		first    *x509.Certificate
+	)
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		certPath = filepath.Join(dir, "cert.pem")
+		keyPath = filepath.Join(dir, "key.pem")
+
+		var key *ecdsa.PrivateKey
+		first, key = newCertificate([]string{"proxy.test.tld"}, time.Now().Add(time.Hour), nil, nil)
+		writeCertificate(certPath, keyPath, first, key)
+	})
+
+	It("fails if the files cannot be read", func() {
+		_, err := NewFile(certPath, filepath.Join(GinkgoT().TempDir(), "missing.pem"))
+		Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+	})
+
+	It("reads the files again once they changed", func() {
+		f, err := NewFile(certPath, keyPath)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := f.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.Leaf.Equal(first)).To(BeTrue())
+
+		second, key := newCertificate([]string{"proxy.test.tld"}, time.Now().Add(time.Hour), nil, nil)
+		writeCertificate(certPath, keyPath, second, key)
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(certPath, later, later)).To(Succeed())
+		Expect(os.Chtimes(keyPath, later, later)).To(Succeed())
+
+		cert, err = f.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.Leaf.Equal(second)).To(BeTrue())
+	})
+
+	It("keeps the current certificate if the files are invalid", func() {
+		f, err := NewFile(certPath, keyPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.WriteFile(keyPath, []byte("invalid"), 0o600)).To(Succeed())
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(keyPath, later, later)).To(Succeed())
+
+		cert, err := f.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.Leaf.Equal(first)).To(BeTrue())
+	})
+})
+
+var _ = Describe("NewTLSConfig", func() {
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return nil, nil }
+
+	It("sets the minimum version", func() {
+		tlsConfig, err := NewTLSConfig(&config.TLS{MinVersion: config.TLSVersion13}, getCertificate)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConfig.MinVersion).To(Equal(uint16(tls.VersionTLS13)))
+		Expect(tlsConfig.ClientAuth).To(Equal(tls.NoClientCert))
+	})
+
+	It("requires client certificates signed by the client CA", func() {
+		ca, key := newCertificate(nil, time.Now().Add(time.Hour), nil, nil)
+		dir := GinkgoT().TempDir()
+		caPath := filepath.Join(dir, "ca.pem")
+		writeCertificate(caPath, filepath.Join(dir, "ca.key"), ca, key)
+
+		tlsConfig, err := NewTLSConfig(&config.TLS{MinVersion: config.TLSVersion12, ClientCA: caPath}, getCertificate)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConfig.MinVersion).To(Equal(uint16(tls.VersionTLS12)))
+		Expect(tlsConfig.ClientAuth).To(Equal(tls.RequireAndVerifyClientCert))
+		Expect(tlsConfig.ClientCAs.Equal(func() *x509.CertPool {
+			pool := x509.NewCertPool()
+			pool.AddCert(ca)
+			return pool
+		}())).To(BeTrue())
+	})
+
+	It("verifies optional client certificates", func() {
+		ca, key := newCertificate(nil, time.Now().Add(time.Hour), nil, nil)
+		dir := GinkgoT().TempDir()
+		caPath := filepath.Join(dir, "ca.pem")
+		writeCertificate(caPath, filepath.Join(dir, "ca.key"), ca, key)
+
+		tlsConfig, err := NewTLSConfig(&config.TLS{ClientCA: caPath, ClientCertOptional: true}, getCertificate)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConfig.ClientAuth).To(Equal(tls.VerifyClientCertIfGiven))
+	})
+
+	It("fails if the client CA contains no certificates", func() {
+		caPath := filepath.Join(GinkgoT().TempDir(), "ca.pem")
+		Expect(os.WriteFile(caPath, []byte("invalid"), 0o600)).To(Succeed())
+
+		_, err := NewTLSConfig(&config.TLS{ClientCA: caPath}, getCertificate)
+		Expect(err).To(MatchError("client CA " + caPath + " contains no certificates"))
+	})
+})
@@ -0,0 +1,275 @@
+// Package challenge removes ACME challenge TXT values that were never
+// cleaned up, e.g. because the client does not support cleanup or crashed
+// before calling it.
+package challenge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+)
+
+const (
+	recordTypeTXT = "TXT"
+	stateFileMode = 0o600
+	// maxAttempts is the number of times removing an expired value is tried
+	// before it is given up
+	maxAttempts = 10
+)
+
+type Cleaner interface {
+	CleanManaged(ctx context.Context, reqData *data.ReqData) error
+}
+
+// entry is a challenge value added by the proxy.
+type entry struct {
+	Zone    string    `json:"zone"`
+	Name    string    `json:"name"`
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+	// Attempts counts the failed attempts to remove the value
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// Reaper remembers the challenge values added by the proxy in a state file
+// and removes them once they expired. Only values of rrsets labeled as
+// managed by the proxy are removed.
+type Reaper struct {
+	mu       sync.Mutex
+	entries  map[string]entry
+	path     string
+	lifetime time.Duration
+	interval time.Duration
+	timeout  time.Duration
+	cleaner  Cleaner
+	now      func() time.Time
+}
+
+// NewReaper creates a Reaper and loads the entries remembered in the state
+// file. A missing state file is not an error.
+func NewReaper(cfg *config.Config, cleaner Cleaner) (*Reaper, error) {
+	r := &Reaper{
+		entries:  make(map[string]entry),
+		path:     cfg.ChallengeExpiry.StateFile,
+		lifetime: time.Duration(cfg.ChallengeExpiry.LifetimeSeconds) * time.Second,
+		interval: time.Duration(cfg.ChallengeExpiry.IntervalSeconds) * time.Second,
+		timeout:  time.Duration(cfg.Timeout) * time.Second,
+		cleaner:  cleaner,
+		now:      time.Now,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Track remembers the value of reqData, it expires after the configured
+// lifetime. Values already tracked keep their expiry.
+func (r *Reaper) Track(reqData *data.ReqData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := key(reqData.Zone, reqData.Name, reqData.Value)
+	if _, ok := r.entries[k]; ok {
+		return
+	}
+	r.entries[k] = entry{
+		Zone:    reqData.Zone,
+		Name:    reqData.Name,
+		Value:   reqData.Value,
+		Expires: r.now().Add(r.lifetime),
+	}
+	r.save()
+}
+
+// Forget stops tracking the value of reqData, e.g. because it was cleaned
+// up by the client.
+func (r *Reaper) Forget(reqData *data.ReqData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := key(reqData.Zone, reqData.Name, reqData.Value)
+	if _, ok := r.entries[k]; !ok {
+		return
+	}
+	delete(r.entries, k)
+	r.save()
+}
+
+// Reload replaces the API timeout and the cleaner expired values are removed
+// with. The challenge expiry settings are only applied on start.
+func (r *Reaper) Reload(cfg *config.Config, cleaner Cleaner) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeout = time.Duration(cfg.Timeout) * time.Second
+	r.cleaner = cleaner
+}
+
+// Run removes expired values every interval until ctx is done.
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Reap(ctx)
+		}
+	}
+}
+
+// Reap removes all expired values. Values failing to be removed are retried
+// on the next call, unless they failed permanently or maxAttempts times.
+func (r *Reaper) Reap(ctx context.Context) {
+	for k, e := range r.expired() {
+		reqData := &data.ReqData{
+			FullName: e.Name + "." + e.Zone,
+			Name:     e.Name,
+			Zone:     e.Zone,
+			Value:    e.Value,
+			Type:     recordTypeTXT,
+		}
+		log.Printf("removing expired challenge value of '%s'", reqData.FullName)
+		if err := r.clean(ctx, reqData); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if r.retry(k, err) {
+				log.Printf("failed to remove expired challenge value of '%s': %v", reqData.FullName, err)
+				continue
+			}
+			log.Printf("giving up removing expired challenge value of '%s': %v", reqData.FullName, err)
+		}
+
+		r.mu.Lock()
+		delete(r.entries, k)
+		r.save()
+		r.mu.Unlock()
+	}
+}
+
+// retry records a failed attempt to remove the value of k and returns true if
+// it should be tried again. Values are given up on permanent errors, e.g.
+// once their zone was deleted or they are not routed to a project anymore.
+func (r *Reaper) retry(k string, err error) bool {
+	if errors.Is(err, hetzner.ErrUnmanaged) || errors.Is(err, hetzner.ErrNoProject) ||
+		hcloud.IsError(err, hcloud.ErrorCodeNotFound) {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[k]
+	if !ok {
+		return false
+	}
+	e.Attempts++
+	if e.Attempts >= maxAttempts {
+		return false
+	}
+	r.entries[k] = e
+	r.save()
+	return true
+}
+
+func (r *Reaper) expired() map[string]entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	expired := make(map[string]entry)
+	for k, e := range r.entries {
+		if !now.Before(e.Expires) {
+			expired[k] = e
+		}
+	}
+	return expired
+}
+
+func (r *Reaper) clean(ctx context.Context, reqData *data.ReqData) error {
+	r.mu.Lock()
+	timeout, cleaner := r.timeout, r.cleaner
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return cleaner.CleanManaged(ctx, reqData)
+}
+
+func (r *Reaper) load() error {
+	b, err := os.ReadFile(r.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		r.entries[key(e.Zone, e.Name, e.Value)] = e
+	}
+	return nil
+}
+
+// save writes the entries to the state file. It must be called with mu
+// held. Failures are only logged, the entries are kept in memory.
+func (r *Reaper) save() {
+	entries := make([]entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	if err := writeFile(r.path, entries); err != nil {
+		log.Printf("failed to write challenge state file: %v", err)
+	}
+}
+
+// writeFile replaces the file at path atomically, so a crash never leaves a
+// truncated state file behind.
+func writeFile(path string, entries []entry) error {
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		return errors.Join(err, f.Close(), os.Remove(f.Name()))
+	}
+	if err := f.Chmod(stateFileMode); err != nil {
+		return errors.Join(err, f.Close(), os.Remove(f.Name()))
+	}
+	if err := f.Close(); err != nil {
+		return errors.Join(err, os.Remove(f.Name()))
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return errors.Join(err, os.Remove(f.Name()))
+	}
+	return nil
+}
+
+func key(zone, name, value string) string {
+	return strings.ToLower(zone) + "/" + strings.ToLower(name) + "/" + value
+}
@@ -0,0 +1,164 @@
+package challenge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+)
+
+type fakeCleaner struct {
+	cleaned []data.ReqData
+	err     error
+}
+
+func (c *fakeCleaner) CleanManaged(_ context.Context, reqData *data.ReqData) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.cleaned = append(c.cleaned, *reqData)
+	return nil
+}
+
+var _ = Describe("Reaper", func() {
+	var (
+		now     time.Time
+		cfg     *config.Config
+		cleaner *fakeCleaner
+		r       *Reaper
+		reqData *data.ReqData
+	)
+
+	newReaper := func() *Reaper {
+		reaper, err := NewReaper(cfg, cleaner)
+		Expect(err).ToNot(HaveOccurred())
+		reaper.now = func() time.Time { return now }
+		return reaper
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		cfg = &config.Config{
+			Timeout: 10,
+			ChallengeExpiry: config.ChallengeExpiry{
+				Enabled:         true,
+				LifetimeSeconds: 3600,
+				IntervalSeconds: 60,
+				StateFile:       filepath.Join(GinkgoT().TempDir(), "challenges.json"),
+			},
+		}
+		cleaner = &fakeCleaner{}
+		r = newReaper()
+		reqData = &data.ReqData{Zone: "example.com", Name: "_acme-challenge", Value: "token"}
+	})
+
+	It("does not remove values before they expired", func(ctx context.Context) {
+		r.Track(reqData)
+		now = now.Add(59 * time.Minute)
+		r.Reap(ctx)
+		Expect(cleaner.cleaned).To(BeEmpty())
+		Expect(r.entries).To(HaveLen(1))
+	})
+
+	It("removes expired values", func(ctx context.Context) {
+		r.Track(reqData)
+		now = now.Add(time.Hour)
+		r.Reap(ctx)
+		Expect(cleaner.cleaned).To(ConsistOf(data.ReqData{
+			FullName: "_acme-challenge.example.com",
+			Name:     "_acme-challenge",
+			Zone:     "example.com",
+			Value:    "token",
+			Type:     "TXT",
+		}))
+		Expect(r.entries).To(BeEmpty())
+	})
+
+	It("keeps the expiry of values tracked again", func(ctx context.Context) {
+		r.Track(reqData)
+		now = now.Add(30 * time.Minute)
+		r.Track(reqData)
+		now = now.Add(30 * time.Minute)
+		r.Reap(ctx)
+		Expect(cleaner.cleaned).To(HaveLen(1))
+	})
+
+	It("does not remove forgotten values", func(ctx context.Context) {
+		r.Track(reqData)
+		r.Forget(reqData)
+		now = now.Add(time.Hour)
+		r.Reap(ctx)
+		Expect(cleaner.cleaned).To(BeEmpty())
+	})
+
+	It("retries values that failed to be removed", func(ctx context.Context) {
+		r.Track(reqData)
+		now = now.Add(time.Hour)
+		cleaner.err = errors.New("api unavailable")
+		r.Reap(ctx)
+		Expect(r.entries).To(HaveLen(1))
+
+		cleaner.err = nil
+		r.Reap(ctx)
+		Expect(cleaner.cleaned).To(HaveLen(1))
+		Expect(r.entries).To(BeEmpty())
+	})
+
+	DescribeTable("drops values that failed to be removed permanently", func(ctx context.Context, err error) {
+		r.Track(reqData)
+		now = now.Add(time.Hour)
+		cleaner.err = err
+		r.Reap(ctx)
+		Expect(r.entries).To(BeEmpty())
+	},
+		Entry("rrset not managed", fmt.Errorf("%w: TXT _acme-challenge.example.com", hetzner.ErrUnmanaged)),
+		Entry("no project", fmt.Errorf("%w: example.com", hetzner.ErrNoProject)),
+		Entry("not found", hcloud.Error{Code: hcloud.ErrorCodeNotFound, Message: "not found"}),
+	)
+
+	It("gives up values after failing to remove them repeatedly", func(ctx context.Context) {
+		r.Track(reqData)
+		now = now.Add(time.Hour)
+		cleaner.err = errors.New("api unavailable")
+		for range maxAttempts - 1 {
+			r.Reap(ctx)
+		}
+		Expect(r.entries).To(HaveLen(1))
+
+		r = newReaper()
+		Expect(r.entries).To(HaveKeyWithValue(key(reqData.Zone, reqData.Name, reqData.Value),
+			HaveField("Attempts", maxAttempts-1)))
+		r.Reap(ctx)
+		Expect(r.entries).To(BeEmpty())
+	})
+
+	It("remembers values across restarts", func(ctx context.Context) {
+		r.Track(reqData)
+
+		r = newReaper()
+		Expect(r.entries).To(HaveLen(1))
+		now = now.Add(time.Hour)
+		r.Reap(ctx)
+		Expect(cleaner.cleaned).To(HaveLen(1))
+
+		r = newReaper()
+		Expect(r.entries).To(BeEmpty())
+	})
+
+	It("fails on a corrupt state file", func() {
+		Expect(os.WriteFile(cfg.ChallengeExpiry.StateFile, []byte("{"), 0o600)).To(Succeed())
+		_, err := NewReaper(cfg, cleaner)
+		Expect(err).To(HaveOccurred())
+	})
+})
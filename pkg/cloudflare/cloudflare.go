@@ -0,0 +1,237 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	headerContentType      = "Content-Type"
+	applicationJSON        = "application/json"
+	maxRequestBodySize     = 64 << 10 // 64 KB
+	failedWriteResponseFmt = "failed to write response: %v"
+	zoneStatusActive       = "active"
+)
+
+// Error codes as returned by the Cloudflare API
+const (
+	codeInternal         = 1000
+	codeInvalidRequest   = 1004
+	codeZoneNotFound     = 1001
+	codeAuthentication   = 10000
+	codeRateLimited      = 10429
+	codeRecordNotFound   = 81044
+	codePermissionDenied = 9109
+)
+
+type Updater interface {
+	Add(ctx context.Context, reqData *data.ReqData) error
+}
+
+type Cleaner interface {
+	Clean(ctx context.Context, reqData *data.ReqData) error
+}
+
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type resultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
+	TotalPages int `json:"total_pages"`
+}
+
+type envelope struct {
+	Success    bool        `json:"success"`
+	Errors     []apiError  `json:"errors"`
+	Messages   []apiError  `json:"messages"`
+	Result     any         `json:"result"`
+	ResultInfo *resultInfo `json:"result_info,omitempty"`
+}
+
+type zone struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// API emulates the parts of the Cloudflare API v4 needed to manage DNS
+// records. Clients authenticate with the bearer token of a configured user
+// and every record is authorized with CheckPermission.
+type API struct {
+	cfg      *config.Config
+	lockout  *ratelimit.Lockout
+	updater  Updater
+	cleaner  Cleaner
+	projects *hetzner.Projects
+}
+
+func New(cfg *config.Config, projects *hetzner.Projects, lockout *ratelimit.Lockout, updater Updater, cleaner Cleaner) *API {
+	return &API{
+		cfg:      cfg,
+		lockout:  lockout,
+		updater:  updater,
+		cleaner:  cleaner,
+		projects: projects,
+	}
+}
+
+// Zones lists the zones containing domains the client is allowed to update.
+func (a *API) Zones(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(a.cfg.Timeout)*time.Second)
+		defer cancel()
+		hZones, err := a.projects.AllZones(ctx, hcloud.ZoneListOpts{Name: r.URL.Query().Get("name")})
+		if err != nil {
+			log.Printf("failed to list zones: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "failed to list zones")
+			return
+		}
+
+		zones := []zone{}
+		for _, z := range hZones {
+			if middleware.ZoneOverlaps(z.Name, domains) {
+				zones = append(zones, zone{ID: strconv.FormatInt(z.ID, 10), Name: z.Name, Status: zoneStatusActive})
+			}
+		}
+		writeList(w, zones, len(zones))
+	})
+}
+
+// authenticate returns the domains the client is allowed to update. If there
+// are none, an error response is written.
+func (a *API) authenticate(w http.ResponseWriter, r *http.Request) (map[string]struct{}, bool) {
+	addr := sanitize.LogValue(r.RemoteAddr)
+	if a.lockout.IsBlocked(r.RemoteAddr) {
+		//nolint:gosec // value is sanitized above
+		log.Printf("client '%s' is locked out", addr)
+		writeError(w, http.StatusTooManyRequests, codeRateLimited, "too many failed authentication attempts")
+		return nil, false
+	}
+
+	domains := middleware.GetDomains(a.cfg, &data.ReqData{
+		Token:      middleware.BearerToken(r),
+		ClientCert: middleware.ClientCert(r),
+		Endpoint:   config.EndpointCloudflare,
+	}, r.RemoteAddr)
+	if len(domains) == 0 {
+		//nolint:gosec // value is sanitized above
+		log.Printf("client '%s' is not allowed to list any domains", addr)
+		a.lockout.RecordFailure(r.RemoteAddr)
+		metrics.AuthFailure(a.cfg.Auth.Method)
+		writeError(w, http.StatusUnauthorized, codeAuthentication, "Authentication error")
+		return nil, false
+	}
+
+	return domains, true
+}
+
+// authorize checks that the client is allowed to update all of reqData.
+func (a *API) authorize(w http.ResponseWriter, r *http.Request, reqData ...*data.ReqData) bool {
+	for _, d := range reqData {
+		if !middleware.CheckPermission(a.cfg, d, r.RemoteAddr) {
+			addr := sanitize.LogValue(r.RemoteAddr)
+			typ := sanitize.LogValue(d.Type)
+			name := sanitize.LogValue(d.FullName)
+			//nolint:gosec // values are sanitized above
+			log.Printf("client '%s' is not allowed to update '%s' data of '%s'", addr, typ, name)
+			a.lockout.RecordFailure(r.RemoteAddr)
+			metrics.AuthFailure(a.cfg.Auth.Method)
+			writeError(w, http.StatusForbidden, codePermissionDenied, "not allowed to update "+d.FullName)
+			return false
+		}
+	}
+	a.lockout.Reset(r.RemoteAddr)
+	return true
+}
+
+// getZone returns the zone referenced in the request path. If the zone does
+// not exist or contains no domains the client may update, an error response
+// is written.
+func (a *API) getZone(
+	ctx context.Context, w http.ResponseWriter, r *http.Request, domains map[string]struct{},
+) (*hcloud.Zone, bool) {
+	z, err := a.projects.GetZone(ctx, r.PathValue("zone_id"))
+	if err != nil {
+		log.Printf("failed to get zone: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "failed to get zone")
+		return nil, false
+	}
+	if z == nil || !middleware.ZoneOverlaps(z.Name, domains) {
+		writeError(w, http.StatusNotFound, codeZoneNotFound, "zone not found")
+		return nil, false
+	}
+	return z, true
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	writeJSON(w, http.StatusOK, envelope{Success: true, Errors: []apiError{}, Messages: []apiError{}, Result: result})
+}
+
+func writeList[T any](w http.ResponseWriter, result []T, count int) {
+	writeJSON(w, http.StatusOK, envelope{
+		Success:  true,
+		Errors:   []apiError{},
+		Messages: []apiError{},
+		Result:   result,
+		ResultInfo: &resultInfo{
+			Page:       1,
+			PerPage:    max(count, 1),
+			Count:      count,
+			TotalCount: count,
+			TotalPages: 1,
+		},
+	})
+}
+
+// writeChangeError writes the response to a failed change of records.
+// Changing rrsets not managed by the proxy is forbidden.
+func writeChangeError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, hetzner.ErrUnmanaged) {
+		writeError(w, http.StatusForbidden, codePermissionDenied, "not allowed to change records not managed by the proxy")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, codeInternal, message)
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, envelope{Errors: []apiError{{Code: code, Message: message}}, Messages: []apiError{}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	resData, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("failed to marshal response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(headerContentType, applicationJSON)
+	w.WriteHeader(status)
+	if _, err := w.Write(resData); err != nil {
+		log.Printf(failedWriteResponseFmt, err)
+	}
+}
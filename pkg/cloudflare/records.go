@@ -0,0 +1,440 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	apexName      = "@"
+	recordIDParts = 3
+)
+
+type record struct {
+	ID        string `json:"id"`
+	ZoneID    string `json:"zone_id"`
+	ZoneName  string `json:"zone_name"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Content   string `json:"content"`
+	Priority  *int   `json:"priority,omitempty"`
+	TTL       int    `json:"ttl"`
+	Proxiable bool   `json:"proxiable"`
+	Proxied   bool   `json:"proxied"`
+}
+
+type recordRequest struct {
+	Type     *string `json:"type"`
+	Name     *string `json:"name"`
+	Content  *string `json:"content"`
+	Priority *int    `json:"priority"`
+}
+
+type deleteResult struct {
+	ID string `json:"id"`
+}
+
+// recordKey identifies a single record of an rrset. Cloudflare manages
+// records individually, so the key is encoded into the record ID.
+type recordKey struct {
+	typ   string
+	name  string
+	value string
+}
+
+func (k recordKey) id() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(k.typ + "/" + k.name + "/" + k.value))
+}
+
+func (k recordKey) fqdn(zoneName string) string {
+	if k.name == apexName {
+		return zoneName
+	}
+	return k.name + "." + zoneName
+}
+
+func parseRecordID(id string) (recordKey, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return recordKey{}, false
+	}
+	parts := strings.SplitN(string(b), "/", recordIDParts)
+	if len(parts) != recordIDParts {
+		return recordKey{}, false
+	}
+	return recordKey{typ: parts[0], name: parts[1], value: parts[2]}, true
+}
+
+// ListRecords lists the records of supported types of a zone the client is
+// allowed to update. The type, name and content query parameters filter the
+// records.
+func (a *API) ListRecords(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(a.cfg.Timeout)*time.Second)
+		defer cancel()
+		z, ok := a.getZone(ctx, w, r, domains)
+		if !ok {
+			return
+		}
+
+		rrSets, err := a.projects.AllRRSets(ctx, z)
+		if err != nil {
+			log.Printf("failed to list records: %v", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "failed to list records")
+			return
+		}
+
+		query := r.URL.Query()
+		records := []record{}
+		for _, rrSet := range rrSets {
+			if _, err := hetzner.RRSetTypeFromString(string(rrSet.Type)); err != nil {
+				continue
+			}
+			for _, value := range hetzner.Values(rrSet) {
+				k := recordKey{typ: string(rrSet.Type), name: rrSet.Name, value: value}
+				rec := newRecord(z, k, rrSet.TTL)
+				if !matchesQuery(rec, query.Get("type"), query.Get("name"), query.Get("content")) ||
+					!middleware.CheckPermission(a.cfg, a.reqData(r, z, k), r.RemoteAddr) {
+					continue
+				}
+				records = append(records, rec)
+			}
+		}
+		writeList(w, records, len(records))
+	})
+}
+
+// GetRecord returns a single record.
+func (a *API) GetRecord(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(a.cfg.Timeout)*time.Second)
+		defer cancel()
+		z, ok := a.getZone(ctx, w, r, domains)
+		if !ok {
+			return
+		}
+		k, rrSet, ok := a.getRecord(ctx, w, r, z)
+		if !ok {
+			return
+		}
+		if !middleware.CheckPermission(a.cfg, a.reqData(r, z, k), r.RemoteAddr) {
+			writeError(w, http.StatusNotFound, codeRecordNotFound, "Record does not exist.")
+			return
+		}
+
+		writeResult(w, newRecord(z, k, rrSet.TTL))
+	})
+}
+
+// CreateRecord adds a record to the rrset of its name and type.
+func (a *API) CreateRecord(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+		req, ok := parseRecordRequest(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(a.cfg.Timeout)*time.Second)
+		defer cancel()
+		z, ok := a.getZone(ctx, w, r, domains)
+		if !ok {
+			return
+		}
+
+		k, err := newRecordKey(z.Name, req, recordKey{}, false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		reqData := a.reqData(r, z, k)
+		if !a.authorize(w, r, reqData) {
+			return
+		}
+
+		logRequest("add", reqData)
+		if err := a.updater.Add(ctx, reqData); err != nil {
+			log.Printf("failed to add record: %v", err)
+			writeChangeError(w, err, "failed to add record")
+			return
+		}
+		writeResult(w, newRecord(z, k, &reqData.TTL))
+	})
+}
+
+// UpdateRecord overwrites (PUT) or patches (PATCH) a record by adding the
+// new record and removing the old one.
+func (a *API) UpdateRecord(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+		req, ok := parseRecordRequest(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(a.cfg.Timeout)*time.Second)
+		defer cancel()
+		z, ok := a.getZone(ctx, w, r, domains)
+		if !ok {
+			return
+		}
+		oldKey, rrSet, ok := a.getRecord(ctx, w, r, z)
+		if !ok {
+			return
+		}
+
+		k, err := newRecordKey(z.Name, req, oldKey, r.Method == http.MethodPatch)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		oldData, newData := a.reqData(r, z, oldKey), a.reqData(r, z, k)
+		if !a.authorize(w, r, oldData, newData) {
+			return
+		}
+
+		if k == oldKey {
+			writeResult(w, newRecord(z, k, rrSet.TTL))
+			return
+		}
+		if err := a.replace(ctx, oldData, newData); err != nil {
+			log.Printf("failed to update record: %v", err)
+			writeChangeError(w, err, "failed to update record")
+			return
+		}
+		writeResult(w, newRecord(z, k, &newData.TTL))
+	})
+}
+
+// DeleteRecord removes a record from its rrset.
+func (a *API) DeleteRecord(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(a.cfg.Timeout)*time.Second)
+		defer cancel()
+		z, ok := a.getZone(ctx, w, r, domains)
+		if !ok {
+			return
+		}
+		k, _, ok := a.getRecord(ctx, w, r, z)
+		if !ok {
+			return
+		}
+		reqData := a.reqData(r, z, k)
+		if !a.authorize(w, r, reqData) {
+			return
+		}
+
+		logRequest("remove", reqData)
+		if err := a.cleaner.Clean(ctx, reqData); err != nil {
+			log.Printf("failed to remove record: %v", err)
+			writeChangeError(w, err, "failed to remove record")
+			return
+		}
+		writeResult(w, deleteResult{ID: k.id()})
+	})
+}
+
+// getRecord returns the key of the record referenced in the request path and
+// its rrset. If the record does not exist, an error response is written.
+func (a *API) getRecord(
+	ctx context.Context, w http.ResponseWriter, r *http.Request, z *hcloud.Zone,
+) (recordKey, *hcloud.ZoneRRSet, bool) {
+	k, ok := parseRecordID(r.PathValue("record_id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, codeRecordNotFound, "Record does not exist.")
+		return recordKey{}, nil, false
+	}
+	rrSetType, err := hetzner.RRSetTypeFromString(k.typ)
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeRecordNotFound, "Record does not exist.")
+		return recordKey{}, nil, false
+	}
+
+	rrSet, err := a.projects.GetRRSet(ctx, z, k.name, rrSetType)
+	if err != nil {
+		log.Printf("failed to get record: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "failed to get record")
+		return recordKey{}, nil, false
+	}
+	if rrSet == nil || !slices.Contains(hetzner.Values(rrSet), k.value) {
+		writeError(w, http.StatusNotFound, codeRecordNotFound, "Record does not exist.")
+		return recordKey{}, nil, false
+	}
+
+	return k, rrSet, true
+}
+
+// replace adds the new record before removing the old one, so the name
+// never resolves to nothing when only the content changes.
+func (a *API) replace(ctx context.Context, oldData, newData *data.ReqData) error {
+	logRequest("add", newData)
+	if err := a.updater.Add(ctx, newData); err != nil {
+		return err
+	}
+	logRequest("remove", oldData)
+	return a.cleaner.Clean(ctx, oldData)
+}
+
+func (a *API) reqData(r *http.Request, z *hcloud.Zone, k recordKey) *data.ReqData {
+	return &data.ReqData{
+		FullName:   k.fqdn(z.Name),
+		Name:       k.name,
+		Zone:       z.Name,
+		Value:      k.value,
+		Type:       k.typ,
+		Token:      middleware.BearerToken(r),
+		ClientCert: middleware.ClientCert(r),
+		Endpoint:   config.EndpointCloudflare,
+	}
+}
+
+func parseRecordRequest(w http.ResponseWriter, r *http.Request) (*recordRequest, bool) {
+	req := &recordRequest{}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		log.Printf("failed to parse request: %v", err)
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid request body")
+		return nil, false
+	}
+	return req, true
+}
+
+// newRecordKey applies req to base. Unless patch is true, all fields of req
+// are required.
+func newRecordKey(zoneName string, req *recordRequest, base recordKey, patch bool) (recordKey, error) {
+	if !patch && (req.Type == nil || req.Name == nil || req.Content == nil) {
+		return recordKey{}, errors.New("type, name and content are required")
+	}
+
+	k := base
+	if req.Type != nil {
+		k.typ = strings.ToUpper(*req.Type)
+	}
+	if req.Name != nil {
+		k.name = relativeName(zoneName, *req.Name)
+	}
+
+	priority, content := splitPriority(base.typ, base.value)
+	if req.Content != nil {
+		content = *req.Content
+	}
+	if req.Priority != nil {
+		priority = req.Priority
+	}
+	k.value = content
+	if hasPriority(k.typ) && priority != nil {
+		k.value = strconv.Itoa(*priority) + " " + content
+	}
+
+	if _, err := hetzner.RRSetTypeFromString(k.typ); err != nil {
+		return recordKey{}, err
+	}
+	if k.name == "" || content == "" {
+		return recordKey{}, errors.New("name and content cannot be empty")
+	}
+	value, err := middleware.CanonicalValue(k.value, k.typ)
+	if err != nil {
+		return recordKey{}, err
+	}
+	k.value = value
+	return k, nil
+}
+
+// relativeName returns name relative to the zone. Like Cloudflare, names
+// not ending with the zone are considered relative to it.
+func relativeName(zoneName, name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == apexName || name == zoneName {
+		return apexName
+	}
+	return strings.TrimSuffix(name, "."+zoneName)
+}
+
+func newRecord(z *hcloud.Zone, k recordKey, ttl *int) record {
+	rec := record{
+		ID:       k.id(),
+		ZoneID:   strconv.FormatInt(z.ID, 10),
+		ZoneName: z.Name,
+		Name:     k.fqdn(z.Name),
+		Type:     k.typ,
+	}
+	rec.Priority, rec.Content = splitPriority(k.typ, k.value)
+	if ttl != nil {
+		rec.TTL = *ttl
+	}
+	return rec
+}
+
+// hasPriority returns true if Cloudflare returns the first field of records
+// of type typ separately as priority.
+func hasPriority(typ string) bool {
+	return typ == string(hcloud.ZoneRRSetTypeMX) || typ == string(hcloud.ZoneRRSetTypeSRV)
+}
+
+// splitPriority splits the priority off value if records of type typ have
+// one. Otherwise value is returned as content.
+func splitPriority(typ, value string) (priority *int, content string) {
+	if !hasPriority(typ) {
+		return nil, value
+	}
+	first, rest, ok := strings.Cut(value, " ")
+	if !ok {
+		return nil, value
+	}
+	p, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, value
+	}
+	return &p, rest
+}
+
+func matchesQuery(rec record, typ, name, content string) bool {
+	return (typ == "" || strings.EqualFold(rec.Type, typ)) &&
+		(name == "" || strings.EqualFold(rec.Name, strings.TrimSuffix(name, "."))) &&
+		(content == "" || rec.Content == content)
+}
+
+func logRequest(action string, reqData *data.ReqData) {
+	typ := sanitize.LogValue(reqData.Type)
+	name := sanitize.LogValue(reqData.FullName)
+	val := sanitize.LogValue(reqData.Value)
+	//nolint:gosec // values are sanitized above
+	log.Printf("received request to %s '%s' data of '%s' with value '%s'", action, typ, name, val)
+}
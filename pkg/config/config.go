@@ -1,15 +1,26 @@
 package config
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/apitoken"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/password"
 )
 
 type AllowedDomains map[string][]*net.IPNet
@@ -39,16 +50,29 @@ func (out *AllowedDomains) FromString(val string) error {
 type Config struct {
 	BaseURL              string         `yaml:"baseURL"`
 	Token                string         `yaml:"token"`
+	TokenFile            string         `yaml:"tokenFile,omitempty"`
 	Timeout              int            `yaml:"timeout"`
+	Projects             []Project      `yaml:"projects,omitempty"`
 	Auth                 Auth           `yaml:"auth"`
 	Endpoints            Endpoints      `yaml:"endpoints"`
 	RecordTTL            int            `yaml:"recordTTL"`
 	ListenAddr           string         `yaml:"listenAddr"`
+	TLS                  TLS            `yaml:"tls"`
 	TrustedProxies       []string       `yaml:"trustedProxies"`
 	TrustedProxyPrefixes []netip.Prefix `yaml:"-"`
-	RateLimit            RateLimit      `yaml:"rateLimit"`
-	Lockout              Lockout        `yaml:"lockout"`
-	Debug                bool           `yaml:"debug"`
+	// ClientCAs are the CAs read from TLS.ClientCA, forwarded client
+	// certificates are verified with them
+	ClientCAs       *x509.CertPool  `yaml:"-"`
+	RateLimit       RateLimit       `yaml:"rateLimit"`
+	Lockout         Lockout         `yaml:"lockout"`
+	APIBudget       APIBudget       `yaml:"apiBudget"`
+	ZoneDiscovery   ZoneDiscovery   `yaml:"zoneDiscovery"`
+	RFC2136         RFC2136         `yaml:"rfc2136"`
+	Metrics         Metrics         `yaml:"metrics"`
+	DynDNS          DynDNS          `yaml:"dyndns"`
+	ChallengeExpiry ChallengeExpiry `yaml:"challengeExpiry"`
+	Ownership       Ownership       `yaml:"ownership"`
+	Debug           bool            `yaml:"debug"`
 }
 
 type Endpoints struct {
@@ -57,6 +81,9 @@ type Endpoints struct {
 	AcmeDNS     bool `yaml:"acmedns"`
 	HTTPReq     bool `yaml:"httpreq"`
 	DirectAdmin bool `yaml:"directadmin"`
+	ExternalDNS bool `yaml:"externaldns"`
+	Cloudflare  bool `yaml:"cloudflare"`
+	PowerDNS    bool `yaml:"powerdns"`
 }
 
 func (e *Endpoints) Enabled() []string {
@@ -76,6 +103,15 @@ func (e *Endpoints) Enabled() []string {
 	if e.DirectAdmin {
 		names = append(names, EndpointDirectAdmin)
 	}
+	if e.ExternalDNS {
+		names = append(names, EndpointExternalDNS)
+	}
+	if e.Cloudflare {
+		names = append(names, EndpointCloudflare)
+	}
+	if e.PowerDNS {
+		names = append(names, EndpointPowerDNS)
+	}
 	return names
 }
 
@@ -92,7 +128,88 @@ func (e *Endpoints) UnmarshalYAML(unmarshal func(any) error) error {
 type Auth struct {
 	Method         string         `yaml:"method"`
 	AllowedDomains AllowedDomains `yaml:"allowedDomains"`
-	Users          []User         `yaml:"users"`
+	// AllowedDomainsRestrictions restricts the entries of AllowedDomains
+	// with the same key.
+	AllowedDomainsRestrictions map[string]Restrictions `yaml:"allowedDomainsRestrictions,omitempty"`
+	Users                      []User                  `yaml:"users"`
+	// ClientCertHeader is the header a TLS terminating proxy forwards the
+	// client certificate in, it is only accepted from TrustedProxies
+	ClientCertHeader string      `yaml:"clientCertHeader,omitempty"`
+	APITokens        []APIToken  `yaml:"apiTokens,omitempty"`
+	JWTIssuers       []JWTIssuer `yaml:"jwtIssuers,omitempty"`
+}
+
+// APIToken is an opaque bearer token with its own scope, accepted in the
+// Authorization header of JSON endpoints and as password of Basic auth.
+type APIToken struct {
+	// Hash is the SHA-256 hash of the token as generated by the
+	// generate-token subcommand
+	Hash        string `yaml:"hash"`
+	Description string `yaml:"description,omitempty"`
+	// Expires is the time the token expires at in RFC 3339 format or a
+	// date (expiring at its start in UTC), it never expires if empty
+	Expires   string    `yaml:"expires,omitempty"`
+	ExpiresAt time.Time `yaml:"-"`
+	Domains   []string  `yaml:"domains"`
+	// Endpoints are the endpoint groups the token is accepted on, all if
+	// empty
+	Endpoints    []string `yaml:"endpoints,omitempty"`
+	Restrictions `yaml:",inline"`
+	// Takeover allows changing rrsets not managed by the proxy in
+	// ownership mode
+	Takeover bool `yaml:"takeover,omitempty"`
+}
+
+// Expired returns true if the token is expired at now.
+func (t *APIToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// AllowsEndpoint returns true if the token is accepted on endpoint.
+func (t *APIToken) AllowsEndpoint(endpoint string) bool {
+	return len(t.Endpoints) == 0 || slices.Contains(t.Endpoints, endpoint)
+}
+
+// JWTIssuer is an issuer of JSON Web Tokens like the OIDC ID tokens minted
+// for CI jobs. Its tokens are verified with the keys of its JSON Web Key Set,
+// read from JWKSFile or fetched from JWKSURL, and may update the domains of
+// the rules whose claims they match.
+type JWTIssuer struct {
+	// Issuer is the iss claim of the tokens
+	Issuer string `yaml:"issuer"`
+	// Audience must be contained in the aud claim of the tokens
+	Audience string `yaml:"audience"`
+	JWKSFile string `yaml:"jwksFile,omitempty"`
+	JWKSURL  string `yaml:"jwksURL,omitempty"`
+	// JWKSRefreshSeconds is the time the keys are cached for
+	JWKSRefreshSeconds int           `yaml:"jwksRefreshSeconds,omitempty"`
+	Rules              []JWTRule     `yaml:"rules"`
+	Verifier           *jwt.Verifier `yaml:"-"`
+}
+
+const (
+	defaultJWKSRefreshSeconds = 3600
+	// jwksTimeout is the timeout of fetching a JWKS URL
+	jwksTimeout = 10 * time.Second
+)
+
+// JWTRule maps tokens whose claims match to the domains they may update.
+type JWTRule struct {
+	// Claims map claim names to patterns in the syntax of path.Match, all
+	// of which the claims of a token must match, e.g. project_path: group/*
+	Claims  map[string]string `yaml:"claims"`
+	Domains []string          `yaml:"domains"`
+	// Endpoints are the endpoint groups the rule applies to, all if empty
+	Endpoints    []string `yaml:"endpoints,omitempty"`
+	Restrictions `yaml:",inline"`
+	// Takeover allows changing rrsets not managed by the proxy in
+	// ownership mode
+	Takeover bool `yaml:"takeover,omitempty"`
+}
+
+// AllowsEndpoint returns true if the rule applies to endpoint.
+func (r *JWTRule) AllowsEndpoint(endpoint string) bool {
+	return len(r.Endpoints) == 0 || slices.Contains(r.Endpoints, endpoint)
 }
 
 const (
@@ -101,6 +218,9 @@ const (
 	EndpointAcmeDNS     = "acmedns"
 	EndpointHTTPReq     = "httpreq"
 	EndpointDirectAdmin = "directadmin"
+	EndpointExternalDNS = "externaldns"
+	EndpointCloudflare  = "cloudflare"
+	EndpointPowerDNS    = "powerdns"
 )
 
 const (
@@ -108,12 +228,67 @@ const (
 	AuthMethodUsers          = "users"
 	AuthMethodBoth           = "both"
 	AuthMethodAny            = "any"
+	AuthMethodClientCert     = "clientCert"
+	AuthMethodJWT            = "jwt"
 )
 
 type User struct {
-	Username string   `yaml:"username"`
-	Password string   `yaml:"password"`
-	Domains  []string `yaml:"domains"`
+	Username string `yaml:"username"`
+	// Password is either plaintext or a bcrypt or argon2id hash as
+	// generated by the hash-password subcommand
+	Password string `yaml:"password"`
+	// PasswordFile is the file Password is read from
+	PasswordFile string `yaml:"passwordFile,omitempty"`
+	// TokenHash is the SHA-256 hash of the bearer token of the user as
+	// generated by the generate-token subcommand, the token is accepted
+	// by the Cloudflare and PowerDNS endpoints
+	TokenHash string `yaml:"tokenHash,omitempty"`
+	// CertNames are subject common names or DNS names of client
+	// certificates identifying the user
+	CertNames []string `yaml:"certNames,omitempty"`
+	// CertFingerprints are SHA-256 fingerprints of client certificates
+	// identifying the user, hex encoded with optional colons
+	CertFingerprints []string `yaml:"certFingerprints,omitempty"`
+	Domains          []string `yaml:"domains"`
+	Restrictions     `yaml:",inline"`
+	// Takeover allows changing rrsets not managed by the proxy in
+	// ownership mode
+	Takeover bool `yaml:"takeover,omitempty"`
+}
+
+// DefaultRecordTypes are the record types that may be updated if no record
+// types are configured. Other types must be allowed explicitly.
+var DefaultRecordTypes = []string{"A", "AAAA", "TXT"}
+
+// Restrictions limit the records a user or allowed domain may update
+// further. Empty names do not restrict anything.
+type Restrictions struct {
+	// RecordTypes are the record types that may be updated,
+	// DefaultRecordTypes if empty
+	RecordTypes []string `yaml:"recordTypes,omitempty"`
+	// Names are patterns in the syntax of path.Match one of which the fqdn
+	// of an updated record must match, e.g. _acme-challenge.*
+	Names []string `yaml:"names,omitempty"`
+}
+
+// Allows returns true if records of type recordType of fqdn may be updated.
+func (r *Restrictions) Allows(fqdn, recordType string) bool {
+	recordTypes := r.RecordTypes
+	if len(recordTypes) == 0 {
+		recordTypes = DefaultRecordTypes
+	}
+	if !slices.Contains(recordTypes, recordType) {
+		return false
+	}
+	if len(r.Names) == 0 {
+		return true
+	}
+	for _, pattern := range r.Names {
+		if matched, err := path.Match(pattern, fqdn); err == nil && matched {
+			return true
+		}
+	}
+	return false
 }
 
 type RateLimit struct {
@@ -128,6 +303,126 @@ type Lockout struct {
 	WindowSeconds   int `yaml:"windowSeconds"`
 }
 
+// APIBudget configures the outbound limiter of requests to the Hetzner API.
+// Once the remaining requests of the token reported by the API drop to
+// Reserve, low priority requests of DynDNS clients are rejected, so ACME
+// challenges and other updates can still be made.
+type APIBudget struct {
+	Reserve int `yaml:"reserve"`
+}
+
+// Project is a Hetzner Cloud project whose zones are managed with its own API
+// token. Zones lists the names of its zones, a pattern like *.example.com
+// matches all zones below example.com. Zones of no project are managed with
+// the top-level token.
+type Project struct {
+	Name      string   `yaml:"name"`
+	Token     string   `yaml:"token"`
+	TokenFile string   `yaml:"tokenFile,omitempty"`
+	BaseURL   string   `yaml:"baseURL"`
+	Zones     []string `yaml:"zones"`
+}
+
+// TLS configures serving the proxy over HTTPS on ListenAddr. The certificate
+// is read from CertFile and KeyFile, which are read again once they changed,
+// or obtained from an ACME CA if ACME is enabled. If ClientCA is set, clients
+// must present a certificate signed by one of the CAs in it, unless
+// ClientCertOptional is set. Clients presenting a certificate are verified
+// in either case, as are certificates forwarded in Auth.ClientCertHeader.
+type TLS struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty"`
+	// MinVersion is the minimum TLS version accepted from clients, 1.2 or
+	// 1.3
+	MinVersion         string `yaml:"minVersion"`
+	ClientCA           string `yaml:"clientCA,omitempty"`
+	ClientCertOptional bool   `yaml:"clientCertOptional,omitempty"`
+	ACME               ACME   `yaml:"acme"`
+}
+
+// ACME configures obtaining the certificate of the proxy from an ACME CA
+// like Let's Encrypt. The DNS-01 challenges are presented in the zones
+// managed by the proxy, so Domains must belong to them. The account key and
+// the certificate are kept in CacheDir.
+type ACME struct {
+	Enabled            bool     `yaml:"enabled"`
+	Domains            []string `yaml:"domains,omitempty"`
+	Email              string   `yaml:"email,omitempty"`
+	DirectoryURL       string   `yaml:"directoryURL"`
+	CacheDir           string   `yaml:"cacheDir"`
+	RenewBeforeDays    int      `yaml:"renewBeforeDays"`
+	PropagationSeconds int      `yaml:"propagationSeconds"`
+}
+
+const (
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+)
+
+type ZoneDiscovery struct {
+	Enabled        bool `yaml:"enabled"`
+	RefreshSeconds int  `yaml:"refreshSeconds"`
+}
+
+type RFC2136 struct {
+	Enabled    bool      `yaml:"enabled"`
+	ListenAddr string    `yaml:"listenAddr"`
+	Keys       []TSIGKey `yaml:"keys,omitempty"`
+}
+
+// DynDNS configures the DynDNS2 endpoint. Hosts going offline get their A
+// and AAAA records switched to the parking addresses if set, otherwise the
+// rrsets are deleted.
+type DynDNS struct {
+	OfflineIPv4 string `yaml:"offlineIPv4,omitempty"`
+	OfflineIPv6 string `yaml:"offlineIPv6,omitempty"`
+}
+
+// ChallengeExpiry configures the removal of ACME challenge TXT values that
+// were never cleaned up. Values added by the proxy are remembered in
+// StateFile and removed once they are older than LifetimeSeconds.
+type ChallengeExpiry struct {
+	Enabled         bool   `yaml:"enabled"`
+	LifetimeSeconds int    `yaml:"lifetimeSeconds"`
+	IntervalSeconds int    `yaml:"intervalSeconds"`
+	StateFile       string `yaml:"stateFile"`
+}
+
+// Ownership configures the protection of rrsets managed by hand. If enabled,
+// rrsets created by the proxy are labeled, and rrsets without the label may
+// only be changed by users or TSIG keys with takeover permission.
+type Ownership struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// Metrics configures the optional listener serving Prometheus metrics on
+// /metrics. It is separate from ListenAddr, so metrics are not exposed to
+// clients of the proxy.
+type Metrics struct {
+	Enabled    bool   `yaml:"enabled"`
+	ListenAddr string `yaml:"listenAddr"`
+}
+
+type TSIGKey struct {
+	Name         string   `yaml:"name"`
+	Algorithm    string   `yaml:"algorithm"`
+	Secret       string   `yaml:"secret"`
+	Domains      []string `yaml:"domains"`
+	Restrictions `yaml:",inline"`
+	// Takeover allows changing rrsets not managed by the proxy in
+	// ownership mode
+	Takeover bool `yaml:"takeover,omitempty"`
+}
+
+const (
+	TSIGAlgorithmHMACSHA1   = "hmac-sha1"
+	TSIGAlgorithmHMACSHA224 = "hmac-sha224"
+	TSIGAlgorithmHMACSHA256 = "hmac-sha256"
+	TSIGAlgorithmHMACSHA384 = "hmac-sha384"
+	TSIGAlgorithmHMACSHA512 = "hmac-sha512"
+)
+
 func NewConfig() *Config {
 	return &Config{
 		Timeout: 60,
@@ -143,6 +438,16 @@ func NewConfig() *Config {
 		},
 		RecordTTL:  60,
 		ListenAddr: ":8081",
+		TLS: TLS{
+			Enabled:    false,
+			MinVersion: TLSVersion12,
+			ACME: ACME{
+				Enabled:            false,
+				DirectoryURL:       "https://acme-v02.api.letsencrypt.org/directory",
+				RenewBeforeDays:    30,
+				PropagationSeconds: 60,
+			},
+		},
 		RateLimit: RateLimit{
 			RPS:         5,
 			Burst:       10,
@@ -153,6 +458,29 @@ func NewConfig() *Config {
 			DurationSeconds: 3600,
 			WindowSeconds:   900,
 		},
+		APIBudget: APIBudget{
+			Reserve: 100,
+		},
+		ZoneDiscovery: ZoneDiscovery{
+			Enabled:        false,
+			RefreshSeconds: 300,
+		},
+		RFC2136: RFC2136{
+			Enabled:    false,
+			ListenAddr: ":5353",
+		},
+		Metrics: Metrics{
+			Enabled:    false,
+			ListenAddr: ":9090",
+		},
+		ChallengeExpiry: ChallengeExpiry{
+			Enabled:         false,
+			LifetimeSeconds: 3600,
+			IntervalSeconds: 60,
+		},
+		Ownership: Ownership{
+			Enabled: false,
+		},
 		Debug: false,
 	}
 }
@@ -163,13 +491,8 @@ func ParseEnv() (*Config, error) {
 
 	envString("API_BASE_URL", &cfg.BaseURL)
 
-	token, ok := os.LookupEnv("API_TOKEN")
-	if !ok {
-		return nil, errors.New("API_TOKEN environment variable not set")
-	}
-	cfg.Token = token
-	if err := os.Unsetenv("API_TOKEN"); err != nil {
-		return nil, fmt.Errorf("failed to unset API_TOKEN: %v", err)
+	if err := envToken(cfg); err != nil {
+		return nil, err
 	}
 
 	if err := envInt("API_TIMEOUT", &cfg.Timeout); err != nil {
@@ -203,6 +526,9 @@ func ParseEnv() (*Config, error) {
 	if err := envEndpoints(&cfg.Endpoints); err != nil {
 		return nil, err
 	}
+	if err := envFeatures(cfg); err != nil {
+		return nil, err
+	}
 
 	prefixes, parseErr := parseTrustedProxies(cfg.TrustedProxies)
 	if parseErr != nil {
@@ -215,6 +541,45 @@ func ParseEnv() (*Config, error) {
 	return cfg, nil
 }
 
+// envFeatures parses and validates the settings of optional features.
+func envFeatures(cfg *Config) error {
+	if err := envInt("API_BUDGET_RESERVE", &cfg.APIBudget.Reserve); err != nil {
+		return err
+	}
+	if err := validateAPIBudget(&cfg.APIBudget); err != nil {
+		return err
+	}
+	if err := envZoneDiscovery(&cfg.ZoneDiscovery); err != nil {
+		return err
+	}
+	if err := validateZoneDiscovery(&cfg.ZoneDiscovery); err != nil {
+		return err
+	}
+	if err := envMetrics(&cfg.Metrics); err != nil {
+		return err
+	}
+	if err := validateMetrics(&cfg.Metrics, cfg.ListenAddr); err != nil {
+		return err
+	}
+	envDynDNS(&cfg.DynDNS)
+	if err := validateDynDNS(&cfg.DynDNS); err != nil {
+		return err
+	}
+	if err := envChallengeExpiry(&cfg.ChallengeExpiry); err != nil {
+		return err
+	}
+	if err := validateChallengeExpiry(&cfg.ChallengeExpiry); err != nil {
+		return err
+	}
+	if err := envTLS(&cfg.TLS); err != nil {
+		return err
+	}
+	if err := validateTLS(&cfg.TLS); err != nil {
+		return err
+	}
+	return envBool("OWNERSHIP", &cfg.Ownership.Enabled)
+}
+
 func envString(key string, dst *string) {
 	if v, ok := os.LookupEnv(key); ok {
 		*dst = v
@@ -280,6 +645,63 @@ func envLockout(l *Lockout) error {
 	return envInt("LOCKOUT_WINDOW_SECONDS", &l.WindowSeconds)
 }
 
+func envZoneDiscovery(zd *ZoneDiscovery) error {
+	if err := envBool("ZONE_DISCOVERY", &zd.Enabled); err != nil {
+		return err
+	}
+	return envInt("ZONE_DISCOVERY_REFRESH_SECONDS", &zd.RefreshSeconds)
+}
+
+func envMetrics(m *Metrics) error {
+	if err := envBool("METRICS", &m.Enabled); err != nil {
+		return err
+	}
+	envString("METRICS_LISTEN_ADDR", &m.ListenAddr)
+	return nil
+}
+
+func envDynDNS(d *DynDNS) {
+	envString("DYNDNS_OFFLINE_IPV4", &d.OfflineIPv4)
+	envString("DYNDNS_OFFLINE_IPV6", &d.OfflineIPv6)
+}
+
+func envChallengeExpiry(ce *ChallengeExpiry) error {
+	if err := envBool("CHALLENGE_EXPIRY", &ce.Enabled); err != nil {
+		return err
+	}
+	if err := envInt("CHALLENGE_EXPIRY_LIFETIME_SECONDS", &ce.LifetimeSeconds); err != nil {
+		return err
+	}
+	if err := envInt("CHALLENGE_EXPIRY_INTERVAL_SECONDS", &ce.IntervalSeconds); err != nil {
+		return err
+	}
+	envString("CHALLENGE_EXPIRY_STATE_FILE", &ce.StateFile)
+	return nil
+}
+
+func envTLS(t *TLS) error {
+	if err := envBool("TLS", &t.Enabled); err != nil {
+		return err
+	}
+	envString("TLS_CERT_FILE", &t.CertFile)
+	envString("TLS_KEY_FILE", &t.KeyFile)
+	envString("TLS_MIN_VERSION", &t.MinVersion)
+	envString("TLS_CLIENT_CA", &t.ClientCA)
+	if err := envBool("TLS_CLIENT_CERT_OPTIONAL", &t.ClientCertOptional); err != nil {
+		return err
+	}
+	if err := envBool("ACME", &t.ACME.Enabled); err != nil {
+		return err
+	}
+	if v, ok := os.LookupEnv("ACME_DOMAINS"); ok {
+		t.ACME.Domains = strings.Split(v, ",")
+	}
+	envString("ACME_EMAIL", &t.ACME.Email)
+	envString("ACME_DIRECTORY_URL", &t.ACME.DirectoryURL)
+	envString("ACME_CACHE_DIR", &t.ACME.CacheDir)
+	return nil
+}
+
 func envEndpoints(endpoints *Endpoints) error {
 	v, ok := os.LookupEnv("ENDPOINTS")
 	if !ok {
@@ -299,6 +721,12 @@ func envEndpoints(endpoints *Endpoints) error {
 			endpoints.HTTPReq = true
 		case EndpointDirectAdmin:
 			endpoints.DirectAdmin = true
+		case EndpointExternalDNS:
+			endpoints.ExternalDNS = true
+		case EndpointCloudflare:
+			endpoints.Cloudflare = true
+		case EndpointPowerDNS:
+			endpoints.PowerDNS = true
 		default:
 			return fmt.Errorf("invalid endpoint %q in ENDPOINTS", name)
 		}
@@ -328,17 +756,10 @@ func ReadFile(path string) (*Config, error) {
 		return nil, err
 	}
 
-	if cfg.Token == "" {
-		return nil, errors.New("token is required")
-	}
-
-	if err := validateRateLimit(&cfg.RateLimit); err != nil {
+	if err := readSecrets(cfg); err != nil {
 		return nil, err
 	}
-	if err := validateLockout(&cfg.Lockout); err != nil {
-		return nil, err
-	}
-	if err := validateAuth(&cfg.Auth); err != nil {
+	if err := validate(cfg); err != nil {
 		return nil, err
 	}
 	prefixes, parseErr := parseTrustedProxies(cfg.TrustedProxies)
@@ -353,6 +774,44 @@ func ReadFile(path string) (*Config, error) {
 	return cfg, nil
 }
 
+// validate validates the settings of a config file.
+func validate(cfg *Config) error {
+	if err := validateProjects(cfg); err != nil {
+		return err
+	}
+	if err := validateRateLimit(&cfg.RateLimit); err != nil {
+		return err
+	}
+	if err := validateLockout(&cfg.Lockout); err != nil {
+		return err
+	}
+	if err := validateAPIBudget(&cfg.APIBudget); err != nil {
+		return err
+	}
+	if err := validateAuth(&cfg.Auth); err != nil {
+		return err
+	}
+	if err := validateClientCerts(cfg); err != nil {
+		return err
+	}
+	if err := validateZoneDiscovery(&cfg.ZoneDiscovery); err != nil {
+		return err
+	}
+	if err := validateRFC2136(&cfg.RFC2136); err != nil {
+		return err
+	}
+	if err := validateMetrics(&cfg.Metrics, cfg.ListenAddr); err != nil {
+		return err
+	}
+	if err := validateDynDNS(&cfg.DynDNS); err != nil {
+		return err
+	}
+	if err := validateChallengeExpiry(&cfg.ChallengeExpiry); err != nil {
+		return err
+	}
+	return validateTLS(&cfg.TLS)
+}
+
 func validateAuth(a *Auth) error {
 	if !AuthMethodIsValid(a.Method) {
 		return fmt.Errorf("invalid auth method: %s", a.Method)
@@ -360,15 +819,238 @@ func validateAuth(a *Auth) error {
 	if len(a.AllowedDomains) == 0 && (a.Method == AuthMethodAllowedDomains || a.Method == AuthMethodBoth) {
 		return fmt.Errorf("auth.allowedDomains cannot be empty with auth method %s", a.Method)
 	}
-	if len(a.Users) == 0 && (a.Method == AuthMethodUsers || a.Method == AuthMethodBoth) {
+	noUsers := len(a.Users) == 0 && len(a.APITokens) == 0 && len(a.JWTIssuers) == 0
+	if noUsers && (a.Method == AuthMethodUsers || a.Method == AuthMethodBoth) {
+		return fmt.Errorf("auth.users, auth.apiTokens and auth.jwtIssuers cannot all be empty with auth method %s", a.Method)
+	}
+	if len(a.Users) == 0 && a.Method == AuthMethodClientCert {
 		return fmt.Errorf("auth.users cannot be empty with auth method %s", a.Method)
 	}
-	if len(a.AllowedDomains) == 0 && len(a.Users) == 0 && a.Method == AuthMethodAny {
-		return errors.New("auth.allowedDomains or auth.users cannot both be empty with auth method any")
+	if len(a.JWTIssuers) == 0 && a.Method == AuthMethodJWT {
+		return fmt.Errorf("auth.jwtIssuers cannot be empty with auth method %s", a.Method)
+	}
+	if len(a.AllowedDomains) == 0 && noUsers && a.Method == AuthMethodAny {
+		return errors.New("auth.allowedDomains, auth.users, auth.apiTokens and auth.jwtIssuers cannot all be empty with auth method any")
+	}
+	return validateAuthEntries(a)
+}
+
+// validateAuthEntries validates the users, API tokens, JWT issuers and
+// restrictions of allowed domains of a.
+func validateAuthEntries(a *Auth) error {
+	for i := range a.Users {
+		if err := validateUser(fmt.Sprintf("auth.users[%d]", i), &a.Users[i]); err != nil {
+			return err
+		}
+	}
+	for i := range a.APITokens {
+		if err := validateAPIToken(fmt.Sprintf("auth.apiTokens[%d]", i), &a.APITokens[i]); err != nil {
+			return err
+		}
+	}
+	for i := range a.JWTIssuers {
+		if err := validateJWTIssuer(fmt.Sprintf("auth.jwtIssuers[%d]", i), &a.JWTIssuers[i]); err != nil {
+			return err
+		}
+	}
+	for domain, r := range a.AllowedDomainsRestrictions {
+		if _, ok := a.AllowedDomains[domain]; !ok {
+			return fmt.Errorf("auth.allowedDomainsRestrictions contains %s which is not in auth.allowedDomains", domain)
+		}
+		if err := validateRestrictions("auth.allowedDomainsRestrictions."+domain, &r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateUser validates u and converts its token hash to lower case.
+func validateUser(field string, u *User) error {
+	if err := password.Validate(u.Password); err != nil {
+		return fmt.Errorf("%s.password: %w", field, err)
+	}
+	if u.TokenHash != "" {
+		u.TokenHash = strings.ToLower(u.TokenHash)
+		if err := apitoken.Validate(u.TokenHash); err != nil {
+			return fmt.Errorf("%s.tokenHash: %w", field, err)
+		}
+	}
+	if err := validateRestrictions(field, &u.Restrictions); err != nil {
+		return err
+	}
+	return validateCertFingerprints(field, u.CertFingerprints)
+}
+
+// validateRestrictions validates r and converts its record types to upper
+// case.
+func validateRestrictions(field string, r *Restrictions) error {
+	for i, recordType := range r.RecordTypes {
+		if recordType == "" {
+			return fmt.Errorf("%s.recordTypes cannot contain empty types", field)
+		}
+		r.RecordTypes[i] = strings.ToUpper(recordType)
+	}
+	for _, pattern := range r.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s.names contains invalid pattern %q: %w", field, pattern, err)
+		}
 	}
 	return nil
 }
 
+// validateAPIToken validates t, converts its hash to lower case and parses
+// its expiry.
+func validateAPIToken(field string, t *APIToken) error {
+	t.Hash = strings.ToLower(t.Hash)
+	if err := apitoken.Validate(t.Hash); err != nil {
+		return fmt.Errorf("%s.hash: %w", field, err)
+	}
+	if len(t.Domains) == 0 {
+		return fmt.Errorf("%s.domains cannot be empty", field)
+	}
+	for _, endpoint := range t.Endpoints {
+		if !EndpointIsValid(endpoint) {
+			return fmt.Errorf("%s.endpoints contains invalid endpoint %q", field, endpoint)
+		}
+	}
+	t.ExpiresAt = time.Time{}
+	if t.Expires != "" {
+		expiresAt, err := time.Parse(time.RFC3339, t.Expires)
+		if err != nil {
+			expiresAt, err = time.Parse(time.DateOnly, t.Expires)
+		}
+		if err != nil {
+			return fmt.Errorf("%s.expires must be an RFC 3339 time or a date: %s", field, t.Expires)
+		}
+		t.ExpiresAt = expiresAt
+	}
+	return validateRestrictions(field, &t.Restrictions)
+}
+
+// validateJWTIssuer validates i and creates its verifier. Keys of a JWKS
+// file are loaded right away, keys of a JWKS URL on the first token.
+func validateJWTIssuer(field string, i *JWTIssuer) error {
+	if i.Issuer == "" {
+		return fmt.Errorf("%s.issuer cannot be empty", field)
+	}
+	if i.Audience == "" {
+		return fmt.Errorf("%s.audience cannot be empty", field)
+	}
+	if (i.JWKSFile == "") == (i.JWKSURL == "") {
+		return fmt.Errorf("%s must set exactly one of jwksFile and jwksURL", field)
+	}
+	if i.JWKSRefreshSeconds < 0 {
+		return fmt.Errorf("%s.jwksRefreshSeconds must be >= 0", field)
+	}
+	if i.JWKSRefreshSeconds == 0 {
+		i.JWKSRefreshSeconds = defaultJWKSRefreshSeconds
+	}
+	if len(i.Rules) == 0 {
+		return fmt.Errorf("%s.rules cannot be empty", field)
+	}
+	for j := range i.Rules {
+		if err := validateJWTRule(fmt.Sprintf("%s.rules[%d]", field, j), &i.Rules[j]); err != nil {
+			return err
+		}
+	}
+
+	refresh := time.Duration(i.JWKSRefreshSeconds) * time.Second
+	if i.JWKSFile != "" {
+		i.Verifier = jwt.NewVerifier(i.Issuer, i.Audience, jwt.File(i.JWKSFile), refresh)
+		if err := i.Verifier.Refresh(); err != nil {
+			return fmt.Errorf("%s.jwksFile: %w", field, err)
+		}
+		return nil
+	}
+	i.Verifier = jwt.NewVerifier(i.Issuer, i.Audience, jwt.URL(i.JWKSURL, jwksTimeout), refresh)
+	return nil
+}
+
+// validateJWTRule validates r. Rules must match claims, otherwise any token
+// of the issuer would match, e.g. of any repository on GitHub.
+func validateJWTRule(field string, r *JWTRule) error {
+	if len(r.Claims) == 0 {
+		return fmt.Errorf("%s.claims cannot be empty", field)
+	}
+	for claim, pattern := range r.Claims {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s.claims.%s is an invalid pattern %q: %w", field, claim, pattern, err)
+		}
+	}
+	if len(r.Domains) == 0 {
+		return fmt.Errorf("%s.domains cannot be empty", field)
+	}
+	for _, endpoint := range r.Endpoints {
+		if !EndpointIsValid(endpoint) {
+			return fmt.Errorf("%s.endpoints contains invalid endpoint %q", field, endpoint)
+		}
+	}
+	return validateRestrictions(field, &r.Restrictions)
+}
+
+// validateCertFingerprints validates fingerprints and converts them to lower
+// case hex without colons.
+func validateCertFingerprints(field string, fingerprints []string) error {
+	for i, fingerprint := range fingerprints {
+		normalized := strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+		if b, err := hex.DecodeString(normalized); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("%s.certFingerprints[%d] is not a SHA-256 fingerprint: %s", field, i, fingerprint)
+		}
+		fingerprints[i] = normalized
+	}
+	return nil
+}
+
+// validateClientCerts ensures that client certificates can reach the proxy
+// if users are identified by them.
+func validateClientCerts(cfg *Config) error {
+	certUsers := false
+	for i := range cfg.Auth.Users {
+		if len(cfg.Auth.Users[i].CertNames) > 0 || len(cfg.Auth.Users[i].CertFingerprints) > 0 {
+			certUsers = true
+		}
+	}
+	if cfg.Auth.Method == AuthMethodClientCert && !certUsers {
+		return errors.New("auth.users must identify users by certNames or certFingerprints with auth method clientCert")
+	}
+	if certUsers && (!cfg.TLS.Enabled || cfg.TLS.ClientCA == "") && cfg.Auth.ClientCertHeader == "" {
+		return errors.New("tls.clientCA or auth.clientCertHeader must be set to identify users by client certificates")
+	}
+	if cfg.Auth.ClientCertHeader == "" {
+		return nil
+	}
+	if cfg.TLS.ClientCA == "" {
+		// Forwarded certificates cannot be verified, their names could be
+		// chosen freely.
+		for i := range cfg.Auth.Users {
+			if len(cfg.Auth.Users[i].CertNames) > 0 {
+				return fmt.Errorf("auth.users[%d].certNames require tls.clientCA with auth.clientCertHeader, use certFingerprints", i)
+			}
+		}
+		return nil
+	}
+	pool, err := readCertPool(cfg.TLS.ClientCA)
+	if err != nil {
+		return fmt.Errorf("tls.clientCA: %w", err)
+	}
+	cfg.ClientCAs = pool
+	return nil
+}
+
+// readCertPool returns a pool of the PEM encoded certificates in the file at
+// path.
+func readCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s contains no certificates", path)
+	}
+	return pool, nil
+}
+
 func validateRateLimit(rl *RateLimit) error {
 	if rl.RPS <= 0 {
 		return errors.New("rateLimit.rps must be > 0")
@@ -418,17 +1100,214 @@ func validateLockout(l *Lockout) error {
 	return nil
 }
 
+func validateProjects(cfg *Config) error {
+	if cfg.Token == "" && len(cfg.Projects) == 0 {
+		return errors.New("token is required")
+	}
+	names := map[string]struct{}{}
+	for i := range cfg.Projects {
+		p := &cfg.Projects[i]
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("projects[%d]", i)
+		}
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("projects contains %s more than once", p.Name)
+		}
+		names[p.Name] = struct{}{}
+		if p.Token == "" {
+			return fmt.Errorf("projects[%d].token is required", i)
+		}
+		if len(p.Zones) == 0 {
+			return fmt.Errorf("projects[%d].zones cannot be empty", i)
+		}
+		for j, zone := range p.Zones {
+			zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+			if name := strings.TrimPrefix(zone, "*."); name == "" || strings.Contains(name, "*") {
+				return fmt.Errorf("projects[%d].zones[%d] is not a valid zone or pattern: %s", i, j, p.Zones[j])
+			}
+			p.Zones[j] = zone
+		}
+	}
+	return nil
+}
+
+func validateAPIBudget(b *APIBudget) error {
+	if b.Reserve < 0 {
+		return errors.New("apiBudget.reserve must be >= 0")
+	}
+	return nil
+}
+
+func validateZoneDiscovery(zd *ZoneDiscovery) error {
+	if zd.Enabled && zd.RefreshSeconds <= 0 {
+		return errors.New("zoneDiscovery.refreshSeconds must be > 0")
+	}
+	return nil
+}
+
+func validateRFC2136(r *RFC2136) error {
+	if !r.Enabled {
+		return nil
+	}
+	if r.ListenAddr == "" {
+		return errors.New("rfc2136.listenAddr cannot be empty when rfc2136 is enabled")
+	}
+	if len(r.Keys) == 0 {
+		return errors.New("rfc2136.keys cannot be empty when rfc2136 is enabled")
+	}
+	for i := range r.Keys {
+		if err := validateTSIGKey(&r.Keys[i]); err != nil {
+			return fmt.Errorf("invalid rfc2136.keys entry %d: %w", i, err)
+		}
+		if err := validateRestrictions(fmt.Sprintf("rfc2136.keys[%d]", i), &r.Keys[i].Restrictions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateMetrics(m *Metrics, listenAddr string) error {
+	if !m.Enabled {
+		return nil
+	}
+	if m.ListenAddr == "" {
+		return errors.New("metrics.listenAddr cannot be empty when metrics are enabled")
+	}
+	if m.ListenAddr == listenAddr {
+		return errors.New("metrics.listenAddr cannot be the same as listenAddr")
+	}
+	return nil
+}
+
+func validateDynDNS(d *DynDNS) error {
+	if d.OfflineIPv4 != "" {
+		if ip := net.ParseIP(d.OfflineIPv4); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("dyndns.offlineIPv4 %q is not an ipv4 address", d.OfflineIPv4)
+		}
+	}
+	if d.OfflineIPv6 != "" {
+		if ip := net.ParseIP(d.OfflineIPv6); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("dyndns.offlineIPv6 %q is not an ipv6 address", d.OfflineIPv6)
+		}
+	}
+	return nil
+}
+
+func validateChallengeExpiry(ce *ChallengeExpiry) error {
+	if !ce.Enabled {
+		return nil
+	}
+	if ce.LifetimeSeconds <= 0 {
+		return errors.New("challengeExpiry.lifetimeSeconds must be > 0")
+	}
+	if ce.IntervalSeconds <= 0 {
+		return errors.New("challengeExpiry.intervalSeconds must be > 0")
+	}
+	if ce.StateFile == "" {
+		return errors.New("challengeExpiry.stateFile cannot be empty when challenge expiry is enabled")
+	}
+	return nil
+}
+
+func validateTLS(t *TLS) error {
+	if !t.Enabled {
+		return nil
+	}
+	if t.MinVersion != TLSVersion12 && t.MinVersion != TLSVersion13 {
+		return fmt.Errorf("tls.minVersion must be %s or %s", TLSVersion12, TLSVersion13)
+	}
+	if t.ACME.Enabled {
+		if t.CertFile != "" || t.KeyFile != "" {
+			return errors.New("tls.certFile and tls.keyFile cannot be set when tls.acme is enabled")
+		}
+		return validateACME(&t.ACME)
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		return errors.New("tls.certFile and tls.keyFile cannot be empty when tls is enabled without tls.acme")
+	}
+	return nil
+}
+
+// validateACME validates a and converts its domains to lower case without
+// trailing dot.
+func validateACME(a *ACME) error {
+	if len(a.Domains) == 0 {
+		return errors.New("tls.acme.domains cannot be empty when tls.acme is enabled")
+	}
+	for i, domain := range a.Domains {
+		domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+		if name := strings.TrimPrefix(domain, "*."); name == "" || strings.Contains(name, "*") {
+			return fmt.Errorf("tls.acme.domains[%d] is not a valid domain: %s", i, a.Domains[i])
+		}
+		a.Domains[i] = domain
+	}
+	if a.DirectoryURL == "" {
+		return errors.New("tls.acme.directoryURL cannot be empty when tls.acme is enabled")
+	}
+	if a.CacheDir == "" {
+		return errors.New("tls.acme.cacheDir cannot be empty when tls.acme is enabled")
+	}
+	if a.RenewBeforeDays <= 0 {
+		return errors.New("tls.acme.renewBeforeDays must be > 0")
+	}
+	if a.PropagationSeconds < 0 {
+		return errors.New("tls.acme.propagationSeconds must be >= 0")
+	}
+	return nil
+}
+
+func validateTSIGKey(k *TSIGKey) error {
+	if k.Name == "" {
+		return errors.New("name cannot be empty")
+	}
+	if k.Secret == "" {
+		return errors.New("secret cannot be empty")
+	}
+	if _, err := base64.StdEncoding.DecodeString(k.Secret); err != nil {
+		return fmt.Errorf("secret must be base64 encoded: %w", err)
+	}
+	if len(k.Domains) == 0 {
+		return errors.New("domains cannot be empty")
+	}
+	k.Algorithm = strings.ToLower(strings.TrimSuffix(k.Algorithm, "."))
+	switch k.Algorithm {
+	case "":
+		k.Algorithm = TSIGAlgorithmHMACSHA256
+	case TSIGAlgorithmHMACSHA1, TSIGAlgorithmHMACSHA224, TSIGAlgorithmHMACSHA256,
+		TSIGAlgorithmHMACSHA384, TSIGAlgorithmHMACSHA512:
+	default:
+		return fmt.Errorf("unsupported algorithm %s", k.Algorithm)
+	}
+	return nil
+}
+
+// EndpointIsValid returns true if name is the name of an endpoint group.
+func EndpointIsValid(name string) bool {
+	all := Endpoints{
+		Plain: true, Nic: true, AcmeDNS: true, HTTPReq: true,
+		DirectAdmin: true, ExternalDNS: true, Cloudflare: true, PowerDNS: true,
+	}
+	return slices.Contains(all.Enabled(), name)
+}
+
 func AuthMethodIsValid(authMethod string) bool {
 	return authMethod == AuthMethodAllowedDomains ||
 		authMethod == AuthMethodUsers ||
 		authMethod == AuthMethodBoth ||
-		authMethod == AuthMethodAny
+		authMethod == AuthMethodAny ||
+		authMethod == AuthMethodClientCert ||
+		authMethod == AuthMethodJWT
 }
 
 func setDefaultBaseURL(c *Config) {
 	if c.BaseURL == "" {
 		c.BaseURL = "https://api.hetzner.cloud/v1"
 	}
+	for i := range c.Projects {
+		if c.Projects[i].BaseURL == "" {
+			c.Projects[i].BaseURL = c.BaseURL
+		}
+	}
 }
 
 func setDefaultIPMask(allowedDomains AllowedDomains) {
@@ -5,12 +5,15 @@ import (
 	"net/netip"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
 	"github.com/goccy/go-yaml"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/apitoken"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 )
 
@@ -144,6 +147,27 @@ var _ = Describe("Config", func() {
 			envListenAddr     = "LISTEN_ADDR"
 			envTrustedProxies = "TRUSTED_PROXIES"
 			envDebug          = "DEBUG"
+
+			envZoneDiscovery               = "ZONE_DISCOVERY"
+			envZoneDiscoveryRefreshSeconds = "ZONE_DISCOVERY_REFRESH_SECONDS"
+			envMetrics                     = "METRICS"
+			envMetricsListenAddr           = "METRICS_LISTEN_ADDR"
+			envDynDNSOfflineIPv4           = "DYNDNS_OFFLINE_IPV4"
+			envDynDNSOfflineIPv6           = "DYNDNS_OFFLINE_IPV6"
+			envChallengeExpiry             = "CHALLENGE_EXPIRY"
+			envChallengeExpiryLifetime     = "CHALLENGE_EXPIRY_LIFETIME_SECONDS"
+			envChallengeExpiryStateFile    = "CHALLENGE_EXPIRY_STATE_FILE"
+			envOwnership                   = "OWNERSHIP"
+			envAPIBudgetReserve            = "API_BUDGET_RESERVE"
+			envAPITokenFile                = "API_TOKEN_FILE"
+			envCredentialsDirectory        = "CREDENTIALS_DIRECTORY"
+			envTLS                         = "TLS"
+			envTLSCertFile                 = "TLS_CERT_FILE"
+			envTLSKeyFile                  = "TLS_KEY_FILE"
+			envTLSMinVersion               = "TLS_MIN_VERSION"
+			envACME                        = "ACME"
+			envACMEDomains                 = "ACME_DOMAINS"
+			envACMECacheDir                = "ACME_CACHE_DIR"
 		)
 
 		BeforeEach(func() {
@@ -166,6 +190,26 @@ var _ = Describe("Config", func() {
 			Expect(os.Unsetenv(envListenAddr)).To(Succeed())
 			Expect(os.Unsetenv(envTrustedProxies)).To(Succeed())
 			Expect(os.Unsetenv(envDebug)).To(Succeed())
+			Expect(os.Unsetenv(envZoneDiscovery)).To(Succeed())
+			Expect(os.Unsetenv(envZoneDiscoveryRefreshSeconds)).To(Succeed())
+			Expect(os.Unsetenv(envMetrics)).To(Succeed())
+			Expect(os.Unsetenv(envMetricsListenAddr)).To(Succeed())
+			Expect(os.Unsetenv(envDynDNSOfflineIPv4)).To(Succeed())
+			Expect(os.Unsetenv(envDynDNSOfflineIPv6)).To(Succeed())
+			Expect(os.Unsetenv(envChallengeExpiry)).To(Succeed())
+			Expect(os.Unsetenv(envChallengeExpiryLifetime)).To(Succeed())
+			Expect(os.Unsetenv(envChallengeExpiryStateFile)).To(Succeed())
+			Expect(os.Unsetenv(envOwnership)).To(Succeed())
+			Expect(os.Unsetenv(envAPIBudgetReserve)).To(Succeed())
+			Expect(os.Unsetenv(envAPITokenFile)).To(Succeed())
+			Expect(os.Unsetenv(envCredentialsDirectory)).To(Succeed())
+			Expect(os.Unsetenv(envTLS)).To(Succeed())
+			Expect(os.Unsetenv(envTLSCertFile)).To(Succeed())
+			Expect(os.Unsetenv(envTLSKeyFile)).To(Succeed())
+			Expect(os.Unsetenv(envTLSMinVersion)).To(Succeed())
+			Expect(os.Unsetenv(envACME)).To(Succeed())
+			Expect(os.Unsetenv(envACMEDomains)).To(Succeed())
+			Expect(os.Unsetenv(envACMECacheDir)).To(Succeed())
 		})
 
 		It("should parse environment successfully", func() {
@@ -194,6 +238,116 @@ var _ = Describe("Config", func() {
 			Expect(cfg.Debug).To(BeTrue())
 		})
 
+		It("should parse metrics settings", func() {
+			Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+			Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+			Expect(os.Setenv(envMetrics, "true")).To(Succeed())
+			Expect(os.Setenv(envMetricsListenAddr, "127.0.0.1:9100")).To(Succeed())
+
+			cfg, err := config.ParseEnv()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Metrics).To(Equal(config.Metrics{Enabled: true, ListenAddr: "127.0.0.1:9100"}))
+		})
+
+		It("should parse dyndns settings", func() {
+			Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+			Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+			Expect(os.Setenv(envDynDNSOfflineIPv4, "192.0.2.1")).To(Succeed())
+			Expect(os.Setenv(envDynDNSOfflineIPv6, "2001:db8::1")).To(Succeed())
+
+			cfg, err := config.ParseEnv()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.DynDNS).To(Equal(config.DynDNS{OfflineIPv4: "192.0.2.1", OfflineIPv6: "2001:db8::1"}))
+		})
+
+		It("should parse challenge expiry settings", func() {
+			Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+			Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+			Expect(os.Setenv(envChallengeExpiry, "true")).To(Succeed())
+			Expect(os.Setenv(envChallengeExpiryLifetime, "7200")).To(Succeed())
+			Expect(os.Setenv(envChallengeExpiryStateFile, "/var/lib/proxy/challenges.json")).To(Succeed())
+
+			cfg, err := config.ParseEnv()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.ChallengeExpiry).To(Equal(config.ChallengeExpiry{
+				Enabled:         true,
+				LifetimeSeconds: 7200,
+				IntervalSeconds: 60,
+				StateFile:       "/var/lib/proxy/challenges.json",
+			}))
+		})
+
+		It("should parse ownership settings", func() {
+			Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+			Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+			Expect(os.Setenv(envOwnership, "true")).To(Succeed())
+
+			cfg, err := config.ParseEnv()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Ownership).To(Equal(config.Ownership{Enabled: true}))
+		})
+
+		It("should parse api budget settings", func() {
+			Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+			Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+			Expect(os.Setenv(envAPIBudgetReserve, "250")).To(Succeed())
+
+			cfg, err := config.ParseEnv()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.APIBudget).To(Equal(config.APIBudget{Reserve: 250}))
+		})
+
+		It("should read the token from API_TOKEN_FILE", func() {
+			tokenFile := path.Join(GinkgoT().TempDir(), "token")
+			Expect(os.WriteFile(tokenFile, []byte(apiToken+"\n"), 0o600)).To(Succeed())
+			Expect(os.Setenv(envAPITokenFile, tokenFile)).To(Succeed())
+			Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+
+			cfg, err := config.ParseEnv()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Token).To(Equal(apiToken))
+			Expect(cfg.TokenFile).To(Equal(tokenFile))
+			_, ok := os.LookupEnv(envAPITokenFile)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should read API_TOKEN_FILE from the credentials directory", func() {
+			dir := GinkgoT().TempDir()
+			Expect(os.WriteFile(path.Join(dir, "api-token"), []byte(apiToken), 0o600)).To(Succeed())
+			Expect(os.Setenv(envCredentialsDirectory, dir)).To(Succeed())
+			Expect(os.Setenv(envAPITokenFile, "api-token")).To(Succeed())
+			Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+
+			cfg, err := config.ParseEnv()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Token).To(Equal(apiToken))
+		})
+
+		It("should parse tls settings", func() {
+			Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+			Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+			Expect(os.Setenv(envTLS, "true")).To(Succeed())
+			Expect(os.Setenv(envTLSMinVersion, config.TLSVersion13)).To(Succeed())
+			Expect(os.Setenv(envACME, "true")).To(Succeed())
+			Expect(os.Setenv(envACMEDomains, "Proxy.Test.tld.,*.test.tld")).To(Succeed())
+			Expect(os.Setenv(envACMECacheDir, "/var/lib/proxy/acme")).To(Succeed())
+
+			cfg, err := config.ParseEnv()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.TLS).To(Equal(config.TLS{
+				Enabled:    true,
+				MinVersion: config.TLSVersion13,
+				ACME: config.ACME{
+					Enabled:            true,
+					Domains:            []string{"proxy.test.tld", "*.test.tld"},
+					DirectoryURL:       "https://acme-v02.api.letsencrypt.org/directory",
+					CacheDir:           "/var/lib/proxy/acme",
+					RenewBeforeDays:    30,
+					PropagationSeconds: 60,
+				},
+			}))
+		})
+
 		It("should parse CIDR ranges in TRUSTED_PROXIES", func() {
 			Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
 			Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
@@ -232,6 +386,12 @@ var _ = Describe("Config", func() {
 				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
 				Expect(os.Setenv(envDebug, "something")).To(Succeed())
 			}, "failed to parse DEBUG: strconv.ParseBool: parsing \"something\": invalid syntax"),
+			Entry("ZONE_DISCOVERY_REFRESH_SECONDS not > 0 with zone discovery", func() {
+				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+				Expect(os.Setenv(envZoneDiscovery, "true")).To(Succeed())
+				Expect(os.Setenv(envZoneDiscoveryRefreshSeconds, "0")).To(Succeed())
+			}, "zoneDiscovery.refreshSeconds must be > 0"),
 			Entry("TRUSTED_PROXIES contains a hostname", func() {
 				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
 				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
@@ -242,6 +402,50 @@ var _ = Describe("Config", func() {
 				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
 				Expect(os.Setenv(envTrustedProxies, "10.0.0.0/99")).To(Succeed())
 			}, `invalid trustedProxies entry "10.0.0.0/99": must be an IP address or CIDR range`),
+			Entry("METRICS not a bool", func() {
+				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+				Expect(os.Setenv(envMetrics, "something")).To(Succeed())
+			}, "failed to parse METRICS: strconv.ParseBool: parsing \"something\": invalid syntax"),
+			Entry("METRICS_LISTEN_ADDR same as LISTEN_ADDR", func() {
+				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+				Expect(os.Setenv(envMetrics, "true")).To(Succeed())
+				Expect(os.Setenv(envListenAddr, ":9090")).To(Succeed())
+			}, "metrics.listenAddr cannot be the same as listenAddr"),
+			Entry("DYNDNS_OFFLINE_IPV4 not an ipv4 address", func() {
+				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+				Expect(os.Setenv(envDynDNSOfflineIPv4, "2001:db8::1")).To(Succeed())
+			}, `dyndns.offlineIPv4 "2001:db8::1" is not an ipv4 address`),
+			Entry("CHALLENGE_EXPIRY without CHALLENGE_EXPIRY_STATE_FILE", func() {
+				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+				Expect(os.Setenv(envChallengeExpiry, "true")).To(Succeed())
+			}, "challengeExpiry.stateFile cannot be empty when challenge expiry is enabled"),
+			Entry("API_BUDGET_RESERVE negative", func() {
+				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+				Expect(os.Setenv(envAPIBudgetReserve, "-1")).To(Succeed())
+			}, "apiBudget.reserve must be >= 0"),
+			Entry("API_TOKEN and API_TOKEN_FILE", func() {
+				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+				Expect(os.Setenv(envAPITokenFile, "/nonexistent")).To(Succeed())
+			}, "API_TOKEN and API_TOKEN_FILE cannot both be set"),
+			Entry("TLS without TLS_CERT_FILE", func() {
+				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+				Expect(os.Setenv(envTLS, "true")).To(Succeed())
+				Expect(os.Setenv(envTLSKeyFile, "/etc/proxy/key.pem")).To(Succeed())
+			}, "tls.certFile and tls.keyFile cannot be empty when tls is enabled without tls.acme"),
+			Entry("API_TOKEN_FILE missing", func() {
+				Expect(os.Setenv(envAPITokenFile, "/nonexistent")).To(Succeed())
+			}, "failed to read API_TOKEN_FILE: stat /nonexistent: no such file or directory"),
+			Entry("API_TOKEN_FILE empty", func() {
+				tokenFile := path.Join(GinkgoT().TempDir(), "token")
+				Expect(os.WriteFile(tokenFile, []byte("\n"), 0o600)).To(Succeed())
+				Expect(os.Setenv(envAPITokenFile, tokenFile)).To(Succeed())
+			}, "is empty"),
 		)
 	})
 
@@ -271,9 +475,14 @@ var _ = Describe("Config", func() {
 
 			users = []config.User{
 				{
-					Username: "testname",
-					Password: "testpassword",
-					Domains:  []string{"test.tld"},
+					Username:  "testname",
+					Password:  "testpassword",
+					TokenHash: apitoken.Hash("testtoken"),
+					Domains:   []string{"test.tld"},
+					Restrictions: config.Restrictions{
+						RecordTypes: []string{"A", "TXT"},
+						Names:       []string{"_acme-challenge.*"},
+					},
 				},
 			}
 
@@ -288,16 +497,30 @@ var _ = Describe("Config", func() {
 				Auth: config.Auth{
 					Method:         config.AuthMethodBoth,
 					AllowedDomains: allowedDomains,
-					Users:          users,
+					AllowedDomainsRestrictions: map[string]config.Restrictions{
+						"*": {RecordTypes: []string{"TXT"}},
+					},
+					Users: users,
+				},
+				Endpoints: config.Endpoints{
+					Plain:       true,
+					Nic:         true,
+					AcmeDNS:     true,
+					HTTPReq:     true,
+					DirectAdmin: true,
+					ExternalDNS: true,
+					Cloudflare:  true,
+					PowerDNS:    true,
 				},
-				Endpoints:      config.Endpoints{Plain: true, Nic: true, AcmeDNS: true, HTTPReq: true, DirectAdmin: true},
 				RecordTTL:      recordTTL,
 				ListenAddr:     listenAddr,
 				TrustedProxies: trustedProxies,
 				RateLimit:      validRL(),
 				Lockout:        validLO(),
+				Ownership:      config.Ownership{Enabled: true},
 				Debug:          true,
 			}
+			cfg.Auth.Users[0].Takeover = true
 
 			data, err := yaml.Marshal(cfg)
 			Expect(err).ToNot(HaveOccurred())
@@ -393,6 +616,76 @@ var _ = Describe("Config", func() {
 			}}))
 		})
 
+		It("should read projects", func() {
+			cfg := &config.Config{
+				Auth: config.Auth{
+					Method:         config.AuthMethodAllowedDomains,
+					AllowedDomains: allowedDomains,
+				},
+				Projects: []config.Project{
+					{Name: "prod", Token: "prodtoken", Zones: []string{"Example.com.", "*.example.net"}},
+					{Token: "othertoken", BaseURL: "https://other.example.com/v1", Zones: []string{"example.org"}},
+				},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.Projects).To(Equal([]config.Project{
+				{
+					Name:    "prod",
+					Token:   "prodtoken",
+					BaseURL: "https://api.hetzner.cloud/v1",
+					Zones:   []string{"example.com", "*.example.net"},
+				},
+				{
+					Name:    "projects[1]",
+					Token:   "othertoken",
+					BaseURL: "https://other.example.com/v1",
+					Zones:   []string{"example.org"},
+				},
+			}))
+		})
+
+		It("should read secrets from files", func() {
+			dir := GinkgoT().TempDir()
+			Expect(os.WriteFile(path.Join(dir, "token"), []byte(apiToken+"\n"), 0o600)).To(Succeed())
+			Expect(os.WriteFile(path.Join(dir, "project-token"), []byte("projecttoken"), 0o600)).To(Succeed())
+			Expect(os.WriteFile(path.Join(dir, "password"), []byte("filepassword\n"), 0o600)).To(Succeed())
+
+			cfg := &config.Config{
+				TokenFile: path.Join(dir, "token"),
+				Projects: []config.Project{
+					{Name: "prod", TokenFile: path.Join(dir, "project-token"), Zones: []string{"example.com"}},
+				},
+				Auth: config.Auth{
+					Method: config.AuthMethodUsers,
+					Users: []config.User{{
+						Username:     "testname",
+						PasswordFile: path.Join(dir, "password"),
+						Domains:      []string{"test.tld"},
+					}},
+				},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.Token).To(Equal(apiToken))
+			Expect(cfgRead.Projects[0].Token).To(Equal("projecttoken"))
+			Expect(cfgRead.Auth.Users[0].Password).To(Equal("filepassword"))
+		})
+
 		DescribeTable(
 			"should fail on ", func(cfgFn func() *config.Config, errMsg string) {
 				data, err := yaml.Marshal(cfgFn())
@@ -404,6 +697,66 @@ var _ = Describe("Config", func() {
 				Expect(cfgRead).To(BeNil())
 			},
 			Entry("missing token", func() *config.Config { return &config.Config{} }, "token is required"),
+			Entry(
+				"token and tokenFile",
+				func() *config.Config {
+					return &config.Config{Token: apiToken, TokenFile: "/nonexistent"}
+				},
+				"token and tokenFile are mutually exclusive",
+			),
+			Entry(
+				"missing password file",
+				func() *config.Config {
+					return &config.Config{
+						Token: apiToken,
+						Auth:  config.Auth{Users: []config.User{{Username: "testname", PasswordFile: "/nonexistent"}}},
+					}
+				},
+				"failed to read auth.users[0].passwordFile: stat /nonexistent: no such file or directory",
+			),
+			Entry(
+				"missing project token",
+				func() *config.Config {
+					return &config.Config{
+						Token:    apiToken,
+						Projects: []config.Project{{Name: "prod", Zones: []string{"example.com"}}},
+					}
+				},
+				"projects[0].token is required",
+			),
+			Entry(
+				"empty project zones",
+				func() *config.Config {
+					return &config.Config{
+						Token:    apiToken,
+						Projects: []config.Project{{Name: "prod", Token: apiToken}},
+					}
+				},
+				"projects[0].zones cannot be empty",
+			),
+			Entry(
+				"invalid project zone pattern",
+				func() *config.Config {
+					return &config.Config{
+						Token:    apiToken,
+						Projects: []config.Project{{Name: "prod", Token: apiToken, Zones: []string{"a.*.example.com"}}},
+					}
+				},
+				"projects[0].zones[0] is not a valid zone or pattern: a.*.example.com",
+			),
+			Entry(
+				"duplicate project names",
+				func() *config.Config {
+					return &config.Config{
+						Token: apiToken,
+						Projects: []config.Project{
+							{Name: "prod", Token: apiToken, Zones: []string{"example.com"}},
+							{Name: "prod", Token: apiToken, Zones: []string{"example.org"}},
+						},
+					}
+				},
+				"projects contains prod more than once",
+			),
 			Entry(
 				"invalid auth method",
 				func() *config.Config {
@@ -463,7 +816,7 @@ var _ = Describe("Config", func() {
 						},
 					}
 				},
-				"auth.users cannot be empty with auth method users",
+				"auth.users, auth.apiTokens and auth.jwtIssuers cannot all be empty with auth method users",
 			),
 			Entry(
 				"empty users with auth method both",
@@ -478,7 +831,7 @@ var _ = Describe("Config", func() {
 						},
 					}
 				},
-				"auth.users cannot be empty with auth method both",
+				"auth.users, auth.apiTokens and auth.jwtIssuers cannot all be empty with auth method both",
 			),
 			Entry(
 				"empty allowed domains and users with auth method any",
@@ -492,7 +845,119 @@ var _ = Describe("Config", func() {
 						},
 					}
 				},
-				"auth.allowedDomains or auth.users cannot both be empty with auth method any",
+				"auth.allowedDomains, auth.users, auth.apiTokens and auth.jwtIssuers cannot all be empty with auth method any",
+			),
+			Entry(
+				"empty jwt issuers with auth method jwt",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodJWT,
+							Users:  []config.User{{Username: "testname", Password: "testpassword", Domains: []string{"test.tld"}}},
+						},
+					}
+				},
+				"auth.jwtIssuers cannot be empty with auth method jwt",
+			),
+			Entry(
+				"empty record type of user",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodUsers,
+							Users: []config.User{{
+								Username:     "testname",
+								Password:     "testpassword",
+								Domains:      []string{"test.tld"},
+								Restrictions: config.Restrictions{RecordTypes: []string{"A", ""}},
+							}},
+						},
+					}
+				},
+				"auth.users[0].recordTypes cannot contain empty types",
+			),
+			Entry(
+				"invalid name pattern of user",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodUsers,
+							Users: []config.User{{
+								Username:     "testname",
+								Password:     "testpassword",
+								Domains:      []string{"test.tld"},
+								Restrictions: config.Restrictions{Names: []string{"[_acme-challenge.*"}},
+							}},
+						},
+					}
+				},
+				`auth.users[0].names contains invalid pattern "[_acme-challenge.*": syntax error in pattern`,
+			),
+			Entry(
+				"restrictions of unknown allowed domain",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+							AllowedDomainsRestrictions: map[string]config.Restrictions{
+								"example.com": {RecordTypes: []string{"TXT"}},
+							},
+						},
+					}
+				},
+				"auth.allowedDomainsRestrictions contains example.com which is not in auth.allowedDomains",
+			),
+			Entry(
+				"invalid password hash",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodUsers,
+							Users: []config.User{{
+								Username: "user",
+								Password: "$argon2id$v=19$m=0,t=2,p=1$c2FsdA$a2V5",
+								Domains:  []string{"example.com"},
+							}},
+						},
+					}
+				},
+				"auth.users[0].password: invalid argon2id hash: parameters must be > 0",
+			),
+			Entry(
+				"user token hash is plaintext",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodUsers,
+							Users: []config.User{{
+								Username:  "user",
+								Password:  "password",
+								TokenHash: "verysecretapitoken",
+								Domains:   []string{"example.com"},
+							}},
+						},
+					}
+				},
+				"auth.users[0].tokenHash: hash must start with sha256:",
 			),
 			Entry(
 				"trustedProxies entry is a hostname",
@@ -510,13 +975,571 @@ var _ = Describe("Config", func() {
 				},
 				`invalid trustedProxies entry "proxy.example.com": must be an IP address or CIDR range`,
 			),
-		)
-
-		It("should fail on invalid yaml", func() {
-			Expect(os.WriteFile(filePath, []byte("not yaml"), 0o600)).To(Succeed())
-			cfg, err := config.ReadFile(filePath)
-			Expect(err).To(MatchError(ContainSubstring("string was used where mapping is expected")))
-			Expect(cfg).To(BeNil())
-		})
-	})
+			Entry(
+				"metrics enabled without listen address",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						Metrics: config.Metrics{Enabled: true},
+					}
+				},
+				"metrics.listenAddr cannot be empty when metrics are enabled",
+			),
+			Entry(
+				"dyndns offline ipv6 not an ipv6 address",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						DynDNS: config.DynDNS{OfflineIPv6: "192.0.2.1"},
+					}
+				},
+				`dyndns.offlineIPv6 "192.0.2.1" is not an ipv6 address`,
+			),
+			Entry(
+				"challenge expiry with invalid lifetime",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						ChallengeExpiry: config.ChallengeExpiry{Enabled: true, IntervalSeconds: 60, StateFile: "challenges.json"},
+					}
+				},
+				"challengeExpiry.lifetimeSeconds must be > 0",
+			),
+			Entry(
+				"rfc2136 enabled without keys",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						RFC2136: config.RFC2136{Enabled: true, ListenAddr: listenAddr},
+					}
+				},
+				"rfc2136.keys cannot be empty when rfc2136 is enabled",
+			),
+			Entry(
+				"rfc2136 key with invalid secret",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						RFC2136: config.RFC2136{Enabled: true, ListenAddr: listenAddr, Keys: []config.TSIGKey{{
+							Name:    "key.",
+							Secret:  "not base64",
+							Domains: []string{"test.tld"},
+						}}},
+					}
+				},
+				"invalid rfc2136.keys entry 0: secret must be base64 encoded",
+			),
+			Entry(
+				"rfc2136 key with unsupported algorithm",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						RFC2136: config.RFC2136{Enabled: true, ListenAddr: listenAddr, Keys: []config.TSIGKey{{
+							Name:      "key.",
+							Algorithm: "hmac-md5",
+							Secret:    "c2VjcmV0",
+							Domains:   []string{"test.tld"},
+						}}},
+					}
+				},
+				"invalid rfc2136.keys entry 0: unsupported algorithm hmac-md5",
+			),
+			Entry(
+				"rfc2136 key with invalid name pattern",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						RFC2136: config.RFC2136{Enabled: true, ListenAddr: listenAddr, Keys: []config.TSIGKey{{
+							Name:         "key.",
+							Secret:       "c2VjcmV0",
+							Domains:      []string{"test.tld"},
+							Restrictions: config.Restrictions{Names: []string{"["}},
+						}}},
+					}
+				},
+				`rfc2136.keys[0].names contains invalid pattern "["`,
+			),
+			Entry(
+				"tls with invalid min version",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						TLS: config.TLS{Enabled: true, CertFile: "cert.pem", KeyFile: "key.pem", MinVersion: "1.1"},
+					}
+				},
+				"tls.minVersion must be 1.2 or 1.3",
+			),
+			Entry(
+				"tls with acme and cert file",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						TLS: config.TLS{
+							Enabled:    true,
+							CertFile:   "cert.pem",
+							MinVersion: config.TLSVersion12,
+							ACME:       config.ACME{Enabled: true, Domains: []string{"test.tld"}},
+						},
+					}
+				},
+				"tls.certFile and tls.keyFile cannot be set when tls.acme is enabled",
+			),
+			Entry(
+				"tls with acme and invalid domain",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						TLS: config.TLS{
+							Enabled:    true,
+							MinVersion: config.TLSVersion12,
+							ACME:       config.ACME{Enabled: true, Domains: []string{"a.*.test.tld"}},
+						},
+					}
+				},
+				"tls.acme.domains[0] is not a valid domain: a.*.test.tld",
+			),
+			Entry(
+				"tls with acme without cache dir",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						TLS: config.TLS{
+							Enabled:    true,
+							MinVersion: config.TLSVersion12,
+							ACME: config.ACME{
+								Enabled:         true,
+								Domains:         []string{"test.tld"},
+								DirectoryURL:    "https://acme.test.tld/directory",
+								RenewBeforeDays: 30,
+							},
+						},
+					}
+				},
+				"tls.acme.cacheDir cannot be empty when tls.acme is enabled",
+			),
+			Entry(
+				"auth method clientCert without certificate users",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodClientCert,
+							Users: []config.User{{
+								Username: "user",
+								Password: "pass",
+								Domains:  []string{"test.tld"},
+							}},
+						},
+					}
+				},
+				"auth.users must identify users by certNames or certFingerprints with auth method clientCert",
+			),
+			Entry(
+				"certificate users without client CA or header",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodClientCert,
+							Users: []config.User{{
+								Username:  "router",
+								CertNames: []string{"router.test.tld"},
+								Domains:   []string{"test.tld"},
+							}},
+						},
+					}
+				},
+				"tls.clientCA or auth.clientCertHeader must be set to identify users by client certificates",
+			),
+			Entry(
+				"certificate names with forwarded certificates without client CA",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:           config.AuthMethodClientCert,
+							ClientCertHeader: "X-Client-Cert",
+							Users: []config.User{{
+								Username:  "router",
+								CertNames: []string{"router.test.tld"},
+								Domains:   []string{"test.tld"},
+							}},
+						},
+					}
+				},
+				"auth.users[0].certNames require tls.clientCA with auth.clientCertHeader, use certFingerprints",
+			),
+			Entry(
+				"unreadable client CA with forwarded certificates",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						TLS:       config.TLS{ClientCA: "/nonexistent/ca.pem"},
+						Auth: config.Auth{
+							Method:           config.AuthMethodClientCert,
+							ClientCertHeader: "X-Client-Cert",
+							Users: []config.User{{
+								Username:  "router",
+								CertNames: []string{"router.test.tld"},
+								Domains:   []string{"test.tld"},
+							}},
+						},
+					}
+				},
+				"tls.clientCA: open /nonexistent/ca.pem: no such file or directory",
+			),
+			Entry(
+				"invalid certificate fingerprint",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:           config.AuthMethodClientCert,
+							ClientCertHeader: "X-Client-Cert",
+							Users: []config.User{{
+								Username:         "router",
+								CertFingerprints: []string{"abc"},
+								Domains:          []string{"test.tld"},
+							}},
+						},
+					}
+				},
+				"auth.users[0].certFingerprints[0] is not a SHA-256 fingerprint: abc",
+			),
+		)
+
+		It("should parse api tokens", func() {
+			hash := "sha256:" + strings.Repeat("AB", 32)
+			cfg := &config.Config{
+				Token:     apiToken,
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+				Auth: config.Auth{
+					Method: config.AuthMethodUsers,
+					APITokens: []config.APIToken{
+						{Hash: hash, Domains: []string{"test.tld"}, Expires: "2027-01-01"},
+						{Hash: hash, Domains: []string{"test.tld"}, Expires: "2027-01-01T12:00:00+02:00"},
+						{Hash: hash, Domains: []string{"test.tld"}, Endpoints: []string{config.EndpointCloudflare}},
+					},
+				},
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			tokens := cfgRead.Auth.APITokens
+			Expect(tokens[0].Hash).To(Equal(strings.ToLower(hash)))
+			Expect(tokens[0].ExpiresAt).To(Equal(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)))
+			Expect(tokens[1].ExpiresAt.Equal(time.Date(2027, 1, 1, 10, 0, 0, 0, time.UTC))).To(BeTrue())
+			Expect(tokens[2].ExpiresAt.IsZero()).To(BeTrue())
+			Expect(tokens[2].Expired(time.Now())).To(BeFalse())
+			Expect(tokens[0].Expired(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))).To(BeTrue())
+		})
+
+		DescribeTable("should fail on invalid api tokens", func(token config.APIToken, msg string) {
+			cfg := &config.Config{
+				Token:     apiToken,
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+				Auth: config.Auth{
+					Method:    config.AuthMethodUsers,
+					APITokens: []config.APIToken{token},
+				},
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			_, err = config.ReadFile(filePath)
+			Expect(err).To(MatchError(msg))
+		},
+			Entry("with invalid hash",
+				config.APIToken{Hash: "plaintext", Domains: []string{"test.tld"}},
+				"auth.apiTokens[0].hash: hash must start with sha256:",
+			),
+			Entry("without domains",
+				config.APIToken{Hash: "sha256:" + strings.Repeat("ab", 32)},
+				"auth.apiTokens[0].domains cannot be empty",
+			),
+			Entry("with invalid endpoint",
+				config.APIToken{Hash: "sha256:" + strings.Repeat("ab", 32), Domains: []string{"test.tld"}, Endpoints: []string{"ftp"}},
+				`auth.apiTokens[0].endpoints contains invalid endpoint "ftp"`,
+			),
+			Entry("with invalid expiry",
+				config.APIToken{Hash: "sha256:" + strings.Repeat("ab", 32), Domains: []string{"test.tld"}, Expires: "tomorrow"},
+				"auth.apiTokens[0].expires must be an RFC 3339 time or a date: tomorrow",
+			),
+		)
+
+		Context("jwt issuers", func() {
+			var jwksFile string
+
+			BeforeEach(func() {
+				jwksFile = path.Join(GinkgoT().TempDir(), "jwks.json")
+				Expect(os.WriteFile(jwksFile, []byte(`{"keys":[{"kty":"OKP","crv":"Ed25519","x":"`+
+					strings.Repeat("A", 43)+`"}]}`), 0o600)).To(Succeed())
+			})
+
+			validIssuer := func() config.JWTIssuer {
+				return config.JWTIssuer{
+					Issuer:   "https://gitlab.example.com",
+					Audience: "hetzner-dnsapi-proxy",
+					JWKSFile: jwksFile,
+					Rules: []config.JWTRule{{
+						Claims:  map[string]string{"project_path": "group/*"},
+						Domains: []string{"test.tld"},
+					}},
+				}
+			}
+
+			writeIssuer := func(issuer config.JWTIssuer) {
+				cfg := &config.Config{
+					Token:     apiToken,
+					RateLimit: validRL(),
+					Lockout:   validLO(),
+					Auth: config.Auth{
+						Method:     config.AuthMethodJWT,
+						JWTIssuers: []config.JWTIssuer{issuer},
+					},
+				}
+				data, err := yaml.Marshal(cfg)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+			}
+
+			It("should parse jwt issuers", func() {
+				issuer := validIssuer()
+				issuer.Rules[0].RecordTypes = []string{"txt"}
+				issuer.Rules[0].Endpoints = []string{config.EndpointHTTPReq}
+				writeIssuer(issuer)
+
+				cfgRead, err := config.ReadFile(filePath)
+				Expect(err).ToNot(HaveOccurred())
+				issuers := cfgRead.Auth.JWTIssuers
+				Expect(issuers).To(HaveLen(1))
+				Expect(issuers[0].Verifier).ToNot(BeNil())
+				Expect(issuers[0].JWKSRefreshSeconds).To(Equal(3600))
+				Expect(issuers[0].Rules[0].RecordTypes).To(Equal([]string{"TXT"}))
+				Expect(issuers[0].Rules[0].AllowsEndpoint(config.EndpointHTTPReq)).To(BeTrue())
+				Expect(issuers[0].Rules[0].AllowsEndpoint(config.EndpointPlain)).To(BeFalse())
+			})
+
+			It("should not fetch a jwks url when reading the file", func() {
+				issuer := validIssuer()
+				issuer.JWKSFile = ""
+				issuer.JWKSURL = "http://127.0.0.1:1/jwks"
+				writeIssuer(issuer)
+
+				cfgRead, err := config.ReadFile(filePath)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cfgRead.Auth.JWTIssuers[0].Verifier).ToNot(BeNil())
+			})
+
+			DescribeTable("should fail on invalid jwt issuers", func(modify func(*config.JWTIssuer), msg string) {
+				issuer := validIssuer()
+				modify(&issuer)
+				writeIssuer(issuer)
+
+				_, err := config.ReadFile(filePath)
+				Expect(err).To(MatchError(ContainSubstring(msg)))
+			},
+				Entry("without issuer", func(i *config.JWTIssuer) {
+					i.Issuer = ""
+				}, "auth.jwtIssuers[0].issuer cannot be empty"),
+				Entry("without audience", func(i *config.JWTIssuer) {
+					i.Audience = ""
+				}, "auth.jwtIssuers[0].audience cannot be empty"),
+				Entry("without jwks", func(i *config.JWTIssuer) {
+					i.JWKSFile = ""
+				}, "auth.jwtIssuers[0] must set exactly one of jwksFile and jwksURL"),
+				Entry("with jwks file and url", func(i *config.JWTIssuer) {
+					i.JWKSURL = "https://gitlab.example.com/oauth/discovery/keys"
+				}, "auth.jwtIssuers[0] must set exactly one of jwksFile and jwksURL"),
+				Entry("with negative refresh", func(i *config.JWTIssuer) {
+					i.JWKSRefreshSeconds = -1
+				}, "auth.jwtIssuers[0].jwksRefreshSeconds must be >= 0"),
+				Entry("with missing jwks file", func(i *config.JWTIssuer) {
+					i.JWKSFile += ".missing"
+				}, "auth.jwtIssuers[0].jwksFile: open "),
+				Entry("without rules", func(i *config.JWTIssuer) {
+					i.Rules = nil
+				}, "auth.jwtIssuers[0].rules cannot be empty"),
+				Entry("with rule without claims", func(i *config.JWTIssuer) {
+					i.Rules[0].Claims = nil
+				}, "auth.jwtIssuers[0].rules[0].claims cannot be empty"),
+				Entry("with invalid claim pattern", func(i *config.JWTIssuer) {
+					i.Rules[0].Claims["project_path"] = "group/["
+				}, `auth.jwtIssuers[0].rules[0].claims.project_path is an invalid pattern "group/["`),
+				Entry("with rule without domains", func(i *config.JWTIssuer) {
+					i.Rules[0].Domains = nil
+				}, "auth.jwtIssuers[0].rules[0].domains cannot be empty"),
+				Entry("with invalid endpoint", func(i *config.JWTIssuer) {
+					i.Rules[0].Endpoints = []string{"ftp"}
+				}, `auth.jwtIssuers[0].rules[0].endpoints contains invalid endpoint "ftp"`),
+			)
+		})
+
+		It("should normalize certificate fingerprints", func() {
+			fingerprint := strings.Repeat("AB:", 31) + "AB"
+			cfg := &config.Config{
+				Token:     apiToken,
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+				Auth: config.Auth{
+					Method:           config.AuthMethodClientCert,
+					ClientCertHeader: "X-Client-Cert",
+					Users: []config.User{{
+						Username:         "router",
+						CertFingerprints: []string{fingerprint},
+						Domains:          []string{"test.tld"},
+					}},
+				},
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.Auth.Users[0].CertFingerprints).To(Equal([]string{strings.Repeat("ab", 32)}))
+		})
+
+		It("should default the rfc2136 key algorithm to hmac-sha256", func() {
+			cfg := &config.Config{
+				Token: apiToken,
+				Auth: config.Auth{
+					Method:         config.AuthMethodAllowedDomains,
+					AllowedDomains: allowedDomains,
+				},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+				RFC2136: config.RFC2136{Enabled: true, ListenAddr: listenAddr, Keys: []config.TSIGKey{{
+					Name:    "key.",
+					Secret:  "c2VjcmV0",
+					Domains: []string{"test.tld"},
+				}}},
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.RFC2136.Keys[0].Algorithm).To(Equal(config.TSIGAlgorithmHMACSHA256))
+		})
+
+		It("should fail on invalid yaml", func() {
+			Expect(os.WriteFile(filePath, []byte("not yaml"), 0o600)).To(Succeed())
+			cfg, err := config.ReadFile(filePath)
+			Expect(err).To(MatchError(ContainSubstring("string was used where mapping is expected")))
+			Expect(cfg).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Restrictions", func() {
+	DescribeTable("should allow", func(r config.Restrictions, fqdn, recordType string) {
+		Expect(r.Allows(fqdn, recordType)).To(BeTrue())
+	},
+		Entry("when empty", config.Restrictions{}, "test.tld", "A"),
+		Entry("when record type is one of the record types",
+			config.Restrictions{RecordTypes: []string{"A", "AAAA"}}, "test.tld", "AAAA"),
+		Entry("when fqdn matches one of the names",
+			config.Restrictions{Names: []string{"home.test.tld", "_acme-challenge.*"}}, "_acme-challenge.sub.test.tld", "TXT"),
+		Entry("when record type and fqdn match",
+			config.Restrictions{RecordTypes: []string{"TXT"}, Names: []string{"_acme-challenge.*"}}, "_acme-challenge.test.tld", "TXT"),
+	)
+
+	DescribeTable("should deny", func(r config.Restrictions, fqdn, recordType string) {
+		Expect(r.Allows(fqdn, recordType)).To(BeFalse())
+	},
+		Entry("when record type does not match",
+			config.Restrictions{RecordTypes: []string{"TXT"}}, "test.tld", "A"),
+		Entry("when fqdn does not match",
+			config.Restrictions{Names: []string{"_acme-challenge.*"}}, "www.test.tld", "TXT"),
+		Entry("when only the record type matches",
+			config.Restrictions{RecordTypes: []string{"TXT"}, Names: []string{"_acme-challenge.*"}}, "www.test.tld", "TXT"),
+	)
 })
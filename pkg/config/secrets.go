@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// credentialsDirectoryEnv is set by systemd to the directory holding the
+// credentials passed with LoadCredential= and similar settings.
+const credentialsDirectoryEnv = "CREDENTIALS_DIRECTORY"
+
+// worldReadable is the permission bit of files readable by everyone.
+const worldReadable os.FileMode = 0o004
+
+// envToken reads the API token from API_TOKEN or from the file named by
+// API_TOKEN_FILE. Both are removed from the environment afterwards.
+func envToken(cfg *Config) error {
+	token, tokenOK := os.LookupEnv("API_TOKEN")
+	tokenFile, tokenFileOK := os.LookupEnv("API_TOKEN_FILE")
+	switch {
+	case tokenOK && tokenFileOK:
+		return errors.New("API_TOKEN and API_TOKEN_FILE cannot both be set")
+	case tokenOK:
+		cfg.Token = token
+	case tokenFileOK:
+		secret, err := readSecret(tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read API_TOKEN_FILE: %w", err)
+		}
+		cfg.Token = secret
+		cfg.TokenFile = tokenFile
+	default:
+		return errors.New("API_TOKEN environment variable not set")
+	}
+
+	for _, key := range []string{"API_TOKEN", "API_TOKEN_FILE"} {
+		if err := os.Unsetenv(key); err != nil {
+			return fmt.Errorf("failed to unset %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// readSecrets reads the tokens and passwords configured as files.
+func readSecrets(cfg *Config) error {
+	if err := readSecretFile("token", &cfg.Token, cfg.TokenFile); err != nil {
+		return err
+	}
+	for i := range cfg.Projects {
+		p := &cfg.Projects[i]
+		if err := readSecretFile(fmt.Sprintf("projects[%d].token", i), &p.Token, p.TokenFile); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Auth.Users {
+		u := &cfg.Auth.Users[i]
+		if err := readSecretFile(fmt.Sprintf("auth.users[%d].password", i), &u.Password, u.PasswordFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSecretFile reads the secret named name from path into dst. Nothing is
+// read if path is empty, a secret given both inline and as file is an error.
+func readSecretFile(name string, dst *string, path string) error {
+	if path == "" {
+		return nil
+	}
+	if *dst != "" {
+		return fmt.Errorf("%s and %sFile are mutually exclusive", name, name)
+	}
+	secret, err := readSecret(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %sFile: %w", name, err)
+	}
+	*dst = secret
+	return nil
+}
+
+// readSecret returns the content of the secret file at path without
+// surrounding whitespace. Relative paths are resolved against the systemd
+// credentials directory if it is set. A warning is logged if the file is
+// readable by everyone.
+func readSecret(path string) (string, error) {
+	if dir, ok := os.LookupEnv(credentialsDirectoryEnv); ok && dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&worldReadable != 0 {
+		log.Printf("Warning: secret file %s is readable by everyone, restrict it with chmod o-r", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return secret, nil
+}
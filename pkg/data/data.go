@@ -2,6 +2,7 @@ package data
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 )
 
@@ -11,15 +12,36 @@ type ReqData struct {
 	Zone      string
 	Value     string
 	Type      string
+	TTL       int
 	Username  string
 	Password  string
+	Token     string
 	BasicAuth bool
+	// ClientCert is the verified client certificate of the request, either
+	// presented to the proxy or forwarded by a trusted proxy
+	ClientCert *x509.Certificate
+	// Endpoint is the endpoint group the request was received on, API
+	// tokens can be limited to endpoint groups
+	Endpoint string
+	// User is the name of the user or TSIG key the request was authorized
+	// for, empty if it was authorized by allowed domains only
+	User string
+	// Takeover is set if User may change rrsets that are not managed by the
+	// proxy in ownership mode
+	Takeover bool
+	// Unchanged is set by updaters if the rrset already held the requested
+	// records and TTL, and by cleaners if the rrset to delete did not exist,
+	// so nothing was written
+	Unchanged bool
 }
 
 // key is an unexported type for keys defined in this package.
 // This prevents collisions with keys defined in other packages.
 type key int
 
+// clientCertKey is the key for client certificates in Contexts.
+const clientCertKey key = 1
+
 // reqDataKey is the key for ReqData values in Contexts.
 // It is unexported; clients use newContextWithReqData and reqDataFromContext
 // instead of using this key directly.
@@ -38,3 +60,16 @@ func ReqDataFromContext(ctx context.Context) (*ReqData, error) {
 	}
 	return data, nil
 }
+
+// NewContextWithClientCert returns a new Context that stores the verified
+// client certificate of a request.
+func NewContextWithClientCert(ctx context.Context, cert *x509.Certificate) context.Context {
+	return context.WithValue(ctx, clientCertKey, cert)
+}
+
+// ClientCertFromContext returns the client certificate stored in a Context,
+// nil if there is none.
+func ClientCertFromContext(ctx context.Context) *x509.Certificate {
+	cert, _ := ctx.Value(clientCertKey).(*x509.Certificate)
+	return cert
+}
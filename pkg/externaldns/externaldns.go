@@ -0,0 +1,448 @@
+package externaldns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	mediaType              = "application/external.dns.webhook+json;version=1"
+	headerContentType      = "Content-Type"
+	maxRequestBodySize     = 1 << 20 // 1 MB
+	failedWriteResponseFmt = "failed to write response: %v"
+)
+
+type Updater interface {
+	Set(ctx context.Context, reqData *data.ReqData, values []string) error
+}
+
+type Cleaner interface {
+	Delete(ctx context.Context, reqData *data.ReqData) error
+}
+
+type endpoint struct {
+	DNSName          string                     `json:"dnsName"`
+	Targets          []string                   `json:"targets"`
+	RecordType       string                     `json:"recordType"`
+	SetIdentifier    string                     `json:"setIdentifier,omitempty"`
+	RecordTTL        int64                      `json:"recordTTL,omitempty"`
+	Labels           map[string]string          `json:"labels,omitempty"`
+	ProviderSpecific []providerSpecificProperty `json:"providerSpecific,omitempty"`
+}
+
+type providerSpecificProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type changes struct {
+	Create    []*endpoint `json:"create"`
+	UpdateOld []*endpoint `json:"updateOld"`
+	UpdateNew []*endpoint `json:"updateNew"`
+	Delete    []*endpoint `json:"delete"`
+}
+
+type change struct {
+	reqData *data.ReqData
+	values  []string
+}
+
+type domainFilter struct {
+	Include []string `json:"include,omitempty"`
+}
+
+// Webhook implements the external-dns webhook provider protocol on top of
+// the updater and cleaner. Every change is authorized with CheckPermission.
+type Webhook struct {
+	cfg      *config.Config
+	lockout  *ratelimit.Lockout
+	updater  Updater
+	cleaner  Cleaner
+	zones    *hetzner.ZoneCache
+	projects *hetzner.Projects
+}
+
+func New(
+	cfg *config.Config, projects *hetzner.Projects, lockout *ratelimit.Lockout, updater Updater, cleaner Cleaner, zones *hetzner.ZoneCache,
+) *Webhook {
+	return &Webhook{
+		cfg:      cfg,
+		lockout:  lockout,
+		updater:  updater,
+		cleaner:  cleaner,
+		zones:    zones,
+		projects: projects,
+	}
+}
+
+// Negotiate returns the domain filter of the client.
+func (wh *Webhook) Negotiate(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := wh.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		filter := domainFilter{}
+		if _, ok := domains["*"]; !ok {
+			for domain := range domains {
+				filter.Include = append(filter.Include, domain)
+			}
+			slices.Sort(filter.Include)
+		}
+		writeJSON(w, http.StatusOK, filter)
+	})
+}
+
+// Records returns all records in the zones of the client it is allowed to
+// update. It is polled periodically, so the zones are taken from the zone
+// cache if zone discovery is enabled.
+func (wh *Webhook) Records(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := wh.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(wh.cfg.Timeout)*time.Second)
+		defer cancel()
+		endpoints, err := wh.listEndpoints(ctx, r, domains)
+		if errors.Is(err, ratelimit.ErrReserved) {
+			log.Printf("failed to list records: %v", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			log.Printf("failed to list records: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, endpoints)
+	})
+}
+
+// ApplyChanges applies the changes computed by external-dns.
+func (wh *Webhook) ApplyChanges(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := wh.authenticate(w, r); !ok {
+			return
+		}
+
+		c := &changes{}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+		if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+			log.Printf("failed to parse request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		deletes, err := wh.toChanges(r, c.Delete)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sets, err := wh.toChanges(r, slices.Concat(c.UpdateNew, c.Create))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		olds, err := wh.toChanges(r, c.UpdateOld)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !wh.authorize(w, r, slices.Concat(deletes, sets, olds)) {
+			return
+		}
+
+		if err := wh.apply(r.Context(), deletes, sets); err != nil {
+			log.Printf("failed to apply changes: %v", err)
+			if errors.Is(err, hetzner.ErrUnmanaged) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// AdjustEndpoints sets the TTL of all endpoints to the configured record TTL
+// and canonicalizes their targets, so external-dns does not try to update
+// records whose TTL or target format differs.
+func (wh *Webhook) AdjustEndpoints(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := wh.authenticate(w, r); !ok {
+			return
+		}
+
+		var endpoints []*endpoint
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+		if err := json.NewDecoder(r.Body).Decode(&endpoints); err != nil {
+			log.Printf("failed to parse request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		for _, e := range endpoints {
+			e.RecordTTL = int64(wh.cfg.RecordTTL)
+			for i, target := range e.Targets {
+				if value, err := middleware.CanonicalValue(target, e.RecordType); err == nil {
+					e.Targets[i] = value
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, endpoints)
+	})
+}
+
+// authenticate returns the domains the client is allowed to update. If there
+// are none, an error response is written.
+func (wh *Webhook) authenticate(w http.ResponseWriter, r *http.Request) (map[string]struct{}, bool) {
+	addr := sanitize.LogValue(r.RemoteAddr)
+	if wh.lockout.IsBlocked(r.RemoteAddr) {
+		//nolint:gosec // value is sanitized above
+		log.Printf("client '%s' is locked out", addr)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return nil, false
+	}
+
+	username, password, _ := r.BasicAuth()
+	domains := middleware.GetDomains(wh.cfg, &data.ReqData{
+		Username:   username,
+		Password:   password,
+		Token:      middleware.BearerToken(r),
+		ClientCert: middleware.ClientCert(r),
+		Endpoint:   config.EndpointExternalDNS,
+	}, r.RemoteAddr)
+	if len(domains) == 0 {
+		//nolint:gosec // value is sanitized above
+		log.Printf("client '%s' is not allowed to list any domains", addr)
+		wh.lockout.RecordFailure(r.RemoteAddr)
+		metrics.AuthFailure(wh.cfg.Auth.Method)
+		wh.unauthorized(w)
+		return nil, false
+	}
+
+	return domains, true
+}
+
+func (wh *Webhook) authorize(w http.ResponseWriter, r *http.Request, changes []change) bool {
+	for _, c := range changes {
+		if !middleware.CheckPermission(wh.cfg, c.reqData, r.RemoteAddr) {
+			addr := sanitize.LogValue(r.RemoteAddr)
+			typ := sanitize.LogValue(c.reqData.Type)
+			name := sanitize.LogValue(c.reqData.FullName)
+			//nolint:gosec // values are sanitized above
+			log.Printf("client '%s' is not allowed to update '%s' data of '%s'", addr, typ, name)
+			wh.lockout.RecordFailure(r.RemoteAddr)
+			metrics.AuthFailure(wh.cfg.Auth.Method)
+			wh.unauthorized(w)
+			return false
+		}
+	}
+	wh.lockout.Reset(r.RemoteAddr)
+	return true
+}
+
+func (wh *Webhook) unauthorized(w http.ResponseWriter) {
+	if wh.cfg.Auth.Method != config.AuthMethodAllowedDomains {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+func (wh *Webhook) apply(ctx context.Context, deletes, sets []change) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(wh.cfg.Timeout)*time.Second)
+	defer cancel()
+
+	for _, c := range deletes {
+		typ := sanitize.LogValue(c.reqData.Type)
+		name := sanitize.LogValue(c.reqData.FullName)
+		//nolint:gosec // values are sanitized above
+		log.Printf("received request to delete '%s' data of '%s'", typ, name)
+		if err := wh.cleaner.Delete(ctx, c.reqData); err != nil {
+			return err
+		}
+	}
+	for _, c := range sets {
+		typ := sanitize.LogValue(c.reqData.Type)
+		name := sanitize.LogValue(c.reqData.FullName)
+		vals := sanitize.LogValue(strings.Join(c.values, ", "))
+		//nolint:gosec // values are sanitized above
+		log.Printf("received request to update '%s' data of '%s' to '%s'", typ, name, vals)
+		if err := wh.updater.Set(ctx, c.reqData, c.values); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (wh *Webhook) toChanges(r *http.Request, endpoints []*endpoint) ([]change, error) {
+	username, password, _ := r.BasicAuth()
+	changes := make([]change, 0, len(endpoints))
+	for _, e := range endpoints {
+		if err := validateEndpoint(e); err != nil {
+			return nil, err
+		}
+
+		fqdn := strings.TrimSuffix(e.DNSName, ".")
+		name, zone, err := wh.split(r.Context(), fqdn)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change{
+			reqData: &data.ReqData{
+				FullName:   fqdn,
+				Name:       name,
+				Zone:       zone,
+				Value:      e.Targets[0],
+				Type:       e.RecordType,
+				Username:   username,
+				Password:   password,
+				Token:      middleware.BearerToken(r),
+				BasicAuth:  true,
+				ClientCert: middleware.ClientCert(r),
+				Endpoint:   config.EndpointExternalDNS,
+			},
+			values: e.Targets,
+		})
+	}
+	return changes, nil
+}
+
+func (wh *Webhook) split(ctx context.Context, fqdn string) (name, zone string, err error) {
+	if wh.zones != nil {
+		if name, zone, ok := wh.zones.Split(ctx, fqdn); ok {
+			return name, zone, nil
+		}
+	}
+	return middleware.SplitFQDN(fqdn)
+}
+
+// listZones returns the zones of all projects, taken from the zone cache if
+// zone discovery is enabled. Without cached zones they are listed, so
+// failures are reported.
+func (wh *Webhook) listZones(ctx context.Context) ([]*hcloud.Zone, error) {
+	if wh.zones != nil {
+		if names := wh.zones.Zones(ctx); len(names) > 0 {
+			zones := make([]*hcloud.Zone, 0, len(names))
+			for _, name := range names {
+				zones = append(zones, &hcloud.Zone{Name: name})
+			}
+			return zones, nil
+		}
+	}
+	return wh.projects.AllZones(ctx, hcloud.ZoneListOpts{})
+}
+
+func (wh *Webhook) listEndpoints(ctx context.Context, r *http.Request, domains map[string]struct{}) ([]*endpoint, error) {
+	zones, err := wh.listZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	username, password, _ := r.BasicAuth()
+	endpoints := []*endpoint{}
+	for _, zone := range zones {
+		if !middleware.ZoneOverlaps(zone.Name, domains) {
+			continue
+		}
+
+		rrSets, err := wh.projects.AllRRSets(ctx, zone)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rrSet := range rrSets {
+			if _, err := hetzner.RRSetTypeFromString(string(rrSet.Type)); err != nil {
+				continue
+			}
+			fqdn := zone.Name
+			if rrSet.Name != "@" {
+				fqdn = rrSet.Name + "." + zone.Name
+			}
+			reqData := &data.ReqData{
+				FullName:   fqdn,
+				Type:       string(rrSet.Type),
+				Username:   username,
+				Password:   password,
+				Token:      middleware.BearerToken(r),
+				ClientCert: middleware.ClientCert(r),
+				Endpoint:   config.EndpointExternalDNS,
+			}
+			if !middleware.CheckPermission(wh.cfg, reqData, r.RemoteAddr) {
+				continue
+			}
+
+			e := &endpoint{
+				DNSName:    fqdn,
+				Targets:    hetzner.Values(rrSet),
+				RecordType: string(rrSet.Type),
+			}
+			if rrSet.TTL != nil {
+				e.RecordTTL = int64(*rrSet.TTL)
+			}
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	return endpoints, nil
+}
+
+// validateEndpoint validates e and replaces its targets with their canonical
+// form.
+func validateEndpoint(e *endpoint) error {
+	if e.DNSName == "" {
+		return errors.New("dnsName is missing")
+	}
+	if len(e.Targets) == 0 {
+		return fmt.Errorf("targets of %s are missing", e.DNSName)
+	}
+	if _, err := hetzner.RRSetTypeFromString(e.RecordType); err != nil {
+		return err
+	}
+	for i, target := range e.Targets {
+		value, err := middleware.CanonicalValue(target, e.RecordType)
+		if err != nil {
+			return fmt.Errorf("invalid %s target of %s: %w", e.RecordType, e.DNSName, err)
+		}
+		e.Targets[i] = value
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	resData, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("failed to marshal response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(headerContentType, mediaType)
+	w.WriteHeader(status)
+	if _, err := w.Write(resData); err != nil {
+		log.Printf(failedWriteResponseFmt, err)
+	}
+}
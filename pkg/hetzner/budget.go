@@ -0,0 +1,82 @@
+package hetzner
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+// Budgets are the budgets of API tokens, shared by all clients using the
+// same token.
+type Budgets struct {
+	mu      sync.Mutex
+	budgets map[string]*ratelimit.Budget
+}
+
+func NewBudgets() *Budgets {
+	return &Budgets{budgets: map[string]*ratelimit.Budget{}}
+}
+
+// budget returns the budget of token, created for project with reserve if
+// it does not exist yet.
+func (b *Budgets) budget(token, project string, reserve int) *ratelimit.Budget {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	budget, ok := b.budgets[token]
+	if !ok {
+		budget = ratelimit.NewBudget(project, reserve)
+		b.budgets[token] = budget
+		return budget
+	}
+	budget.Configure(project, reserve)
+	return budget
+}
+
+// Prune removes the budgets of tokens that are not configured in cfg.
+func (b *Budgets) Prune(cfg *config.Config) {
+	tokens := map[string]struct{}{cfg.Token: {}}
+	for _, proj := range cfg.Projects {
+		tokens[proj.Token] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for token, budget := range b.budgets {
+		if _, ok := tokens[token]; !ok {
+			budget.Close()
+			delete(b.budgets, token)
+		}
+	}
+}
+
+// budgetTransport rejects requests whose priority does not allow spending
+// the remaining budget of the token, and tracks the budget reported by the
+// responses of the API.
+type budgetTransport struct {
+	next   http.RoundTripper
+	budget *ratelimit.Budget
+}
+
+func (t *budgetTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !t.budget.Allow(ratelimit.PriorityFromContext(r.Context())) {
+		return nil, ratelimit.ErrReserved
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		return resp, err
+	}
+	limit, errLimit := strconv.Atoi(resp.Header.Get("RateLimit-Limit"))
+	remaining, errRemaining := strconv.Atoi(resp.Header.Get("RateLimit-Remaining"))
+	reset, errReset := strconv.ParseInt(resp.Header.Get("RateLimit-Reset"), 10, 64)
+	if errLimit == nil && errRemaining == nil && errReset == nil {
+		t.budget.Observe(limit, remaining, time.Unix(reset, 0))
+	}
+
+	return resp, nil
+}
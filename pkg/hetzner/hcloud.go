@@ -2,15 +2,20 @@ package hetzner
 
 import (
 	"fmt"
+	"net/http"
 	"runtime/debug"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 )
 
-func NewHCloudClient(cfg *config.Config) *hcloud.Client {
+// newClient returns a client of project for the API at baseURL authenticated
+// with token. Its requests spend the budget of token in budgets.
+func newClient(cfg *config.Config, budgets *Budgets, project, token, baseURL string) *hcloud.Client {
 	version := "dev"
 	if info, ok := debug.ReadBuildInfo(); ok {
 		for _, setting := range info.Settings {
@@ -22,9 +27,18 @@ func NewHCloudClient(cfg *config.Config) *hcloud.Client {
 	}
 
 	opts := []hcloud.ClientOption{
-		hcloud.WithToken(cfg.Token),
+		hcloud.WithToken(token),
 		hcloud.WithApplication("hetzner-dnsapi-proxy", version),
-		hcloud.WithEndpoint(cfg.BaseURL),
+		hcloud.WithEndpoint(baseURL),
+		hcloud.WithHTTPClient(&http.Client{
+			Transport: newRetryTransport(&budgetTransport{
+				next:   &instrumentedTransport{next: http.DefaultTransport},
+				budget: budgets.budget(token, project, cfg.APIBudget.Reserve),
+			}, time.Duration(cfg.Timeout)*time.Second),
+		}),
+		// Retries are made by retryTransport, which honors the headers of
+		// rate limited responses
+		hcloud.WithRetryOpts(hcloud.RetryOpts{MaxRetries: 0}),
 	}
 
 	return hcloud.NewClient(opts...)
@@ -34,21 +48,21 @@ func RRSetTypeFromString(rType string) (hcloud.ZoneRRSetType, error) {
 	switch rrType := hcloud.ZoneRRSetType(rType); rrType {
 	case hcloud.ZoneRRSetTypeA,
 		hcloud.ZoneRRSetTypeAAAA,
-		hcloud.ZoneRRSetTypeTXT:
-		return rrType, nil
-	case hcloud.ZoneRRSetTypeCAA,
+		hcloud.ZoneRRSetTypeCAA,
 		hcloud.ZoneRRSetTypeCNAME,
-		hcloud.ZoneRRSetTypeDS,
-		hcloud.ZoneRRSetTypeHINFO,
 		hcloud.ZoneRRSetTypeHTTPS,
 		hcloud.ZoneRRSetTypeMX,
-		hcloud.ZoneRRSetTypeNS,
 		hcloud.ZoneRRSetTypePTR,
-		hcloud.ZoneRRSetTypeRP,
-		hcloud.ZoneRRSetTypeSOA,
 		hcloud.ZoneRRSetTypeSRV,
 		hcloud.ZoneRRSetTypeSVCB,
-		hcloud.ZoneRRSetTypeTLSA:
+		hcloud.ZoneRRSetTypeTLSA,
+		hcloud.ZoneRRSetTypeTXT:
+		return rrType, nil
+	case hcloud.ZoneRRSetTypeDS,
+		hcloud.ZoneRRSetTypeHINFO,
+		hcloud.ZoneRRSetTypeNS,
+		hcloud.ZoneRRSetTypeRP,
+		hcloud.ZoneRRSetTypeSOA:
 		return "", fmt.Errorf("unsupported resource record set type %s", rrType)
 	default:
 		return "", fmt.Errorf("unrecognized resource record set type %s", rType)
@@ -61,3 +75,45 @@ func QuoteIfRequired(val string, rrSetType hcloud.ZoneRRSetType) string {
 	}
 	return val
 }
+
+// UnquoteIfRequired reverses QuoteIfRequired. TXT values consisting of
+// multiple quoted strings are concatenated.
+func UnquoteIfRequired(val string, rrSetType hcloud.ZoneRRSetType) string {
+	if rrSetType != hcloud.ZoneRRSetTypeTXT {
+		return val
+	}
+
+	var b strings.Builder
+	rest := strings.TrimSpace(val)
+	for rest != "" {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return val
+		}
+		unquoted, err := strconv.Unquote(quoted)
+		if err != nil {
+			return val
+		}
+		b.WriteString(unquoted)
+		rest = strings.TrimSpace(rest[len(quoted):])
+	}
+	return b.String()
+}
+
+// Records converts values into records of an rrset of type rrSetType.
+func Records(values []string, rrSetType hcloud.ZoneRRSetType) []hcloud.ZoneRRSetRecord {
+	records := make([]hcloud.ZoneRRSetRecord, 0, len(values))
+	for _, val := range values {
+		records = append(records, hcloud.ZoneRRSetRecord{Value: QuoteIfRequired(val, rrSetType)})
+	}
+	return records
+}
+
+// Values converts the records of rrSet into plain values.
+func Values(rrSet *hcloud.ZoneRRSet) []string {
+	values := make([]string, 0, len(rrSet.Records))
+	for _, record := range rrSet.Records {
+		values = append(values, UnquoteIfRequired(record.Value, rrSet.Type))
+	}
+	return values
+}
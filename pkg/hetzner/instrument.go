@@ -0,0 +1,66 @@
+package hetzner
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/exp/ctxutil"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
+)
+
+// operations maps the method and operation path of requests made by the
+// hcloud client to the name of the client method making them. Polling the
+// actions endpoint is only done by Action.WaitFor.
+var operations = map[string]string{
+	"GET /zones":                                      "Zone.All",
+	"GET /zones/-":                                    "Zone.Get",
+	"GET /zones/-/rrsets":                             "Zone.AllRRSets",
+	"POST /zones/-/rrsets":                            "Zone.CreateRRSet",
+	"GET /zones/-/rrsets/-/-":                         "Zone.GetRRSetByNameAndType",
+	"PUT /zones/-/rrsets/-/-":                         "Zone.UpdateRRSet",
+	"DELETE /zones/-/rrsets/-/-":                      "Zone.DeleteRRSet",
+	"POST /zones/-/rrsets/-/-/actions/change_ttl":     "Zone.ChangeRRSetTTL",
+	"POST /zones/-/rrsets/-/-/actions/set_records":    "Zone.SetRRSetRecords",
+	"POST /zones/-/rrsets/-/-/actions/add_records":    "Zone.AddRRSetRecords",
+	"POST /zones/-/rrsets/-/-/actions/remove_records": "Zone.RemoveRRSetRecords",
+	"GET /actions":                                    "Action.WaitFor",
+}
+
+// instrumentedTransport records metrics about each request to the Hetzner
+// API.
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(r)
+
+	code := 0
+	if resp != nil {
+		code = resp.StatusCode
+	}
+	metrics.ObserveAPIRequest(operation(r), code, isAPIError(r, code, err), time.Since(start))
+
+	return resp, err
+}
+
+func operation(r *http.Request) string {
+	if op, ok := operations[r.Method+" "+ctxutil.OpPath(r.Context())]; ok {
+		return op
+	}
+	return "other"
+}
+
+// isAPIError returns true if a request failed. Not found responses to GET
+// requests are not errors, the hcloud client reports them as nil results.
+func isAPIError(r *http.Request, code int, err error) bool {
+	if err != nil {
+		return true
+	}
+	if code == http.StatusNotFound && r.Method == http.MethodGet {
+		return false
+	}
+	return code >= http.StatusBadRequest
+}
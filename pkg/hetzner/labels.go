@@ -0,0 +1,63 @@
+package hetzner
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const (
+	// LabelManagedBy marks rrsets created by the proxy, rrsets without it
+	// are managed by hand.
+	LabelManagedBy = "managed-by"
+	// LabelCreatedBy holds the user that created an rrset in ownership mode.
+	LabelCreatedBy = "created-by"
+	managedBy      = "hetzner-dnsapi-proxy"
+
+	maxLabelValueLength = 63
+)
+
+// ErrUnmanaged is returned if an rrset that is not managed by the proxy is
+// to be changed in ownership mode without takeover permission.
+var ErrUnmanaged = errors.New("rrset is not managed by the proxy")
+
+// ManagedLabels returns the labels of rrsets created by the proxy.
+func ManagedLabels() map[string]string {
+	return map[string]string{LabelManagedBy: managedBy}
+}
+
+// OwnedLabels returns the labels of rrsets created by the proxy on behalf of
+// user. The user is omitted if its name is not a valid label value at all.
+func OwnedLabels(user string) map[string]string {
+	labels := ManagedLabels()
+	if v := labelValue(user); v != "" {
+		labels[LabelCreatedBy] = v
+	}
+	return labels
+}
+
+// IsManaged returns true if rrSet was created by the proxy.
+func IsManaged(rrSet *hcloud.ZoneRRSet) bool {
+	return rrSet.Labels[LabelManagedBy] == managedBy
+}
+
+// labelValue turns s into a valid label value. Invalid characters are
+// replaced and the value is cut to the maximum length, so it starts and ends
+// with an alphanumeric character.
+func labelValue(s string) string {
+	v := strings.Map(func(r rune) rune {
+		if isAlphanumeric(r) || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, s)
+	if len(v) > maxLabelValueLength {
+		v = v[:maxLabelValueLength]
+	}
+	return strings.TrimFunc(v, func(r rune) bool { return !isAlphanumeric(r) })
+}
+
+func isAlphanumeric(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
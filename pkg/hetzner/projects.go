@@ -0,0 +1,152 @@
+package hetzner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+)
+
+// ErrNoProject is returned if a zone belongs to no configured project and
+// there is no top-level token to fall back to.
+var ErrNoProject = errors.New("no project configured for zone")
+
+// defaultProject is the name of the project of the top-level token.
+const defaultProject = "default"
+
+// Projects routes requests to the client of the Hetzner Cloud project a zone
+// belongs to.
+type Projects struct {
+	projects []project
+}
+
+type project struct {
+	name   string
+	zones  []string
+	client *hcloud.Client
+}
+
+// NewProjects returns a client for every configured project. Zones matching
+// no project are routed to the client of the top-level token, if any. The
+// clients spend the budgets of their tokens in budgets.
+func NewProjects(cfg *config.Config, budgets *Budgets) *Projects {
+	p := &Projects{}
+	for _, proj := range cfg.Projects {
+		p.projects = append(p.projects, project{
+			name:   proj.Name,
+			zones:  proj.Zones,
+			client: newClient(cfg, budgets, proj.Name, proj.Token, proj.BaseURL),
+		})
+	}
+	if cfg.Token != "" {
+		p.projects = append(p.projects, project{
+			name:   defaultProject,
+			client: newClient(cfg, budgets, defaultProject, cfg.Token, cfg.BaseURL),
+		})
+	}
+	return p
+}
+
+// Client returns the client of the project zone belongs to.
+func (p *Projects) Client(zone string) (*hcloud.Client, error) {
+	if proj := p.project(zone); proj != nil {
+		return proj.client, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNoProject, zone)
+}
+
+// AllZones returns the zones matching opts of all projects. Zones visible to
+// the token of a project they are not routed to are left out.
+func (p *Projects) AllZones(ctx context.Context, opts hcloud.ZoneListOpts) ([]*hcloud.Zone, error) {
+	var zones []*hcloud.Zone
+	for i := range p.projects {
+		proj := &p.projects[i]
+		projZones, err := proj.client.Zone.AllWithOpts(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list zones of project %s: %w", proj.name, err)
+		}
+		for _, zone := range projZones {
+			if p.project(zone.Name) == proj {
+				zones = append(zones, zone)
+			}
+		}
+	}
+	return zones, nil
+}
+
+// GetZone returns the zone with the id or name idOrName, or nil if no
+// project has such a zone. Zones are looked up by id in all projects.
+func (p *Projects) GetZone(ctx context.Context, idOrName string) (*hcloud.Zone, error) {
+	if _, err := strconv.ParseInt(idOrName, 10, 64); err != nil {
+		proj := p.project(idOrName)
+		if proj == nil {
+			return nil, nil
+		}
+		zone, _, getErr := proj.client.Zone.GetByName(ctx, idOrName)
+		return zone, getErr
+	}
+
+	for i := range p.projects {
+		proj := &p.projects[i]
+		zone, _, err := proj.client.Zone.Get(ctx, idOrName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get zone of project %s: %w", proj.name, err)
+		}
+		if zone != nil && p.project(zone.Name) == proj {
+			return zone, nil
+		}
+	}
+	return nil, nil
+}
+
+// AllRRSets returns all rrsets of zone.
+func (p *Projects) AllRRSets(ctx context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error) {
+	client, err := p.Client(zone.Name)
+	if err != nil {
+		return nil, err
+	}
+	return client.Zone.AllRRSets(ctx, zone)
+}
+
+// GetRRSet returns the rrset of zone with name and rrSetType, or nil if it
+// does not exist.
+func (p *Projects) GetRRSet(
+	ctx context.Context, zone *hcloud.Zone, name string, rrSetType hcloud.ZoneRRSetType,
+) (*hcloud.ZoneRRSet, error) {
+	client, err := p.Client(zone.Name)
+	if err != nil {
+		return nil, err
+	}
+	rrSet, _, err := client.Zone.GetRRSetByNameAndType(ctx, zone, name, rrSetType)
+	return rrSet, err
+}
+
+// project returns the first project zone belongs to, or nil.
+func (p *Projects) project(zone string) *project {
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	for i := range p.projects {
+		if p.projects[i].zones == nil || matchesAny(p.projects[i].zones, zone) {
+			return &p.projects[i]
+		}
+	}
+	return nil
+}
+
+// matchesAny returns true if zone is equal to one of patterns or below a
+// pattern of the form *.example.com.
+func matchesAny(patterns []string, zone string) bool {
+	for _, pattern := range patterns {
+		if zone == pattern {
+			return true
+		}
+		if parent, ok := strings.CutPrefix(pattern, "*."); ok && strings.HasSuffix(zone, "."+parent) {
+			return true
+		}
+	}
+	return false
+}
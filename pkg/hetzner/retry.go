@@ -0,0 +1,176 @@
+package hetzner
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
+)
+
+const (
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+	// maxErrorBodySize limits how much of an error response is read to find
+	// its error code
+	maxErrorBodySize = 64 << 10
+)
+
+// retryableCodes are the hcloud error codes of requests that may succeed when
+// sent again.
+var retryableCodes = map[hcloud.ErrorCode]bool{
+	hcloud.ErrorCodeRateLimitExceeded:   true,
+	hcloud.ErrorCodeConflict:            true,
+	hcloud.ErrorCodeLocked:              true,
+	hcloud.ErrorCodeTimeout:             true,
+	hcloud.ErrorCodeMaintenance:         true,
+	hcloud.ErrorCodeResourceUnavailable: true,
+	hcloud.ErrorCodeRobotUnavailable:    true,
+}
+
+// retryTransport sends requests to the Hetzner API again if they were rate
+// limited or failed transiently. It waits with jittered exponential backoff,
+// or longer if a rate limited response asks for it, and gives up once the
+// next attempt could not be made within timeout or the deadline of the
+// request.
+type retryTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+	backoff hcloud.BackoffFunc
+	now     func() time.Time
+}
+
+func newRetryTransport(next http.RoundTripper, timeout time.Duration) *retryTransport {
+	return &retryTransport{
+		next:    next,
+		timeout: timeout,
+		backoff: hcloud.ExponentialBackoffWithOpts(hcloud.ExponentialBackoffOpts{
+			Base:       retryBaseDelay,
+			Multiplier: 2, //nolint:mnd
+			Cap:        retryMaxDelay,
+			Jitter:     true,
+		}),
+		now: time.Now,
+	}
+}
+
+func (t *retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	deadline := t.now().Add(t.timeout)
+	if d, ok := r.Context().Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	req := r
+	for retries := 0; ; retries++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || !retryable(r, resp) {
+			return resp, err
+		}
+
+		wait := t.backoff(retries)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			wait = max(wait, retryAfter(resp, t.now()))
+		}
+		if t.now().Add(wait).After(deadline) {
+			return resp, nil
+		}
+		next, ok := cloneRequest(r)
+		if !ok {
+			return resp, nil
+		}
+		if err := resp.Body.Close(); err != nil {
+			return nil, err
+		}
+
+		op := operation(r)
+		log.Printf("retrying %s after %s, the Hetzner API answered with status %d", op, wait, resp.StatusCode)
+		metrics.APIRetry(op)
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return nil, r.Context().Err()
+		case <-timer.C:
+		}
+		req = next
+	}
+}
+
+// retryable returns true if the request r answered with resp may succeed
+// when sent again. Rate limited requests and server errors are retried,
+// other errors only if their hcloud error code marks them as transient.
+// POST requests are not retried on server errors, as they are not idempotent
+// and might have been applied already. The body of resp is replaced, so it
+// can still be read by the caller.
+func retryable(r *http.Request, resp *http.Response) bool {
+	if resp.StatusCode < http.StatusBadRequest {
+		return false
+	}
+	if resp.StatusCode >= http.StatusInternalServerError && r.Method == http.MethodPost {
+		return false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+	if err != nil {
+		return false
+	}
+	var errResp schema.ErrorResponse
+	if json.Unmarshal(body, &errResp) == nil && retryableCodes[hcloud.ErrorCode(errResp.Error.Code)] {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// readCloser reads an error response body that was partly read already.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// retryAfter returns how long a rate limited response asks to wait before
+// sending the request again. Retry-After is given in seconds or as HTTP
+// date. Without it, a used up budget is waited for until RateLimit-Reset.
+// Waits beyond the deadline of the request are not made by RoundTrip.
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t.Sub(now)
+		}
+	}
+	remaining, errRemaining := strconv.Atoi(resp.Header.Get("RateLimit-Remaining"))
+	reset, errReset := strconv.ParseInt(resp.Header.Get("RateLimit-Reset"), 10, 64)
+	if errRemaining == nil && errReset == nil && remaining <= 0 {
+		return time.Unix(reset, 0).Sub(now)
+	}
+	return 0
+}
+
+// cloneRequest returns a copy of r that can be sent again. It returns false
+// if the body of r cannot be read again.
+func cloneRequest(r *http.Request) (*http.Request, bool) {
+	clone := r.Clone(r.Context())
+	if r.Body == nil || r.Body == http.NoBody {
+		return clone, true
+	}
+	if r.GetBody == nil {
+		return nil, false
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	clone.Body = body
+	return clone, true
+}
@@ -0,0 +1,121 @@
+package hetzner
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// failedRefreshDelay is the time after a failed refresh before the zones
+// are listed again, unless the refresh interval is shorter.
+const failedRefreshDelay = time.Minute
+
+// ZoneCache caches the names of the zones visible to the API tokens of all
+// projects and refreshes them once they are older than the refresh interval.
+type ZoneCache struct {
+	mu       sync.Mutex
+	projects *Projects
+	refresh  time.Duration
+	zones    []string
+	fetched  time.Time
+	failed   time.Time
+	// refreshing is closed once the refresh in flight is done, it is nil
+	// if there is none
+	refreshing chan struct{}
+	now        func() time.Time
+}
+
+func NewZoneCache(projects *Projects, refresh time.Duration) *ZoneCache {
+	return &ZoneCache{
+		projects: projects,
+		refresh:  refresh,
+		now:      time.Now,
+	}
+}
+
+// Zones returns the cached zone names, refreshing them first if they are
+// stale. Only one refresh is made at a time, callers of stale zones get them
+// while a refresh is in flight and callers without zones wait for it. If the
+// refresh fails the previously cached zones are returned and the zones are
+// not listed again for failedRefreshDelay.
+func (c *ZoneCache) Zones(ctx context.Context) []string {
+	c.mu.Lock()
+	now := c.now()
+	if c.fresh(now) || (c.refreshing != nil && !c.fetched.IsZero()) {
+		defer c.mu.Unlock()
+		return c.zones
+	}
+	if c.refreshing != nil {
+		refreshing := c.refreshing
+		c.mu.Unlock()
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.zones
+	}
+	refreshing := make(chan struct{})
+	c.refreshing = refreshing
+	c.mu.Unlock()
+
+	zones, err := c.projects.AllZones(ctx, hcloud.ZoneListOpts{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = nil
+	close(refreshing)
+	if err != nil {
+		log.Printf("failed to list zones: %v", err)
+		// A canceled request says nothing about the API
+		if ctx.Err() == nil {
+			c.failed = now
+		}
+		return c.zones
+	}
+
+	c.zones = make([]string, 0, len(zones))
+	for _, zone := range zones {
+		c.zones = append(c.zones, strings.ToLower(zone.Name))
+	}
+	c.fetched = now
+	return c.zones
+}
+
+// fresh returns true if the zones were refreshed within the refresh interval
+// or the last refresh failed too recently to try again. c.mu must be held.
+func (c *ZoneCache) fresh(now time.Time) bool {
+	if !c.fetched.IsZero() && now.Sub(c.fetched) < c.refresh {
+		return true
+	}
+	return !c.failed.IsZero() && c.failed.After(c.fetched) && now.Sub(c.failed) < min(c.refresh, failedRefreshDelay)
+}
+
+// Split splits fqdn into name and zone using the longest cached zone that
+// fqdn is part of. ok is false if no cached zone matches.
+func (c *ZoneCache) Split(ctx context.Context, fqdn string) (name, zone string, ok bool) {
+	return MatchZone(c.Zones(ctx), fqdn)
+}
+
+// MatchZone returns the name relative to the longest zone in zones that fqdn
+// is equal to or a subdomain of.
+func MatchZone(zones []string, fqdn string) (name, zone string, ok bool) {
+	fqdn = strings.TrimSuffix(fqdn, ".")
+	lower := strings.ToLower(fqdn)
+	for _, z := range zones {
+		if len(z) <= len(zone) {
+			continue
+		}
+		if lower == z {
+			name, zone, ok = "", z, true
+		} else if strings.HasSuffix(lower, "."+z) {
+			name, zone, ok = fqdn[:len(fqdn)-len(z)-1], z, true
+		}
+	}
+	return name, zone, ok
+}
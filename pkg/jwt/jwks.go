@@ -0,0 +1,307 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// minRSABits is the minimum size of accepted RSA keys.
+	minRSABits = 2048
+	// maxKeySetSize is the maximum size of a key set read from a URL.
+	maxKeySetSize = 1 << 20
+	// minLoadInterval is the minimum time between two loads of the key set
+	// triggered by failed loads or tokens with an unknown key id, so tokens
+	// with random key ids or an unreachable key source do not cause a load
+	// per request.
+	minLoadInterval = time.Minute
+)
+
+// key is a public key of a key set.
+type key struct {
+	id  string
+	alg string
+	pub crypto.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseKeySet parses the signing keys of the JSON Web Key Set data. Keys of
+// unsupported types or for encryption are skipped.
+func parseKeySet(data []byte) ([]key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode key set: %w", err)
+	}
+
+	keys := make([]key, 0, len(set.Keys))
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		if pub != nil {
+			keys = append(keys, key{id: k.Kid, alg: k.Alg, pub: pub})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("key set contains no signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey returns the public key of k, nil if its type is not supported.
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		return k.rsaKey()
+	case "EC":
+		return k.ecKey()
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func (k *jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, errN := base64.RawURLEncoding.DecodeString(k.N)
+	e, errE := base64.RawURLEncoding.DecodeString(k.E)
+	if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid RSA key")
+	}
+	pub := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+	if pub.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key must have at least %d bits", minRSABits)
+	}
+	return pub, nil
+}
+
+func (k *jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, nil
+	}
+	size := (curve.Params().BitSize + 7) / 8 //nolint:mnd // bits to bytes
+	x, errX := base64.RawURLEncoding.DecodeString(k.X)
+	y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+	if errX != nil || errY != nil || len(x) != size || len(y) != size {
+		return nil, errors.New("invalid EC key")
+	}
+	// uncompressed point as of SEC 1, section 2.3.3
+	point := append(append([]byte{4}, x...), y...)
+	pub, err := ecdsa.ParseUncompressedPublicKey(curve, point)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EC key: %w", err)
+	}
+	return pub, nil
+}
+
+// curveOf returns the curve of the ECDSA algorithm alg.
+func curveOf(alg string) elliptic.Curve {
+	switch alg {
+	case AlgES256:
+		return elliptic.P256()
+	case AlgES384:
+		return elliptic.P384()
+	case AlgES512:
+		return elliptic.P521()
+	}
+	return nil
+}
+
+// KeySource returns the content of a JSON Web Key Set.
+type KeySource func() ([]byte, error)
+
+// File returns a KeySource reading the key set from the file at path.
+func File(path string) KeySource {
+	return func() ([]byte, error) {
+		return os.ReadFile(path)
+	}
+}
+
+// URL returns a KeySource fetching the key set from url, e.g. the jwks_uri
+// of an OIDC provider.
+func URL(url string, timeout time.Duration) KeySource {
+	client := &http.Client{Timeout: timeout}
+	return func() ([]byte, error) {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, http.NoBody)
+		if err != nil {
+			return nil, err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = res.Body.Close()
+		}()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch key set: %s", res.Status)
+		}
+		return io.ReadAll(io.LimitReader(res.Body, maxKeySetSize))
+	}
+}
+
+// Verifier verifies tokens of an issuer for an audience. The keys of its key
+// set are cached and loaded again once they are older than the refresh
+// interval or a token is signed with an unknown key, e.g. after the issuer
+// rotated its keys. If loading fails, the cached keys are kept.
+type Verifier struct {
+	issuer   string
+	audience string
+	source   KeySource
+	refresh  time.Duration
+
+	mu          sync.Mutex
+	keys        []key
+	loadedAt    time.Time
+	attemptedAt time.Time
+}
+
+// NewVerifier returns a Verifier for tokens of issuer for audience with the
+// keys of source.
+func NewVerifier(issuer, audience string, source KeySource, refresh time.Duration) *Verifier {
+	return &Verifier{
+		issuer:   issuer,
+		audience: audience,
+		source:   source,
+		refresh:  refresh,
+	}
+}
+
+// Refresh loads the keys from the key source.
+func (v *Verifier) Refresh() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.load(time.Now())
+}
+
+func (v *Verifier) load(now time.Time) error {
+	v.attemptedAt = now
+	data, err := v.source()
+	if err != nil {
+		return err
+	}
+	keys, err := parseKeySet(data)
+	if err != nil {
+		return err
+	}
+	v.keys = keys
+	v.loadedAt = now
+	return nil
+}
+
+// Verify returns an error if t was not signed with a key of the key set or
+// its claims are not valid for the issuer and audience of v.
+func (v *Verifier) Verify(t *Token) error {
+	now := time.Now()
+	if err := t.checkClaims(v.issuer, v.audience, now); err != nil {
+		return err
+	}
+	keys, err := v.keysFor(t, now)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := t.verifySignature(k.pub); err == nil {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
+
+// keysFor returns the cached keys t may be signed with, loading the key set
+// first if needed.
+func (v *Verifier) keysFor(t *Token, now time.Time) ([]key, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.needsLoad(t, now) {
+		if err := v.load(now); err != nil {
+			if len(v.keys) == 0 {
+				return nil, fmt.Errorf("failed to load key set: %w", err)
+			}
+			log.Printf("Failed to load key set of issuer %s, keeping the cached keys: %v", v.issuer, err)
+		}
+	}
+
+	keys := matchingKeys(v.keys, t)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key with id %q for algorithm %s", t.KeyID, t.Alg)
+	}
+	return keys, nil
+}
+
+// needsLoad returns true if the key set was never loaded, its keys are
+// older than the refresh interval or t is signed with an unknown key. Failed
+// loads and loads for unknown keys are retried after minLoadInterval.
+func (v *Verifier) needsLoad(t *Token, now time.Time) bool {
+	if v.attemptedAt.IsZero() {
+		return true
+	}
+	if now.Sub(v.attemptedAt) < minLoadInterval {
+		return false
+	}
+	return now.Sub(v.loadedAt) >= v.refresh || len(matchingKeys(v.keys, t)) == 0
+}
+
+// matchingKeys returns the keys of keys with the key id of t, or all if t
+// has none, that may be used with its algorithm.
+func matchingKeys(keys []key, t *Token) []key {
+	var matching []key
+	for _, k := range keys {
+		if (t.KeyID != "" && k.id != t.KeyID) || (k.alg != "" && k.alg != t.Alg) {
+			continue
+		}
+		matching = append(matching, k)
+	}
+	return matching
+}
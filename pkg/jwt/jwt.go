@@ -0,0 +1,248 @@
+// Package jwt verifies JSON Web Tokens signed with asymmetric keys, like the
+// OIDC ID tokens minted for CI jobs, against the keys of a JSON Web Key Set.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256" // register the hashes of the algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgRS384 = "RS384"
+	AlgRS512 = "RS512"
+	AlgPS256 = "PS256"
+	AlgPS384 = "PS384"
+	AlgPS512 = "PS512"
+	AlgES256 = "ES256"
+	AlgES384 = "ES384"
+	AlgES512 = "ES512"
+	AlgEdDSA = "EdDSA"
+)
+
+// leeway is the clock skew tolerated when checking the times of a token.
+const leeway = time.Minute
+
+// Claims are the claims of a token. Numbers are kept as json.Number.
+type Claims map[string]any
+
+// Token is a parsed, but not yet verified token.
+type Token struct {
+	Alg    string
+	KeyID  string
+	Claims Claims
+
+	signed    []byte
+	signature []byte
+}
+
+type header struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+// Parse parses token in JWS compact serialization without verifying it.
+func Parse(token string) (*Token, error) {
+	parts := strings.Split(token, ".")
+	const expectedParts = 3
+	if len(parts) != expectedParts {
+		return nil, errors.New("token is not a JWS in compact serialization")
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("failed to decode header: %w", err)
+	}
+	if len(h.Crit) > 0 {
+		return nil, fmt.Errorf("unsupported critical header parameters %v", h.Crit)
+	}
+	if _, err := hashOf(h.Alg); err != nil {
+		return nil, err
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("failed to decode claims: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	return &Token{
+		Alg:       h.Alg,
+		KeyID:     h.Kid,
+		Claims:    claims,
+		signed:    []byte(parts[0] + "." + parts[1]),
+		signature: signature,
+	}, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// Issuer returns the iss claim of the token.
+func (t *Token) Issuer() string {
+	iss, _ := t.Claims["iss"].(string)
+	return iss
+}
+
+// Subject returns the sub claim of the token.
+func (t *Token) Subject() string {
+	sub, _ := t.Claims["sub"].(string)
+	return sub
+}
+
+// checkClaims returns an error if the token was not issued by issuer for
+// audience or is not valid at now. Tokens without expiry are rejected.
+func (t *Token) checkClaims(issuer, audience string, now time.Time) error {
+	if t.Issuer() != issuer {
+		return fmt.Errorf("token was issued by %q", t.Issuer())
+	}
+	if !t.hasAudience(audience) {
+		return fmt.Errorf("token was not issued for audience %q", audience)
+	}
+	exp, ok, err := t.time("exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if !now.Before(exp.Add(leeway)) {
+		return fmt.Errorf("token expired at %s", exp.Format(time.RFC3339))
+	}
+	nbf, ok, err := t.time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(leeway).Before(nbf) {
+		return fmt.Errorf("token is not valid before %s", nbf.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// hasAudience returns true if the aud claim, a string or a list of strings,
+// contains audience.
+func (t *Token) hasAudience(audience string) bool {
+	switch aud := t.Claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// time returns the time of the NumericDate claim name and whether it is set.
+func (t *Token) time(name string) (time.Time, bool, error) {
+	v, ok := t.Claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a number", name)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a number: %w", name, err)
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), true, nil
+}
+
+// verifySignature returns an error if the signature of the token was not
+// made with key.
+func (t *Token) verifySignature(key crypto.PublicKey) error {
+	hash, err := hashOf(t.Alg)
+	if err != nil {
+		return err
+	}
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(t.signed)
+		digest = h.Sum(nil)
+	}
+
+	valid := false
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		valid = verifyRSA(t.Alg, k, hash, digest, t.signature)
+	case *ecdsa.PublicKey:
+		valid = verifyECDSA(t.Alg, k, digest, t.signature)
+	case ed25519.PublicKey:
+		valid = t.Alg == AlgEdDSA && ed25519.Verify(k, t.signed, t.signature)
+	}
+	if !valid {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func verifyRSA(alg string, key *rsa.PublicKey, hash crypto.Hash, digest, signature []byte) bool {
+	switch alg {
+	case AlgRS256, AlgRS384, AlgRS512:
+		return rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+	case AlgPS256, AlgPS384, AlgPS512:
+		return rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+	}
+	return false
+}
+
+// verifyECDSA verifies signature, the concatenated r and s, and that the
+// curve of key is the one of alg.
+func verifyECDSA(alg string, key *ecdsa.PublicKey, digest, signature []byte) bool {
+	if curveOf(alg) != key.Curve {
+		return false
+	}
+	size := (key.Curve.Params().BitSize + 7) / 8 //nolint:mnd // bits to bytes
+	if len(signature) != 2*size {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+	return ecdsa.Verify(key, digest, r, s)
+}
+
+// hashOf returns the hash of alg, 0 for EdDSA which signs the message
+// itself.
+func hashOf(alg string) (crypto.Hash, error) {
+	switch alg {
+	case AlgRS256, AlgPS256, AlgES256:
+		return crypto.SHA256, nil
+	case AlgRS384, AlgPS384, AlgES384:
+		return crypto.SHA384, nil
+	case AlgRS512, AlgPS512, AlgES512:
+		return crypto.SHA512, nil
+	case AlgEdDSA:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unsupported algorithm %q", alg)
+}
@@ -0,0 +1,330 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
+)
+
+const (
+	issuer   = "https://gitlab.example.com"
+	audience = "hetzner-dnsapi-proxy"
+)
+
+type signingKey struct {
+	kid  string
+	alg  string
+	priv crypto.Signer
+}
+
+func newSigningKey(kid, alg string) *signingKey {
+	var (
+		priv crypto.Signer
+		err  error
+	)
+	switch alg {
+	case jwt.AlgRS256, jwt.AlgPS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.AlgES256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.AlgES384:
+		priv, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case jwt.AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	}
+	Expect(err).ToNot(HaveOccurred())
+	return &signingKey{kid: kid, alg: alg, priv: priv}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (k *signingKey) jwk() map[string]any {
+	m := map[string]any{"kid": k.kid, "use": "sig"}
+	switch pub := k.priv.Public().(type) {
+	case *rsa.PublicKey:
+		m["kty"] = "RSA"
+		m["n"] = b64(pub.N.Bytes())
+		m["e"] = b64([]byte{1, 0, 1})
+	case *ecdsa.PublicKey:
+		point, err := pub.Bytes()
+		Expect(err).ToNot(HaveOccurred())
+		size := (len(point) - 1) / 2
+		m["kty"] = "EC"
+		m["crv"] = pub.Curve.Params().Name
+		m["x"] = b64(point[1 : 1+size])
+		m["y"] = b64(point[1+size:])
+	case ed25519.PublicKey:
+		m["kty"] = "OKP"
+		m["crv"] = "Ed25519"
+		m["x"] = b64(pub)
+	}
+	return m
+}
+
+func jwks(keys ...*signingKey) []byte {
+	set := map[string]any{"keys": []any{}}
+	for _, k := range keys {
+		set["keys"] = append(set["keys"].([]any), k.jwk())
+	}
+	data, err := json.Marshal(set)
+	Expect(err).ToNot(HaveOccurred())
+	return data
+}
+
+func (k *signingKey) sign(claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": k.alg, "kid": k.kid, "typ": "JWT"})
+	Expect(err).ToNot(HaveOccurred())
+	payload, err := json.Marshal(claims)
+	Expect(err).ToNot(HaveOccurred())
+	signed := b64(header) + "." + b64(payload)
+
+	var signature []byte
+	switch k.alg {
+	case jwt.AlgEdDSA:
+		signature, err = k.priv.Sign(rand.Reader, []byte(signed), crypto.Hash(0))
+	case jwt.AlgES256, jwt.AlgES384:
+		signature, err = k.signECDSA([]byte(signed))
+	default:
+		var opts crypto.SignerOpts = crypto.SHA256
+		if k.alg == jwt.AlgPS256 {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		}
+		digest := crypto.SHA256.New()
+		digest.Write([]byte(signed))
+		signature, err = k.priv.Sign(rand.Reader, digest.Sum(nil), opts)
+	}
+	Expect(err).ToNot(HaveOccurred())
+	return signed + "." + b64(signature)
+}
+
+func (k *signingKey) signECDSA(signed []byte) ([]byte, error) {
+	priv := k.priv.(*ecdsa.PrivateKey)
+	hash := crypto.SHA256
+	if k.alg == jwt.AlgES384 {
+		hash = crypto.SHA384
+	}
+	digest := hash.New()
+	digest.Write(signed)
+	r, s, err := ecdsa.Sign(rand.Reader, priv, digest.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	size := (priv.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature, nil
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":          issuer,
+		"aud":          audience,
+		"sub":          "project_path:group/project:ref_type:branch:ref:main",
+		"project_path": "group/project",
+		"exp":          time.Now().Add(time.Hour).Unix(),
+		"nbf":          time.Now().Add(-time.Minute).Unix(),
+	}
+}
+
+func staticSource(data []byte) jwt.KeySource {
+	return func() ([]byte, error) {
+		return data, nil
+	}
+}
+
+func mustParse(token string) *jwt.Token {
+	t, err := jwt.Parse(token)
+	Expect(err).ToNot(HaveOccurred())
+	return t
+}
+
+var _ = Describe("JWT", func() {
+	var key *signingKey
+
+	BeforeEach(func() {
+		key = newSigningKey("key-1", jwt.AlgES256)
+	})
+
+	DescribeTable("should verify tokens signed with", func(alg string) {
+		k := newSigningKey("key", alg)
+		v := jwt.NewVerifier(issuer, audience, staticSource(jwks(k)), time.Hour)
+		t := mustParse(k.sign(validClaims()))
+		Expect(v.Verify(t)).To(Succeed())
+		Expect(t.Issuer()).To(Equal(issuer))
+		Expect(t.Subject()).To(Equal("project_path:group/project:ref_type:branch:ref:main"))
+		Expect(t.Claims).To(HaveKeyWithValue("project_path", "group/project"))
+	},
+		Entry("RS256", jwt.AlgRS256),
+		Entry("PS256", jwt.AlgPS256),
+		Entry("ES256", jwt.AlgES256),
+		Entry("ES384", jwt.AlgES384),
+		Entry("EdDSA", jwt.AlgEdDSA),
+	)
+
+	It("should accept the audience in a list", func() {
+		claims := validClaims()
+		claims["aud"] = []string{"other", audience}
+		v := jwt.NewVerifier(issuer, audience, staticSource(jwks(key)), time.Hour)
+		Expect(v.Verify(mustParse(key.sign(claims)))).To(Succeed())
+	})
+
+	DescribeTable("should reject tokens", func(modify func(map[string]any), msg string) {
+		claims := validClaims()
+		modify(claims)
+		v := jwt.NewVerifier(issuer, audience, staticSource(jwks(key)), time.Hour)
+		Expect(v.Verify(mustParse(key.sign(claims)))).To(MatchError(ContainSubstring(msg)))
+	},
+		Entry("of other issuers", func(c map[string]any) {
+			c["iss"] = "https://token.actions.githubusercontent.com"
+		}, `token was issued by "https://token.actions.githubusercontent.com"`),
+		Entry("for other audiences", func(c map[string]any) {
+			c["aud"] = []string{"other"}
+		}, `token was not issued for audience "hetzner-dnsapi-proxy"`),
+		Entry("without expiry", func(c map[string]any) {
+			delete(c, "exp")
+		}, "token has no expiry"),
+		Entry("that expired", func(c map[string]any) {
+			c["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+		}, "token expired at"),
+		Entry("that are not valid yet", func(c map[string]any) {
+			c["nbf"] = time.Now().Add(2 * time.Minute).Unix()
+		}, "token is not valid before"),
+		Entry("with invalid expiry", func(c map[string]any) {
+			c["exp"] = "tomorrow"
+		}, "claim exp is not a number"),
+	)
+
+	It("should tolerate clock skew", func() {
+		claims := validClaims()
+		claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+		claims["nbf"] = time.Now().Add(30 * time.Second).Unix()
+		v := jwt.NewVerifier(issuer, audience, staticSource(jwks(key)), time.Hour)
+		Expect(v.Verify(mustParse(key.sign(claims)))).To(Succeed())
+	})
+
+	It("should reject tokens signed with other keys", func() {
+		other := newSigningKey(key.kid, jwt.AlgES256)
+		v := jwt.NewVerifier(issuer, audience, staticSource(jwks(key)), time.Hour)
+		Expect(v.Verify(mustParse(other.sign(validClaims())))).To(MatchError("invalid signature"))
+	})
+
+	It("should reject tokens with an unknown key id", func() {
+		other := newSigningKey("key-2", jwt.AlgES256)
+		v := jwt.NewVerifier(issuer, audience, staticSource(jwks(key)), time.Hour)
+		Expect(v.Verify(mustParse(other.sign(validClaims())))).To(MatchError(`no key with id "key-2" for algorithm ES256`))
+	})
+
+	It("should reject tokens whose algorithm does not match the key", func() {
+		ed := newSigningKey(key.kid, jwt.AlgEdDSA)
+		v := jwt.NewVerifier(issuer, audience, staticSource(jwks(key)), time.Hour)
+		Expect(v.Verify(mustParse(ed.sign(validClaims())))).To(MatchError("invalid signature"))
+	})
+
+	It("should reject tampered tokens", func() {
+		claims := validClaims()
+		claims["project_path"] = "group/other"
+		parts := strings.Split(key.sign(validClaims()), ".")
+		forged := strings.Split(key.sign(claims), ".")
+		v := jwt.NewVerifier(issuer, audience, staticSource(jwks(key)), time.Hour)
+		Expect(v.Verify(mustParse(parts[0] + "." + forged[1] + "." + parts[2]))).To(MatchError("invalid signature"))
+	})
+
+	DescribeTable("Parse should fail", func(token, msg string) {
+		_, err := jwt.Parse(token)
+		Expect(err).To(MatchError(ContainSubstring(msg)))
+	},
+		Entry("on other tokens", "hdp_token", "token is not a JWS in compact serialization"),
+		Entry("on invalid headers", "!.e30.", "failed to decode header"),
+		Entry("on unsigned tokens", b64([]byte(`{"alg":"none"}`))+"."+b64([]byte(`{}`))+".", `unsupported algorithm "none"`),
+		Entry("on symmetric algorithms", b64([]byte(`{"alg":"HS256"}`))+"."+b64([]byte(`{}`))+".", `unsupported algorithm "HS256"`),
+		Entry("on critical headers", b64([]byte(`{"alg":"RS256","crit":["exp"]}`))+"."+b64([]byte(`{}`))+".",
+			"unsupported critical header parameters"),
+		Entry("on invalid claims", b64([]byte(`{"alg":"RS256"}`))+".!.", "failed to decode claims"),
+	)
+
+	Context("Verifier", func() {
+		It("should cache the keys", func() {
+			loads := 0
+			v := jwt.NewVerifier(issuer, audience, func() ([]byte, error) {
+				loads++
+				return jwks(key), nil
+			}, time.Hour)
+			for range 3 {
+				Expect(v.Verify(mustParse(key.sign(validClaims())))).To(Succeed())
+			}
+			Expect(loads).To(Equal(1))
+		})
+
+		It("should replace the keys on refresh", func() {
+			rotated := newSigningKey("key-2", jwt.AlgEdDSA)
+			set := jwks(key)
+			v := jwt.NewVerifier(issuer, audience, func() ([]byte, error) {
+				return set, nil
+			}, time.Hour)
+			Expect(v.Verify(mustParse(key.sign(validClaims())))).To(Succeed())
+
+			set = jwks(rotated)
+			Expect(v.Refresh()).To(Succeed())
+			Expect(v.Verify(mustParse(rotated.sign(validClaims())))).To(Succeed())
+			Expect(v.Verify(mustParse(key.sign(validClaims())))).ToNot(Succeed())
+		})
+
+		It("should keep the cached keys if loading fails", func() {
+			fail := false
+			v := jwt.NewVerifier(issuer, audience, func() ([]byte, error) {
+				if fail {
+					return nil, errors.New("unavailable")
+				}
+				return jwks(key), nil
+			}, time.Hour)
+			Expect(v.Refresh()).To(Succeed())
+			fail = true
+			Expect(v.Refresh()).To(MatchError("unavailable"))
+			Expect(v.Verify(mustParse(key.sign(validClaims())))).To(Succeed())
+		})
+
+		It("should fail without keys", func() {
+			v := jwt.NewVerifier(issuer, audience, jwt.File(path.Join(GinkgoT().TempDir(), "missing.json")), time.Hour)
+			Expect(v.Verify(mustParse(key.sign(validClaims())))).To(MatchError(ContainSubstring("failed to load key set")))
+		})
+
+		It("should read the keys from a file", func() {
+			file := path.Join(GinkgoT().TempDir(), "jwks.json")
+			Expect(os.WriteFile(file, jwks(key), 0o600)).To(Succeed())
+			v := jwt.NewVerifier(issuer, audience, jwt.File(file), time.Hour)
+			Expect(v.Refresh()).To(Succeed())
+			Expect(v.Verify(mustParse(key.sign(validClaims())))).To(Succeed())
+		})
+
+		DescribeTable("Refresh should fail on invalid key sets", func(set, msg string) {
+			v := jwt.NewVerifier(issuer, audience, staticSource([]byte(set)), time.Hour)
+			Expect(v.Refresh()).To(MatchError(ContainSubstring(msg)))
+		},
+			Entry("with invalid json", "{", "failed to decode key set"),
+			Entry("without signing keys", `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`, "key set contains no signing keys"),
+			Entry("with encryption keys only", `{"keys":[{"kty":"OKP","crv":"Ed25519","use":"enc","x":"AAAA"}]}`,
+				"key set contains no signing keys"),
+			Entry("with small RSA keys", `{"keys":[{"kty":"RSA","n":"AQAB","e":"AQAB"}]}`, "RSA key must have at least 2048 bits"),
+			Entry("with EC points not on the curve", `{"keys":[{"kty":"EC","crv":"P-256","x":"`+b64(make([]byte, 32))+`","y":"`+
+				b64(make([]byte, 32))+`"}]}`, "invalid EC key"),
+		)
+	})
+})
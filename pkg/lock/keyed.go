@@ -0,0 +1,82 @@
+// Package lock provides mutexes keyed by rrset, so updates of independent
+// records run concurrently while read-modify-write sequences on the same
+// rrset are serialized.
+package lock
+
+import (
+	"strings"
+	"sync"
+)
+
+type entry struct {
+	sync.Mutex
+	refs int
+}
+
+// Keyed hands out one mutex per key. Entries are dropped once no caller
+// holds or waits for them.
+type Keyed struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+func NewKeyed() *Keyed {
+	return &Keyed{
+		entries: make(map[string]*entry),
+	}
+}
+
+// Get returns a locker for key. Lockers of the same key exclude each other.
+func (k *Keyed) Get(key string) sync.Locker {
+	return &locker{keyed: k, key: key}
+}
+
+// RRSet returns a locker for the rrset of type rrType named name in zone.
+func (k *Keyed) RRSet(zone, name, rrType string) sync.Locker {
+	return k.Get(RRSetKey(zone, name, rrType))
+}
+
+// RRSetKey returns the key of an rrset. Zone and name are case-insensitive.
+func RRSetKey(zone, name, rrType string) string {
+	return strings.ToLower(zone) + "/" + strings.ToLower(name) + "/" + strings.ToUpper(rrType)
+}
+
+func (k *Keyed) acquire(key string) *entry {
+	k.mu.Lock()
+	e, ok := k.entries[key]
+	if !ok {
+		e = &entry{}
+		k.entries[key] = e
+	}
+	e.refs++
+	k.mu.Unlock()
+
+	e.Lock()
+	return e
+}
+
+func (k *Keyed) release(key string, e *entry) {
+	e.Unlock()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	e.refs--
+	if e.refs == 0 {
+		delete(k.entries, key)
+	}
+}
+
+type locker struct {
+	keyed *Keyed
+	key   string
+	entry *entry
+}
+
+func (l *locker) Lock() {
+	l.entry = l.keyed.acquire(l.key)
+}
+
+func (l *locker) Unlock() {
+	l.keyed.release(l.key, l.entry)
+	l.entry = nil
+}
@@ -0,0 +1,89 @@
+package lock
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Keyed", func() {
+	var k *Keyed
+
+	BeforeEach(func() {
+		k = NewKeyed()
+	})
+
+	It("serializes lockers of the same rrset", func() {
+		l := k.RRSet("example.com", "www", "A")
+		l.Lock()
+
+		locked := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			other := k.RRSet("Example.COM", "WWW", "a")
+			other.Lock()
+			close(locked)
+			other.Unlock()
+		}()
+
+		Consistently(locked, 100*time.Millisecond).ShouldNot(BeClosed())
+		l.Unlock()
+		Eventually(locked).Should(BeClosed())
+	})
+
+	It("does not block lockers of other rrsets", func() {
+		l := k.RRSet("example.com", "www", "A")
+		l.Lock()
+		defer l.Unlock()
+
+		for _, other := range []sync.Locker{
+			k.RRSet("example.com", "www", "AAAA"),
+			k.RRSet("example.com", "mail", "A"),
+			k.RRSet("example.org", "www", "A"),
+		} {
+			locked := make(chan struct{})
+			go func() {
+				other.Lock()
+				close(locked)
+				other.Unlock()
+			}()
+			Eventually(locked).Should(BeClosed())
+		}
+	})
+
+	It("drops entries once released", func() {
+		l := k.Get("key")
+		l.Lock()
+		Expect(k.entries).To(HaveLen(1))
+		l.Unlock()
+		Expect(k.entries).To(BeEmpty())
+	})
+
+	It("keeps entries while callers are waiting", func() {
+		l := k.Get("key")
+		l.Lock()
+
+		locked := make(chan struct{})
+		go func() {
+			other := k.Get("key")
+			other.Lock()
+			close(locked)
+			other.Unlock()
+		}()
+
+		Eventually(func() int {
+			k.mu.Lock()
+			defer k.mu.Unlock()
+			return k.entries["key"].refs
+		}).Should(Equal(2))
+		l.Unlock()
+		Eventually(locked).Should(BeClosed())
+		Eventually(func() int {
+			k.mu.Lock()
+			defer k.mu.Unlock()
+			return len(k.entries)
+		}).Should(BeZero())
+	})
+})
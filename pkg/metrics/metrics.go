@@ -0,0 +1,190 @@
+// Package metrics collects Prometheus metrics about requests to the proxy and
+// about the calls it makes to the Hetzner API.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "hetzner_dnsapi_proxy"
+
+// AuthMethodTSIG is the auth method label of failed RFC 2136 requests.
+const AuthMethodTSIG = "tsig"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Requests handled by endpoint group and status.",
+	}, []string{"endpoint", "status"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of handled requests by endpoint group and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "status"})
+	authFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Failed authentications and authorizations by auth method.",
+	}, []string{"method"})
+	lockouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lockouts_total",
+		Help:      "Clients locked out after repeated auth failures.",
+	})
+	rateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by the rate limiter.",
+	})
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hetzner_api_requests_total",
+		Help:      "Requests to the Hetzner API by operation and HTTP status code.",
+	}, []string{"operation", "code"})
+	apiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "hetzner_api_request_duration_seconds",
+		Help:      "Latency of requests to the Hetzner API by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+	apiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hetzner_api_errors_total",
+		Help:      "Failed requests to the Hetzner API by operation.",
+	}, []string{"operation"})
+	apiRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hetzner_api_retries_total",
+		Help:      "Requests to the Hetzner API sent again after a transient error by operation.",
+	}, []string{"operation"})
+	apiBudget = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "hetzner_api_budget_remaining",
+		Help:      "Remaining requests of the Hetzner API token as last reported by the API by project.",
+	}, []string{"project"})
+	apiShed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hetzner_api_shed_total",
+		Help:      "Low priority requests to the Hetzner API rejected to preserve the remaining budget by project.",
+	}, []string{"project"})
+	updateLockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "update_lock_wait_seconds",
+		Help:      "Time spent waiting on the update lock of an rrset.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Reloads of the config file by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests, requestDuration, authFailures, lockouts, rateLimited,
+		apiRequests, apiDuration, apiErrors, apiRetries, apiBudget, apiShed, updateLockWait, configReloads,
+	)
+}
+
+// Handler serves the collected metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a request to endpoint that was answered with
+// status after d.
+func ObserveRequest(endpoint, status string, d time.Duration) {
+	requests.WithLabelValues(endpoint, status).Inc()
+	requestDuration.WithLabelValues(endpoint, status).Observe(d.Seconds())
+}
+
+// ObserveHTTPRequest records a request to endpoint that was answered with
+// the HTTP status code after d.
+func ObserveHTTPRequest(endpoint string, code int, d time.Duration) {
+	ObserveRequest(endpoint, strconv.Itoa(code), d)
+}
+
+// AuthFailure records a failed authentication or authorization with method.
+func AuthFailure(method string) {
+	authFailures.WithLabelValues(method).Inc()
+}
+
+// Lockout records a client being locked out.
+func Lockout() {
+	lockouts.Inc()
+}
+
+// RateLimited records a request rejected by the rate limiter.
+func RateLimited() {
+	rateLimited.Inc()
+}
+
+// ObserveAPIRequest records a request to the Hetzner API for operation that
+// was answered with the HTTP status code after d. A code of 0 means the
+// request failed without a response. failed marks the request as error.
+func ObserveAPIRequest(operation string, code int, failed bool, d time.Duration) {
+	codeLabel := "none"
+	if code != 0 {
+		codeLabel = strconv.Itoa(code)
+	}
+	apiRequests.WithLabelValues(operation, codeLabel).Inc()
+	apiDuration.WithLabelValues(operation).Observe(d.Seconds())
+	if failed {
+		apiErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// APIRetry records a request to the Hetzner API for operation being sent
+// again.
+func APIRetry(operation string) {
+	apiRetries.WithLabelValues(operation).Inc()
+}
+
+// APIBudget records the remaining requests of the Hetzner API token of
+// project.
+func APIBudget(project string, remaining int) {
+	apiBudget.WithLabelValues(project).Set(float64(remaining))
+}
+
+// DeleteAPIBudget removes the remaining requests of project, e.g. once it is
+// not configured anymore.
+func DeleteAPIBudget(project string) {
+	apiBudget.DeleteLabelValues(project)
+}
+
+// APIRequestShed records a low priority request to the Hetzner API of
+// project being rejected.
+func APIRequestShed(project string) {
+	apiShed.WithLabelValues(project).Inc()
+}
+
+// ConfigReload records a reload of the config file, err is the reason it
+// failed.
+func ConfigReload(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	configReloads.WithLabelValues(result).Inc()
+}
+
+// Lock locks m and records the time spent waiting for it.
+func Lock(m sync.Locker) {
+	start := time.Now()
+	m.Lock()
+	updateLockWait.Observe(time.Since(start).Seconds())
+}
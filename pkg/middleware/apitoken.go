@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/apitoken"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	bearerPrefix = "Bearer "
+	// apiTokenUser is the user of requests authorized by an API token
+	// without description
+	apiTokenUser = "api token"
+)
+
+// BearerToken returns the bearer token of the Authorization header of r,
+// empty if there is none.
+func BearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len(bearerPrefix) || !strings.EqualFold(auth[:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(bearerPrefix):])
+}
+
+// CheckAPIToken works like CheckUsers, but identifies an API token by token
+// and additionally checks its expiry and endpoints.
+func CheckAPIToken(fqdn, recordType, endpoint, token string, tokens []config.APIToken) bool {
+	allowed, _ := checkAPIToken(fqdn, recordType, endpoint, token, tokens)
+	return allowed
+}
+
+// checkAPIToken works like CheckAPIToken, but additionally returns the
+// matching token as user.
+func checkAPIToken(fqdn, recordType, endpoint, token string, tokens []config.APIToken) (bool, config.User) {
+	if fqdn == "" || token == "" {
+		return false, config.User{}
+	}
+	matches := make([]int, len(tokens))
+	users := make([]config.User, len(tokens))
+	for i, t := range matchingAPITokens(tokens, token, endpoint) {
+		users[i] = apiTokenAsUser(t)
+		matches[i] = userMatch(&users[i], fqdn, recordType)
+	}
+	return matchedUser(users, matches)
+}
+
+// matchingAPITokens returns the index and the token of tokens with the
+// hash of token that are accepted on endpoint and not expired. Expired
+// tokens are logged.
+func matchingAPITokens(tokens []config.APIToken, token, endpoint string) map[int]*config.APIToken {
+	hashed := apitoken.Hash(token)
+	matching := map[int]*config.APIToken{}
+	for i := range tokens {
+		if apitoken.Matches(tokens[i].Hash, hashed) != 1 || !tokens[i].AllowsEndpoint(endpoint) {
+			continue
+		}
+		if tokens[i].Expired(time.Now()) {
+			logAPITokenExpired(&tokens[i])
+			continue
+		}
+		matching[i] = &tokens[i]
+	}
+	return matching
+}
+
+// apiTokenIsValid returns true if token is a valid API token on endpoint.
+func apiTokenIsValid(tokens []config.APIToken, token, endpoint string) bool {
+	return token != "" && len(matchingAPITokens(tokens, token, endpoint)) > 0
+}
+
+func getDomainsFromAPITokens(tokens []config.APIToken, token, endpoint string) map[string]struct{} {
+	domains := map[string]struct{}{}
+	if token == "" {
+		return domains
+	}
+	for _, t := range matchingAPITokens(tokens, token, endpoint) {
+		for _, domain := range t.Domains {
+			domains[domain] = struct{}{}
+		}
+	}
+
+	return domains
+}
+
+// apiTokenAsUser returns a user with the scope of t, named after its
+// description.
+func apiTokenAsUser(t *config.APIToken) config.User {
+	username := t.Description
+	if username == "" {
+		username = apiTokenUser
+	}
+	return config.User{
+		Username:     username,
+		Domains:      t.Domains,
+		Restrictions: t.Restrictions,
+		Takeover:     t.Takeover,
+	}
+}
+
+// tokenCredential returns the credential of reqData API tokens and JWTs are
+// looked up by, its token or its Basic auth password.
+func tokenCredential(reqData *data.ReqData) string {
+	if reqData.Token != "" {
+		return reqData.Token
+	}
+	return reqData.Password
+}
+
+func logAPITokenExpired(t *config.APIToken) {
+	desc := sanitize.LogValue(t.Description)
+	//nolint:gosec // value is sanitized above
+	log.Printf("API token '%s' expired at %s", desc, t.ExpiresAt.Format(time.RFC3339))
+}
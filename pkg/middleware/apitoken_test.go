@@ -0,0 +1,134 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/apitoken"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+const apiToken = "hdp_token"
+
+func newAPIToken(modify ...func(*config.APIToken)) config.APIToken {
+	t := config.APIToken{
+		Hash:        apitoken.Hash(apiToken),
+		Description: "ci",
+		Domains:     []string{wildcardExample},
+	}
+	for _, m := range modify {
+		m(&t)
+	}
+	return t
+}
+
+var _ = Describe("BearerToken", func() {
+	DescribeTable("should return the token", func(header, expected string) {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		Expect(middleware.BearerToken(req)).To(Equal(expected))
+	},
+		Entry("of a bearer header", "Bearer "+apiToken, apiToken),
+		Entry("ignoring the case of the scheme", "bearer "+apiToken, apiToken),
+		Entry("empty without header", "", ""),
+		Entry("empty with Basic auth", "Basic dXNlcjpwYXNz", ""),
+	)
+})
+
+var _ = Describe("CheckAPIToken", func() {
+	DescribeTable(
+		"should allow access", func(fqdn, endpoint string, token config.APIToken) {
+			Expect(middleware.CheckAPIToken(fqdn, recordTypeA, endpoint, apiToken, []config.APIToken{token})).To(BeTrue())
+		},
+		Entry("when domain matches", subExampleDomain, config.EndpointPlain, newAPIToken()),
+		Entry("when the token expires in the future", subExampleDomain, config.EndpointPlain, newAPIToken(func(t *config.APIToken) {
+			t.ExpiresAt = time.Now().Add(time.Hour)
+		})),
+		Entry("when the endpoint is allowed", subExampleDomain, config.EndpointCloudflare, newAPIToken(func(t *config.APIToken) {
+			t.Endpoints = []string{config.EndpointCloudflare}
+		})),
+	)
+
+	DescribeTable(
+		"should deny access", func(fqdn, endpoint, token string, t config.APIToken) {
+			Expect(middleware.CheckAPIToken(fqdn, recordTypeA, endpoint, token, []config.APIToken{t})).To(BeFalse())
+		},
+		Entry("when token does not match", subExampleDomain, config.EndpointPlain, "hdp_other", newAPIToken()),
+		Entry("when token is empty", subExampleDomain, config.EndpointPlain, "", newAPIToken()),
+		Entry("when domain does not match", testDomain, config.EndpointPlain, apiToken, newAPIToken()),
+		Entry("when the token is expired", subExampleDomain, config.EndpointPlain, apiToken, newAPIToken(func(t *config.APIToken) {
+			t.ExpiresAt = time.Now().Add(-time.Hour)
+		})),
+		Entry("when the endpoint is not allowed", subExampleDomain, config.EndpointPlain, apiToken, newAPIToken(func(t *config.APIToken) {
+			t.Endpoints = []string{config.EndpointCloudflare}
+		})),
+		Entry("when record type does not match", subExampleDomain, config.EndpointPlain, apiToken, newAPIToken(func(t *config.APIToken) {
+			t.RecordTypes = []string{recordTypeTXT}
+		})),
+	)
+})
+
+var _ = Describe("CheckPermission with API tokens", func() {
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		cfg = &config.Config{
+			Auth: config.Auth{
+				Method: config.AuthMethodUsers,
+				Users: []config.User{{
+					Username: username,
+					Password: password,
+					Domains:  []string{exampleDomain},
+				}},
+				APITokens: []config.APIToken{newAPIToken(func(t *config.APIToken) {
+					t.Takeover = true
+				})},
+			},
+		}
+	})
+
+	DescribeTable("should identify the token", func(reqData *data.ReqData) {
+		reqData.FullName = subExampleDomain
+		reqData.Type = recordTypeA
+		Expect(middleware.CheckPermission(cfg, reqData, "")).To(BeTrue())
+		Expect(reqData.User).To(Equal("ci"))
+		Expect(reqData.Takeover).To(BeTrue())
+	},
+		Entry("as bearer token", &data.ReqData{Token: apiToken}),
+		Entry("as Basic auth password", &data.ReqData{Username: "anyone", Password: apiToken}),
+	)
+
+	It("should still authorize users by password", func() {
+		reqData := &data.ReqData{FullName: exampleDomain, Type: recordTypeA, Username: username, Password: password}
+		Expect(middleware.CheckPermission(cfg, reqData, "")).To(BeTrue())
+		Expect(reqData.User).To(Equal(username))
+	})
+
+	It("should name tokens without description", func() {
+		cfg.Auth.APITokens[0].Description = ""
+		reqData := &data.ReqData{FullName: subExampleDomain, Type: recordTypeA, Token: apiToken}
+		Expect(middleware.CheckPermission(cfg, reqData, "")).To(BeTrue())
+		Expect(reqData.User).To(Equal("api token"))
+	})
+
+	It("should ignore API tokens with auth method clientCert", func() {
+		cfg.Auth.Method = config.AuthMethodClientCert
+		Expect(middleware.CheckPermission(cfg, &data.ReqData{FullName: subExampleDomain, Type: recordTypeA, Token: apiToken}, "")).To(BeFalse())
+	})
+
+	It("should list the domains of the token on allowed endpoints", func() {
+		cfg.Auth.APITokens[0].Endpoints = []string{config.EndpointPowerDNS}
+		Expect(middleware.GetDomains(cfg, &data.ReqData{Token: apiToken, Endpoint: config.EndpointPowerDNS}, "")).
+			To(Equal(map[string]struct{}{exampleDomain: {}}))
+		Expect(middleware.GetDomains(cfg, &data.ReqData{Token: apiToken, Endpoint: config.EndpointCloudflare}, "")).
+			To(BeEmpty())
+	})
+})
@@ -1,15 +1,21 @@
 package middleware
 
 import (
+	"crypto/sha256"
 	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
 	"log"
 	"net"
 	"net/http"
 	"slices"
 	"strings"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/apitoken"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/password"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
 )
@@ -33,7 +39,8 @@ func NewAuthorizer(cfg *config.Config, lockout *ratelimit.Lockout) func(http.Han
 			if !CheckPermission(cfg, reqData, r.RemoteAddr) {
 				logPermissionDenied(r.RemoteAddr, reqData)
 				lockout.RecordFailure(r.RemoteAddr)
-				if cfg.Auth.Method != config.AuthMethodAllowedDomains && reqData.BasicAuth {
+				metrics.AuthFailure(cfg.Auth.Method)
+				if authMethodUsesUsers(cfg.Auth.Method) && reqData.BasicAuth {
 					w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 				}
 				w.WriteHeader(http.StatusUnauthorized)
@@ -67,13 +74,20 @@ func CheckPermission(cfg *config.Config, reqData *data.ReqData, remoteAddr strin
 		return false
 	}
 
-	allowedAllowedDomains := CheckAllowedDomains(reqData.FullName, remoteAddr, cfg.Auth.AllowedDomains)
+	allowedAllowedDomains := CheckAllowedDomains(
+		reqData.FullName, reqData.Type, remoteAddr, cfg.Auth.AllowedDomains, cfg.Auth.AllowedDomainsRestrictions,
+	)
 	if cfg.Auth.Method == config.AuthMethodAllowedDomains {
 		return allowedAllowedDomains
 	}
 
-	allowedUsers := CheckUsers(reqData.FullName, reqData.Username, reqData.Password, cfg.Auth.Users)
-	if cfg.Auth.Method == config.AuthMethodUsers {
+	allowedUsers, user := checkAnyUser(&cfg.Auth, reqData)
+	if allowedUsers {
+		reqData.User = user.Username
+		reqData.Takeover = user.Takeover
+	}
+	if cfg.Auth.Method == config.AuthMethodUsers || cfg.Auth.Method == config.AuthMethodClientCert ||
+		cfg.Auth.Method == config.AuthMethodJWT {
 		return allowedUsers
 	}
 
@@ -88,11 +102,16 @@ func CheckPermission(cfg *config.Config, reqData *data.ReqData, remoteAddr strin
 	return false
 }
 
-func CheckAllowedDomains(fqdn, clientIP string, allowedDomains config.AllowedDomains) bool {
+func CheckAllowedDomains(
+	fqdn, recordType, clientIP string, allowedDomains config.AllowedDomains, restrictions map[string]config.Restrictions,
+) bool {
 	for domain, ipNets := range allowedDomains {
 		if fqdn != domain && !IsSubDomain(fqdn, domain) {
 			continue
 		}
+		if r := restrictions[domain]; !r.Allows(fqdn, recordType) {
+			continue
+		}
 		for _, ipNet := range ipNets {
 			ip := net.ParseIP(clientIP)
 			if ip != nil && ipNet.Contains(ip) {
@@ -103,22 +122,178 @@ func CheckAllowedDomains(fqdn, clientIP string, allowedDomains config.AllowedDom
 	return false
 }
 
-func CheckUsers(fqdn, username, password string, users []config.User) bool {
+func CheckUsers(fqdn, recordType, username, password string, users []config.User) bool {
+	allowed, _ := checkUsers(fqdn, recordType, username, password, users)
+	return allowed
+}
+
+// CheckUserToken works like CheckUsers, but identifies the user by its token.
+func CheckUserToken(fqdn, recordType, token string, users []config.User) bool {
+	allowed, _ := checkUserToken(fqdn, recordType, token, users)
+	return allowed
+}
+
+// CheckUserCert works like CheckUsers, but identifies the user by a verified
+// client certificate.
+func CheckUserCert(fqdn, recordType string, cert *x509.Certificate, users []config.User) bool {
+	allowed, _ := checkUserCert(fqdn, recordType, cert, users)
+	return allowed
+}
+
+// CheckTSIGKey works like CheckUsers, but for a TSIG key authenticated by
+// the RFC 2136 server.
+func CheckTSIGKey(fqdn, recordType string, key *config.TSIGKey) bool {
+	user := config.User{Domains: key.Domains, Restrictions: key.Restrictions}
+	return fqdn != "" && userMatch(&user, fqdn, recordType) == 1
+}
+
+// checkAnyUser returns the user identified by the credentials of reqData.
+// With auth method clientCert only client certificates and with auth method
+// jwt only JWTs identify users, otherwise API tokens, JWTs and client
+// certificates are tried if the token or password of a user did not match.
+func checkAnyUser(auth *config.Auth, reqData *data.ReqData) (bool, config.User) {
+	switch auth.Method {
+	case config.AuthMethodClientCert:
+		return checkUserCert(reqData.FullName, reqData.Type, reqData.ClientCert, auth.Users)
+	case config.AuthMethodJWT:
+		return checkJWT(reqData.FullName, reqData.Type, reqData.Endpoint, tokenCredential(reqData), auth.JWTIssuers)
+	}
+
+	allowed, user := checkUsers(reqData.FullName, reqData.Type, reqData.Username, reqData.Password, auth.Users)
+	if reqData.Token != "" {
+		allowed, user = checkUserToken(reqData.FullName, reqData.Type, reqData.Token, auth.Users)
+	}
+	if !allowed {
+		allowed, user = checkAPIToken(reqData.FullName, reqData.Type, reqData.Endpoint, tokenCredential(reqData), auth.APITokens)
+	}
+	if !allowed {
+		allowed, user = checkJWT(reqData.FullName, reqData.Type, reqData.Endpoint, tokenCredential(reqData), auth.JWTIssuers)
+	}
+	if !allowed {
+		allowed, user = checkUserCert(reqData.FullName, reqData.Type, reqData.ClientCert, auth.Users)
+	}
+	return allowed, user
+}
+
+// checkUsers works like CheckUsers, but additionally returns the matching
+// user. If several users match, the returned user has the username of the
+// first one and takeover permission if any of them has it.
+func checkUsers(fqdn, recordType, username, password string, users []config.User) (bool, config.User) {
 	if fqdn == "" || username == "" || password == "" {
+		return false, config.User{}
+	}
+	matches := make([]int, len(users))
+	for i := range users {
+		matches[i] = credentialsMatch(&users[i], username, password) & userMatch(&users[i], fqdn, recordType)
+	}
+	return matchedUser(users, matches)
+}
+
+// checkUserToken works like checkUsers, but identifies the user by its token.
+func checkUserToken(fqdn, recordType, token string, users []config.User) (bool, config.User) {
+	if fqdn == "" || token == "" {
+		return false, config.User{}
+	}
+	hashed := apitoken.Hash(token)
+	matches := make([]int, len(users))
+	for i := range users {
+		matches[i] = apitoken.Matches(users[i].TokenHash, hashed) & userMatch(&users[i], fqdn, recordType)
+	}
+	return matchedUser(users, matches)
+}
+
+// checkUserCert works like checkUsers, but identifies the user by a
+// verified client certificate.
+func checkUserCert(fqdn, recordType string, cert *x509.Certificate, users []config.User) (bool, config.User) {
+	if fqdn == "" || cert == nil {
+		return false, config.User{}
+	}
+	fingerprint, names := certIdentity(cert)
+	matches := make([]int, len(users))
+	for i := range users {
+		matches[i] = certMatch(&users[i], fingerprint, names) & userMatch(&users[i], fqdn, recordType)
+	}
+	return matchedUser(users, matches)
+}
+
+// matchedUser merges the users with a match of 1 into one.
+func matchedUser(users []config.User, matches []int) (bool, config.User) {
+	matched := config.User{}
+	allowed := false
+	for i, match := range matches {
+		if match != 1 {
+			continue
+		}
+		if !allowed {
+			matched.Username = users[i].Username
+		}
+		matched.Takeover = matched.Takeover || users[i].Takeover
+		allowed = true
+	}
+	return allowed, matched
+}
+
+// userMatch returns 1 if user may update records of type recordType of fqdn.
+func userMatch(user *config.User, fqdn, recordType string) int {
+	domainMatch := 0
+	for _, domain := range user.Domains {
+		if fqdn == domain || IsSubDomain(fqdn, domain) {
+			domainMatch = 1
+		}
+	}
+	restrictionsMatch := 0
+	if user.Allows(fqdn, recordType) {
+		restrictionsMatch = 1
+	}
+	return domainMatch & restrictionsMatch
+}
+
+// credentialsMatch returns 1 if username and pass match the credentials
+// of user. The password is only verified if the username matches, so requests
+// with unknown usernames do not cost a hash computation per user.
+func credentialsMatch(user *config.User, username, pass string) int {
+	if constantTimeEqual(user.Username, username) == 0 {
+		return 0
+	}
+	if !password.Verify(user.Password, pass) {
+		return 0
+	}
+	return 1
+}
+
+// certIdentifiesUser returns true if cert identifies any of users.
+func certIdentifiesUser(cert *x509.Certificate, users []config.User) bool {
+	if cert == nil {
 		return false
 	}
-	allowed := 0
-	for _, user := range users {
-		credsMatch := constantTimeEqual(user.Username, username) & constantTimeEqual(user.Password, password)
-		domainMatch := 0
-		for _, domain := range user.Domains {
-			if fqdn == domain || IsSubDomain(fqdn, domain) {
-				domainMatch = 1
-			}
+	fingerprint, names := certIdentity(cert)
+	matched := 0
+	for i := range users {
+		matched |= certMatch(&users[i], fingerprint, names)
+	}
+	return matched == 1
+}
+
+// certIdentity returns the SHA-256 fingerprint of cert and the names users
+// can be identified by, its subject common name and DNS names.
+func certIdentity(cert *x509.Certificate) (fingerprint string, names []string) {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:]), append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+}
+
+// certMatch returns 1 if the certificate with fingerprint and names
+// identifies user.
+func certMatch(user *config.User, fingerprint string, names []string) int {
+	match := 0
+	for _, f := range user.CertFingerprints {
+		match |= constantTimeEqual(f, fingerprint)
+	}
+	for _, name := range names {
+		if name != "" && slices.ContainsFunc(user.CertNames, func(n string) bool { return strings.EqualFold(n, name) }) {
+			match = 1
 		}
-		allowed |= credsMatch & domainMatch
 	}
-	return allowed == 1
+	return match
 }
 
 func constantTimeEqual(a, b string) int {
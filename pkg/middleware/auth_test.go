@@ -6,6 +6,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/apitoken"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
@@ -31,6 +32,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 			},
 			"127.0.0.1",
 		),
@@ -48,6 +50,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 				Username: username,
 				Password: password,
 			},
@@ -73,6 +76,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 				Username: username,
 				Password: password,
 			},
@@ -93,6 +97,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 			},
 			"127.0.0.1",
 		),
@@ -110,6 +115,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 				Username: username,
 				Password: password,
 			},
@@ -135,6 +141,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 				Username: username,
 				Password: password,
 			},
@@ -155,6 +162,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 				Username: username,
 				Password: password,
 			},
@@ -174,6 +182,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 				Username: username,
 				Password: password,
 			},
@@ -194,6 +203,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 				Username: username,
 				Password: password,
 			},
@@ -208,6 +218,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 				Username: username,
 				Password: password,
 			},
@@ -233,6 +244,7 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 				Username: username,
 				Password: password,
 			},
@@ -258,18 +270,55 @@ var _ = Describe("CheckPermission", func() {
 			},
 			&data.ReqData{
 				FullName: exampleDomain,
+				Type:     recordTypeA,
 				Username: username,
 				Password: password,
 			},
 			"127.0.0.1",
 		),
 	)
+
+	DescribeTable(
+		"should record the matching user", func(users []config.User, reqData *data.ReqData, takeover bool) {
+			cfg := &config.Config{Auth: config.Auth{Method: config.AuthMethodUsers, Users: users}}
+			Expect(middleware.CheckPermission(cfg, reqData, "")).To(BeTrue())
+			Expect(reqData.User).To(Equal(username))
+			Expect(reqData.Takeover).To(Equal(takeover))
+		},
+		Entry(
+			"without takeover permission",
+			[]config.User{{Username: username, Password: password, Domains: []string{exampleDomain}}},
+			&data.ReqData{FullName: exampleDomain, Type: recordTypeA, Username: username, Password: password},
+			false,
+		),
+		Entry(
+			"with takeover permission",
+			[]config.User{{Username: username, Password: password, Domains: []string{exampleDomain}, Takeover: true}},
+			&data.ReqData{FullName: exampleDomain, Type: recordTypeA, Username: username, Password: password},
+			true,
+		),
+		Entry(
+			"with takeover permission by token",
+			[]config.User{{Username: username, TokenHash: apitoken.Hash("token"), Domains: []string{exampleDomain}, Takeover: true}},
+			&data.ReqData{FullName: exampleDomain, Type: recordTypeA, Token: "token"},
+			true,
+		),
+		Entry(
+			"without takeover permission of a user not allowed to update the record",
+			[]config.User{
+				{Username: username, Password: password, Domains: []string{exampleDomain}},
+				{Username: username, Password: password, Domains: []string{"other.tld"}, Takeover: true},
+			},
+			&data.ReqData{FullName: exampleDomain, Type: recordTypeA, Username: username, Password: password},
+			false,
+		),
+	)
 })
 
 var _ = Describe("CheckAllowedDomains", func() {
 	DescribeTable(
 		"should allow access", func(fqdn, clientIP string, allowedDomains config.AllowedDomains) {
-			Expect(middleware.CheckAllowedDomains(fqdn, clientIP, allowedDomains)).To(BeTrue())
+			Expect(middleware.CheckAllowedDomains(fqdn, recordTypeA, clientIP, allowedDomains, nil)).To(BeTrue())
 		},
 		Entry(
 			"with wildcard and matching host", exampleDomain, "127.0.0.1",
@@ -329,7 +378,7 @@ var _ = Describe("CheckAllowedDomains", func() {
 
 	DescribeTable(
 		"should deny access", func(fqdn, clientIP string, allowedDomains config.AllowedDomains) {
-			Expect(middleware.CheckAllowedDomains(fqdn, clientIP, allowedDomains)).To(BeFalse())
+			Expect(middleware.CheckAllowedDomains(fqdn, recordTypeA, clientIP, allowedDomains, nil)).To(BeFalse())
 		},
 		Entry(
 			"with wildcard and non matching host", exampleDomain, "127.0.0.2",
@@ -424,10 +473,34 @@ var _ = Describe("CheckAllowedDomains", func() {
 	)
 })
 
+var _ = Describe("CheckAllowedDomains with restrictions", func() {
+	allowedDomains := config.AllowedDomains{
+		wildcardExample: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 1), Mask: net.IPv4Mask(255, 255, 255, 255)}},
+		testDomain:      []*net.IPNet{{IP: net.IPv4(127, 0, 0, 1), Mask: net.IPv4Mask(255, 255, 255, 255)}},
+	}
+	restrictions := map[string]config.Restrictions{
+		wildcardExample: {RecordTypes: []string{recordTypeTXT}, Names: []string{"_acme-challenge.*"}},
+	}
+
+	DescribeTable("should allow access", func(fqdn, recordType string) {
+		Expect(middleware.CheckAllowedDomains(fqdn, recordType, "127.0.0.1", allowedDomains, restrictions)).To(BeTrue())
+	},
+		Entry("when the restrictions are satisfied", "_acme-challenge.sub.example.com", recordTypeTXT),
+		Entry("when the domain has no restrictions", testDomain, recordTypeA),
+	)
+
+	DescribeTable("should deny access", func(fqdn, recordType string) {
+		Expect(middleware.CheckAllowedDomains(fqdn, recordType, "127.0.0.1", allowedDomains, restrictions)).To(BeFalse())
+	},
+		Entry("when record type is not allowed", "_acme-challenge.sub.example.com", recordTypeA),
+		Entry("when name is not allowed", subExampleDomain, recordTypeTXT),
+	)
+})
+
 var _ = Describe("CheckUsers", func() {
 	DescribeTable(
 		"should allow access", func(fqdn, username, password string, users []config.User) {
-			Expect(middleware.CheckUsers(fqdn, username, password, users)).To(BeTrue())
+			Expect(middleware.CheckUsers(fqdn, recordTypeA, username, password, users)).To(BeTrue())
 		},
 		Entry(
 			"with matching credentials and wildcard", exampleDomain, username, password,
@@ -469,11 +542,36 @@ var _ = Describe("CheckUsers", func() {
 				Domains:  []string{testDomain, wildcardExample},
 			}},
 		),
+		Entry(
+			"with matching credentials and record type is one of the record types", exampleDomain, username, password,
+			[]config.User{{
+				Username:     username,
+				Password:     password,
+				Domains:      []string{exampleDomain},
+				Restrictions: config.Restrictions{RecordTypes: []string{recordTypeTXT, recordTypeA}},
+			}},
+		),
+		Entry(
+			"with matching credentials and argon2id hashed password", exampleDomain, username, password,
+			[]config.User{{
+				Username: username,
+				Password: passwordArgon2id,
+				Domains:  []string{exampleDomain},
+			}},
+		),
+		Entry(
+			"with matching credentials and bcrypt hashed password", exampleDomain, username, password,
+			[]config.User{{
+				Username: username,
+				Password: passwordBcrypt,
+				Domains:  []string{exampleDomain},
+			}},
+		),
 	)
 
 	DescribeTable(
 		"should deny access", func(fqdn, username, password string, users []config.User) {
-			Expect(middleware.CheckUsers(fqdn, username, password, users)).To(BeFalse())
+			Expect(middleware.CheckUsers(fqdn, recordTypeA, username, password, users)).To(BeFalse())
 		},
 		Entry(
 			"when username does not match", exampleDomain, "something", password,
@@ -491,6 +589,30 @@ var _ = Describe("CheckUsers", func() {
 				Domains:  []string{"*"},
 			}},
 		),
+		Entry(
+			"when password does not match argon2id hash", exampleDomain, username, "something",
+			[]config.User{{
+				Username: username,
+				Password: passwordArgon2id,
+				Domains:  []string{"*"},
+			}},
+		),
+		Entry(
+			"when password does not match bcrypt hash", exampleDomain, username, "something",
+			[]config.User{{
+				Username: username,
+				Password: passwordBcrypt,
+				Domains:  []string{"*"},
+			}},
+		),
+		Entry(
+			"when hashed password is used as password", exampleDomain, username, passwordArgon2id,
+			[]config.User{{
+				Username: username,
+				Password: passwordArgon2id,
+				Domains:  []string{"*"},
+			}},
+		),
 		Entry(
 			"when domain does not match", testDomain, username, password,
 			[]config.User{{
@@ -547,6 +669,117 @@ var _ = Describe("CheckUsers", func() {
 				Domains:  nil,
 			}},
 		),
+		Entry(
+			"when record type does not match", exampleDomain, username, password,
+			[]config.User{{
+				Username:     username,
+				Password:     password,
+				Domains:      []string{"*"},
+				Restrictions: config.Restrictions{RecordTypes: []string{recordTypeTXT}},
+			}},
+		),
+		Entry(
+			"when name does not match", exampleDomain, username, password,
+			[]config.User{{
+				Username:     username,
+				Password:     password,
+				Domains:      []string{"*"},
+				Restrictions: config.Restrictions{Names: []string{"_acme-challenge.*"}},
+			}},
+		),
+	)
+})
+
+var _ = Describe("CheckUserToken", func() {
+	const token = "token"
+
+	DescribeTable(
+		"should allow access", func(fqdn string, users []config.User) {
+			Expect(middleware.CheckUserToken(fqdn, recordTypeA, token, users)).To(BeTrue())
+		},
+		Entry(
+			"with matching token and fqdn equals domain", exampleDomain,
+			[]config.User{{
+				Username:  username,
+				TokenHash: apitoken.Hash(token),
+				Domains:   []string{exampleDomain},
+			}},
+		),
+		Entry(
+			"with matching token and fqdn is a subdomain of one of the domains", subExampleDomain,
+			[]config.User{{
+				Username:  username,
+				TokenHash: apitoken.Hash(token),
+				Domains:   []string{testDomain, wildcardExample},
+			}},
+		),
+	)
+
+	DescribeTable(
+		"should deny access", func(fqdn string, users []config.User) {
+			Expect(middleware.CheckUserToken(fqdn, recordTypeA, token, users)).To(BeFalse())
+		},
+		Entry(
+			"when token does not match", exampleDomain,
+			[]config.User{{
+				Username:  username,
+				TokenHash: apitoken.Hash("something"),
+				Domains:   []string{"*"},
+			}},
+		),
+		Entry(
+			"when user has no token", exampleDomain,
+			[]config.User{{
+				Username: username,
+				Password: password,
+				Domains:  []string{"*"},
+			}},
+		),
+		Entry(
+			"when domain does not match", testDomain,
+			[]config.User{{
+				Username:  username,
+				TokenHash: apitoken.Hash(token),
+				Domains:   []string{exampleDomain},
+			}},
+		),
+		Entry(
+			"when fqdn is empty", "",
+			[]config.User{{
+				Username:  username,
+				TokenHash: apitoken.Hash(token),
+				Domains:   []string{"*"},
+			}},
+		),
+		Entry(
+			"when record type does not match", exampleDomain,
+			[]config.User{{
+				Username:     username,
+				TokenHash:    apitoken.Hash(token),
+				Domains:      []string{"*"},
+				Restrictions: config.Restrictions{RecordTypes: []string{recordTypeTXT}},
+			}},
+		),
+	)
+})
+
+var _ = Describe("CheckTSIGKey", func() {
+	key := &config.TSIGKey{
+		Name:    "ddns-key",
+		Domains: []string{wildcardExample},
+		Restrictions: config.Restrictions{
+			Names: []string{"_acme-challenge.*"},
+		},
+	}
+
+	DescribeTable("should check the domains and restrictions of the key", func(fqdn, recordType string, allowed bool) {
+		Expect(middleware.CheckTSIGKey(fqdn, recordType, key)).To(Equal(allowed))
+	},
+		Entry("when name and record type match", "_acme-challenge.example.com", recordTypeTXT, true),
+		Entry("when the domain does not match", "_acme-challenge.test.com", recordTypeTXT, false),
+		Entry("when the name does not match", subExampleDomain, recordTypeTXT, false),
+		Entry("when the record type is not allowed by default", "_acme-challenge.example.com", "CNAME", false),
+		Entry("when fqdn is empty", "", recordTypeTXT, false),
 	)
 })
 
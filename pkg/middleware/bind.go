@@ -2,7 +2,6 @@ package middleware
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -11,7 +10,9 @@ import (
 
 	"golang.org/x/net/publicsuffix"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
 )
 
 const (
@@ -61,14 +62,16 @@ func BindPlain(next http.Handler) http.Handler {
 				data.NewContextWithReqData(
 					r.Context(),
 					&data.ReqData{
-						FullName:  hostname,
-						Name:      name,
-						Zone:      zone,
-						Value:     ip,
-						Type:      recordType,
-						Username:  username,
-						Password:  password,
-						BasicAuth: true,
+						FullName:   hostname,
+						Name:       name,
+						Zone:       zone,
+						Value:      ip,
+						Type:       recordType,
+						Username:   username,
+						Password:   password,
+						BasicAuth:  true,
+						ClientCert: ClientCert(r),
+						Endpoint:   config.EndpointPlain,
 					},
 				),
 			),
@@ -112,14 +115,17 @@ func BindAcmeDNS(next http.Handler) http.Handler {
 				data.NewContextWithReqData(
 					r.Context(),
 					&data.ReqData{
-						FullName:  d.Subdomain,
-						Name:      name,
-						Zone:      zone,
-						Value:     d.TXT,
-						Type:      recordTypeTXT,
-						Username:  r.Header.Get("X-Api-User"),
-						Password:  r.Header.Get("X-Api-Key"),
-						BasicAuth: false,
+						FullName:   d.Subdomain,
+						Name:       name,
+						Zone:       zone,
+						Value:      d.TXT,
+						Type:       recordTypeTXT,
+						Username:   r.Header.Get("X-Api-User"),
+						Password:   r.Header.Get("X-Api-Key"),
+						Token:      BearerToken(r),
+						BasicAuth:  false,
+						ClientCert: ClientCert(r),
+						Endpoint:   config.EndpointAcmeDNS,
 					},
 				),
 			),
@@ -163,14 +169,17 @@ func BindHTTPReq(next http.Handler) http.Handler {
 				data.NewContextWithReqData(
 					r.Context(),
 					&data.ReqData{
-						FullName:  d.FQDN,
-						Name:      name,
-						Zone:      zone,
-						Value:     d.Value,
-						Type:      recordTypeTXT,
-						Username:  username,
-						Password:  password,
-						BasicAuth: true,
+						FullName:   d.FQDN,
+						Name:       name,
+						Zone:       zone,
+						Value:      d.Value,
+						Type:       recordTypeTXT,
+						Username:   username,
+						Password:   password,
+						Token:      BearerToken(r),
+						BasicAuth:  true,
+						ClientCert: ClientCert(r),
+						Endpoint:   config.EndpointHTTPReq,
 					},
 				),
 			),
@@ -199,14 +208,14 @@ func BindDirectAdmin(next http.Handler) http.Handler {
 			return
 		}
 
-		recordType := r.Form.Get("type")
-		if recordType != recordTypeA && recordType != recordTypeAAAA && recordType != recordTypeTXT {
-			http.Error(w, "type can only be A, AAAA or TXT", http.StatusBadRequest)
+		recordType := strings.ToUpper(r.Form.Get("type"))
+		if _, err := hetzner.RRSetTypeFromString(recordType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		value := r.Form.Get("value")
-		if err := validateValue(value, recordType); err != nil {
+		value, err := CanonicalValue(r.Form.Get("value"), recordType)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -228,14 +237,16 @@ func BindDirectAdmin(next http.Handler) http.Handler {
 				data.NewContextWithReqData(
 					r.Context(),
 					&data.ReqData{
-						FullName:  fqdn,
-						Name:      name,
-						Zone:      zone,
-						Value:     value,
-						Type:      recordType,
-						Username:  username,
-						Password:  password,
-						BasicAuth: true,
+						FullName:   fqdn,
+						Name:       name,
+						Zone:       zone,
+						Value:      value,
+						Type:       recordType,
+						Username:   username,
+						Password:   password,
+						BasicAuth:  true,
+						ClientCert: ClientCert(r),
+						Endpoint:   config.EndpointDirectAdmin,
 					},
 				),
 			),
@@ -243,22 +254,6 @@ func BindDirectAdmin(next http.Handler) http.Handler {
 	})
 }
 
-func validateValue(value, recordType string) error {
-	if recordType == recordTypeA || recordType == recordTypeAAAA {
-		parsedIP := net.ParseIP(value)
-		if parsedIP == nil {
-			return errors.New("invalid ip address")
-		}
-		if recordType == recordTypeA && parsedIP.To4() == nil {
-			return errors.New("invalid ipv4 address")
-		}
-		if recordType == recordTypeAAAA && parsedIP.To4() != nil {
-			return errors.New("invalid ipv6 address")
-		}
-	}
-	return nil
-}
-
 func SplitFQDN(fqdn string) (name, zone string, err error) {
 	zone, err = publicsuffix.EffectiveTLDPlusOne(fqdn)
 	if err != nil {
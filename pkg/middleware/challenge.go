@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/challenge"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+)
+
+// NewTrackChallenge passes challenge values added by the request to reaper,
+// which removes them once they expired. Values that were already present are
+// not tracked.
+func NewTrackChallenge(reaper *challenge.Reaper) func(http.Handler) http.Handler {
+	return newChallengeHandler(reaper, func(reqData *data.ReqData) {
+		if !reqData.Unchanged {
+			reaper.Track(reqData)
+		}
+	})
+}
+
+// NewForgetChallenge stops tracking challenge values cleaned up by the
+// request.
+func NewForgetChallenge(reaper *challenge.Reaper) func(http.Handler) http.Handler {
+	return newChallengeHandler(reaper, reaper.Forget)
+}
+
+func newChallengeHandler(reaper *challenge.Reaper, fn func(reqData *data.ReqData)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if reaper == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqData, err := data.ReqDataFromContext(r.Context())
+			if err != nil {
+				log.Printf("%v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			fn(reqData)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
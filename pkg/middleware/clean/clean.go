@@ -2,19 +2,32 @@ package clean
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/lock"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware/clean/cloud"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
 )
 
-func New(cfg *config.Config, m *sync.Mutex) func(http.Handler) http.Handler {
-	c := cloud.New(cfg, m)
+// New removes the value of the request from its rrset.
+func New(cfg *config.Config, projects *hetzner.Projects, locks *lock.Keyed) func(http.Handler) http.Handler {
+	return newCleaner(cfg, "clean", cloud.New(cfg, projects, locks).Clean)
+}
+
+// NewDelete deletes the rrset of the request with all of its records.
+func NewDelete(cfg *config.Config, projects *hetzner.Projects, locks *lock.Keyed) func(http.Handler) http.Handler {
+	return newCleaner(cfg, "delete", cloud.New(cfg, projects, locks).Delete)
+}
 
+func newCleaner(
+	cfg *config.Config, action string, clean func(ctx context.Context, reqData *data.ReqData) error,
+) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			reqData, err := data.ReqDataFromContext(r.Context())
@@ -24,12 +37,12 @@ func New(cfg *config.Config, m *sync.Mutex) func(http.Handler) http.Handler {
 				return
 			}
 
-			log.Printf("received request to clean '%s' data of '%s'", reqData.Type, reqData.FullName)
+			log.Printf("received request to %s '%s' data of '%s'", action, reqData.Type, reqData.FullName)
 			ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfg.Timeout)*time.Second)
 			defer cancel()
-			if err := c.Clean(ctx, reqData); err != nil {
-				log.Printf("failed to clean record: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
+			if err := clean(ctx, reqData); err != nil {
+				log.Printf("failed to %s record: %v", action, err)
+				w.WriteHeader(errorStatus(err))
 				return
 			}
 
@@ -37,3 +50,17 @@ func New(cfg *config.Config, m *sync.Mutex) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// errorStatus returns the status code of a failed request. RRSets not managed
+// by the proxy are forbidden to change, requests rejected to preserve the API
+// budget may be retried later.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, hetzner.ErrUnmanaged):
+		return http.StatusForbidden
+	case errors.Is(err, ratelimit.ErrReserved):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -2,57 +2,150 @@ package cloud
 
 import (
 	"context"
-	"sync"
+	"fmt"
+	"slices"
 
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/lock"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
 )
 
 type cleaner struct {
-	cfg    *config.Config
-	client *hcloud.Client
-	m      *sync.Mutex
+	cfg      *config.Config
+	projects *hetzner.Projects
+	locks    *lock.Keyed
 }
 
-func New(cfg *config.Config, m *sync.Mutex) *cleaner {
+func New(cfg *config.Config, projects *hetzner.Projects, locks *lock.Keyed) *cleaner {
 	return &cleaner{
-		cfg:    cfg,
-		client: hetzner.NewHCloudClient(cfg),
-		m:      m,
+		cfg:      cfg,
+		projects: projects,
+		locks:    locks,
 	}
 }
 
+// Clean removes the value of reqData from the records of its rrset. The
+// rrset is deleted if no records are left.
 func (u *cleaner) Clean(ctx context.Context, reqData *data.ReqData) error {
-	// Ensure only one simultaneous update sequence
-	u.m.Lock()
-	defer u.m.Unlock()
+	return u.clean(ctx, reqData, false)
+}
 
-	rrSetType, err := hetzner.RRSetTypeFromString(reqData.Type)
+// CleanManaged is Clean for rrsets created by the proxy. Values of rrsets
+// managed by hand are left untouched.
+func (u *cleaner) CleanManaged(ctx context.Context, reqData *data.ReqData) error {
+	return u.clean(ctx, reqData, true)
+}
+
+func (u *cleaner) clean(ctx context.Context, reqData *data.ReqData, managedOnly bool) error {
+	// Ensure only one simultaneous update sequence per rrset
+	l := u.locks.RRSet(reqData.Zone, reqData.Name, reqData.Type)
+	metrics.Lock(l)
+	defer l.Unlock()
+
+	client, err := u.projects.Client(reqData.Zone)
 	if err != nil {
 		return err
 	}
-
-	zone, _, err := u.client.Zone.Get(ctx, reqData.Zone)
+	rrSet, err := getRRSet(ctx, client, reqData)
 	if err != nil {
 		return err
 	}
 
-	rrSet, _, err := u.client.Zone.GetRRSetByNameAndType(ctx, zone, reqData.Name, rrSetType)
-	if err != nil {
+	if rrSet == nil || (managedOnly && !hetzner.IsManaged(rrSet)) {
+		reqData.Unchanged = true
+		return nil
+	}
+	if err := u.checkOwnership(reqData, rrSet); err != nil {
 		return err
 	}
+	values := hetzner.Values(rrSet)
+	if !slices.Contains(values, reqData.Value) {
+		reqData.Unchanged = true
+		return nil
+	}
+	if len(slices.DeleteFunc(values, func(v string) bool { return v == reqData.Value })) == 0 {
+		return deleteRRSet(ctx, client, rrSet)
+	}
 
-	action, _, err := u.client.Zone.RemoveRRSetRecords(ctx, rrSet, hcloud.ZoneRRSetRemoveRecordsOpts{
-		Records: []hcloud.ZoneRRSetRecord{{Value: hetzner.QuoteIfRequired(reqData.Value, rrSetType)}},
+	action, _, err := client.Zone.RemoveRRSetRecords(ctx, rrSet, hcloud.ZoneRRSetRemoveRecordsOpts{
+		Records: hetzner.Records([]string{reqData.Value}, rrSet.Type),
 	})
 	if err != nil {
 		return err
 	}
 	if action != nil {
-		return u.client.Action.WaitFor(ctx, action)
+		return client.Action.WaitFor(ctx, action)
+	}
+
+	return nil
+}
+
+// Delete deletes the rrset of reqData with all of its records.
+func (u *cleaner) Delete(ctx context.Context, reqData *data.ReqData) error {
+	// Ensure only one simultaneous update sequence per rrset
+	l := u.locks.RRSet(reqData.Zone, reqData.Name, reqData.Type)
+	metrics.Lock(l)
+	defer l.Unlock()
+
+	client, err := u.projects.Client(reqData.Zone)
+	if err != nil {
+		return err
+	}
+	rrSet, err := getRRSet(ctx, client, reqData)
+	if err != nil {
+		return err
+	}
+	if rrSet == nil {
+		reqData.Unchanged = true
+		return nil
+	}
+	if err := u.checkOwnership(reqData, rrSet); err != nil {
+		return err
+	}
+
+	return deleteRRSet(ctx, client, rrSet)
+}
+
+// checkOwnership checks that rrSet may be changed on behalf of reqData. In
+// ownership mode rrsets managed by hand may only be changed by users with
+// takeover permission.
+func (u *cleaner) checkOwnership(reqData *data.ReqData, rrSet *hcloud.ZoneRRSet) error {
+	if u.cfg.Ownership.Enabled && !hetzner.IsManaged(rrSet) && !reqData.Takeover {
+		return fmt.Errorf("%w: %s %s", hetzner.ErrUnmanaged, reqData.Type, reqData.FullName)
+	}
+	return nil
+}
+
+func getRRSet(ctx context.Context, client *hcloud.Client, reqData *data.ReqData) (*hcloud.ZoneRRSet, error) {
+	rrSetType, err := hetzner.RRSetTypeFromString(reqData.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	zone, _, err := client.Zone.Get(ctx, reqData.Zone)
+	if err != nil || zone == nil {
+		return nil, err
+	}
+
+	rrSet, _, err := client.Zone.GetRRSetByNameAndType(ctx, zone, reqData.Name, rrSetType)
+	if err != nil {
+		return nil, err
+	}
+
+	return rrSet, nil
+}
+
+func deleteRRSet(ctx context.Context, client *hcloud.Client, rrSet *hcloud.ZoneRRSet) error {
+	result, _, err := client.Zone.DeleteRRSet(ctx, rrSet)
+	if err != nil {
+		return err
+	}
+	if result.Action != nil {
+		return client.Action.WaitFor(ctx, result.Action)
 	}
 
 	return nil
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+)
+
+// NewSetClientCert stores the verified client certificate of a request in
+// its context. Requests of trusted proxies may forward the certificate in
+// header instead, which is ignored from other clients. Forwarded
+// certificates must be signed by one of roots, the CAs of tls.clientCA.
+// Without roots they cannot be verified and only their fingerprint is kept,
+// so they cannot match certNames. It must run before NewSetClientIP
+// replaces the address of the proxy.
+func NewSetClientCert(trustedProxies []netip.Prefix, header string, roots *x509.CertPool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cert := verifiedClientCert(r)
+			if header != "" && r.Header.Get(header) != "" && isTrustedRemote(trustedProxies, r.RemoteAddr) {
+				forwarded, err := forwardedClientCert(r.Header.Get(header), roots)
+				if err != nil {
+					//nolint:gosec // err contains no user-controlled data
+					log.Printf("ignoring invalid forwarded client certificate from proxy %s: %v", r.RemoteAddr, err)
+				} else {
+					cert = forwarded
+				}
+			}
+
+			if cert != nil {
+				r = r.WithContext(data.NewContextWithClientCert(r.Context(), cert))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientCert returns the verified client certificate of r, nil if there is
+// none.
+func ClientCert(r *http.Request) *x509.Certificate {
+	return data.ClientCertFromContext(r.Context())
+}
+
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+func isTrustedRemote(trustedProxies []netip.Prefix, remoteAddr string) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	return isTrustedProxy(trustedProxies, addrPort.Addr())
+}
+
+// forwardedClientCert parses the certificate forwarded in value and verifies
+// it with roots. Without roots only the raw certificate is returned, its
+// names are left out as they are not verified.
+func forwardedClientCert(value string, roots *x509.CertPool) (*x509.Certificate, error) {
+	cert, err := parseForwardedClientCert(value)
+	if err != nil {
+		return nil, err
+	}
+	if roots == nil {
+		return &x509.Certificate{Raw: cert.Raw}, nil
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, errors.New("not verified by tls.clientCA")
+	}
+	return cert, nil
+}
+
+// parseForwardedClientCert parses a certificate forwarded by a proxy, either
+// as URL escaped PEM like nginx's $ssl_client_escaped_cert or as base64
+// encoded DER.
+func parseForwardedClientCert(value string) (*x509.Certificate, error) {
+	unescaped, err := url.PathUnescape(value)
+	if err != nil {
+		return nil, errors.New("invalid escaping")
+	}
+	if strings.Contains(unescaped, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(unescaped))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, errors.New("no PEM encoded certificate")
+		}
+		return x509.ParseCertificate(block.Bytes)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(unescaped))
+	if err != nil {
+		return nil, errors.New("neither PEM nor base64 encoded")
+	}
+	return x509.ParseCertificate(der)
+}
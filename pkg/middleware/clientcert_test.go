@@ -0,0 +1,262 @@
+package middleware_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+const headerClientCert = "X-Client-Cert"
+
+// newClientCert returns a self-signed client certificate with commonName
+// and dnsNames.
+func newClientCert(commonName string, dnsNames ...string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	return cert
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+var _ = Describe("SetClientCert", func() {
+	var (
+		captured *x509.Certificate
+		inner    http.Handler
+		verified *x509.Certificate
+		proxied  *x509.Certificate
+		trusted  []netip.Prefix
+		roots    *x509.CertPool
+	)
+
+	BeforeEach(func() {
+		captured = nil
+		inner = http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			captured = middleware.ClientCert(r)
+		})
+		verified = newClientCert("verified")
+		proxied = newClientCert("proxied")
+		trusted = []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}
+		roots = x509.NewCertPool()
+		roots.AddCert(proxied)
+	})
+
+	run := func(remoteAddr string, state *tls.ConnectionState, headers map[string]string) {
+		handler := middleware.NewSetClientCert(trusted, headerClientCert, roots)(inner)
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = remoteAddr
+		req.TLS = state
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	escapedPEM := func(cert *x509.Certificate) string {
+		return url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+	}
+
+	It("stores the verified certificate of the connection", func() {
+		run("192.0.2.1:1234", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{verified}}}, nil)
+		Expect(captured).To(Equal(verified))
+	})
+
+	It("ignores unverified certificates of the connection", func() {
+		run("192.0.2.1:1234", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{verified}}, nil)
+		Expect(captured).To(BeNil())
+	})
+
+	DescribeTable("accepts a certificate forwarded by a trusted proxy", func(value func(*x509.Certificate) string) {
+		run("10.0.0.1:1234", nil, map[string]string{headerClientCert: value(proxied)})
+		Expect(captured).To(Equal(proxied))
+	},
+		Entry("as URL escaped PEM", escapedPEM),
+		Entry("as base64 encoded DER", func(cert *x509.Certificate) string {
+			return base64.StdEncoding.EncodeToString(cert.Raw)
+		}),
+	)
+
+	It("ignores forwarded certificates not signed by the client CA", func() {
+		roots = x509.NewCertPool()
+		roots.AddCert(verified)
+		run("10.0.0.1:1234", nil, map[string]string{headerClientCert: escapedPEM(proxied)})
+		Expect(captured).To(BeNil())
+	})
+
+	It("keeps only the raw forwarded certificate without client CA", func() {
+		roots = nil
+		proxied = newClientCert("proxied", "proxied.example.com")
+		run("10.0.0.1:1234", nil, map[string]string{headerClientCert: escapedPEM(proxied)})
+		Expect(captured).ToNot(BeNil())
+		Expect(captured.Raw).To(Equal(proxied.Raw))
+		Expect(captured.Subject.CommonName).To(BeEmpty())
+		Expect(captured.DNSNames).To(BeEmpty())
+	})
+
+	It("ignores certificates forwarded by other clients", func() {
+		run("192.0.2.1:1234", nil, map[string]string{headerClientCert: escapedPEM(proxied)})
+		Expect(captured).To(BeNil())
+	})
+
+	It("keeps the verified certificate if the forwarded one is invalid", func() {
+		run("10.0.0.1:1234", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{verified}}},
+			map[string]string{headerClientCert: "invalid"})
+		Expect(captured).To(Equal(verified))
+	})
+})
+
+var _ = Describe("CheckUserCert", func() {
+	var cert *x509.Certificate
+
+	BeforeEach(func() {
+		cert = newClientCert("router", "router.example.com")
+	})
+
+	DescribeTable(
+		"should allow access", func(user func() config.User) {
+			Expect(middleware.CheckUserCert(exampleDomain, recordTypeA, cert, []config.User{user()})).To(BeTrue())
+		},
+		Entry("when the common name matches", func() config.User {
+			return config.User{Username: username, CertNames: []string{"router"}, Domains: []string{exampleDomain}}
+		}),
+		Entry("when a DNS name matches ignoring case", func() config.User {
+			return config.User{Username: username, CertNames: []string{"Router.Example.com"}, Domains: []string{exampleDomain}}
+		}),
+		Entry("when the fingerprint matches", func() config.User {
+			return config.User{Username: username, CertFingerprints: []string{fingerprint(cert)}, Domains: []string{exampleDomain}}
+		}),
+	)
+
+	DescribeTable(
+		"should deny access", func(user func() config.User) {
+			Expect(middleware.CheckUserCert(exampleDomain, recordTypeA, cert, []config.User{user()})).To(BeFalse())
+		},
+		Entry("when no name or fingerprint matches", func() config.User {
+			return config.User{
+				Username:         username,
+				CertNames:        []string{"other"},
+				CertFingerprints: []string{fingerprint(newClientCert("other"))},
+				Domains:          []string{exampleDomain},
+			}
+		}),
+		Entry("when the user has no certificate", func() config.User {
+			return config.User{Username: username, Password: password, Domains: []string{exampleDomain}}
+		}),
+		Entry("when domain does not match", func() config.User {
+			return config.User{Username: username, CertNames: []string{"router"}, Domains: []string{testDomain}}
+		}),
+		Entry("when record type does not match", func() config.User {
+			return config.User{
+				Username:     username,
+				CertNames:    []string{"router"},
+				Domains:      []string{exampleDomain},
+				Restrictions: config.Restrictions{RecordTypes: []string{recordTypeTXT}},
+			}
+		}),
+	)
+
+	It("should deny access without certificate", func() {
+		users := []config.User{{Username: username, CertNames: []string{"router"}, Domains: []string{exampleDomain}}}
+		Expect(middleware.CheckUserCert(exampleDomain, recordTypeA, nil, users)).To(BeFalse())
+	})
+})
+
+var _ = Describe("CheckPermission with client certificates", func() {
+	var (
+		cert *x509.Certificate
+		cfg  *config.Config
+	)
+
+	BeforeEach(func() {
+		cert = newClientCert("router")
+		cfg = &config.Config{
+			Auth: config.Auth{
+				AllowedDomains: config.AllowedDomains{testDomain: []*net.IPNet{{
+					IP:   net.IPv4(127, 0, 0, 1),
+					Mask: net.IPv4Mask(255, 255, 255, 255),
+				}}},
+				Users: []config.User{{
+					Username:  "router",
+					Password:  password,
+					CertNames: []string{"router"},
+					Domains:   []string{exampleDomain},
+					Takeover:  true,
+				}},
+			},
+		}
+	})
+
+	It("should identify the user by certificate with auth method clientCert", func() {
+		cfg.Auth.Method = config.AuthMethodClientCert
+		reqData := &data.ReqData{FullName: exampleDomain, Type: recordTypeA, ClientCert: cert}
+		Expect(middleware.CheckPermission(cfg, reqData, "")).To(BeTrue())
+		Expect(reqData.User).To(Equal("router"))
+		Expect(reqData.Takeover).To(BeTrue())
+	})
+
+	It("should ignore passwords with auth method clientCert", func() {
+		cfg.Auth.Method = config.AuthMethodClientCert
+		reqData := &data.ReqData{FullName: exampleDomain, Type: recordTypeA, Username: "router", Password: password}
+		Expect(middleware.CheckPermission(cfg, reqData, "")).To(BeFalse())
+	})
+
+	It("should fall back to the certificate if the password does not match", func() {
+		cfg.Auth.Method = config.AuthMethodUsers
+		reqData := &data.ReqData{FullName: exampleDomain, Type: recordTypeA, Username: "router", Password: "wrong", ClientCert: cert}
+		Expect(middleware.CheckPermission(cfg, reqData, "")).To(BeTrue())
+	})
+
+	DescribeTable("should combine with allowedDomains", func(method, fqdn, remoteAddr string, allowed bool) {
+		cfg.Auth.Method = method
+		cfg.Auth.AllowedDomains[exampleDomain] = cfg.Auth.AllowedDomains[testDomain]
+		reqData := &data.ReqData{FullName: fqdn, Type: recordTypeA, ClientCert: cert}
+		Expect(middleware.CheckPermission(cfg, reqData, remoteAddr)).To(Equal(allowed))
+	},
+		Entry("any with certificate only", config.AuthMethodAny, exampleDomain, "", true),
+		Entry("any with allowed domain only", config.AuthMethodAny, testDomain, "127.0.0.1", true),
+		Entry("both with certificate and allowed domain", config.AuthMethodBoth, exampleDomain, "127.0.0.1", true),
+		Entry("both with certificate only", config.AuthMethodBoth, exampleDomain, "", false),
+	)
+
+	It("should list the domains of the certificate user", func() {
+		cfg.Auth.Method = config.AuthMethodClientCert
+		Expect(middleware.GetDomains(cfg, &data.ReqData{Username: "router", Password: password, ClientCert: cert}, "")).
+			To(Equal(map[string]struct{}{exampleDomain: {}}))
+		Expect(middleware.GetDomains(cfg, &data.ReqData{Username: "router", Password: password}, "")).To(BeEmpty())
+	})
+})
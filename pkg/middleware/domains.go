@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"crypto/x509"
 	"log"
 	"maps"
 	"net"
@@ -8,7 +9,10 @@ import (
 	"net/url"
 	"strings"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/apitoken"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
 )
@@ -31,14 +35,22 @@ func NewShowDomainsDirectAdmin(cfg *config.Config, lockout *ratelimit.Lockout) f
 			username, password, _ := r.BasicAuth()
 			usesUsers := authMethodUsesUsers(cfg.Auth.Method)
 			if usesUsers && (username != "" || password != "") {
-				if checkUserCredentials(username, password, cfg.Auth.Users) {
+				if checkUserCredentials(username, password, cfg.Auth.Users) ||
+					apiTokenIsValid(cfg.Auth.APITokens, password, config.EndpointDirectAdmin) ||
+					jwtIsValid(cfg.Auth.JWTIssuers, password, config.EndpointDirectAdmin) {
 					lockout.Reset(r.RemoteAddr)
 				} else {
 					lockout.RecordFailure(r.RemoteAddr)
+					metrics.AuthFailure(cfg.Auth.Method)
 				}
 			}
 
-			domains := GetDomains(cfg, r.RemoteAddr, username, password)
+			domains := GetDomains(cfg, &data.ReqData{
+				Username:   username,
+				Password:   password,
+				ClientCert: ClientCert(r),
+				Endpoint:   config.EndpointDirectAdmin,
+			}, r.RemoteAddr)
 			if len(domains) == 0 {
 				addr := sanitize.LogValue(r.RemoteAddr)
 				//nolint:gosec // value is sanitized above
@@ -70,14 +82,49 @@ func authMethodUsesUsers(method string) bool {
 		method == config.AuthMethodAny
 }
 
-func GetDomains(cfg *config.Config, remoteAddr, username, password string) map[string]struct{} {
+// GetDomains returns the domains the client with remoteAddr may update with
+// the credentials of reqData, the token or username and password of a user,
+// an API token, a JWT or a client certificate.
+func GetDomains(cfg *config.Config, reqData *data.ReqData, remoteAddr string) map[string]struct{} {
+	domainsUsers := map[string]struct{}{}
+	if cfg.Auth.Method != config.AuthMethodJWT {
+		maps.Copy(domainsUsers, getDomainsFromUserCert(cfg.Auth.Users, reqData.ClientCert))
+	}
+	if cfg.Auth.Method != config.AuthMethodClientCert {
+		maps.Copy(domainsUsers, getDomainsFromJWT(cfg.Auth.JWTIssuers, tokenCredential(reqData), reqData.Endpoint))
+	}
+	if cfg.Auth.Method != config.AuthMethodClientCert && cfg.Auth.Method != config.AuthMethodJWT {
+		if reqData.Token != "" {
+			maps.Copy(domainsUsers, getDomainsFromUserToken(cfg.Auth.Users, reqData.Token))
+		} else {
+			maps.Copy(domainsUsers, getDomainsFromUsers(cfg.Auth.Users, reqData.Username, reqData.Password))
+		}
+		maps.Copy(domainsUsers, getDomainsFromAPITokens(cfg.Auth.APITokens, tokenCredential(reqData), reqData.Endpoint))
+	}
+	return combineDomains(cfg, remoteAddr, domainsUsers)
+}
+
+// ZoneOverlaps returns true if zone contains any of domains or is contained
+// by any of them. domains are expected without wildcards as returned by
+// GetDomains.
+func ZoneOverlaps(zone string, domains map[string]struct{}) bool {
+	for domain := range domains {
+		if domain == "*" || domain == zone ||
+			strings.HasSuffix(domain, "."+zone) || strings.HasSuffix(zone, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func combineDomains(cfg *config.Config, remoteAddr string, domainsUsers map[string]struct{}) map[string]struct{} {
 	domainsAllowedDomains := getDomainsFromAllowedDomains(cfg.Auth.AllowedDomains, remoteAddr)
 	if cfg.Auth.Method == config.AuthMethodAllowedDomains {
 		return stripWildcards(domainsAllowedDomains)
 	}
 
-	domainsUsers := getDomainsFromUsers(cfg.Auth.Users, username, password)
-	if cfg.Auth.Method == config.AuthMethodUsers {
+	if cfg.Auth.Method == config.AuthMethodUsers || cfg.Auth.Method == config.AuthMethodClientCert ||
+		cfg.Auth.Method == config.AuthMethodJWT {
 		return stripWildcards(domainsUsers)
 	}
 
@@ -124,7 +171,41 @@ func getDomainsFromUsers(users []config.User, username, password string) map[str
 		return domains
 	}
 	for _, user := range users {
-		if constantTimeEqual(user.Username, username)&constantTimeEqual(user.Password, password) == 1 {
+		if credentialsMatch(&user, username, password) == 1 {
+			for _, domain := range user.Domains {
+				domains[domain] = struct{}{}
+			}
+		}
+	}
+
+	return domains
+}
+
+func getDomainsFromUserToken(users []config.User, token string) map[string]struct{} {
+	domains := map[string]struct{}{}
+	if token == "" {
+		return domains
+	}
+	hashed := apitoken.Hash(token)
+	for _, user := range users {
+		if apitoken.Matches(user.TokenHash, hashed) == 1 {
+			for _, domain := range user.Domains {
+				domains[domain] = struct{}{}
+			}
+		}
+	}
+
+	return domains
+}
+
+func getDomainsFromUserCert(users []config.User, cert *x509.Certificate) map[string]struct{} {
+	domains := map[string]struct{}{}
+	if cert == nil {
+		return domains
+	}
+	fingerprint, names := certIdentity(cert)
+	for _, user := range users {
+		if certMatch(&user, fingerprint, names) == 1 {
 			for _, domain := range user.Domains {
 				domains[domain] = struct{}{}
 			}
@@ -10,6 +10,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
 )
@@ -60,7 +61,7 @@ var _ = Describe("GetDomains", func() {
 					},
 				},
 			}
-			Expect(middleware.GetDomains(cfg, remoteAddr, username, password)).To(Equal(expectedDomains))
+			Expect(middleware.GetDomains(cfg, &data.ReqData{Username: username, Password: password}, remoteAddr)).To(Equal(expectedDomains))
 		},
 		Entry(
 			"with auth method allowed domains",
@@ -107,7 +108,7 @@ var _ = Describe("GetDomains", func() {
 				Method: invalidAuthMethod,
 			},
 		}
-		Expect(middleware.GetDomains(cfg, remoteAddr, username, password)).To(BeEmpty())
+		Expect(middleware.GetDomains(cfg, &data.ReqData{Username: username, Password: password}, remoteAddr)).To(BeEmpty())
 	})
 
 	DescribeTable(
@@ -117,7 +118,7 @@ var _ = Describe("GetDomains", func() {
 					Method: authMethod,
 				},
 			}
-			Expect(middleware.GetDomains(cfg, remoteAddr, "", "")).To(BeEmpty())
+			Expect(middleware.GetDomains(cfg, &data.ReqData{}, remoteAddr)).To(BeEmpty())
 		},
 		Entry("allowedDomains", config.AuthMethodAllowedDomains),
 		Entry("any", config.AuthMethodAny),
@@ -130,7 +131,7 @@ var _ = Describe("GetDomains", func() {
 					Method: authMethod,
 				},
 			}
-			Expect(middleware.GetDomains(cfg, remoteAddr, "", "")).To(BeEmpty())
+			Expect(middleware.GetDomains(cfg, &data.ReqData{}, remoteAddr)).To(BeEmpty())
 		},
 		Entry("users", config.AuthMethodUsers),
 		Entry("both", config.AuthMethodBoth),
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"path"
+	"strconv"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+// jwtUser is the user of requests authorized by a JWT without sub claim
+const jwtUser = "jwt"
+
+// CheckJWT works like CheckAPIToken, but identifies the rules of an issuer
+// by the claims of the JWT token.
+func CheckJWT(fqdn, recordType, endpoint, token string, issuers []config.JWTIssuer) bool {
+	allowed, _ := checkJWT(fqdn, recordType, endpoint, token, issuers)
+	return allowed
+}
+
+// checkJWT works like CheckJWT, but additionally returns the matching rules
+// as user named after the subject of the token.
+func checkJWT(fqdn, recordType, endpoint, token string, issuers []config.JWTIssuer) (bool, config.User) {
+	if fqdn == "" || token == "" {
+		return false, config.User{}
+	}
+	t, rules := matchingJWTRules(issuers, token, endpoint)
+	matches := make([]int, len(rules))
+	users := make([]config.User, len(rules))
+	for i, rule := range rules {
+		users[i] = jwtRuleAsUser(t, rule)
+		matches[i] = userMatch(&users[i], fqdn, recordType)
+	}
+	return matchedUser(users, matches)
+}
+
+// matchingJWTRules returns the verified token and the rules of its issuer
+// applying to endpoint whose claims it matches. Tokens of configured issuers
+// failing verification are logged, other tokens are ignored silently as the
+// credential might be a password or another kind of token.
+func matchingJWTRules(issuers []config.JWTIssuer, token, endpoint string) (*jwt.Token, []*config.JWTRule) {
+	if len(issuers) == 0 {
+		return nil, nil
+	}
+	t, err := jwt.Parse(token)
+	if err != nil {
+		return nil, nil
+	}
+	for i := range issuers {
+		issuer := &issuers[i]
+		if issuer.Issuer != t.Issuer() || issuer.Verifier == nil {
+			continue
+		}
+		if err := issuer.Verifier.Verify(t); err != nil {
+			logJWTRejected(t, err)
+			return nil, nil
+		}
+		var rules []*config.JWTRule
+		for j := range issuer.Rules {
+			if issuer.Rules[j].AllowsEndpoint(endpoint) && claimsMatch(t.Claims, issuer.Rules[j].Claims) {
+				rules = append(rules, &issuer.Rules[j])
+			}
+		}
+		return t, rules
+	}
+	return nil, nil
+}
+
+// jwtIsValid returns true if token is a verified JWT matching any rule
+// applying to endpoint.
+func jwtIsValid(issuers []config.JWTIssuer, token, endpoint string) bool {
+	if token == "" {
+		return false
+	}
+	_, rules := matchingJWTRules(issuers, token, endpoint)
+	return len(rules) > 0
+}
+
+func getDomainsFromJWT(issuers []config.JWTIssuer, token, endpoint string) map[string]struct{} {
+	domains := map[string]struct{}{}
+	if token == "" {
+		return domains
+	}
+	_, rules := matchingJWTRules(issuers, token, endpoint)
+	for _, rule := range rules {
+		for _, domain := range rule.Domains {
+			domains[domain] = struct{}{}
+		}
+	}
+
+	return domains
+}
+
+// claimsMatch returns true if claims match all patterns.
+func claimsMatch(claims jwt.Claims, patterns map[string]string) bool {
+	for name, pattern := range patterns {
+		if !claimMatches(claims[name], pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimMatches returns true if the claim v or, if it is a list, any of its
+// elements matches pattern. Numbers and booleans are matched in their JSON
+// representation.
+func claimMatches(v any, pattern string) bool {
+	var s string
+	switch c := v.(type) {
+	case string:
+		s = c
+	case json.Number:
+		s = c.String()
+	case bool:
+		s = strconv.FormatBool(c)
+	case []any:
+		for _, e := range c {
+			if claimMatches(e, pattern) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+	matched, err := path.Match(pattern, s)
+	return err == nil && matched
+}
+
+// jwtRuleAsUser returns a user with the scope of rule, named after the
+// subject of t.
+func jwtRuleAsUser(t *jwt.Token, rule *config.JWTRule) config.User {
+	username := t.Subject()
+	if username == "" {
+		username = jwtUser
+	}
+	return config.User{
+		Username:     username,
+		Domains:      rule.Domains,
+		Restrictions: rule.Restrictions,
+		Takeover:     rule.Takeover,
+	}
+}
+
+func logJWTRejected(t *jwt.Token, err error) {
+	iss := sanitize.LogValue(t.Issuer())
+	sub := sanitize.LogValue(t.Subject())
+	reason := sanitize.LogValue(err.Error())
+	//nolint:gosec // values are sanitized above
+	log.Printf("JWT of issuer '%s' for subject '%s' rejected: %s", iss, sub, reason)
+}
@@ -0,0 +1,212 @@
+package middleware_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+const (
+	jwtIssuer   = "https://gitlab.example.com"
+	jwtAudience = "hetzner-dnsapi-proxy"
+	jwtSubject  = "project_path:group/project:ref_type:branch:ref:main"
+)
+
+// newJWTIssuer returns an issuer verifying tokens with the public key of
+// priv and a rule allowing wildcardExample to projects of group.
+func newJWTIssuer(priv ed25519.PrivateKey) config.JWTIssuer {
+	set, err := json.Marshal(map[string]any{"keys": []any{map[string]string{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"x":   base64.RawURLEncoding.EncodeToString(priv.Public().(ed25519.PublicKey)),
+	}}})
+	Expect(err).ToNot(HaveOccurred())
+	return config.JWTIssuer{
+		Issuer:   jwtIssuer,
+		Audience: jwtAudience,
+		Rules: []config.JWTRule{{
+			Claims:  map[string]string{"project_path": "group/*"},
+			Domains: []string{wildcardExample},
+		}},
+		Verifier: jwt.NewVerifier(jwtIssuer, jwtAudience, func() ([]byte, error) {
+			return set, nil
+		}, time.Hour),
+	}
+}
+
+// signJWT returns a token with claims signed by priv.
+func signJWT(priv ed25519.PrivateKey, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": jwt.AlgEdDSA, "typ": "JWT"})
+	Expect(err).ToNot(HaveOccurred())
+	payload, err := json.Marshal(claims)
+	Expect(err).ToNot(HaveOccurred())
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(priv, []byte(signed)))
+}
+
+func jwtClaims(modify ...func(map[string]any)) map[string]any {
+	claims := map[string]any{
+		"iss":          jwtIssuer,
+		"aud":          jwtAudience,
+		"sub":          jwtSubject,
+		"project_path": "group/project",
+		"exp":          time.Now().Add(time.Hour).Unix(),
+	}
+	for _, m := range modify {
+		m(claims)
+	}
+	return claims
+}
+
+var _ = Describe("CheckJWT", func() {
+	var (
+		priv   ed25519.PrivateKey
+		issuer config.JWTIssuer
+	)
+
+	BeforeEach(func() {
+		var err error
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		issuer = newJWTIssuer(priv)
+	})
+
+	check := func(fqdn, endpoint string, claims map[string]any) bool {
+		return middleware.CheckJWT(fqdn, recordTypeA, endpoint, signJWT(priv, claims), []config.JWTIssuer{issuer})
+	}
+
+	It("should allow access when the claims match a rule", func() {
+		Expect(check(subExampleDomain, config.EndpointHTTPReq, jwtClaims())).To(BeTrue())
+	})
+
+	DescribeTable("should match claims", func(claim any, pattern string) {
+		issuer.Rules[0].Claims = map[string]string{"claim": pattern}
+		Expect(check(subExampleDomain, config.EndpointHTTPReq, jwtClaims(func(c map[string]any) {
+			c["claim"] = claim
+		}))).To(BeTrue())
+	},
+		Entry("of strings by pattern", "refs/heads/main", "refs/heads/*"),
+		Entry("of numbers", 42, "42"),
+		Entry("of booleans", true, "true"),
+		Entry("of lists by any element", []string{"a", "b"}, "b"),
+	)
+
+	DescribeTable("should deny access", func(fqdn, endpoint string, modify func(map[string]any)) {
+		Expect(check(fqdn, endpoint, jwtClaims(modify))).To(BeFalse())
+	},
+		Entry("when the claims match no rule", subExampleDomain, config.EndpointHTTPReq, func(c map[string]any) {
+			c["project_path"] = "other/project"
+		}),
+		Entry("when a claim is missing", subExampleDomain, config.EndpointHTTPReq, func(c map[string]any) {
+			delete(c, "project_path")
+		}),
+		Entry("when the domain does not match", testDomain, config.EndpointHTTPReq, func(map[string]any) {}),
+		Entry("when the token is expired", subExampleDomain, config.EndpointHTTPReq, func(c map[string]any) {
+			c["exp"] = time.Now().Add(-time.Hour).Unix()
+		}),
+		Entry("when the audience does not match", subExampleDomain, config.EndpointHTTPReq, func(c map[string]any) {
+			c["aud"] = "other"
+		}),
+		Entry("when the issuer is unknown", subExampleDomain, config.EndpointHTTPReq, func(c map[string]any) {
+			c["iss"] = "https://token.actions.githubusercontent.com"
+		}),
+	)
+
+	It("should deny access when the rule does not apply to the endpoint", func() {
+		issuer.Rules[0].Endpoints = []string{config.EndpointHTTPReq}
+		Expect(check(subExampleDomain, config.EndpointPlain, jwtClaims())).To(BeFalse())
+	})
+
+	It("should deny access when the record type is restricted", func() {
+		issuer.Rules[0].RecordTypes = []string{recordTypeTXT}
+		Expect(check(subExampleDomain, config.EndpointHTTPReq, jwtClaims())).To(BeFalse())
+	})
+
+	It("should deny access to tokens signed with other keys", func() {
+		_, other, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		token := signJWT(other, jwtClaims())
+		Expect(middleware.CheckJWT(subExampleDomain, recordTypeA, config.EndpointHTTPReq, token, []config.JWTIssuer{issuer})).
+			To(BeFalse())
+	})
+
+	It("should deny access to other credentials", func() {
+		Expect(middleware.CheckJWT(subExampleDomain, recordTypeA, config.EndpointHTTPReq, password, []config.JWTIssuer{issuer})).
+			To(BeFalse())
+	})
+})
+
+var _ = Describe("CheckPermission with JWTs", func() {
+	var (
+		priv ed25519.PrivateKey
+		cfg  *config.Config
+	)
+
+	BeforeEach(func() {
+		var err error
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		issuer := newJWTIssuer(priv)
+		issuer.Rules[0].Takeover = true
+		cfg = &config.Config{
+			Auth: config.Auth{
+				Method: config.AuthMethodJWT,
+				Users: []config.User{{
+					Username: username,
+					Password: password,
+					Domains:  []string{exampleDomain},
+				}},
+				JWTIssuers: []config.JWTIssuer{issuer},
+			},
+		}
+	})
+
+	DescribeTable("should identify the subject", func(method string, reqData func(token string) *data.ReqData) {
+		cfg.Auth.Method = method
+		r := reqData(signJWT(priv, jwtClaims()))
+		r.FullName = subExampleDomain
+		r.Type = recordTypeA
+		Expect(middleware.CheckPermission(cfg, r, "")).To(BeTrue())
+		Expect(r.User).To(Equal(jwtSubject))
+		Expect(r.Takeover).To(BeTrue())
+	},
+		Entry("of a bearer token with auth method jwt", config.AuthMethodJWT, func(token string) *data.ReqData {
+			return &data.ReqData{Token: token}
+		}),
+		Entry("of a Basic auth password with auth method jwt", config.AuthMethodJWT, func(token string) *data.ReqData {
+			return &data.ReqData{Username: "gitlab", Password: token}
+		}),
+		Entry("of a bearer token with auth method users", config.AuthMethodUsers, func(token string) *data.ReqData {
+			return &data.ReqData{Token: token}
+		}),
+	)
+
+	It("should name tokens without subject", func() {
+		r := &data.ReqData{FullName: subExampleDomain, Type: recordTypeA, Token: signJWT(priv, jwtClaims(func(c map[string]any) {
+			delete(c, "sub")
+		}))}
+		Expect(middleware.CheckPermission(cfg, r, "")).To(BeTrue())
+		Expect(r.User).To(Equal("jwt"))
+	})
+
+	It("should ignore passwords with auth method jwt", func() {
+		r := &data.ReqData{FullName: exampleDomain, Type: recordTypeA, Username: username, Password: password}
+		Expect(middleware.CheckPermission(cfg, r, "")).To(BeFalse())
+	})
+
+	It("should list the domains of the matching rules", func() {
+		token := signJWT(priv, jwtClaims())
+		Expect(middleware.GetDomains(cfg, &data.ReqData{Token: token}, "")).To(Equal(map[string]struct{}{exampleDomain: {}}))
+		Expect(middleware.GetDomains(cfg, &data.ReqData{Username: username, Password: password}, "")).To(BeEmpty())
+	})
+})
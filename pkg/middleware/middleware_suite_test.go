@@ -14,7 +14,11 @@ const (
 	wildcardExample   = "*.example.com"
 	username          = "username"
 	password          = "password"
+	passwordArgon2id  = "$argon2id$v=19$m=19456,t=2,p=1$umvWs4MKOZ8qQPQ8brrcrw$0wnjoOMeEBcLs3AN5CrcUPGZtZxmf/5fWXaBUQHeTV0"
+	passwordBcrypt    = "$2a$10$n4e9bHsI2IX.aQxL3rrWHOcdw1Ynfee19OtLXaYQp5PI6jPX9J2CC"
 	invalidAuthMethod = "invalid"
+	recordTypeA       = "A"
+	recordTypeTXT     = "TXT"
 )
 
 func TestMiddleware(t *testing.T) {
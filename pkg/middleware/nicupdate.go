@@ -1,92 +1,76 @@
 package middleware
 
 import (
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strings"
 
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
 )
 
 const (
 	nicTokenGood    = "good"
+	nicTokenNoChg   = "nochg"
 	nicTokenNotFQDN = "notfqdn"
 	nicTokenBadAuth = "badauth"
 	nicTokenNoHost  = "nohost"
 	nicTokenDNSErr  = "dnserr"
 	nicTokenAbuse   = "abuse"
+	nicTokenNumHost = "numhost"
 	nicToken911     = "911"
 	textPlainUTF8   = "text/plain; charset=utf-8"
-)
-
-func BindNicUpdate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
-		if err := r.ParseForm(); err != nil {
-			log.Printf(failedParseRequestFmt, err)
-			writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
-			return
-		}
 
-		hostname := r.Form.Get("hostname")
-		if hostname == "" {
-			writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
-			return
-		}
-
-		ip := r.Form.Get("myip")
-		if ip == "" {
-			ip = r.RemoteAddr
-		}
+	// maxNicHostnames is the maximum number of hostnames in a single update
+	// request as defined by the DynDNS2 spec
+	maxNicHostnames = 20
+)
 
-		parsedIP := net.ParseIP(ip)
-		if parsedIP == nil {
-			writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
-			return
-		}
+// nicRequest is a DynDNS2 update request. Every hostname is updated with
+// every ip, so a hostname can get an A and an AAAA record at once. If offline
+// is set, ips only select the address families to take offline.
+type nicRequest struct {
+	hostnames []string
+	ips       []string
+	offline   bool
+	username  string
+	password  string
+	cert      *x509.Certificate
+}
 
-		recordType := recordTypeA
-		if parsedIP.To4() == nil {
-			recordType = recordTypeAAAA
-		}
+// nicHost holds the updates of a single hostname of a nicRequest. token is
+// set if the hostname is answered without updating it.
+type nicHost struct {
+	reqData []*data.ReqData
+	token   string
+}
 
-		name, zone, err := SplitFQDN(hostname)
-		if err != nil {
-			writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
-			return
-		}
+// NewNicUpdate handles DynDNS2 update requests with one or more hostnames.
+// Each hostname is authorized on its own and updated by passing it through
+// resolveZone and updater, the response contains one token line per hostname
+// in request order. Invalid credentials are answered with a single badauth
+// token. Hosts going offline without a parking address are passed through
+// deleter instead.
+func NewNicUpdate(
+	cfg *config.Config, lockout *ratelimit.Lockout, resolveZone, updater, deleter func(http.Handler) http.Handler,
+) func(http.Handler) http.Handler {
+	update := resolveZone(updater(StatusOk(nil)))
+	remove := resolveZone(deleter(StatusOk(nil)))
 
-		username, password, _ := r.BasicAuth()
-		next.ServeHTTP(
-			w, r.WithContext(
-				data.NewContextWithReqData(
-					r.Context(),
-					&data.ReqData{
-						FullName:  hostname,
-						Name:      name,
-						Zone:      zone,
-						Value:     ip,
-						Type:      recordType,
-						Username:  username,
-						Password:  password,
-						BasicAuth: true,
-					},
-				),
-			),
-		)
-	})
-}
-
-func NicAuth(cfg *config.Config, lockout *ratelimit.Lockout) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
+	return func(_ http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			reqData, err := data.ReqDataFromContext(r.Context())
-			if err != nil {
-				log.Printf("%v", err)
-				writeNicToken(w, http.StatusOK, nicToken911)
+			req, ok := parseNicRequest(w, r)
+			if !ok {
+				writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
+				return
+			}
+			if len(req.hostnames) > maxNicHostnames {
+				writeNicToken(w, http.StatusOK, nicTokenNumHost)
 				return
 			}
 
@@ -96,28 +80,210 @@ func NicAuth(cfg *config.Config, lockout *ratelimit.Lockout) func(http.Handler)
 				return
 			}
 
-			if CheckPermission(cfg, reqData, r.RemoteAddr) {
+			hosts := newNicHosts(cfg, req)
+			if !authorizeNicHosts(cfg, hosts, r.RemoteAddr) {
+				lockout.RecordFailure(r.RemoteAddr)
+				metrics.AuthFailure(cfg.Auth.Method)
+				if isBadAuth(cfg, req) {
+					w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+					writeNicToken(w, http.StatusUnauthorized, nicTokenBadAuth)
+					return
+				}
+			} else {
 				lockout.Reset(r.RemoteAddr)
-				next.ServeHTTP(w, r)
-				return
 			}
 
-			logPermissionDenied(r.RemoteAddr, reqData)
-			lockout.RecordFailure(r.RemoteAddr)
-			if isBadAuth(cfg, reqData) {
-				w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-				writeNicToken(w, http.StatusUnauthorized, nicTokenBadAuth)
-				return
+			tokens := make([]string, 0, len(hosts))
+			for _, host := range hosts {
+				if host.token == "" {
+					host.token = updateNicHost(update, remove, r, host.reqData)
+				}
+				tokens = append(tokens, host.token)
 			}
-			writeNicToken(w, http.StatusOK, nicTokenNoHost)
+			writeNicToken(w, http.StatusOK, strings.Join(tokens, "\n"))
 		})
 	}
 }
 
-func isBadAuth(cfg *config.Config, reqData *data.ReqData) bool {
+// parseNicRequest parses the comma-separated hostname, myip and myipv6
+// parameters and the offline flag. At most one ip per address family is
+// accepted, if none is given the client ip is used unless going offline.
+func parseNicRequest(w http.ResponseWriter, r *http.Request) (*nicRequest, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	if err := r.ParseForm(); err != nil {
+		log.Printf(failedParseRequestFmt, err)
+		return nil, false
+	}
+
+	req := &nicRequest{
+		hostnames: splitList(r.Form.Get("hostname")),
+		ips:       append(splitList(r.Form.Get("myip")), splitList(r.Form.Get("myipv6"))...),
+		offline:   strings.EqualFold(r.Form.Get("offline"), "yes"),
+	}
+	if len(req.hostnames) == 0 {
+		return nil, false
+	}
+	if len(req.ips) == 0 && !req.offline {
+		req.ips = []string{r.RemoteAddr}
+	}
+
+	var hasIPv4, hasIPv6 bool
+	for _, ip := range req.ips {
+		parsedIP := net.ParseIP(ip)
+		if parsedIP == nil {
+			return nil, false
+		}
+		isIPv4 := parsedIP.To4() != nil
+		if (isIPv4 && hasIPv4) || (!isIPv4 && hasIPv6) {
+			return nil, false
+		}
+		hasIPv4 = hasIPv4 || isIPv4
+		hasIPv6 = hasIPv6 || !isIPv4
+	}
+
+	req.username, req.password, _ = r.BasicAuth()
+	req.cert = ClientCert(r)
+	return req, true
+}
+
+// newNicHosts creates the updates of each hostname of req. Hostnames that
+// are not fully qualified are answered with notfqdn.
+func newNicHosts(cfg *config.Config, req *nicRequest) []*nicHost {
+	values := nicValues(cfg, req)
+	hosts := make([]*nicHost, 0, len(req.hostnames))
+	for _, hostname := range req.hostnames {
+		name, zone, err := SplitFQDN(hostname)
+		if err != nil {
+			hosts = append(hosts, &nicHost{token: nicTokenNotFQDN})
+			continue
+		}
+
+		host := &nicHost{}
+		for _, v := range values {
+			host.reqData = append(host.reqData, &data.ReqData{
+				FullName:   hostname,
+				Name:       name,
+				Zone:       zone,
+				Value:      v.value,
+				Type:       v.recordType,
+				Username:   req.username,
+				Password:   req.password,
+				BasicAuth:  true,
+				ClientCert: req.cert,
+				Endpoint:   config.EndpointNic,
+			})
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+type nicValue struct {
+	recordType string
+	value      string
+}
+
+// nicValues returns the record type and value to set for each ip of req. When
+// going offline the value is the parking address of the address family or
+// empty if the rrset is to be deleted. Without ips both families go offline.
+func nicValues(cfg *config.Config, req *nicRequest) []nicValue {
+	if !req.offline {
+		values := make([]nicValue, 0, len(req.ips))
+		for _, ip := range req.ips {
+			values = append(values, nicValue{recordType: ipRecordType(ip), value: ip})
+		}
+		return values
+	}
+
+	parking := map[string]string{
+		recordTypeA:    cfg.DynDNS.OfflineIPv4,
+		recordTypeAAAA: cfg.DynDNS.OfflineIPv6,
+	}
+	recordTypes := []string{recordTypeA, recordTypeAAAA}
+	if len(req.ips) > 0 {
+		recordTypes = recordTypes[:0]
+		for _, ip := range req.ips {
+			recordTypes = append(recordTypes, ipRecordType(ip))
+		}
+	}
+
+	values := make([]nicValue, 0, len(recordTypes))
+	for _, recordType := range recordTypes {
+		values = append(values, nicValue{recordType: recordType, value: parking[recordType]})
+	}
+	return values
+}
+
+func ipRecordType(ip string) string {
+	if net.ParseIP(ip).To4() == nil {
+		return recordTypeAAAA
+	}
+	return recordTypeA
+}
+
+// authorizeNicHosts answers hosts the client is not allowed to update with
+// nohost. It returns false if any host was denied.
+func authorizeNicHosts(cfg *config.Config, hosts []*nicHost, remoteAddr string) bool {
+	allowed := true
+	for _, host := range hosts {
+		for _, reqData := range host.reqData {
+			if !CheckPermission(cfg, reqData, remoteAddr) {
+				logPermissionDenied(remoteAddr, reqData)
+				host.token = nicTokenNoHost
+				allowed = false
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+// updateNicHost passes each of reqData through update, or through remove if
+// it has no value, and returns the token answering the hostname. Deleted
+// rrsets are not listed in the token.
+func updateNicHost(update, remove http.Handler, r *http.Request, reqData []*data.ReqData) string {
+	changed := false
+	values := make([]string, 0, len(reqData))
+	for _, d := range reqData {
+		next := update
+		if d.Value == "" {
+			next = remove
+		}
+		rec := &nicStatusRecorder{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(data.NewContextWithReqData(r.Context(), d)))
+		switch rec.status {
+		case http.StatusOK:
+		case http.StatusForbidden:
+			return nicTokenNoHost
+		case http.StatusServiceUnavailable:
+			return nicToken911
+		default:
+			return nicTokenDNSErr
+		}
+		changed = changed || !d.Unchanged
+		if d.Value != "" {
+			values = append(values, d.Value)
+		}
+	}
+
+	token := nicTokenNoChg
+	if changed {
+		token = nicTokenGood
+	}
+	if len(values) == 0 {
+		return token
+	}
+	return token + " " + strings.Join(values, ",")
+}
+
+func isBadAuth(cfg *config.Config, req *nicRequest) bool {
+	if certIdentifiesUser(req.cert, cfg.Auth.Users) || apiTokenIsValid(cfg.Auth.APITokens, req.password, config.EndpointNic) ||
+		jwtIsValid(cfg.Auth.JWTIssuers, req.password, config.EndpointNic) {
+		return false
+	}
 	switch cfg.Auth.Method {
 	case config.AuthMethodUsers, config.AuthMethodBoth, config.AuthMethodAny:
-		return !checkUserCredentials(reqData.Username, reqData.Password, cfg.Auth.Users)
+		return !checkUserCredentials(req.username, req.password, cfg.Auth.Users)
 	}
 	return false
 }
@@ -128,35 +294,20 @@ func checkUserCredentials(username, password string, users []config.User) bool {
 	}
 	matched := 0
 	for _, user := range users {
-		matched |= constantTimeEqual(user.Username, username) & constantTimeEqual(user.Password, password)
+		matched |= credentialsMatch(&user, username, password)
 	}
 	return matched == 1
 }
 
-func NicUpdate(updater func(http.Handler) http.Handler) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		inner := updater(next)
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			inner.ServeHTTP(&nicErrorWriter{
-				ResponseWriter: w,
-				mapStatus: func(int) (int, string) {
-					return http.StatusOK, nicTokenDNSErr
-				},
-			}, r)
-		})
-	}
-}
-
-func StatusOkNicUpdate(_ http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		reqData, err := data.ReqDataFromContext(r.Context())
-		if err != nil {
-			log.Printf("%v", err)
-			writeNicToken(w, http.StatusOK, nicTokenDNSErr)
-			return
+// splitList splits a comma-separated list and drops empty elements.
+func splitList(s string) []string {
+	var elems []string
+	for elem := range strings.SplitSeq(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
 		}
-		writeNicToken(w, http.StatusOK, nicTokenGood+" "+reqData.Value)
-	})
+	}
+	return elems
 }
 
 func writeNicToken(w http.ResponseWriter, status int, token string) {
@@ -167,32 +318,28 @@ func writeNicToken(w http.ResponseWriter, status int, token string) {
 	}
 }
 
-type nicErrorWriter struct {
-	http.ResponseWriter
-	mapStatus func(code int) (int, string)
-	handled   bool
+// nicStatusRecorder records the status written by the updaters of a
+// hostname and discards everything else, the response is written once all
+// hostnames are done.
+type nicStatusRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
 }
 
-func (w *nicErrorWriter) WriteHeader(code int) {
-	if w.handled {
-		return
-	}
-	if code == http.StatusOK {
-		w.ResponseWriter.WriteHeader(code)
+func (w *nicStatusRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *nicStatusRecorder) WriteHeader(code int) {
+	if w.wroteHeader {
 		return
 	}
-	w.handled = true
-	status, token := w.mapStatus(code)
-	w.ResponseWriter.Header().Set(headerContentType, textPlainUTF8)
-	w.ResponseWriter.WriteHeader(status)
-	if _, err := fmt.Fprint(w.ResponseWriter, token); err != nil {
-		log.Printf(failedWriteResponseFmt, err)
-	}
+	w.wroteHeader = true
+	w.status = code
 }
 
-func (w *nicErrorWriter) Write(b []byte) (int, error) {
-	if w.handled {
-		return len(b), nil
-	}
-	return w.ResponseWriter.Write(b)
+func (w *nicStatusRecorder) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(b), nil
 }
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+// LowPriority marks the requests to the Hetzner API made while handling a
+// request as low priority. They are rejected first if the budget of the API
+// token runs low.
+func LowPriority(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(ratelimit.NewContextWithPriority(r.Context(), ratelimit.PriorityLow)))
+	})
+}
+
+// LowPriorityAddresses works like LowPriority, but only for requests updating
+// A or AAAA records, so DynDNS updates are rejected before ACME challenges
+// sent to the same endpoint. It must be used after the request is bound.
+func LowPriorityAddresses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqData, err := data.ReqDataFromContext(r.Context())
+		if err != nil {
+			log.Printf("%v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if reqData.Type == recordTypeA || reqData.Type == recordTypeAAAA {
+			LowPriority(next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
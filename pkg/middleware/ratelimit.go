@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
 )
@@ -15,6 +16,7 @@ func NewRateLimit(limiter *ratelimit.Limiter, onExceeded http.HandlerFunc) func(
 				addr := sanitize.LogValue(r.RemoteAddr)
 				//nolint:gosec // value is sanitized above
 				log.Printf("rate limit exceeded for %s", addr)
+				metrics.RateLimited()
 				onExceeded(w, r)
 				return
 			}
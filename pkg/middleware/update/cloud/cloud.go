@@ -2,102 +2,312 @@ package cloud
 
 import (
 	"context"
-	"sync"
+	"fmt"
+	"slices"
 
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/lock"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
 )
 
 type updater struct {
-	cfg    *config.Config
-	client *hcloud.Client
-	m      *sync.Mutex
+	cfg      *config.Config
+	projects *hetzner.Projects
+	locks    *lock.Keyed
 }
 
-func New(cfg *config.Config, m *sync.Mutex) *updater {
+func New(cfg *config.Config, projects *hetzner.Projects, locks *lock.Keyed) *updater {
 	return &updater{
-		cfg:    cfg,
-		client: hetzner.NewHCloudClient(cfg),
-		m:      m,
+		cfg:      cfg,
+		projects: projects,
+		locks:    locks,
 	}
 }
 
 func (u *updater) Update(ctx context.Context, reqData *data.ReqData) error {
-	// Ensure only one simultaneous update sequence
-	u.m.Lock()
-	defer u.m.Unlock()
+	return u.Set(ctx, reqData, []string{reqData.Value})
+}
+
+// Set replaces the records of the rrset described by reqData with values.
+func (u *updater) Set(ctx context.Context, reqData *data.ReqData, values []string) error {
+	// Ensure only one simultaneous update sequence per rrset
+	l := u.locks.RRSet(reqData.Zone, reqData.Name, reqData.Type)
+	metrics.Lock(l)
+	defer l.Unlock()
+
+	client, err := u.projects.Client(reqData.Zone)
+	if err != nil {
+		return err
+	}
+	zone, rrSetType, rrSet, err := getRRSet(ctx, client, reqData)
+	if err != nil {
+		return err
+	}
+	if rrSet == nil {
+		return createRRSet(ctx, client, zone, rrSetType, reqData.Name, values, u.ttl(reqData), u.labels(reqData, false))
+	}
+	if err := u.claim(ctx, client, reqData, rrSet); err != nil {
+		return err
+	}
+
+	return u.setRRSet(ctx, client, reqData, rrSet, values)
+}
+
+// Append adds the value of reqData to the records of its rrset while keeping
+// the records already present, so concurrent ACME challenges for the same
+// name do not overwrite each other. The rrset is created if it does not exist
+// yet, labeled as managed by the proxy if challenge expiry is enabled.
+func (u *updater) Append(ctx context.Context, reqData *data.ReqData) error {
+	return u.AppendLatest(ctx, reqData, 0)
+}
+
+// AppendLatest works like Append, but keeps only the newest limit records of
+// the rrset if limit is positive. Records are assumed to be kept in the order
+// they were added in, the value of reqData becomes the newest.
+func (u *updater) AppendLatest(ctx context.Context, reqData *data.ReqData, limit int) error {
+	// Ensure only one simultaneous update sequence per rrset
+	l := u.locks.RRSet(reqData.Zone, reqData.Name, reqData.Type)
+	metrics.Lock(l)
+	defer l.Unlock()
+
+	client, err := u.projects.Client(reqData.Zone)
+	if err != nil {
+		return err
+	}
+	zone, rrSetType, rrSet, err := getRRSet(ctx, client, reqData)
+	if err != nil {
+		return err
+	}
+	if rrSet == nil {
+		labels := u.labels(reqData, u.cfg.ChallengeExpiry.Enabled)
+		return createRRSet(ctx, client, zone, rrSetType, reqData.Name, []string{reqData.Value}, u.ttl(reqData), labels)
+	}
+	if err := u.claim(ctx, client, reqData, rrSet); err != nil {
+		return err
+	}
+
+	values := slices.DeleteFunc(hetzner.Values(rrSet), func(v string) bool { return v == reqData.Value })
+	values = append(values, reqData.Value)
+	if limit > 0 && len(values) > limit {
+		values = values[len(values)-limit:]
+	}
+	return u.setRRSet(ctx, client, reqData, rrSet, values)
+}
+
+// Add adds the value of reqData to the records of its rrset. The rrset is
+// created if it does not exist yet. The TTL of the rrset is set as well,
+// reqData.TTL is set to it.
+func (u *updater) Add(ctx context.Context, reqData *data.ReqData) error {
+	// Ensure only one simultaneous update sequence per rrset
+	l := u.locks.RRSet(reqData.Zone, reqData.Name, reqData.Type)
+	metrics.Lock(l)
+	defer l.Unlock()
+
+	reqData.TTL = u.ttl(reqData)
+
+	client, err := u.projects.Client(reqData.Zone)
+	if err != nil {
+		return err
+	}
+
+	// The rrset has to be looked up to check its labels in ownership mode,
+	// otherwise adding records creates it on demand
+	if u.cfg.Ownership.Enabled {
+		return u.addOwned(ctx, client, reqData)
+	}
 
 	rrSetType, err := hetzner.RRSetTypeFromString(reqData.Type)
 	if err != nil {
 		return err
 	}
+	return u.addRecords(ctx, client, reqData, &hcloud.ZoneRRSet{
+		Zone: &hcloud.Zone{Name: reqData.Zone},
+		Name: reqData.Name,
+		Type: rrSetType,
+	})
+}
 
-	zone, _, err := u.client.Zone.Get(ctx, reqData.Zone)
+// addOwned adds the value of reqData to the records of its rrset after
+// checking the labels of the rrset.
+func (u *updater) addOwned(ctx context.Context, client *hcloud.Client, reqData *data.ReqData) error {
+	zone, rrSetType, rrSet, err := getRRSet(ctx, client, reqData)
 	if err != nil {
 		return err
 	}
+	if rrSet == nil {
+		return createRRSet(ctx, client, zone, rrSetType, reqData.Name, []string{reqData.Value}, u.ttl(reqData), u.labels(reqData, false))
+	}
+	if err := u.claim(ctx, client, reqData, rrSet); err != nil {
+		return err
+	}
+	return u.addRecords(ctx, client, reqData, rrSet)
+}
 
-	rrSet, _, err := u.client.Zone.GetRRSetByNameAndType(ctx, zone, reqData.Name, rrSetType)
+// addRecords adds the value of reqData to the records of rrSet and sets its
+// TTL.
+func (u *updater) addRecords(ctx context.Context, client *hcloud.Client, reqData *data.ReqData, rrSet *hcloud.ZoneRRSet) error {
+	ttl := u.ttl(reqData)
+	opts := hcloud.ZoneRRSetAddRecordsOpts{
+		Records: hetzner.Records([]string{reqData.Value}, rrSet.Type),
+		TTL:     &ttl,
+	}
+	action, _, err := client.Zone.AddRRSetRecords(ctx, rrSet, opts)
 	if err != nil {
 		return err
 	}
+	if action != nil {
+		return client.Action.WaitFor(ctx, action)
+	}
 
-	if rrSet != nil {
-		return u.updateRRSet(ctx, rrSet, reqData.Value)
+	return nil
+}
+
+// claim checks that rrSet may be changed on behalf of reqData in ownership
+// mode. RRSets managed by hand may only be changed by users with takeover
+// permission, they are labeled as managed by the proxy before.
+func (u *updater) claim(ctx context.Context, client *hcloud.Client, reqData *data.ReqData, rrSet *hcloud.ZoneRRSet) error {
+	if !u.cfg.Ownership.Enabled || hetzner.IsManaged(rrSet) {
+		return nil
+	}
+	if !reqData.Takeover {
+		return fmt.Errorf("%w: %s %s", hetzner.ErrUnmanaged, reqData.Type, reqData.FullName)
 	}
 
-	return u.createRRSet(ctx, zone, rrSetType, reqData.Name, reqData.Value)
+	labels := hetzner.OwnedLabels(reqData.User)
+	for k, v := range rrSet.Labels {
+		if _, ok := labels[k]; !ok {
+			labels[k] = v
+		}
+	}
+	_, _, err := client.Zone.UpdateRRSet(ctx, rrSet, hcloud.ZoneRRSetUpdateOpts{Labels: labels})
+	return err
 }
 
-func (u *updater) updateRRSet(ctx context.Context, rrSet *hcloud.ZoneRRSet, val string) error {
-	if rrSet.TTL == nil || *rrSet.TTL != u.cfg.RecordTTL {
-		opts := hcloud.ZoneRRSetChangeTTLOpts{TTL: &u.cfg.RecordTTL}
-		action, _, err := u.client.Zone.ChangeRRSetTTL(ctx, rrSet, opts)
+func getRRSet(
+	ctx context.Context, client *hcloud.Client, reqData *data.ReqData,
+) (*hcloud.Zone, hcloud.ZoneRRSetType, *hcloud.ZoneRRSet, error) {
+	rrSetType, err := hetzner.RRSetTypeFromString(reqData.Type)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	zone, _, err := client.Zone.Get(ctx, reqData.Zone)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	rrSet, _, err := client.Zone.GetRRSetByNameAndType(ctx, zone, reqData.Name, rrSetType)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return zone, rrSetType, rrSet, nil
+}
+
+// setRRSet sets the records of the existing rrSet to values and its TTL to
+// the one requested by reqData. reqData is marked unchanged if rrSet already
+// matches.
+func (u *updater) setRRSet(
+	ctx context.Context, client *hcloud.Client, reqData *data.ReqData, rrSet *hcloud.ZoneRRSet, values []string,
+) error {
+	ttl := u.ttl(reqData)
+	if ttlEqual(rrSet, ttl) && valuesEqual(rrSet, values) {
+		reqData.Unchanged = true
+		return nil
+	}
+	return updateRRSet(ctx, client, rrSet, values, ttl)
+}
+
+// updateRRSet changes the TTL and the records of rrSet if they differ from
+// ttl and values.
+func updateRRSet(ctx context.Context, client *hcloud.Client, rrSet *hcloud.ZoneRRSet, values []string, ttl int) error {
+	if !ttlEqual(rrSet, ttl) {
+		opts := hcloud.ZoneRRSetChangeTTLOpts{TTL: &ttl}
+		action, _, err := client.Zone.ChangeRRSetTTL(ctx, rrSet, opts)
 		if err != nil {
 			return err
 		}
 		if action != nil {
-			if err := u.client.Action.WaitFor(ctx, action); err != nil {
+			if err := client.Action.WaitFor(ctx, action); err != nil {
 				return err
 			}
 		}
 	}
 
+	if valuesEqual(rrSet, values) {
+		return nil
+	}
+
 	opts := hcloud.ZoneRRSetSetRecordsOpts{
-		Records: []hcloud.ZoneRRSetRecord{{
-			Value: hetzner.QuoteIfRequired(val, rrSet.Type),
-		}},
+		Records: hetzner.Records(values, rrSet.Type),
 	}
-	action, _, err := u.client.Zone.SetRRSetRecords(ctx, rrSet, opts)
+	action, _, err := client.Zone.SetRRSetRecords(ctx, rrSet, opts)
 	if err != nil {
 		return err
 	}
 	if action != nil {
-		return u.client.Action.WaitFor(ctx, action)
+		return client.Action.WaitFor(ctx, action)
 	}
 
 	return nil
 }
 
-func (u *updater) createRRSet(ctx context.Context, zone *hcloud.Zone, rrSetType hcloud.ZoneRRSetType, name, val string) error {
+func createRRSet(
+	ctx context.Context, client *hcloud.Client, zone *hcloud.Zone, rrSetType hcloud.ZoneRRSetType, name string, values []string, ttl int,
+	labels map[string]string,
+) error {
 	opts := hcloud.ZoneRRSetCreateOpts{
-		Name: name,
-		Type: rrSetType,
-		TTL:  &u.cfg.RecordTTL,
-		Records: []hcloud.ZoneRRSetRecord{{
-			Value: hetzner.QuoteIfRequired(val, rrSetType),
-		}},
+		Name:    name,
+		Type:    rrSetType,
+		TTL:     &ttl,
+		Labels:  labels,
+		Records: hetzner.Records(values, rrSetType),
 	}
-	result, _, err := u.client.Zone.CreateRRSet(ctx, zone, opts)
+	result, _, err := client.Zone.CreateRRSet(ctx, zone, opts)
 	if err != nil {
 		return err
 	}
 	if result.Action != nil {
-		return u.client.Action.WaitFor(ctx, result.Action)
+		return client.Action.WaitFor(ctx, result.Action)
 	}
 
 	return nil
 }
+
+// labels returns the labels of a new rrset. In ownership mode it is labeled
+// with the user of reqData, otherwise only if managed is true.
+func (u *updater) labels(reqData *data.ReqData, managed bool) map[string]string {
+	if u.cfg.Ownership.Enabled {
+		return hetzner.OwnedLabels(reqData.User)
+	}
+	if managed {
+		return hetzner.ManagedLabels()
+	}
+	return nil
+}
+
+func ttlEqual(rrSet *hcloud.ZoneRRSet, ttl int) bool {
+	return rrSet.TTL != nil && *rrSet.TTL == ttl
+}
+
+// valuesEqual returns true if rrSet holds exactly values, regardless of
+// their order.
+func valuesEqual(rrSet *hcloud.ZoneRRSet, values []string) bool {
+	current := hetzner.Values(rrSet)
+	desired := slices.Clone(values)
+	slices.Sort(current)
+	slices.Sort(desired)
+	return slices.Equal(slices.Compact(current), slices.Compact(desired))
+}
+
+// ttl returns the TTL requested by the client or the configured record TTL.
+func (u *updater) ttl(reqData *data.ReqData) int {
+	if reqData.TTL > 0 {
+		return reqData.TTL
+	}
+	return u.cfg.RecordTTL
+}
@@ -2,19 +2,41 @@ package update
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/lock"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware/update/cloud"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
 )
 
-func New(cfg *config.Config, m *sync.Mutex) func(http.Handler) http.Handler {
-	u := cloud.New(cfg, m)
+// New replaces the records of the rrset of the request with its value.
+func New(cfg *config.Config, projects *hetzner.Projects, locks *lock.Keyed) func(http.Handler) http.Handler {
+	return newUpdater(cfg, "update", cloud.New(cfg, projects, locks).Update)
+}
+
+// NewAppend adds the value of the request to the records of its rrset.
+func NewAppend(cfg *config.Config, projects *hetzner.Projects, locks *lock.Keyed) func(http.Handler) http.Handler {
+	return newUpdater(cfg, "append", cloud.New(cfg, projects, locks).Append)
+}
+
+// NewAppendLatest works like NewAppend, but keeps only the newest limit
+// records of the rrset.
+func NewAppendLatest(cfg *config.Config, projects *hetzner.Projects, locks *lock.Keyed, limit int) func(http.Handler) http.Handler {
+	u := cloud.New(cfg, projects, locks)
+	return newUpdater(cfg, "append", func(ctx context.Context, reqData *data.ReqData) error {
+		return u.AppendLatest(ctx, reqData, limit)
+	})
+}
 
+func newUpdater(
+	cfg *config.Config, action string, update func(ctx context.Context, reqData *data.ReqData) error,
+) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			reqData, err := data.ReqDataFromContext(r.Context())
@@ -24,12 +46,12 @@ func New(cfg *config.Config, m *sync.Mutex) func(http.Handler) http.Handler {
 				return
 			}
 
-			log.Printf("received request to update '%s' data of '%s' to '%s'", reqData.Type, reqData.FullName, reqData.Value)
+			log.Printf("received request to %s '%s' data of '%s' to '%s'", action, reqData.Type, reqData.FullName, reqData.Value)
 			ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfg.Timeout)*time.Second)
 			defer cancel()
-			if err := u.Update(ctx, reqData); err != nil {
-				log.Printf("failed to update record: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
+			if err := update(ctx, reqData); err != nil {
+				log.Printf("failed to %s record: %v", action, err)
+				w.WriteHeader(errorStatus(err))
 				return
 			}
 
@@ -37,3 +59,17 @@ func New(cfg *config.Config, m *sync.Mutex) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// errorStatus returns the status code of a failed request. RRSets not managed
+// by the proxy are forbidden to change, requests rejected to preserve the API
+// budget may be retried later.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, hetzner.ErrUnmanaged):
+		return http.StatusForbidden
+	case errors.Is(err, ratelimit.ErrReserved):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// relativeOrigin is the origin of a second parse of a value, which reveals
+// the targets given relative to the origin.
+const relativeOrigin = "relative.invalid."
+
+// CanonicalValue validates value as data of a record of type recordType and
+// returns it in the presentation format stored in rrsets. Targets are made
+// fully qualified and quoted fields like the CAA value are always quoted.
+// Single-label targets without trailing dot are rejected, as they would
+// become top-level names. TXT values are plain text and returned as is.
+func CanonicalValue(value, recordType string) (string, error) {
+	switch recordType {
+	case recordTypeA, recordTypeAAAA:
+		parsedIP := net.ParseIP(value)
+		if parsedIP == nil {
+			return "", errors.New("invalid ip address")
+		}
+		if recordType == recordTypeA && parsedIP.To4() == nil {
+			return "", errors.New("invalid ipv4 address")
+		}
+		if recordType == recordTypeAAAA && parsedIP.To4() != nil {
+			return "", errors.New("invalid ipv6 address")
+		}
+		return value, nil
+	case recordTypeTXT:
+		return value, nil
+	}
+
+	if strings.TrimSpace(value) == "" {
+		return "", fmt.Errorf("%s value is empty", recordType)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return "", fmt.Errorf("%s value must be a single line", recordType)
+	}
+
+	rr, err := dns.NewRR(". 0 IN " + recordType + " " + value)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s value: %s", recordType, parseErrorMessage(err))
+	}
+	if rr == nil || dns.TypeToString[rr.Header().Rrtype] != recordType {
+		return "", fmt.Errorf("invalid %s value", recordType)
+	}
+	if err := checkRelativeTargets(rr, value, recordType); err != nil {
+		return "", err
+	}
+	if tlsa, ok := rr.(*dns.TLSA); ok {
+		tlsa.Certificate = strings.ToLower(tlsa.Certificate)
+	}
+
+	return strings.TrimPrefix(rr.String(), rr.Header().String()), nil
+}
+
+// checkRelativeTargets returns an error if a target of rr, parsed from value,
+// is a single label without trailing dot.
+func checkRelativeTargets(rr dns.RR, value, recordType string) error {
+	rel, err := dns.NewRR("$ORIGIN " + relativeOrigin + "\n. 0 IN " + recordType + " " + value)
+	if err != nil || rel == nil {
+		return fmt.Errorf("invalid %s value", recordType)
+	}
+	relTargets := targets(rel)
+	for i, target := range targets(rr) {
+		if target != relTargets[i] && dns.CountLabel(target) == 1 {
+			return fmt.Errorf("invalid %s value: single-label target %s must end with a dot", recordType, strings.TrimSuffix(target, "."))
+		}
+	}
+	return nil
+}
+
+// targets returns the domain names rr points to.
+func targets(rr dns.RR) []string {
+	switch rr := rr.(type) {
+	case *dns.CNAME:
+		return []string{rr.Target}
+	case *dns.MX:
+		return []string{rr.Mx}
+	case *dns.SRV:
+		return []string{rr.Target}
+	case *dns.PTR:
+		return []string{rr.Ptr}
+	case *dns.SVCB:
+		return []string{rr.Target}
+	case *dns.HTTPS:
+		return []string{rr.Target}
+	}
+	return nil
+}
+
+// RRValue returns the data of rr in the format used by CanonicalValue.
+func RRValue(rr dns.RR) string {
+	if txt, ok := rr.(*dns.TXT); ok {
+		return strings.Join(txt.Txt, "")
+	}
+	if tlsa, ok := rr.(*dns.TLSA); ok {
+		tlsa.Certificate = strings.ToLower(tlsa.Certificate)
+	}
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// parseErrorMessage strips the prefix and position from errors of the zone
+// file parser, as the line is constructed by CanonicalValue.
+func parseErrorMessage(err error) string {
+	msg := strings.TrimPrefix(err.Error(), "dns: ")
+	msg, _, _ = strings.Cut(msg, " at line:")
+	return msg
+}
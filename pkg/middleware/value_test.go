@@ -0,0 +1,54 @@
+package middleware_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+var _ = Describe("CanonicalValue", func() {
+	DescribeTable(
+		"should return the canonical value", func(recordType, value, expected string) {
+			Expect(middleware.CanonicalValue(value, recordType)).To(Equal(expected))
+		},
+		Entry("A", "A", "127.0.0.1", "127.0.0.1"),
+		Entry("AAAA", "AAAA", "::1", "::1"),
+		Entry("TXT", "TXT", "some text; with \"quotes\"", "some text; with \"quotes\""),
+		Entry("CNAME with trailing dot", "CNAME", "target.example.com.", "target.example.com."),
+		Entry("CNAME without trailing dot", "CNAME", "target.example.com", "target.example.com."),
+		Entry("PTR", "PTR", "host.example.com", "host.example.com."),
+		Entry("MX", "MX", "10   mail.example.com", "10 mail.example.com."),
+		Entry("SRV", "SRV", "10 5 5060 sip.example.com", "10 5 5060 sip.example.com."),
+		Entry("CAA with unquoted value", "CAA", "0 issue letsencrypt.org", `0 issue "letsencrypt.org"`),
+		Entry("CAA with quoted value", "CAA", `128 iodef "mailto:security@example.com"`, `128 iodef "mailto:security@example.com"`),
+		Entry("CAA with parameters", "CAA", `0 issue "letsencrypt.org; validationmethods=dns-01"`,
+			`0 issue "letsencrypt.org; validationmethods=dns-01"`),
+		Entry("TLSA", "TLSA", "3 1 1 ABCDEF0123", "3 1 1 abcdef0123"),
+		Entry("HTTPS", "HTTPS", "1 . alpn=h2,h3", `1 . alpn="h2,h3"`),
+		Entry("SVCB", "SVCB", "0 svc.example.com", "0 svc.example.com."),
+		Entry("MX with single-label target with trailing dot", "MX", "10 mail.", "10 mail."),
+		Entry("MX with null target", "MX", "0 .", "0 ."),
+	)
+
+	DescribeTable(
+		"should fail", func(recordType, value, expectedError string) {
+			_, err := middleware.CanonicalValue(value, recordType)
+			Expect(err).To(MatchError(expectedError))
+		},
+		Entry("A with invalid IP", "A", "invalid", "invalid ip address"),
+		Entry("A with IPv6", "A", "::1", "invalid ipv4 address"),
+		Entry("AAAA with IPv4", "AAAA", "127.0.0.1", "invalid ipv6 address"),
+		Entry("empty CNAME", "CNAME", " ", "CNAME value is empty"),
+		Entry("CNAME with multiple targets", "CNAME", "a.example.com b.example.com",
+			`invalid CNAME value: garbage after rdata: "b.example.com"`),
+		Entry("MX without priority", "MX", "mail.example.com", `invalid MX value: bad MX Pref: "mail.example.com"`),
+		Entry("SRV with invalid port", "SRV", "10 5 99999 sip.example.com", `invalid SRV value: bad SRV Port: "99999"`),
+		Entry("CAA without value", "CAA", "0 issue", `invalid CAA value: bad CAA Value: "issue"`),
+		Entry("CNAME with single-label target", "CNAME", "www", "invalid CNAME value: single-label target www must end with a dot"),
+		Entry("MX with single-label target", "MX", "10 mail", "invalid MX value: single-label target mail must end with a dot"),
+		Entry("SRV with single-label target", "SRV", "10 5 5060 host",
+			"invalid SRV value: single-label target host must end with a dot"),
+		Entry("value with multiple lines", "MX", "10 mail.example.com\n20 mail2.example.com", "MX value must be a single line"),
+	)
+})
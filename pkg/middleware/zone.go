@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+)
+
+// NewResolveZone replaces the zone and name determined by SplitFQDN with the
+// longest matching zone known to the API token. If no zone matches, the
+// publicsuffix based split is kept. A nil cache disables zone resolution.
+func NewResolveZone(zones *hetzner.ZoneCache) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if zones == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqData, err := data.ReqDataFromContext(r.Context())
+			if err != nil {
+				log.Printf("%v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if name, zone, ok := zones.Split(r.Context(), reqData.FullName); ok {
+				reqData.Name = name
+				reqData.Zone = zone
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const (
+	cacheTTL = 30 * time.Second
+	// cacheMaxEntries caps memory use when many unique credentials are
+	// seen. When full, expired entries are swept; if that fails to free
+	// space, the cache is cleared.
+	cacheMaxEntries = 1 << 10
+)
+
+var defaultCache = newCache()
+
+type cacheEntry struct {
+	ok      bool
+	expires time.Time
+}
+
+// cache remembers the results of slow hash verifications, so repeated
+// requests with the same credentials do not cost a hash computation each.
+// Entries are keyed by an HMAC with a random key, so neither passwords nor
+// unsalted digests of them are kept in memory.
+type cache struct {
+	mu      sync.Mutex
+	key     []byte
+	entries map[[sha256.Size]byte]cacheEntry
+	now     func() time.Time
+}
+
+func newCache() *cache {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &cache{
+		key:     key,
+		entries: make(map[[sha256.Size]byte]cacheEntry),
+		now:     time.Now,
+	}
+}
+
+func (c *cache) verify(stored, password string, verify func(stored, password string) bool) bool {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(stored))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	var k [sha256.Size]byte
+	copy(k[:], mac.Sum(nil))
+
+	c.mu.Lock()
+	e, found := c.entries[k]
+	c.mu.Unlock()
+	if found && c.now().Before(e.expires) {
+		return e.ok
+	}
+
+	ok := verify(stored, password)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if len(c.entries) >= cacheMaxEntries {
+		for key, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= cacheMaxEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[k] = cacheEntry{ok: ok, expires: now.Add(cacheTTL)}
+
+	return ok
+}
@@ -0,0 +1,155 @@
+// Package password hashes and verifies the passwords of users. Passwords are
+// either stored in plaintext or as bcrypt or argon2id hash, the format is
+// detected by prefix.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	prefixArgon2id = "$argon2id$"
+	argon2idParts  = 6
+
+	// Parameters of new argon2id hashes as recommended by OWASP
+	argon2idMemory  = 19 * 1024
+	argon2idTime    = 2
+	argon2idThreads = 1
+	argon2idKeyLen  = 32
+	argon2idSaltLen = 16
+)
+
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// IsHash returns true if stored has the prefix of a supported hash format.
+func IsHash(stored string) bool {
+	return isArgon2id(stored) || isBcrypt(stored)
+}
+
+// Validate returns an error if stored has the prefix of a supported hash
+// format but cannot be parsed.
+func Validate(stored string) error {
+	switch {
+	case isArgon2id(stored):
+		_, err := parseArgon2id(stored)
+		return err
+	case isBcrypt(stored):
+		if _, err := bcrypt.Cost([]byte(stored)); err != nil {
+			return fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+	}
+	return nil
+}
+
+// Hash returns an argon2id hash of password in PHC string format.
+func Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argon2idTime, argon2idMemory, argon2idThreads, argon2idKeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		prefixArgon2id, argon2.Version, argon2idMemory, argon2idTime, argon2idThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// HashBcrypt returns a bcrypt hash of password.
+func HashBcrypt(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify returns true if password matches stored, which is either a hash
+// in a supported format or a plaintext password. Results of verifying
+// hashes are cached briefly.
+func Verify(stored, password string) bool {
+	if !IsHash(stored) {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+	return defaultCache.verify(stored, password, verifyHash)
+}
+
+func verifyHash(stored, password string) bool {
+	if isBcrypt(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+
+	h, err := parseArgon2id(stored)
+	if err != nil {
+		return false
+	}
+	//nolint:gosec // the key length was checked when parsing
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+func isArgon2id(stored string) bool {
+	return strings.HasPrefix(stored, prefixArgon2id)
+}
+
+func isBcrypt(stored string) bool {
+	for _, prefix := range bcryptPrefixes {
+		if strings.HasPrefix(stored, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseArgon2id parses a hash in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>.
+func parseArgon2id(stored string) (*argon2idHash, error) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != argon2idParts {
+		return nil, errors.New("invalid argon2id hash: unexpected number of fields")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash: invalid version: %w", err)
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("invalid argon2id hash: unsupported version %d", version)
+	}
+
+	h := &argon2idHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash: invalid parameters: %w", err)
+	}
+	if h.memory == 0 || h.time == 0 || h.threads == 0 {
+		return nil, errors.New("invalid argon2id hash: parameters must be > 0")
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash: invalid salt: %w", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash: invalid key: %w", err)
+	}
+	if len(h.key) == 0 {
+		return nil, errors.New("invalid argon2id hash: key is empty")
+	}
+
+	return h, nil
+}
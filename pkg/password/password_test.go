@@ -0,0 +1,94 @@
+package password_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/password"
+)
+
+const (
+	pass     = "password"
+	argon2id = "$argon2id$v=19$m=19456,t=2,p=1$umvWs4MKOZ8qQPQ8brrcrw$0wnjoOMeEBcLs3AN5CrcUPGZtZxmf/5fWXaBUQHeTV0"
+	bcrypt2a = "$2a$10$n4e9bHsI2IX.aQxL3rrWHOcdw1Ynfee19OtLXaYQp5PI6jPX9J2CC"
+)
+
+var _ = Describe("Password", func() {
+	DescribeTable("IsHash", func(stored string, expected bool) {
+		Expect(password.IsHash(stored)).To(Equal(expected))
+	},
+		Entry("argon2id", argon2id, true),
+		Entry("bcrypt", bcrypt2a, true),
+		Entry("plaintext", pass, false),
+		Entry("plaintext starting with $", "$password", false),
+	)
+
+	It("should create argon2id hashes that verify", func() {
+		hash, err := password.Hash(pass)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hash).To(HavePrefix("$argon2id$v=19$m=19456,t=2,p=1$"))
+		Expect(password.Validate(hash)).To(Succeed())
+		Expect(password.Verify(hash, pass)).To(BeTrue())
+		Expect(password.Verify(hash, "something")).To(BeFalse())
+	})
+
+	It("should create bcrypt hashes that verify", func() {
+		hash, err := password.HashBcrypt(pass)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hash).To(HavePrefix("$2a$"))
+		Expect(password.Validate(hash)).To(Succeed())
+		Expect(password.Verify(hash, pass)).To(BeTrue())
+		Expect(password.Verify(hash, "something")).To(BeFalse())
+	})
+
+	It("should use a random salt", func() {
+		hash1, err := password.Hash(pass)
+		Expect(err).ToNot(HaveOccurred())
+		hash2, err := password.Hash(pass)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hash1).ToNot(Equal(hash2))
+	})
+
+	DescribeTable("Verify", func(stored, input string, expected bool) {
+		// Verify twice to cover cached results
+		Expect(password.Verify(stored, input)).To(Equal(expected))
+		Expect(password.Verify(stored, input)).To(Equal(expected))
+	},
+		Entry("matching argon2id hash", argon2id, pass, true),
+		Entry("matching bcrypt hash", bcrypt2a, pass, true),
+		Entry("matching plaintext", pass, pass, true),
+		Entry("mismatching argon2id hash", argon2id, "something", false),
+		Entry("mismatching bcrypt hash", bcrypt2a, "something", false),
+		Entry("mismatching plaintext", pass, "something", false),
+		Entry("hash used as password", argon2id, argon2id, false),
+		Entry("invalid argon2id hash", "$argon2id$invalid", pass, false),
+	)
+
+	DescribeTable("Validate should succeed", func(stored string) {
+		Expect(password.Validate(stored)).To(Succeed())
+	},
+		Entry("argon2id", argon2id),
+		Entry("bcrypt", bcrypt2a),
+		Entry("plaintext", pass),
+	)
+
+	DescribeTable("Validate should fail", func(stored, errMsg string) {
+		Expect(password.Validate(stored)).To(MatchError(ContainSubstring(errMsg)))
+	},
+		Entry("argon2id with missing fields", "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA",
+			"invalid argon2id hash: unexpected number of fields"),
+		Entry("argon2id with unsupported version", "$argon2id$v=16$m=19456,t=2,p=1$c2FsdA$a2V5",
+			"invalid argon2id hash: unsupported version 16"),
+		Entry("argon2id with invalid parameters", "$argon2id$v=19$t=2$c2FsdA$a2V5",
+			"invalid argon2id hash: invalid parameters"),
+		Entry("argon2id with zero parameters", "$argon2id$v=19$m=19456,t=0,p=1$c2FsdA$a2V5",
+			"invalid argon2id hash: parameters must be > 0"),
+		Entry("argon2id with invalid salt", "$argon2id$v=19$m=19456,t=2,p=1$!!$a2V5",
+			"invalid argon2id hash: invalid salt"),
+		Entry("argon2id with invalid key", "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$!!",
+			"invalid argon2id hash: invalid key"),
+		Entry("argon2id with empty key", "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$",
+			"invalid argon2id hash: key is empty"),
+		Entry("truncated bcrypt", "$2a$10$short", "invalid bcrypt hash"),
+	)
+})
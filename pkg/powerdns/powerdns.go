@@ -0,0 +1,443 @@
+package powerdns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	headerContentType      = "Content-Type"
+	headerAPIKey           = "X-API-Key"
+	applicationJSON        = "application/json"
+	maxRequestBodySize     = 1 << 20 // 1 MB
+	failedWriteResponseFmt = "failed to write response: %v"
+	zonesPath              = "/api/v1/servers/localhost/zones/"
+	zoneKindNative         = "Native"
+	changeTypeReplace      = "REPLACE"
+	changeTypeDelete       = "DELETE"
+	apexName               = "@"
+)
+
+type Updater interface {
+	Set(ctx context.Context, reqData *data.ReqData, values []string) error
+}
+
+type Cleaner interface {
+	Delete(ctx context.Context, reqData *data.ReqData) error
+}
+
+type apiVersion struct {
+	URL     string `json:"url"`
+	Version int    `json:"version"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type zone struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Kind   string   `json:"kind"`
+	URL    string   `json:"url"`
+	Serial int      `json:"serial"`
+	RRSets []*rrSet `json:"rrsets,omitempty"`
+}
+
+type rrSet struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	TTL        int      `json:"ttl"`
+	ChangeType string   `json:"changetype,omitempty"`
+	Records    []record `json:"records"`
+}
+
+type record struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type patch struct {
+	RRSets []*rrSet `json:"rrsets"`
+}
+
+type change struct {
+	reqData *data.ReqData
+	values  []string
+	delete  bool
+}
+
+// API emulates the zone endpoints of the PowerDNS Authoritative HTTP API.
+// Clients authenticate with the token of a configured user as X-API-Key and
+// every rrset is authorized with CheckPermission.
+type API struct {
+	cfg      *config.Config
+	lockout  *ratelimit.Lockout
+	updater  Updater
+	cleaner  Cleaner
+	projects *hetzner.Projects
+}
+
+func New(cfg *config.Config, projects *hetzner.Projects, lockout *ratelimit.Lockout, updater Updater, cleaner Cleaner) *API {
+	return &API{
+		cfg:      cfg,
+		lockout:  lockout,
+		updater:  updater,
+		cleaner:  cleaner,
+		projects: projects,
+	}
+}
+
+// Versions returns the supported API versions.
+func (a *API) Versions(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := a.authenticate(w, r); !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, []apiVersion{{URL: "/api/v1", Version: 1}})
+	})
+}
+
+// Zones lists the zones containing domains the client is allowed to update.
+func (a *API) Zones(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(a.cfg.Timeout)*time.Second)
+		defer cancel()
+		hZones, err := a.projects.AllZones(ctx, hcloud.ZoneListOpts{})
+		if err != nil {
+			log.Printf("failed to list zones: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to list zones")
+			return
+		}
+
+		zones := []*zone{}
+		for _, z := range hZones {
+			if middleware.ZoneOverlaps(z.Name, domains) {
+				zones = append(zones, newZone(z.Name))
+			}
+		}
+		writeJSON(w, http.StatusOK, zones)
+	})
+}
+
+// Zone returns a zone with the rrsets of supported types the client is
+// allowed to update.
+func (a *API) Zone(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(a.cfg.Timeout)*time.Second)
+		defer cancel()
+		z, ok := a.getZone(ctx, w, r, domains)
+		if !ok {
+			return
+		}
+
+		hRRSets, err := a.projects.AllRRSets(ctx, z)
+		if err != nil {
+			log.Printf("failed to list rrsets: %v", err)
+			writeError(w, http.StatusInternalServerError, "failed to list rrsets")
+			return
+		}
+
+		res := newZone(z.Name)
+		res.RRSets = []*rrSet{}
+		for _, hRRSet := range hRRSets {
+			if _, err := hetzner.RRSetTypeFromString(string(hRRSet.Type)); err != nil {
+				continue
+			}
+			s := newRRSet(z, hRRSet)
+			reqData := a.reqData(r, z.Name, strings.TrimSuffix(s.Name, "."), hRRSet.Name, s.Type)
+			if !middleware.CheckPermission(a.cfg, reqData, r.RemoteAddr) {
+				continue
+			}
+			res.RRSets = append(res.RRSets, s)
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
+
+// PatchZone replaces or deletes rrsets of a zone. All rrsets are validated
+// and authorized before any change is applied.
+func (a *API) PatchZone(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domains, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		p := &patch{}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+		if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+			log.Printf("failed to parse request: %v", err)
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(a.cfg.Timeout)*time.Second)
+		defer cancel()
+		z, ok := a.getZone(ctx, w, r, domains)
+		if !ok {
+			return
+		}
+
+		changes := make([]change, 0, len(p.RRSets))
+		for _, s := range p.RRSets {
+			c, err := a.toChange(r, z.Name, s)
+			if err != nil {
+				writeError(w, http.StatusUnprocessableEntity, err.Error())
+				return
+			}
+			changes = append(changes, c)
+		}
+		if !a.authorize(w, r, changes) {
+			return
+		}
+
+		if err := a.apply(ctx, changes); err != nil {
+			log.Printf("failed to apply changes: %v", err)
+			if errors.Is(err, hetzner.ErrUnmanaged) {
+				writeError(w, http.StatusForbidden, "not allowed to change rrsets not managed by the proxy")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "failed to apply changes")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// authenticate returns the domains the client is allowed to update. If there
+// are none, an error response is written.
+func (a *API) authenticate(w http.ResponseWriter, r *http.Request) (map[string]struct{}, bool) {
+	addr := sanitize.LogValue(r.RemoteAddr)
+	if a.lockout.IsBlocked(r.RemoteAddr) {
+		//nolint:gosec // value is sanitized above
+		log.Printf("client '%s' is locked out", addr)
+		writeError(w, http.StatusTooManyRequests, "too many failed authentication attempts")
+		return nil, false
+	}
+
+	domains := middleware.GetDomains(a.cfg, &data.ReqData{
+		Token:      apiKey(r),
+		ClientCert: middleware.ClientCert(r),
+		Endpoint:   config.EndpointPowerDNS,
+	}, r.RemoteAddr)
+	if len(domains) == 0 {
+		//nolint:gosec // value is sanitized above
+		log.Printf("client '%s' is not allowed to list any domains", addr)
+		a.lockout.RecordFailure(r.RemoteAddr)
+		metrics.AuthFailure(a.cfg.Auth.Method)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+
+	return domains, true
+}
+
+func (a *API) authorize(w http.ResponseWriter, r *http.Request, changes []change) bool {
+	for _, c := range changes {
+		if !middleware.CheckPermission(a.cfg, c.reqData, r.RemoteAddr) {
+			addr := sanitize.LogValue(r.RemoteAddr)
+			typ := sanitize.LogValue(c.reqData.Type)
+			name := sanitize.LogValue(c.reqData.FullName)
+			//nolint:gosec // values are sanitized above
+			log.Printf("client '%s' is not allowed to update '%s' data of '%s'", addr, typ, name)
+			a.lockout.RecordFailure(r.RemoteAddr)
+			metrics.AuthFailure(a.cfg.Auth.Method)
+			writeError(w, http.StatusForbidden, "not allowed to update "+c.reqData.FullName)
+			return false
+		}
+	}
+	a.lockout.Reset(r.RemoteAddr)
+	return true
+}
+
+// getZone returns the zone referenced in the request path. If the zone does
+// not exist or contains no domains the client may update, an error response
+// is written.
+func (a *API) getZone(
+	ctx context.Context, w http.ResponseWriter, r *http.Request, domains map[string]struct{},
+) (*hcloud.Zone, bool) {
+	zoneName := strings.ToLower(strings.TrimSuffix(r.PathValue("zone_id"), "."))
+	z, err := a.projects.GetZone(ctx, zoneName)
+	if err != nil {
+		log.Printf("failed to get zone: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to get zone")
+		return nil, false
+	}
+	if z == nil || !middleware.ZoneOverlaps(z.Name, domains) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Could not find domain '%s.'", zoneName))
+		return nil, false
+	}
+	return z, true
+}
+
+func (a *API) apply(ctx context.Context, changes []change) error {
+	for _, c := range changes {
+		typ := sanitize.LogValue(c.reqData.Type)
+		name := sanitize.LogValue(c.reqData.FullName)
+		if c.delete {
+			//nolint:gosec // values are sanitized above
+			log.Printf("received request to delete '%s' data of '%s'", typ, name)
+			if err := a.cleaner.Delete(ctx, c.reqData); err != nil {
+				return err
+			}
+			continue
+		}
+
+		vals := sanitize.LogValue(strings.Join(c.values, ", "))
+		//nolint:gosec // values are sanitized above
+		log.Printf("received request to update '%s' data of '%s' to '%s'", typ, name, vals)
+		if err := a.updater.Set(ctx, c.reqData, c.values); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toChange validates s and converts it into a change. A REPLACE without
+// enabled records deletes the rrset like in PowerDNS.
+func (a *API) toChange(r *http.Request, zoneName string, s *rrSet) (change, error) {
+	changeType := strings.ToUpper(s.ChangeType)
+	if changeType != changeTypeReplace && changeType != changeTypeDelete {
+		return change{}, fmt.Errorf("changetype of %s must be %s or %s", s.Name, changeTypeReplace, changeTypeDelete)
+	}
+
+	fqdn := strings.ToLower(strings.TrimSuffix(s.Name, "."))
+	name := apexName
+	if fqdn != zoneName {
+		if !strings.HasSuffix(fqdn, "."+zoneName) {
+			return change{}, fmt.Errorf("RRset %s is out of zone %s", s.Name, zoneName+".")
+		}
+		name = strings.TrimSuffix(fqdn, "."+zoneName)
+	}
+
+	typ := strings.ToUpper(s.Type)
+	rrSetType, err := hetzner.RRSetTypeFromString(typ)
+	if err != nil {
+		return change{}, err
+	}
+
+	var values []string
+	for _, rec := range s.Records {
+		if rec.Disabled {
+			continue
+		}
+		value, err := middleware.CanonicalValue(hetzner.UnquoteIfRequired(rec.Content, rrSetType), typ)
+		if err != nil {
+			return change{}, fmt.Errorf("invalid record of %s: %w", s.Name, err)
+		}
+		values = append(values, value)
+	}
+	if changeType == changeTypeReplace && s.TTL < 0 {
+		return change{}, errors.New("ttl cannot be negative")
+	}
+
+	reqData := a.reqData(r, zoneName, fqdn, name, typ)
+	reqData.TTL = s.TTL
+	if len(values) > 0 {
+		reqData.Value = values[0]
+	}
+
+	return change{
+		reqData: reqData,
+		values:  values,
+		delete:  changeType == changeTypeDelete || len(values) == 0,
+	}, nil
+}
+
+func (a *API) reqData(r *http.Request, zoneName, fqdn, name, typ string) *data.ReqData {
+	return &data.ReqData{
+		FullName:   fqdn,
+		Name:       name,
+		Zone:       zoneName,
+		Type:       typ,
+		Token:      apiKey(r),
+		ClientCert: middleware.ClientCert(r),
+		Endpoint:   config.EndpointPowerDNS,
+	}
+}
+
+// apiKey returns the API key of r, taken from the X-API-Key header or a
+// bearer token.
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get(headerAPIKey); key != "" {
+		return key
+	}
+	return middleware.BearerToken(r)
+}
+
+func newZone(name string) *zone {
+	return &zone{
+		ID:   name + ".",
+		Name: name + ".",
+		Kind: zoneKindNative,
+		URL:  zonesPath + name + ".",
+	}
+}
+
+func newRRSet(z *hcloud.Zone, hRRSet *hcloud.ZoneRRSet) *rrSet {
+	fqdn := z.Name
+	if hRRSet.Name != apexName {
+		fqdn = hRRSet.Name + "." + z.Name
+	}
+
+	s := &rrSet{
+		Name:    fqdn + ".",
+		Type:    string(hRRSet.Type),
+		Records: make([]record, 0, len(hRRSet.Records)),
+	}
+	if hRRSet.TTL != nil {
+		s.TTL = *hRRSet.TTL
+	} else if z.TTL > 0 {
+		s.TTL = z.TTL
+	}
+	for _, rec := range hRRSet.Records {
+		s.Records = append(s.Records, record{Content: rec.Value})
+	}
+	return s
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	resData, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("failed to marshal response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(headerContentType, applicationJSON)
+	w.WriteHeader(status)
+	if _, err := w.Write(resData); err != nil {
+		log.Printf(failedWriteResponseFmt, err)
+	}
+}
@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
+)
+
+// Priority is the priority of a request to the Hetzner API.
+type Priority int
+
+const (
+	// PriorityNormal is the priority of ACME challenges and all other
+	// requests without explicit priority.
+	PriorityNormal Priority = iota
+	// PriorityLow is the priority of periodic DynDNS refreshes, they are
+	// rejected first if the budget runs low.
+	PriorityLow
+)
+
+// ErrReserved is returned if a request is rejected because the remaining
+// budget is reserved for requests of higher priority.
+var ErrReserved = errors.New("remaining Hetzner API requests are reserved for higher priority requests")
+
+// Budget tracks the remaining requests of an API token as reported by the
+// API. Low priority requests are rejected once the remaining requests drop
+// to reserve. Between responses the budget is assumed to be replenished
+// linearly until it is reset. The budget is reported in metrics and logs by
+// the name of its project.
+type Budget struct {
+	mu        sync.Mutex
+	project   string
+	reserve   int
+	limit     int
+	remaining int
+	observed  time.Time
+	reset     time.Time
+	shedding  bool
+	now       func() time.Time
+}
+
+func NewBudget(project string, reserve int) *Budget {
+	return &Budget{
+		project: project,
+		reserve: reserve,
+		now:     time.Now,
+	}
+}
+
+// Configure changes the project the budget is reported for and the number
+// of requests reserved for requests of normal priority.
+func (b *Budget) Configure(project string, reserve int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if project != b.project {
+		metrics.DeleteAPIBudget(b.project)
+		b.project = project
+		if b.limit != 0 {
+			metrics.APIBudget(b.project, b.remaining)
+		}
+	}
+	b.reserve = reserve
+}
+
+// Close removes the budget from metrics once it is not used anymore.
+func (b *Budget) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	metrics.DeleteAPIBudget(b.project)
+}
+
+// Observe records the budget reported by a response of the API: limit
+// requests in total of which remaining are left, fully replenished at reset.
+func (b *Budget) Observe(limit, remaining int, reset time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.limit = limit
+	b.remaining = remaining
+	b.reset = reset
+	b.observed = b.now()
+	metrics.APIBudget(b.project, remaining)
+	b.setShedding(remaining <= b.reserve, remaining)
+}
+
+// Allow returns true if a request of priority p may be sent. Requests are
+// always allowed as long as the API did not report a budget yet.
+func (b *Budget) Allow(p Priority) bool {
+	if p != PriorityLow {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.limit == 0 {
+		return true
+	}
+	remaining := b.estimate(b.now())
+	allowed := remaining > b.reserve
+	b.setShedding(!allowed, remaining)
+	if !allowed {
+		metrics.APIRequestShed(b.project)
+	}
+	return allowed
+}
+
+// estimate returns the remaining requests at now.
+func (b *Budget) estimate(now time.Time) int {
+	if !now.Before(b.reset) {
+		return b.limit
+	}
+	window := b.reset.Sub(b.observed)
+	if window <= 0 {
+		return b.remaining
+	}
+	refill := float64(b.limit-b.remaining) * float64(now.Sub(b.observed)) / float64(window)
+	return b.remaining + int(refill)
+}
+
+// setShedding logs when low priority requests start or stop being rejected.
+func (b *Budget) setShedding(shedding bool, remaining int) {
+	if shedding == b.shedding {
+		return
+	}
+	b.shedding = shedding
+	if shedding {
+		log.Printf("Hetzner API budget of project %s down to %d of %d requests, rejecting low priority requests",
+			b.project, remaining, b.limit)
+		return
+	}
+	log.Printf("Hetzner API budget of project %s recovered to %d of %d requests, accepting low priority requests again",
+		b.project, remaining, b.limit)
+}
+
+// priorityKey is the key for Priority values in Contexts.
+type priorityKey struct{}
+
+// NewContextWithPriority returns a new Context that carries the priority p of
+// requests made with it.
+func NewContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority stored in ctx, or PriorityNormal.
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
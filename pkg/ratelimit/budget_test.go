@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Budget", func() {
+	const (
+		limit   = 3600
+		reserve = 100
+	)
+
+	var (
+		now time.Time
+		b   *Budget
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		b = NewBudget("default", reserve)
+		b.now = func() time.Time { return now }
+	})
+
+	It("allows requests before the API reported a budget", func() {
+		Expect(b.Allow(PriorityLow)).To(BeTrue())
+	})
+
+	It("allows low priority requests above the reserve", func() {
+		b.Observe(limit, reserve+1, now.Add(time.Hour))
+		Expect(b.Allow(PriorityLow)).To(BeTrue())
+	})
+
+	It("rejects low priority requests at the reserve", func() {
+		b.Observe(limit, reserve, now.Add(time.Hour))
+		Expect(b.Allow(PriorityLow)).To(BeFalse())
+	})
+
+	It("allows normal priority requests at the reserve", func() {
+		b.Observe(limit, 0, now.Add(time.Hour))
+		Expect(b.Allow(PriorityNormal)).To(BeTrue())
+	})
+
+	It("assumes the budget is replenished until it is reset", func() {
+		b.Observe(limit, 0, now.Add(time.Hour))
+		now = now.Add(time.Minute)
+		Expect(b.Allow(PriorityLow)).To(BeFalse())
+		now = now.Add(time.Minute)
+		Expect(b.Allow(PriorityLow)).To(BeTrue())
+	})
+
+	It("allows low priority requests after the budget was reset", func() {
+		b.Observe(limit, 0, now.Add(time.Hour))
+		now = now.Add(time.Hour)
+		Expect(b.Allow(PriorityLow)).To(BeTrue())
+	})
+
+	It("applies a changed reserve", func() {
+		b.Observe(limit, reserve, now.Add(time.Hour))
+		b.Configure("default", reserve-1)
+		Expect(b.Allow(PriorityLow)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Priority", func() {
+	It("defaults to normal", func() {
+		Expect(PriorityFromContext(context.Background())).To(Equal(PriorityNormal))
+	})
+
+	It("is read from the context", func() {
+		ctx := NewContextWithPriority(context.Background(), PriorityLow)
+		Expect(PriorityFromContext(ctx)).To(Equal(PriorityLow))
+	})
+})
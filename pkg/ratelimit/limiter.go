@@ -43,6 +43,22 @@ func NewLimiter(ratePerSecond float64, burst int, idle time.Duration) *Limiter {
 	}
 }
 
+// SetLimits changes the rate, burst and idle time of the limiter. Buckets of
+// keys seen before keep their tokens and refill at the new rate.
+func (l *Limiter) SetLimits(ratePerSecond float64, burst int, idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = rate.Limit(ratePerSecond)
+	l.burst = burst
+	l.idle = idle
+	now := l.now()
+	for _, b := range l.buckets {
+		b.limiter.SetLimitAt(now, l.limit)
+		b.limiter.SetBurstAt(now, l.burst)
+	}
+}
+
 func (l *Limiter) Allow(key string) bool {
 	l.mu.Lock()
 	defer l.mu.Unlock()
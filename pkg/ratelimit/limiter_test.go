@@ -49,6 +49,21 @@ var _ = Describe("Limiter", func() {
 		Expect(l.Allow(ip)).To(BeFalse())
 	})
 
+	It("keeps the tokens of buckets when the limits change", func() {
+		for range 3 {
+			Expect(l.Allow(ip)).To(BeTrue())
+		}
+		Expect(l.Allow(ip)).To(BeFalse())
+
+		l.SetLimits(2.0, 5, 10*time.Minute)
+		Expect(l.Allow(ip)).To(BeFalse())
+
+		now = now.Add(time.Second)
+		Expect(l.Allow(ip)).To(BeTrue())
+		Expect(l.Allow(ip)).To(BeTrue())
+		Expect(l.Allow(ip)).To(BeFalse())
+	})
+
 	It("isolates keys", func() {
 		for range 3 {
 			Expect(l.Allow(ip)).To(BeTrue())
@@ -3,6 +3,8 @@ package ratelimit
 import (
 	"sync"
 	"time"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
 )
 
 const (
@@ -43,6 +45,17 @@ func NewLockout(maxAttempts int, duration, window time.Duration) *Lockout {
 	}
 }
 
+// SetLimits changes the attempts, lockout duration and window of the lockout.
+// Failures recorded and lockouts started before are kept.
+func (l *Lockout) SetLimits(maxAttempts int, duration, window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.maxAttempts = maxAttempts
+	l.duration = duration
+	l.window = window
+}
+
 func (l *Lockout) IsBlocked(key string) bool {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -91,6 +104,7 @@ func (l *Lockout) RecordFailure(key string) bool {
 	e.lastAttempt = now
 	if e.count >= l.maxAttempts && e.lockedUntil.IsZero() {
 		e.lockedUntil = now.Add(l.duration)
+		metrics.Lockout()
 		return true
 	}
 	return false
@@ -38,6 +38,19 @@ var _ = Describe("Lockout", func() {
 		Expect(l.IsBlocked(ip)).To(BeTrue())
 	})
 
+	It("keeps recorded failures when the limits change", func() {
+		Expect(l.RecordFailure(ip)).To(BeFalse())
+		Expect(l.RecordFailure(ip)).To(BeFalse())
+
+		l.SetLimits(2, time.Minute, 15*time.Minute)
+		Expect(l.IsBlocked(ip)).To(BeFalse())
+		Expect(l.RecordFailure(ip)).To(BeTrue())
+		Expect(l.IsBlocked(ip)).To(BeTrue())
+
+		now = now.Add(time.Minute + time.Second)
+		Expect(l.IsBlocked(ip)).To(BeFalse())
+	})
+
 	It("isolates keys", func() {
 		for range 3 {
 			l.RecordFailure(ip)
@@ -0,0 +1,378 @@
+package rfc2136
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	tsigFudge = 300
+	// endpoint is the endpoint group of requests in metrics
+	endpoint = "rfc2136"
+)
+
+type Updater interface {
+	Add(ctx context.Context, reqData *data.ReqData) error
+}
+
+type Cleaner interface {
+	Clean(ctx context.Context, reqData *data.ReqData) error
+	Delete(ctx context.Context, reqData *data.ReqData) error
+}
+
+type opKind int
+
+const (
+	opAdd opKind = iota
+	opRemove
+	opDelete
+)
+
+type op struct {
+	kind    opKind
+	reqData *data.ReqData
+}
+
+// Server accepts RFC 2136 DNS UPDATE messages signed with TSIG keys and
+// translates them into updates and cleanups of rrsets.
+type Server struct {
+	limiter *ratelimit.Limiter
+	lockout *ratelimit.Lockout
+	keys    map[string]config.TSIGKey
+	udp     *dns.Server
+	tcp     *dns.Server
+
+	mu      sync.Mutex
+	cfg     *config.Config
+	updater Updater
+	cleaner Cleaner
+}
+
+func New(cfg *config.Config, limiter *ratelimit.Limiter, lockout *ratelimit.Lockout, updater Updater, cleaner Cleaner) *Server {
+	s := &Server{
+		cfg:     cfg,
+		limiter: limiter,
+		lockout: lockout,
+		updater: updater,
+		cleaner: cleaner,
+		keys:    map[string]config.TSIGKey{},
+	}
+
+	secrets := map[string]string{}
+	for _, key := range cfg.RFC2136.Keys {
+		name := dns.CanonicalName(key.Name)
+		s.keys[name] = key
+		secrets[name] = key.Secret
+	}
+
+	s.udp = &dns.Server{Net: "udp", Handler: s, TsigSecret: secrets, MsgAcceptFunc: acceptUpdate}
+	s.tcp = &dns.Server{Net: "tcp", Handler: s, TsigSecret: secrets, MsgAcceptFunc: acceptUpdate}
+
+	return s
+}
+
+// Reload replaces the config and the clients updates are applied with. The
+// TSIG keys and the listen address are only applied on start.
+func (s *Server) Reload(cfg *config.Config, updater Updater, cleaner Cleaner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	s.updater = updater
+	s.cleaner = cleaner
+}
+
+// clients returns the config and the clients updates are applied with.
+func (s *Server) clients() (*config.Config, Updater, Cleaner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg, s.updater, s.cleaner
+}
+
+// ListenAndServe listens on the configured address via UDP and TCP.
+func (s *Server) ListenAndServe() error {
+	cfg, _, _ := s.clients()
+	pc, err := net.ListenPacket("udp", cfg.RFC2136.ListenAddr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", cfg.RFC2136.ListenAddr)
+	if err != nil {
+		return errors.Join(err, pc.Close())
+	}
+	return s.Serve(pc, l)
+}
+
+// Serve serves DNS UPDATE messages on the given connections until one of
+// them fails or Shutdown is called.
+func (s *Server) Serve(pc net.PacketConn, l net.Listener) error {
+	s.udp.PacketConn = pc
+	s.tcp.Listener = l
+
+	const servers = 2
+	errs := make(chan error, servers)
+	go func() { errs <- s.udp.ActivateAndServe() }()
+	go func() { errs <- s.tcp.ActivateAndServe() }()
+	return <-errs
+}
+
+// NotifyStartedFunc sets f to be called once both the UDP and the TCP
+// listener are serving.
+func (s *Server) NotifyStartedFunc(f func()) {
+	var wg sync.WaitGroup
+	const servers = 2
+	wg.Add(servers)
+	s.udp.NotifyStartedFunc = wg.Done
+	s.tcp.NotifyStartedFunc = wg.Done
+	go func() {
+		wg.Wait()
+		f()
+	}()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return errors.Join(s.udp.ShutdownContext(ctx), s.tcp.ShutdownContext(ctx))
+}
+
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	remoteAddr := remoteIP(w.RemoteAddr())
+	rcode := s.handle(w, r, remoteAddr)
+	metrics.ObserveRequest(endpoint, dns.RcodeToString[rcode], time.Since(start))
+
+	m := &dns.Msg{}
+	m.SetRcode(r, rcode)
+	if t := r.IsTsig(); t != nil && w.TsigStatus() == nil {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, tsigFudge, time.Now().Unix())
+	}
+	if err := w.WriteMsg(m); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func (s *Server) handle(w dns.ResponseWriter, r *dns.Msg, remoteAddr string) int {
+	if r.Opcode != dns.OpcodeUpdate {
+		return dns.RcodeNotImplemented
+	}
+
+	addr := sanitize.LogValue(remoteAddr)
+	if !s.limiter.Allow(remoteAddr) {
+		//nolint:gosec // value is sanitized above
+		log.Printf("rate limit exceeded for %s", addr)
+		metrics.RateLimited()
+		return dns.RcodeRefused
+	}
+	if s.lockout.IsBlocked(remoteAddr) {
+		//nolint:gosec // value is sanitized above
+		log.Printf("client '%s' is locked out", addr)
+		return dns.RcodeRefused
+	}
+
+	key, rcode := s.authenticate(w, r, remoteAddr)
+	if rcode != dns.RcodeSuccess {
+		s.lockout.RecordFailure(remoteAddr)
+		metrics.AuthFailure(metrics.AuthMethodTSIG)
+		return rcode
+	}
+
+	ops, rcode := parseUpdate(r)
+	if rcode != dns.RcodeSuccess {
+		return rcode
+	}
+
+	for _, o := range ops {
+		if !middleware.CheckTSIGKey(o.reqData.FullName, o.reqData.Type, &key) {
+			logPermissionDenied(addr, key.Name, o.reqData)
+			s.lockout.RecordFailure(remoteAddr)
+			metrics.AuthFailure(metrics.AuthMethodTSIG)
+			return dns.RcodeRefused
+		}
+		o.reqData.User = key.Name
+		o.reqData.Takeover = key.Takeover
+	}
+	s.lockout.Reset(remoteAddr)
+
+	return s.apply(ops)
+}
+
+func (s *Server) authenticate(w dns.ResponseWriter, r *dns.Msg, remoteAddr string) (config.TSIGKey, int) {
+	addr := sanitize.LogValue(remoteAddr)
+	t := r.IsTsig()
+	if t == nil {
+		//nolint:gosec // value is sanitized above
+		log.Printf("client '%s' sent an unsigned update", addr)
+		return config.TSIGKey{}, dns.RcodeRefused
+	}
+
+	name := sanitize.LogValue(t.Hdr.Name)
+	if err := w.TsigStatus(); err != nil {
+		//nolint:gosec // values are sanitized above
+		log.Printf("client '%s' sent an update with invalid signature of key '%s': %v", addr, name, err)
+		return config.TSIGKey{}, dns.RcodeNotAuth
+	}
+
+	key, ok := s.keys[dns.CanonicalName(t.Hdr.Name)]
+	if !ok || dns.CanonicalName(t.Algorithm) != dns.Fqdn(key.Algorithm) {
+		//nolint:gosec // values are sanitized above
+		log.Printf("client '%s' sent an update with unexpected key or algorithm '%s'", addr, name)
+		return config.TSIGKey{}, dns.RcodeNotAuth
+	}
+
+	return key, dns.RcodeSuccess
+}
+
+func (s *Server) apply(ops []op) int {
+	cfg, updater, cleaner := s.clients()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeout)*time.Second)
+	defer cancel()
+
+	for _, o := range ops {
+		typ := sanitize.LogValue(o.reqData.Type)
+		name := sanitize.LogValue(o.reqData.FullName)
+		var err error
+		switch o.kind {
+		case opAdd:
+			val := sanitize.LogValue(o.reqData.Value)
+			//nolint:gosec // values are sanitized above
+			log.Printf("received request to add '%s' data of '%s' with '%s'", typ, name, val)
+			err = updater.Add(ctx, o.reqData)
+		case opRemove:
+			//nolint:gosec // values are sanitized above
+			log.Printf("received request to clean '%s' data of '%s'", typ, name)
+			err = cleaner.Clean(ctx, o.reqData)
+		case opDelete:
+			//nolint:gosec // values are sanitized above
+			log.Printf("received request to delete '%s' data of '%s'", typ, name)
+			err = cleaner.Delete(ctx, o.reqData)
+		}
+		if errors.Is(err, hetzner.ErrUnmanaged) {
+			log.Printf("refused to apply update: %v", err)
+			return dns.RcodeRefused
+		}
+		if err != nil {
+			log.Printf("failed to apply update: %v", err)
+			return dns.RcodeServerFailure
+		}
+	}
+
+	return dns.RcodeSuccess
+}
+
+func parseUpdate(r *dns.Msg) ([]op, int) {
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		return nil, dns.RcodeFormatError
+	}
+	zone := dns.CanonicalName(r.Question[0].Name)
+
+	// Prerequisites are not supported
+	if len(r.Answer) > 0 {
+		return nil, dns.RcodeNotImplemented
+	}
+
+	ops := make([]op, 0, len(r.Ns))
+	for _, rr := range r.Ns {
+		o, rcode := parseRR(rr, zone)
+		if rcode != dns.RcodeSuccess {
+			return nil, rcode
+		}
+		ops = append(ops, o)
+	}
+
+	return ops, dns.RcodeSuccess
+}
+
+func parseRR(rr dns.RR, zone string) (op, int) {
+	hdr := rr.Header()
+	fqdn := dns.CanonicalName(hdr.Name)
+	if !dns.IsSubDomain(zone, fqdn) {
+		return op{}, dns.RcodeNotZone
+	}
+
+	reqData := &data.ReqData{
+		FullName: strings.TrimSuffix(fqdn, "."),
+		Name:     strings.TrimSuffix(strings.TrimSuffix(fqdn, zone), "."),
+		Zone:     strings.TrimSuffix(zone, "."),
+		Type:     dns.TypeToString[hdr.Rrtype],
+	}
+
+	switch hdr.Class {
+	case dns.ClassINET:
+		value, ok := rrValue(rr)
+		if !ok {
+			return op{}, dns.RcodeRefused
+		}
+		reqData.Value = value
+		return op{kind: opAdd, reqData: reqData}, dns.RcodeSuccess
+	case dns.ClassNONE:
+		value, ok := rrValue(rr)
+		if !ok {
+			return op{}, dns.RcodeRefused
+		}
+		reqData.Value = value
+		return op{kind: opRemove, reqData: reqData}, dns.RcodeSuccess
+	case dns.ClassANY:
+		if hdr.Rdlength != 0 {
+			return op{}, dns.RcodeFormatError
+		}
+		if _, err := hetzner.RRSetTypeFromString(reqData.Type); err != nil {
+			return op{}, dns.RcodeRefused
+		}
+		return op{kind: opDelete, reqData: reqData}, dns.RcodeSuccess
+	default:
+		return op{}, dns.RcodeFormatError
+	}
+}
+
+func rrValue(rr dns.RR) (string, bool) {
+	if _, err := hetzner.RRSetTypeFromString(dns.TypeToString[rr.Header().Rrtype]); err != nil {
+		return "", false
+	}
+	return middleware.RRValue(rr), true
+}
+
+func logPermissionDenied(addr, keyName string, reqData *data.ReqData) {
+	key := sanitize.LogValue(keyName)
+	typ := sanitize.LogValue(reqData.Type)
+	name := sanitize.LogValue(reqData.FullName)
+	//nolint:gosec // values are sanitized above
+	log.Printf("client '%s' with key '%s' is not allowed to update '%s' data of '%s'", addr, key, typ, name)
+}
+
+func acceptUpdate(dh dns.Header) dns.MsgAcceptAction {
+	const qrBit = 1 << 15
+	if dh.Bits&qrBit != 0 {
+		return dns.MsgIgnore
+	}
+	const opcodeShift, opcodeMask = 11, 0xF
+	if opcode := int(dh.Bits>>opcodeShift) & opcodeMask; opcode != dns.OpcodeUpdate {
+		return dns.MsgRejectNotImplemented
+	}
+	// The zone section of an update must contain exactly one zone
+	if dh.Qdcount != 1 {
+		return dns.MsgReject
+	}
+	return dns.MsgAccept
+}
+
+func remoteIP(addr net.Addr) string {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return addrPort.Addr().String()
+}
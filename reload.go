@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/app"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/metrics"
+)
+
+// reloadConfig reads the config file at path again and applies it to a. If
+// the file cannot be read or is invalid, the current config is kept.
+func reloadConfig(a *app.App, path string) {
+	if path == "" {
+		log.Printf("Config file not set, ignoring reload")
+		return
+	}
+
+	log.Printf("Reloading config file: %s", path)
+	cfg, err := config.ReadFile(path)
+	metrics.ConfigReload(err)
+	if err != nil {
+		log.Printf("Failed to reload config file, keeping the current config: %v", err)
+		return
+	}
+	a.Reload(cfg)
+	log.Printf("Reloaded config file, enabled endpoints: %s", enabledEndpoints(cfg))
+}
+
+// watchConfig checks the config file at path for changes every interval and
+// sends to changed when its content differs from the last check. The content
+// is compared, so files replaced by renames or symlink swaps, as done for
+// Kubernetes ConfigMaps, are noticed as well.
+func watchConfig(ctx context.Context, path string, interval time.Duration, changed chan<- struct{}) {
+	last, _ := os.ReadFile(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil || bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
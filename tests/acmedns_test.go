@@ -7,12 +7,15 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
 	"github.com/onsi/gomega/gstruct"
 
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+
 	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
 )
@@ -66,14 +69,14 @@ var _ = Describe("AcmeDNS", func() {
 		)
 
 		DescribeTable(
-			"updating an existing record", func(ctx context.Context, subdomain string) {
+			"appending to an existing record", func(ctx context.Context, subdomain string) {
 				server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
 
 				api.AppendHandlers(
 					libcloudapi.GetZone(token, libcloudapi.Zone()),
 					libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetTXT(), true),
-					libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetTXT()),
-					libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetTXT()),
+					libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), libcloudapi.AppendedRRSetTXT()),
+					libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.AppendedRRSetTXT()),
 				)
 
 				statusCode, resBody := doAcmeDNSRequest(
@@ -94,6 +97,29 @@ var _ = Describe("AcmeDNS", func() {
 			Entry("with prefix", libserver.TXTRecordNameFull),
 			Entry("without prefix", libserver.TXTRecordNameNoPrefix),
 		)
+
+		It("keeping only the latest two values", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			existing := libcloudapi.ExistingRRSetTXT()
+			existing.Records = append([]schema.ZoneRRSetRecord{{Value: strconv.Quote("oldest")}}, existing.Records...)
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), existing, true),
+				libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), libcloudapi.AppendedRRSetTXT()),
+				libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.AppendedRRSetTXT()),
+			)
+
+			statusCode, _ := doAcmeDNSRequest(
+				ctx, server.URL+"/acmedns/update", username, password,
+				map[string]string{
+					keySubdomain: libserver.TXTRecordNameFull,
+					keyTXT:       libserver.TXTUpdated,
+				},
+			)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(4))
+		})
 	})
 
 	Context("should make no api calls and should fail", func() {
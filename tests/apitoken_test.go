@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("API tokens", func() {
+	const apiToken = "hdp_secret"
+
+	var (
+		api    *ghttp.Server
+		server *httptest.Server
+		token  string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	expectPresent := func() {
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT()),
+		)
+	}
+
+	present := func(ctx context.Context) int {
+		return doBearerRequest(ctx, server.URL+"/httpreq/present", apiToken, map[string]string{
+			keyFQDN:  libserver.TXTRecordNameFull,
+			keyValue: libserver.TXTUpdated,
+		})
+	}
+
+	It("should authorize bearer tokens on JSON endpoints", func(ctx context.Context) {
+		server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithAPIToken(apiToken))
+		expectPresent()
+
+		Expect(present(ctx)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("should authorize tokens as Basic auth password", func(ctx context.Context) {
+		server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithAPIToken(apiToken))
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		Expect(doPlainRequest(ctx, server.URL+"/plain/update", "legacy", apiToken, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		})).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("should deny expired tokens", func(ctx context.Context) {
+		server, _, _, _ = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithAPIToken(apiToken, func(t *config.APIToken) {
+			t.ExpiresAt = time.Now().Add(-time.Minute)
+		}))
+
+		Expect(present(ctx)).To(Equal(http.StatusUnauthorized))
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("should deny tokens on endpoints outside their scope", func(ctx context.Context) {
+		server, _, _, _ = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithAPIToken(apiToken, func(t *config.APIToken) {
+			t.Endpoints = []string{config.EndpointPlain}
+		}))
+
+		Expect(present(ctx)).To(Equal(http.StatusUnauthorized))
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("should deny tokens for domains outside their scope", func(ctx context.Context) {
+		server, _, _, _ = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithAPIToken(apiToken, func(t *config.APIToken) {
+			t.Domains = []string{"other.tld"}
+		}))
+
+		Expect(present(ctx)).To(Equal(http.StatusUnauthorized))
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+})
+
+func doBearerRequest(ctx context.Context, serverURL, token string, data map[string]string) int {
+	body, err := json.Marshal(data)
+	Expect(err).ToNot(HaveOccurred())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverURL, bytes.NewReader(body))
+	Expect(err).ToNot(HaveOccurred())
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	c := &http.Client{}
+	res, err := c.Do(req)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(res.Body.Close()).To(Succeed())
+
+	return res.StatusCode
+}
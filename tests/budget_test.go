@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/app"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("APIBudget", func() {
+	const (
+		limit   = 3600
+		reserve = 100
+	)
+
+	var (
+		api      *ghttp.Server
+		server   *httptest.Server
+		token    string
+		username string
+		password string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		server, token, username, password = libserver.New(
+			api.URL(), libserver.DefaultTTL, libserver.WithAPIBudgetReserve(reserve),
+		)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	present := func(ctx context.Context) int {
+		return doHTTPReqRequest(
+			ctx, server.URL+"/httpreq/present", username, password,
+			map[string]string{
+				keyFQDN:  libserver.TXTRecordNameFull,
+				keyValue: libserver.TXTUpdated,
+			},
+		)
+	}
+
+	presentWithBudget := func(remaining int) {
+		reset := time.Now().Add(time.Hour)
+		api.AppendHandlers(
+			libcloudapi.WithRateLimit(libcloudapi.GetZone(token, libcloudapi.Zone()), limit, remaining, reset),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT()),
+		)
+	}
+
+	It("should accept DynDNS updates while the budget lasts", func(ctx context.Context) {
+		presentWithBudget(limit / 2)
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		Expect(present(ctx)).To(Equal(http.StatusOK))
+		Expect(doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		})).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(6))
+	})
+
+	It("should reject DynDNS updates once the budget is reserved", func(ctx context.Context) {
+		presentWithBudget(reserve)
+
+		Expect(present(ctx)).To(Equal(http.StatusOK))
+		Expect(doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		})).To(Equal(http.StatusServiceUnavailable))
+		status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyMyIP:     []string{libserver.AUpdated},
+		})
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("911"))
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("should still accept ACME challenges once the budget is reserved", func(ctx context.Context) {
+		presentWithBudget(reserve)
+		presentWithBudget(reserve - 1)
+
+		Expect(present(ctx)).To(Equal(http.StatusOK))
+		Expect(present(ctx)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(6))
+	})
+
+	It("should still accept ACME challenges via DirectAdmin once the budget is reserved", func(ctx context.Context) {
+		presentWithBudget(reserve)
+		presentWithBudget(reserve - 1)
+
+		Expect(present(ctx)).To(Equal(http.StatusOK))
+		status, _ := doDirectAdminRequest(ctx, server.URL+"/directadmin/CMD_API_DNS_CONTROL", username, password, url.Values{
+			keyDomain: []string{libserver.ZoneName},
+			keyAction: []string{"add"},
+			keyType:   []string{libserver.RecordTypeTXT},
+			keyName:   []string{libserver.TXTRecordName},
+			keyValue:  []string{libserver.TXTUpdated},
+		})
+		Expect(status).To(Equal(http.StatusOK))
+		status, _ = doDirectAdminRequest(ctx, server.URL+"/directadmin/CMD_API_DNS_CONTROL", username, password, url.Values{
+			keyDomain: []string{libserver.ZoneName},
+			keyAction: []string{"add"},
+			keyType:   []string{libserver.RecordTypeA},
+			keyName:   []string{libserver.ARecordName},
+			keyValue:  []string{libserver.AUpdated},
+		})
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(api.ReceivedRequests()).To(HaveLen(6))
+	})
+
+	Context("on reload", func() {
+		var (
+			a   *app.App
+			cfg *config.Config
+		)
+
+		BeforeEach(func() {
+			server.Close()
+			server, a, cfg = libserver.NewReloadable(api.URL(), libserver.WithAPIBudgetReserve(reserve))
+			token, username, password = cfg.Token, cfg.Auth.Users[0].Username, cfg.Auth.Users[0].Password
+		})
+
+		update := func(ctx context.Context) int {
+			return doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyIP:       []string{libserver.AUpdated},
+			})
+		}
+
+		It("should keep the budget of a token", func(ctx context.Context) {
+			presentWithBudget(reserve)
+			Expect(present(ctx)).To(Equal(http.StatusOK))
+
+			next := *cfg
+			a.Reload(&next)
+			Expect(update(ctx)).To(Equal(http.StatusServiceUnavailable))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("should forget the budget of a token that is not configured anymore", func(ctx context.Context) {
+			presentWithBudget(reserve)
+			Expect(present(ctx)).To(Equal(http.StatusOK))
+
+			next := *cfg
+			next.Token = "othertoken"
+			a.Reload(&next)
+			prev := *cfg
+			a.Reload(&prev)
+
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+			)
+			Expect(update(ctx)).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(6))
+		})
+	})
+})
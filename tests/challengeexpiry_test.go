@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/challenge"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("ChallengeExpiry", func() {
+	const lifetimeSeconds = 3600
+
+	var (
+		api       *ghttp.Server
+		server    *httptest.Server
+		reaper    *challenge.Reaper
+		stateFile string
+		token     string
+		username  string
+		password  string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		stateFile = filepath.Join(GinkgoT().TempDir(), "challenges.json")
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	present := func(ctx context.Context) {
+		Expect(doHTTPReqRequest(
+			ctx, server.URL+"/httpreq/present", username, password,
+			map[string]string{
+				keyFQDN:  libserver.TXTRecordNameFull,
+				keyValue: libserver.TXTUpdated,
+			},
+		)).To(Equal(http.StatusOK))
+	}
+
+	It("should label created rrsets and remember their value", func(ctx context.Context) {
+		server, _, token, username, password = libserver.NewChallengeExpiry(api.URL(), stateFile, lifetimeSeconds)
+
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), managed(libcloudapi.NewRRSetTXT())),
+		)
+
+		present(ctx)
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+		Expect(os.ReadFile(stateFile)).To(ContainSubstring(libserver.TXTUpdated))
+	})
+
+	It("should forget values cleaned up by the client", func(ctx context.Context) {
+		server, _, token, username, password = libserver.NewChallengeExpiry(api.URL(), stateFile, lifetimeSeconds)
+
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), managed(libcloudapi.NewRRSetTXT())),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), managed(libcloudapi.UpdatedRRSetTXT()), true),
+			libcloudapi.DeleteRRSet(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetTXT()),
+		)
+
+		present(ctx)
+		Expect(doHTTPReqRequest(
+			ctx, server.URL+"/httpreq/cleanup", username, password,
+			map[string]string{
+				keyFQDN:  libserver.TXTRecordNameFull,
+				keyValue: libserver.TXTUpdated,
+			},
+		)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(6))
+		Expect(os.ReadFile(stateFile)).ToNot(ContainSubstring(libserver.TXTUpdated))
+	})
+
+	It("should remove expired values of managed rrsets", func(ctx context.Context) {
+		server, reaper, token, username, password = libserver.NewChallengeExpiry(api.URL(), stateFile, 0)
+
+		rrSet := managed(libcloudapi.AppendedRRSetTXT())
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), managed(libcloudapi.ExistingRRSetTXT()), true),
+			libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), rrSet),
+			libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), rrSet),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSet, true),
+			libcloudapi.RemoveRRSetRecords(token, libcloudapi.Zone(), rrSet, []schema.ZoneRRSetRecord{
+				{Value: strconv.Quote(libserver.TXTUpdated)},
+			}),
+		)
+
+		present(ctx)
+		reaper.Reap(ctx)
+		Expect(api.ReceivedRequests()).To(HaveLen(7))
+		Expect(os.ReadFile(stateFile)).ToNot(ContainSubstring(libserver.TXTUpdated))
+	})
+
+	It("should forget expired values of deleted zones", func(ctx context.Context) {
+		server, reaper, token, username, password = libserver.NewChallengeExpiry(api.URL(), stateFile, 0)
+
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), managed(libcloudapi.NewRRSetTXT())),
+			libcloudapi.Error(http.StatusNotFound, "not_found"),
+		)
+
+		present(ctx)
+		reaper.Reap(ctx)
+		Expect(api.ReceivedRequests()).To(HaveLen(4))
+		Expect(os.ReadFile(stateFile)).ToNot(ContainSubstring(libserver.TXTUpdated))
+	})
+
+	It("should leave expired values of rrsets managed by hand", func(ctx context.Context) {
+		server, reaper, token, username, password = libserver.NewChallengeExpiry(api.URL(), stateFile, 0)
+
+		rrSet := libcloudapi.AppendedRRSetTXT()
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetTXT(), true),
+			libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), rrSet),
+			libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), rrSet),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSet, true),
+		)
+
+		present(ctx)
+		reaper.Reap(ctx)
+		Expect(api.ReceivedRequests()).To(HaveLen(6))
+		Expect(os.ReadFile(stateFile)).ToNot(ContainSubstring(libserver.TXTUpdated))
+	})
+})
+
+func managed(rrSet schema.ZoneRRSet) schema.ZoneRRSet {
+	rrSet.Labels = map[string]string{"managed-by": "hetzner-dnsapi-proxy"}
+	return rrSet
+}
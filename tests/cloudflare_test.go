@@ -0,0 +1,294 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+const cloudflareToken = "cloudflaretoken"
+
+type cloudflareRecord struct {
+	ID       string `json:"id"`
+	ZoneID   string `json:"zone_id"`
+	ZoneName string `json:"zone_name"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Content  string `json:"content"`
+	Priority *int   `json:"priority"`
+	TTL      int    `json:"ttl"`
+}
+
+type cloudflareResponse struct {
+	Success bool            `json:"success"`
+	Errors  []any           `json:"errors"`
+	Result  json.RawMessage `json:"result"`
+}
+
+var _ = Describe("Cloudflare", func() {
+	var (
+		api    *ghttp.Server
+		server *httptest.Server
+		token  string
+
+		zonePath    = "/client/v4/zones/" + libserver.ZoneID
+		recordsPath = zonePath + "/dns_records"
+		existingAID = cloudflareRecordID(libserver.RecordTypeA, libserver.ARecordName, libserver.AExisting)
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithUserToken(cloudflareToken),
+			libserver.WithRecordTypes(libserver.RecordTypeA, libserver.RecordTypeAAAA, libserver.RecordTypeTXT, libserver.RecordTypeMX))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	Context("should succeed", func() {
+		It("listing zones", func(ctx context.Context) {
+			api.AppendHandlers(libcloudapi.ListZones(token, libcloudapi.Zone()))
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodGet, server.URL+"/client/v4/zones?name=test.tld", cloudflareToken, nil)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(res.Success).To(BeTrue())
+			Expect(res.Result).To(MatchJSON(`[{"id":"1","name":"test.tld","status":"active"}]`))
+			Expect(api.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("listing records", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZoneByID(token, libcloudapi.Zone()),
+				libcloudapi.ListRRSets(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), libcloudapi.ExistingRRSetTXT()),
+			)
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodGet, server.URL+recordsPath+"?type=A", cloudflareToken, nil)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(res.Success).To(BeTrue())
+			var records []cloudflareRecord
+			Expect(json.Unmarshal(res.Result, &records)).To(Succeed())
+			Expect(records).To(HaveExactElements(cloudflareRecord{
+				ID:       existingAID,
+				ZoneID:   libserver.ZoneID,
+				ZoneName: libserver.ZoneName,
+				Name:     libserver.ARecordNameFull,
+				Type:     libserver.RecordTypeA,
+				Content:  libserver.AExisting,
+				TTL:      300,
+			}))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("getting a record with the TTL of its rrset", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZoneByID(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+			)
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodGet, server.URL+recordsPath+"/"+existingAID, cloudflareToken, nil)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(res.Success).To(BeTrue())
+			var record cloudflareRecord
+			Expect(json.Unmarshal(res.Result, &record)).To(Succeed())
+			Expect(record.ID).To(Equal(existingAID))
+			Expect(record.TTL).To(Equal(300))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("overwriting a record without changes", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZoneByID(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+			)
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodPut, server.URL+recordsPath+"/"+existingAID, cloudflareToken,
+				map[string]any{
+					"type":    libserver.RecordTypeA,
+					"name":    libserver.ARecordNameFull,
+					"content": libserver.AExisting,
+				},
+			)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(res.Success).To(BeTrue())
+			var record cloudflareRecord
+			Expect(json.Unmarshal(res.Result, &record)).To(Succeed())
+			Expect(record.TTL).To(Equal(300))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("creating a record", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZoneByID(token, libcloudapi.Zone()),
+				libcloudapi.AddRRSetRecords(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT()),
+			)
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodPost, server.URL+recordsPath, cloudflareToken, map[string]any{
+				"type":    libserver.RecordTypeTXT,
+				"name":    libserver.TXTRecordNameFull,
+				"content": libserver.TXTUpdated,
+				"ttl":     120,
+			})
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(res.Success).To(BeTrue())
+			var record cloudflareRecord
+			Expect(json.Unmarshal(res.Result, &record)).To(Succeed())
+			Expect(record.ID).To(Equal(cloudflareRecordID(libserver.RecordTypeTXT, libserver.TXTRecordName, libserver.TXTUpdated)))
+			Expect(record.Name).To(Equal(libserver.TXTRecordNameFull))
+			Expect(record.TTL).To(Equal(libserver.DefaultTTL))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("creating an MX record with priority", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZoneByID(token, libcloudapi.Zone()),
+				libcloudapi.AddRRSetRecords(token, libcloudapi.Zone(), libcloudapi.NewRRSetMX()),
+			)
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodPost, server.URL+recordsPath, cloudflareToken, map[string]any{
+				"type":     libserver.RecordTypeMX,
+				"name":     libserver.MXRecordName,
+				"content":  "mx.test.tld",
+				"priority": 10,
+			})
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(res.Success).To(BeTrue())
+			var record cloudflareRecord
+			Expect(json.Unmarshal(res.Result, &record)).To(Succeed())
+			Expect(record.ID).To(Equal(cloudflareRecordID(libserver.RecordTypeMX, libserver.MXRecordName, libserver.MXUpdated)))
+			Expect(record.Content).To(Equal("mx.test.tld."))
+			Expect(record.Priority).To(HaveValue(Equal(10)))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("updating a record", func(ctx context.Context) {
+			appended := libcloudapi.ExistingRRSetA()
+			appended.Records = append(appended.Records, schema.ZoneRRSetRecord{Value: libserver.AUpdated})
+			api.AppendHandlers(
+				libcloudapi.GetZoneByID(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+				libcloudapi.AddRRSetRecords(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), appended, true),
+				libcloudapi.RemoveRRSetRecords(token, libcloudapi.Zone(), appended, []schema.ZoneRRSetRecord{
+					{Value: libserver.AExisting},
+				}),
+			)
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodPatch, server.URL+recordsPath+"/"+existingAID, cloudflareToken,
+				map[string]any{"content": libserver.AUpdated},
+			)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(res.Success).To(BeTrue())
+			var record cloudflareRecord
+			Expect(json.Unmarshal(res.Result, &record)).To(Succeed())
+			Expect(record.TTL).To(Equal(libserver.DefaultTTL))
+			Expect(api.ReceivedRequests()).To(HaveLen(6))
+		})
+
+		It("deleting a record", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZoneByID(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+				libcloudapi.DeleteRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA()),
+			)
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodDelete, server.URL+recordsPath+"/"+existingAID, cloudflareToken, nil)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(res.Success).To(BeTrue())
+			Expect(res.Result).To(MatchJSON(`{"id":"` + existingAID + `"}`))
+			Expect(api.ReceivedRequests()).To(HaveLen(5))
+		})
+	})
+
+	Context("should fail", func() {
+		It("with 404 when the record does not exist", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZoneByID(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), false),
+			)
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodDelete, server.URL+recordsPath+"/"+existingAID, cloudflareToken, nil)
+			Expect(statusCode).To(Equal(http.StatusNotFound))
+			Expect(res.Success).To(BeFalse())
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("with 404 when the zone contains no domains of the client", func(ctx context.Context) {
+			server.Close()
+			server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL,
+				libserver.WithUserToken(cloudflareToken), libserver.WithUserDomains("*.example.com"))
+			api.AppendHandlers(libcloudapi.GetZoneByID(token, libcloudapi.Zone()))
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodGet, server.URL+recordsPath, cloudflareToken, nil)
+			Expect(statusCode).To(Equal(http.StatusNotFound))
+			Expect(res.Success).To(BeFalse())
+			Expect(api.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("with 400 when the content is invalid", func(ctx context.Context) {
+			api.AppendHandlers(libcloudapi.GetZoneByID(token, libcloudapi.Zone()))
+
+			statusCode, res := doCloudflareRequest(ctx, http.MethodPost, server.URL+recordsPath, cloudflareToken, map[string]any{
+				"type":    libserver.RecordTypeA,
+				"name":    libserver.ARecordName,
+				"content": libserver.AAAAUpdated,
+			})
+			Expect(statusCode).To(Equal(http.StatusBadRequest))
+			Expect(res.Success).To(BeFalse())
+			Expect(api.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("with 401 and no api calls when the token is invalid", func(ctx context.Context) {
+			statusCode, res := doCloudflareRequest(ctx, http.MethodGet, server.URL+"/client/v4/zones", invalidValue, nil)
+			Expect(statusCode).To(Equal(http.StatusUnauthorized))
+			Expect(res.Success).To(BeFalse())
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+})
+
+func cloudflareRecordID(typ, name, value string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(typ + "/" + name + "/" + value))
+}
+
+func doCloudflareRequest(
+	ctx context.Context, method, serverURL, token string, data map[string]any,
+) (statusCode int, res *cloudflareResponse) {
+	var body io.Reader = http.NoBody
+	if data != nil {
+		reqBody, err := json.Marshal(data)
+		Expect(err).ToNot(HaveOccurred())
+		body = bytes.NewReader(reqBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, serverURL, body)
+	Expect(err).ToNot(HaveOccurred())
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	c := &http.Client{}
+	resp, err := c.Do(req)
+	Expect(err).ToNot(HaveOccurred())
+	res = &cloudflareResponse{}
+	Expect(json.NewDecoder(resp.Body).Decode(res)).To(Succeed())
+	Expect(resp.Body.Close()).To(Succeed())
+
+	return resp.StatusCode, res
+}
@@ -45,7 +45,8 @@ var _ = Describe("DirectAdmin", func() {
 	Context("should succeed", func() {
 		DescribeTable(
 			"creating a new", func(ctx context.Context, domain, name, recordType, value string) {
-				server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+				server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL,
+					libserver.WithRecordTypes(libserver.RecordTypeA, libserver.RecordTypeAAAA, libserver.RecordTypeTXT, libserver.RecordTypeMX))
 
 				var newRRSet func() schema.ZoneRRSet
 				switch recordType {
@@ -55,6 +56,8 @@ var _ = Describe("DirectAdmin", func() {
 					newRRSet = libcloudapi.NewRRSetAAAA
 				case libserver.RecordTypeTXT:
 					newRRSet = libcloudapi.NewRRSetTXT
+				case libserver.RecordTypeMX:
+					newRRSet = libcloudapi.NewRRSetMX
 				}
 				api.AppendHandlers(
 					libcloudapi.GetZone(token, libcloudapi.Zone()),
@@ -90,6 +93,8 @@ var _ = Describe("DirectAdmin", func() {
 				libserver.TXTRecordNameFull, "", libserver.RecordTypeTXT, libserver.TXTUpdated),
 			Entry("TXT record with fqdn from name and domain",
 				libserver.ZoneName, libserver.TXTRecordName, libserver.RecordTypeTXT, libserver.TXTUpdated),
+			Entry("MX record with target without trailing dot",
+				libserver.ZoneName, libserver.MXRecordName, libserver.RecordTypeMX, "10 mx.test.tld"),
 		)
 
 		DescribeTable(
@@ -233,7 +238,7 @@ var _ = Describe("DirectAdmin", func() {
 				Expect(resData).To(Equal(domainActionMissing))
 			})
 
-			It("when type is not A, AAAA or TXT", func(ctx context.Context) {
+			It("when type is unknown", func(ctx context.Context) {
 				server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
 				statusCode, resData := doDirectAdminRequest(
 					ctx, server.URL+"/directadmin/CMD_API_DNS_CONTROL", username, password,
@@ -246,11 +251,11 @@ var _ = Describe("DirectAdmin", func() {
 					},
 				)
 				Expect(statusCode).To(Equal(http.StatusBadRequest))
-				Expect(resData).To(Equal("type can only be A, AAAA or TXT\n"))
+				Expect(resData).To(Equal("unrecognized resource record set type MADEUP\n"))
 			})
 
 			DescribeTable(
-				"when ip is invalid", func(ctx context.Context, recordType, value, expectedError string) {
+				"when value is invalid", func(ctx context.Context, recordType, value, expectedError string) {
 					server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
 					statusCode, resData := doDirectAdminRequest(
 						ctx, server.URL+"/directadmin/CMD_API_DNS_CONTROL", username, password,
@@ -269,8 +274,44 @@ var _ = Describe("DirectAdmin", func() {
 				Entry("A with IPv6", libserver.RecordTypeA, libserver.AAAAUpdated, "invalid ipv4 address"),
 				Entry("AAAA with invalid IP", libserver.RecordTypeAAAA, "invalid", "invalid ip address"),
 				Entry("AAAA with IPv4", libserver.RecordTypeAAAA, libserver.AUpdated, "invalid ipv6 address"),
+				Entry("MX without priority", libserver.RecordTypeMX, "mx.test.tld", `invalid MX value: bad MX Pref: "mx.test.tld"`),
+				Entry("MX with relative target", libserver.RecordTypeMX, "10 mail", "invalid MX value: single-label target mail must end with a dot"),
 			)
 
+			It("when type is not allowed for the user", func(ctx context.Context) {
+				server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL,
+					libserver.WithUserRecordTypes(libserver.RecordTypeTXT))
+				statusCode, resData := doDirectAdminRequest(
+					ctx, server.URL+"/directadmin/CMD_API_DNS_CONTROL", username, password,
+					url.Values{
+						keyAction: []string{actionAdd},
+						keyDomain: []string{libserver.ZoneName},
+						keyType:   []string{libserver.RecordTypeA},
+						keyName:   []string{libserver.ARecordName},
+						keyValue:  []string{libserver.AUpdated},
+					},
+				)
+				Expect(statusCode).To(Equal(http.StatusUnauthorized))
+				Expect(resData).To(BeEmpty())
+			})
+
+			It("when type is not allowed by default", func(ctx context.Context) {
+				server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+				statusCode, resData := doDirectAdminRequest(
+					ctx, server.URL+"/directadmin/CMD_API_DNS_CONTROL", username, password,
+					url.Values{
+						keyAction: []string{actionAdd},
+						keyDomain: []string{libserver.ZoneName},
+						keyType:   []string{libserver.RecordTypeMX},
+						keyName:   []string{libserver.MXRecordName},
+						keyValue:  []string{libserver.MXUpdated},
+					},
+				)
+				Expect(statusCode).To(Equal(http.StatusUnauthorized))
+				Expect(resData).To(BeEmpty())
+				Expect(api.ReceivedRequests()).To(BeEmpty())
+			})
+
 			It("when domain is malformed and name is empty", func(ctx context.Context) {
 				server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
 				statusCode, resData := doDirectAdminRequest(
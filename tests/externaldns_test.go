@@ -0,0 +1,327 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+const externalDNSMediaType = "application/external.dns.webhook+json;version=1"
+
+type externalDNSEndpoint struct {
+	DNSName    string   `json:"dnsName"`
+	Targets    []string `json:"targets"`
+	RecordType string   `json:"recordType"`
+	RecordTTL  int64    `json:"recordTTL,omitempty"`
+}
+
+type externalDNSChanges struct {
+	Create    []externalDNSEndpoint `json:"create,omitempty"`
+	UpdateOld []externalDNSEndpoint `json:"updateOld,omitempty"`
+	UpdateNew []externalDNSEndpoint `json:"updateNew,omitempty"`
+	Delete    []externalDNSEndpoint `json:"delete,omitempty"`
+}
+
+var _ = Describe("ExternalDNS", func() {
+	var (
+		api      *ghttp.Server
+		server   *httptest.Server
+		token    string
+		username string
+		password string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL,
+			libserver.WithRecordTypes(libserver.RecordTypeA, libserver.RecordTypeAAAA, libserver.RecordTypeTXT, libserver.RecordTypeMX))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	Context("should succeed", func() {
+		It("negotiating the domain filter", func(ctx context.Context) {
+			statusCode, resBody := doExternalDNSRequest(ctx, http.MethodGet, server.URL+"/externaldns", username, password, nil)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(resBody).To(MatchJSON(`{}`))
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("listing records", func(ctx context.Context) {
+			ns := schema.ZoneRRSet{
+				ID:      "@/NS",
+				Name:    "@",
+				Type:    "NS",
+				Records: []schema.ZoneRRSetRecord{{Value: "hydrogen.ns.hetzner.com."}},
+				Zone:    libcloudapi.Zone().ID,
+			}
+			api.AppendHandlers(
+				libcloudapi.ListZones(token, libcloudapi.Zone()),
+				libcloudapi.ListRRSets(token, libcloudapi.Zone(),
+					libcloudapi.ExistingRRSetA(), libcloudapi.ExistingRRSetTXT(), libcloudapi.NewRRSetMX(), ns),
+			)
+
+			statusCode, resBody := doExternalDNSRequest(
+				ctx, http.MethodGet, server.URL+"/externaldns/records", username, password, nil,
+			)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			var endpoints []externalDNSEndpoint
+			Expect(json.Unmarshal(resBody, &endpoints)).To(Succeed())
+			Expect(endpoints).To(ConsistOf(
+				externalDNSEndpoint{
+					DNSName:    libserver.ARecordNameFull,
+					Targets:    []string{libserver.AExisting},
+					RecordType: libserver.RecordTypeA,
+					RecordTTL:  300,
+				},
+				externalDNSEndpoint{
+					DNSName:    libserver.TXTRecordNameFull,
+					Targets:    []string{libserver.TXTExisting},
+					RecordType: libserver.RecordTypeTXT,
+					RecordTTL:  300,
+				},
+				externalDNSEndpoint{
+					DNSName:    libserver.MXRecordNameFull,
+					Targets:    []string{libserver.MXUpdated},
+					RecordType: libserver.RecordTypeMX,
+					RecordTTL:  libserver.DefaultTTL,
+				},
+			))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("listing records of the cached zones", func(ctx context.Context) {
+			server.Close()
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithZoneDiscovery)
+			api.AppendHandlers(
+				libcloudapi.ListZones(token, libcloudapi.Zone()),
+				libcloudapi.ListRRSetsByName(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA()),
+				libcloudapi.ListRRSetsByName(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA()),
+			)
+
+			for range 2 {
+				statusCode, resBody := doExternalDNSRequest(
+					ctx, http.MethodGet, server.URL+"/externaldns/records", username, password, nil,
+				)
+				Expect(statusCode).To(Equal(http.StatusOK))
+				var endpoints []externalDNSEndpoint
+				Expect(json.Unmarshal(resBody, &endpoints)).To(Succeed())
+				Expect(endpoints).To(HaveExactElements(externalDNSEndpoint{
+					DNSName:    libserver.ARecordNameFull,
+					Targets:    []string{libserver.AExisting},
+					RecordType: libserver.RecordTypeA,
+					RecordTTL:  300,
+				}))
+			}
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("creating a record", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+			)
+
+			statusCode, _ := doExternalDNSRequest(ctx, http.MethodPost, server.URL+"/externaldns/records", username, password,
+				externalDNSChanges{
+					Create: []externalDNSEndpoint{{
+						DNSName:    libserver.ARecordNameFull,
+						Targets:    []string{libserver.AUpdated},
+						RecordType: libserver.RecordTypeA,
+					}},
+				},
+			)
+			Expect(statusCode).To(Equal(http.StatusNoContent))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("creating an MX record with a relative target", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetMX(), false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetMX()),
+			)
+
+			statusCode, _ := doExternalDNSRequest(ctx, http.MethodPost, server.URL+"/externaldns/records", username, password,
+				externalDNSChanges{
+					Create: []externalDNSEndpoint{{
+						DNSName:    libserver.MXRecordNameFull,
+						Targets:    []string{"10 mx.test.tld"},
+						RecordType: libserver.RecordTypeMX,
+					}},
+				},
+			)
+			Expect(statusCode).To(Equal(http.StatusNoContent))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("updating a record", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+				libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA()),
+				libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA()),
+			)
+
+			statusCode, _ := doExternalDNSRequest(ctx, http.MethodPost, server.URL+"/externaldns/records", username, password,
+				externalDNSChanges{
+					UpdateOld: []externalDNSEndpoint{{
+						DNSName:    libserver.ARecordNameFull,
+						Targets:    []string{libserver.AExisting},
+						RecordType: libserver.RecordTypeA,
+					}},
+					UpdateNew: []externalDNSEndpoint{{
+						DNSName:    libserver.ARecordNameFull,
+						Targets:    []string{libserver.AUpdated},
+						RecordType: libserver.RecordTypeA,
+					}},
+				},
+			)
+			Expect(statusCode).To(Equal(http.StatusNoContent))
+			Expect(api.ReceivedRequests()).To(HaveLen(4))
+		})
+
+		It("deleting a record", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+				libcloudapi.DeleteRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA()),
+			)
+
+			statusCode, _ := doExternalDNSRequest(ctx, http.MethodPost, server.URL+"/externaldns/records", username, password,
+				externalDNSChanges{
+					Delete: []externalDNSEndpoint{{
+						DNSName:    libserver.ARecordNameFull,
+						Targets:    []string{libserver.AExisting},
+						RecordType: libserver.RecordTypeA,
+					}},
+				},
+			)
+			Expect(statusCode).To(Equal(http.StatusNoContent))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("adjusting endpoints", func(ctx context.Context) {
+			statusCode, resBody := doExternalDNSRequest(
+				ctx, http.MethodPost, server.URL+"/externaldns/adjustendpoints", username, password,
+				[]externalDNSEndpoint{{
+					DNSName:    libserver.ARecordNameFull,
+					Targets:    []string{libserver.AUpdated},
+					RecordType: libserver.RecordTypeA,
+					RecordTTL:  300,
+				}},
+			)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			var endpoints []externalDNSEndpoint
+			Expect(json.Unmarshal(resBody, &endpoints)).To(Succeed())
+			Expect(endpoints).To(HaveExactElements(externalDNSEndpoint{
+				DNSName:    libserver.ARecordNameFull,
+				Targets:    []string{libserver.AUpdated},
+				RecordType: libserver.RecordTypeA,
+				RecordTTL:  libserver.DefaultTTL,
+			}))
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+
+	Context("should fail", func() {
+		It("with 503 listing records once the API budget is reserved", func(ctx context.Context) {
+			const reserve = 100
+			server.Close()
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL,
+				libserver.WithAPIBudgetReserve(reserve))
+			api.AppendHandlers(
+				libcloudapi.WithRateLimit(libcloudapi.ListZones(token, libcloudapi.Zone()), 3600, reserve, time.Now().Add(time.Hour)),
+			)
+
+			statusCode, _ := doExternalDNSRequest(ctx, http.MethodGet, server.URL+"/externaldns/records", username, password, nil)
+			Expect(statusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(api.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Context("should make no api calls and fail", func() {
+		AfterEach(func() {
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("with invalid credentials", func(ctx context.Context) {
+			statusCode, _ := doExternalDNSRequest(ctx, http.MethodGet, server.URL+"/externaldns", username, invalidValue, nil)
+			Expect(statusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("with an invalid target", func(ctx context.Context) {
+			statusCode, _ := doExternalDNSRequest(ctx, http.MethodPost, server.URL+"/externaldns/records", username, password,
+				externalDNSChanges{
+					Create: []externalDNSEndpoint{{
+						DNSName:    libserver.ARecordNameFull,
+						Targets:    []string{libserver.AAAAUpdated},
+						RecordType: libserver.RecordTypeA,
+					}},
+				},
+			)
+			Expect(statusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		It("with an unsupported record type", func(ctx context.Context) {
+			statusCode, _ := doExternalDNSRequest(ctx, http.MethodPost, server.URL+"/externaldns/records", username, password,
+				externalDNSChanges{
+					Create: []externalDNSEndpoint{{
+						DNSName:    libserver.ARecordNameFull,
+						Targets:    []string{"hydrogen.ns.hetzner.com."},
+						RecordType: "NS",
+					}},
+				},
+			)
+			Expect(statusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
+
+func doExternalDNSRequest(
+	ctx context.Context, method, serverURL, username, password string, data any,
+) (statusCode int, resBody []byte) {
+	var body io.Reader = http.NoBody
+	if data != nil {
+		reqBody, err := json.Marshal(data)
+		Expect(err).ToNot(HaveOccurred())
+		body = bytes.NewReader(reqBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, serverURL, body)
+	Expect(err).ToNot(HaveOccurred())
+	req.Header.Add("Accept", externalDNSMediaType)
+	if data != nil {
+		req.Header.Add("Content-Type", externalDNSMediaType)
+	}
+	req.SetBasicAuth(username, password)
+
+	c := &http.Client{}
+	res, err := c.Do(req)
+	Expect(err).ToNot(HaveOccurred())
+	resBody, err = io.ReadAll(res.Body)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(res.Body.Close()).To(Succeed())
+	if res.StatusCode == http.StatusOK {
+		Expect(res.Header.Get("Content-Type")).To(Equal(externalDNSMediaType))
+	}
+
+	return res.StatusCode, resBody
+}
@@ -60,15 +60,35 @@ var _ = Describe("HTTPReq", func() {
 			Entry("without dot suffix", libserver.TXTRecordNameFull),
 		)
 
+		It("creating a new record with a user restricted to acme challenges", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL,
+				libserver.WithUserRecordTypes(libserver.RecordTypeTXT), libserver.WithUserNames("_acme-challenge.*"))
+
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT(), false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT()),
+			)
+
+			Expect(doHTTPReqRequest(
+				ctx, server.URL+"/httpreq/present", username, password,
+				map[string]string{
+					keyFQDN:  libserver.TXTRecordNameFull,
+					keyValue: libserver.TXTUpdated,
+				},
+			)).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
 		DescribeTable(
-			"updating an existing record", func(ctx context.Context, fqdn string) {
+			"appending to an existing record", func(ctx context.Context, fqdn string) {
 				server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
 
 				api.AppendHandlers(
 					libcloudapi.GetZone(token, libcloudapi.Zone()),
 					libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetTXT(), true),
-					libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetTXT()),
-					libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetTXT()),
+					libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), libcloudapi.AppendedRRSetTXT()),
+					libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.AppendedRRSetTXT()),
 				)
 
 				Expect(doHTTPReqRequest(
@@ -84,11 +104,29 @@ var _ = Describe("HTTPReq", func() {
 			Entry("without dot suffix", libserver.TXTRecordNameFull),
 		)
 
+		It("returning without changes when the value is already present", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.AppendedRRSetTXT(), true),
+			)
+
+			Expect(doHTTPReqRequest(
+				ctx, server.URL+"/httpreq/present", username, password,
+				map[string]string{
+					keyFQDN:  libserver.TXTRecordNameFull,
+					keyValue: libserver.TXTUpdated,
+				},
+			)).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
 		DescribeTable(
 			"cleaning up", func(ctx context.Context, fqdn string) {
 				server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
 
-				rrSet := libcloudapi.ExistingRRSetTXT()
+				rrSet := libcloudapi.AppendedRRSetTXT()
 				api.AppendHandlers(
 					libcloudapi.GetZone(token, libcloudapi.Zone()),
 					libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSet, true),
@@ -109,6 +147,44 @@ var _ = Describe("HTTPReq", func() {
 			Entry("with dot suffix", libserver.TXTRecordNameFull+"."),
 			Entry("without dot suffix", libserver.TXTRecordNameFull),
 		)
+
+		It("deleting the record when cleaning up its last value", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			rrSet := libcloudapi.ExistingRRSetTXT()
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSet, true),
+				libcloudapi.DeleteRRSet(token, libcloudapi.Zone(), rrSet),
+			)
+
+			Expect(doHTTPReqRequest(
+				ctx, server.URL+"/httpreq/cleanup", username, password,
+				map[string]string{
+					keyFQDN:  libserver.TXTRecordNameFull,
+					keyValue: libserver.TXTExisting,
+				},
+			)).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("returning without changes when cleaning up a missing value", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetTXT(), true),
+			)
+
+			Expect(doHTTPReqRequest(
+				ctx, server.URL+"/httpreq/cleanup", username, password,
+				map[string]string{
+					keyFQDN:  libserver.TXTRecordNameFull,
+					keyValue: libserver.TXTExisting,
+				},
+			)).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
 	})
 
 	Context("should make no api calls and should fail", func() {
@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("JWT", func() {
+	const (
+		issuer   = "https://gitlab.example.com"
+		audience = "hetzner-dnsapi-proxy"
+	)
+
+	var (
+		api      *ghttp.Server
+		server   *httptest.Server
+		token    string
+		priv     ed25519.PrivateKey
+		jwksFile string
+		claims   map[string]string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+
+		var err error
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		jwksFile = filepath.Join(GinkgoT().TempDir(), "jwks.json")
+		Expect(os.WriteFile(jwksFile, jwks(priv), 0o600)).To(Succeed())
+		claims = map[string]string{"project_path": "infra/*", "ref_protected": "true"}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	expectPresent := func() {
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT()),
+		)
+	}
+
+	present := func(ctx context.Context, modify ...func(map[string]any)) int {
+		c := map[string]any{
+			"iss":           issuer,
+			"aud":           audience,
+			"sub":           "project_path:infra/certs:ref_type:branch:ref:main",
+			"project_path":  "infra/certs",
+			"ref_protected": "true",
+			"exp":           time.Now().Add(5 * time.Minute).Unix(),
+		}
+		for _, m := range modify {
+			m(c)
+		}
+		return doBearerRequest(ctx, server.URL+"/httpreq/present", signJWT(priv, c), map[string]string{
+			keyFQDN:  libserver.TXTRecordNameFull,
+			keyValue: libserver.TXTUpdated,
+		})
+	}
+
+	Context("with a local JWKS file", func() {
+		newServer := func(endpoints ...string) {
+			server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL,
+				libserver.WithJWTIssuer(config.AuthMethodJWT, issuer, audience, jwt.File(jwksFile), claims, endpoints...))
+		}
+
+		It("should authorize tokens with matching claims", func(ctx context.Context) {
+			newServer()
+			expectPresent()
+
+			Expect(present(ctx)).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("should authorize tokens as Basic auth password", func(ctx context.Context) {
+			newServer()
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+			)
+
+			Expect(doPlainRequest(ctx, server.URL+"/plain/update", "gitlab-ci-token", signJWT(priv, map[string]any{
+				"iss":           issuer,
+				"aud":           audience,
+				"project_path":  "infra/dyndns",
+				"ref_protected": "true",
+				"exp":           time.Now().Add(5 * time.Minute).Unix(),
+			}), url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyIP:       []string{libserver.AUpdated},
+			})).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		DescribeTable("should deny tokens", func(ctx context.Context, modify func(map[string]any)) {
+			newServer()
+
+			Expect(present(ctx, modify)).To(Equal(http.StatusUnauthorized))
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		},
+			Entry("of other projects", func(c map[string]any) {
+				c["project_path"] = "other/certs"
+			}),
+			Entry("of unprotected refs", func(c map[string]any) {
+				c["ref_protected"] = "false"
+			}),
+			Entry("for other audiences", func(c map[string]any) {
+				c["aud"] = "https://gitlab.example.com"
+			}),
+			Entry("that expired", func(c map[string]any) {
+				c["exp"] = time.Now().Add(-5 * time.Minute).Unix()
+			}),
+		)
+
+		It("should deny tokens signed with other keys", func(ctx context.Context) {
+			newServer()
+			var err error
+			_, priv, err = ed25519.GenerateKey(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(present(ctx)).To(Equal(http.StatusUnauthorized))
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("should deny tokens on endpoints outside the scope of the rule", func(ctx context.Context) {
+			newServer(config.EndpointPlain)
+
+			Expect(present(ctx)).To(Equal(http.StatusUnauthorized))
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+
+	It("should accept tokens besides users with auth method users", func(ctx context.Context) {
+		server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL,
+			libserver.WithJWTIssuer(config.AuthMethodUsers, issuer, audience, jwt.File(jwksFile), claims))
+		expectPresent()
+
+		Expect(present(ctx)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("should fetch and cache the keys of a JWKS URL", func(ctx context.Context) {
+		keys := ghttp.NewServer()
+		defer keys.Close()
+		keys.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest(http.MethodGet, "/jwks"),
+			ghttp.RespondWith(http.StatusOK, jwks(priv)),
+		))
+		server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL,
+			libserver.WithJWTIssuer(config.AuthMethodJWT, issuer, audience, jwt.URL(keys.URL()+"/jwks", time.Second), claims))
+
+		expectPresent()
+		Expect(present(ctx)).To(Equal(http.StatusOK))
+		expectPresent()
+		Expect(present(ctx)).To(Equal(http.StatusOK))
+		Expect(keys.ReceivedRequests()).To(HaveLen(1))
+	})
+})
+
+// jwks returns a JSON Web Key Set with the public key of priv.
+func jwks(priv ed25519.PrivateKey) []byte {
+	data, err := json.Marshal(map[string]any{"keys": []any{map[string]string{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"use": "sig",
+		"x":   base64.RawURLEncoding.EncodeToString(priv.Public().(ed25519.PublicKey)),
+	}}})
+	Expect(err).ToNot(HaveOccurred())
+	return data
+}
+
+// signJWT returns a token with claims signed by priv.
+func signJWT(priv ed25519.PrivateKey, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": jwt.AlgEdDSA, "typ": "JWT"})
+	Expect(err).ToNot(HaveOccurred())
+	payload, err := json.Marshal(claims)
+	Expect(err).ToNot(HaveOccurred())
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(priv, []byte(signed)))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
@@ -26,6 +27,19 @@ func Zone() schema.Zone {
 	}
 }
 
+func SubZone() schema.Zone {
+	return schema.Zone{
+		ID:   mustParseInt(libserver.SubZoneID),
+		Name: libserver.SubZoneName,
+	}
+}
+
+func NewSubZoneRRSetA() schema.ZoneRRSet {
+	r := NewRRSetA()
+	r.Zone = mustParseInt(libserver.SubZoneID)
+	return r
+}
+
 func ExistingRRSetA() schema.ZoneRRSet {
 	return schema.ZoneRRSet{
 		ID:   libserver.ARecordName + "/" + libserver.RecordTypeA,
@@ -132,6 +146,25 @@ func UpdatedRRSetTXT() schema.ZoneRRSet {
 	return r
 }
 
+// AppendedRRSetTXT is ExistingRRSetTXT with the updated value appended.
+func AppendedRRSetTXT() schema.ZoneRRSet {
+	r := UpdatedRRSetTXT()
+	r.Records = append(ExistingRRSetTXT().Records, r.Records...)
+	return r
+}
+
+func NewRRSetMX() schema.ZoneRRSet {
+	return schema.ZoneRRSet{
+		Name: libserver.MXRecordName,
+		Type: libserver.RecordTypeMX,
+		TTL:  ptr(libserver.DefaultTTL),
+		Records: []schema.ZoneRRSetRecord{
+			{Value: libserver.MXUpdated},
+		},
+		Zone: mustParseInt(libserver.ZoneID),
+	}
+}
+
 func GetZone(token string, zone schema.Zone) http.HandlerFunc {
 	return ghttp.CombineHandlers(
 		ghttp.VerifyRequest(http.MethodGet, "/v1/zones/"+zone.Name),
@@ -144,6 +177,54 @@ func GetZone(token string, zone schema.Zone) http.HandlerFunc {
 	)
 }
 
+func GetZoneByID(token string, zone schema.Zone) http.HandlerFunc {
+	return ghttp.CombineHandlers(
+		ghttp.VerifyRequest(http.MethodGet, fmt.Sprintf("/v1/zones/%d", zone.ID)),
+		ghttp.VerifyHeader(http.Header{
+			headerAuthorization: []string{authBearerPrefix + token},
+		}),
+		ghttp.RespondWithJSONEncoded(http.StatusOK, schema.ZoneGetResponse{
+			Zone: zone,
+		}),
+	)
+}
+
+func ListZones(token string, zones ...schema.Zone) http.HandlerFunc {
+	return ghttp.CombineHandlers(
+		ghttp.VerifyRequest(http.MethodGet, "/v1/zones"),
+		ghttp.VerifyHeader(http.Header{
+			headerAuthorization: []string{authBearerPrefix + token},
+		}),
+		ghttp.RespondWithJSONEncoded(http.StatusOK, schema.ZoneListResponse{
+			Zones: zones,
+		}),
+	)
+}
+
+func ListRRSets(token string, zone schema.Zone, rrSets ...schema.ZoneRRSet) http.HandlerFunc {
+	return ghttp.CombineHandlers(
+		ghttp.VerifyRequest(http.MethodGet, fmt.Sprintf("/v1/zones/%d/rrsets", zone.ID)),
+		ghttp.VerifyHeader(http.Header{
+			headerAuthorization: []string{authBearerPrefix + token},
+		}),
+		ghttp.RespondWithJSONEncoded(http.StatusOK, schema.ZoneRRSetListResponse{
+			RRSets: rrSets,
+		}),
+	)
+}
+
+func ListRRSetsByName(token string, zone schema.Zone, rrSets ...schema.ZoneRRSet) http.HandlerFunc {
+	return ghttp.CombineHandlers(
+		ghttp.VerifyRequest(http.MethodGet, "/v1/zones/"+zone.Name+"/rrsets"),
+		ghttp.VerifyHeader(http.Header{
+			headerAuthorization: []string{authBearerPrefix + token},
+		}),
+		ghttp.RespondWithJSONEncoded(http.StatusOK, schema.ZoneRRSetListResponse{
+			RRSets: rrSets,
+		}),
+	)
+}
+
 func GetRRSet(token string, zone schema.Zone, rrSet schema.ZoneRRSet, found bool) http.HandlerFunc {
 	handlers := []http.HandlerFunc{
 		ghttp.VerifyRequest(http.MethodGet, fmt.Sprintf("/v1/zones/%d/rrsets/%s/%s", zone.ID, rrSet.Name, rrSet.Type)),
@@ -169,6 +250,10 @@ func GetRRSet(token string, zone schema.Zone, rrSet schema.ZoneRRSet, found bool
 }
 
 func CreateRRSet(token string, zone schema.Zone, rrSet schema.ZoneRRSet) http.HandlerFunc {
+	var labels *map[string]string
+	if rrSet.Labels != nil {
+		labels = &rrSet.Labels
+	}
 	return ghttp.CombineHandlers(
 		ghttp.VerifyRequest(http.MethodPost, fmt.Sprintf("/v1/zones/%d/rrsets", zone.ID)),
 		ghttp.VerifyHeader(http.Header{
@@ -178,6 +263,7 @@ func CreateRRSet(token string, zone schema.Zone, rrSet schema.ZoneRRSet) http.Ha
 			Name:    rrSet.Name,
 			Type:    rrSet.Type,
 			TTL:     rrSet.TTL,
+			Labels:  labels,
 			Records: rrSet.Records,
 		}),
 		ghttp.RespondWithJSONEncoded(http.StatusCreated, schema.ZoneRRSetCreateResponse{
@@ -186,6 +272,21 @@ func CreateRRSet(token string, zone schema.Zone, rrSet schema.ZoneRRSet) http.Ha
 	)
 }
 
+func UpdateRRSet(token string, zone schema.Zone, rrSet schema.ZoneRRSet) http.HandlerFunc {
+	return ghttp.CombineHandlers(
+		ghttp.VerifyRequest(http.MethodPut, fmt.Sprintf("/v1/zones/%d/rrsets/%s/%s", zone.ID, rrSet.Name, rrSet.Type)),
+		ghttp.VerifyHeader(http.Header{
+			headerAuthorization: []string{authBearerPrefix + token},
+		}),
+		ghttp.VerifyJSONRepresenting(schema.ZoneRRSetUpdateRequest{
+			Labels: &rrSet.Labels,
+		}),
+		ghttp.RespondWithJSONEncoded(http.StatusOK, schema.ZoneRRSetUpdateResponse{
+			RRSet: rrSet,
+		}),
+	)
+}
+
 func ChangeRRSetTTL(token string, zone schema.Zone, rrSet schema.ZoneRRSet) http.HandlerFunc {
 	return ghttp.CombineHandlers(
 		ghttp.VerifyRequest(http.MethodPost, fmt.Sprintf("/v1/zones/%d/rrsets/%s/%s/actions/change_ttl", zone.ID, rrSet.Name, rrSet.Type)),
@@ -212,6 +313,21 @@ func SetRRSetRecords(token string, zone schema.Zone, rrSet schema.ZoneRRSet) htt
 	)
 }
 
+// AddRRSetRecords expects the zone to be referenced by name.
+func AddRRSetRecords(token string, zone schema.Zone, rrSet schema.ZoneRRSet) http.HandlerFunc {
+	return ghttp.CombineHandlers(
+		ghttp.VerifyRequest(http.MethodPost, fmt.Sprintf("/v1/zones/%s/rrsets/%s/%s/actions/add_records", zone.Name, rrSet.Name, rrSet.Type)),
+		ghttp.VerifyHeader(http.Header{
+			headerAuthorization: []string{authBearerPrefix + token},
+		}),
+		ghttp.VerifyJSONRepresenting(schema.ZoneRRSetAddRecordsRequest{
+			Records: rrSet.Records,
+			TTL:     rrSet.TTL,
+		}),
+		getResponseSuccess(),
+	)
+}
+
 func RemoveRRSetRecords(token string, zone schema.Zone, rrSet schema.ZoneRRSet, records []schema.ZoneRRSetRecord) http.HandlerFunc {
 	return ghttp.CombineHandlers(
 		ghttp.VerifyRequest(http.MethodPost, fmt.Sprintf("/v1/zones/%d/rrsets/%s/%s/actions/remove_records", zone.ID, rrSet.Name, rrSet.Type)),
@@ -225,6 +341,39 @@ func RemoveRRSetRecords(token string, zone schema.Zone, rrSet schema.ZoneRRSet,
 	)
 }
 
+func DeleteRRSet(token string, zone schema.Zone, rrSet schema.ZoneRRSet) http.HandlerFunc {
+	return ghttp.CombineHandlers(
+		ghttp.VerifyRequest(http.MethodDelete, fmt.Sprintf("/v1/zones/%d/rrsets/%s/%s", zone.ID, rrSet.Name, rrSet.Type)),
+		ghttp.VerifyHeader(http.Header{
+			headerAuthorization: []string{authBearerPrefix + token},
+		}),
+		getResponseSuccess(),
+	)
+}
+
+// WithRateLimit adds the rate limit headers of the Hetzner API to the
+// response of handler.
+func WithRateLimit(handler http.HandlerFunc, limit, remaining int, reset time.Time) http.HandlerFunc {
+	return ghttp.CombineHandlers(
+		func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		},
+		handler,
+	)
+}
+
+// Error responds with an hcloud error of code.
+func Error(status int, code string, header ...http.Header) http.HandlerFunc {
+	return ghttp.RespondWithJSONEncoded(status, schema.ErrorResponse{
+		Error: schema.Error{
+			Code:    code,
+			Message: code,
+		},
+	}, header...)
+}
+
 func getResponseSuccess() http.HandlerFunc {
 	return ghttp.RespondWithJSONEncoded(http.StatusOK, schema.ActionGetResponse{
 		Action: schema.Action{
@@ -3,6 +3,7 @@ package libserver
 const (
 	TLD                   = "tld"
 	ZoneName              = "test.tld"
+	SubZoneName           = "dyn.test.tld"
 	ARecordName           = "asub"
 	ARecordNameFull       = "asub.test.tld"
 	AAAARecordName        = "aaaasub"
@@ -10,6 +11,9 @@ const (
 	TXTRecordNameNoPrefix = "txtsub.test.tld"
 	TXTRecordName         = "_acme-challenge.txtsub"
 	TXTRecordNameFull     = "_acme-challenge.txtsub.test.tld"
+	MXRecordName          = "mail"
+	MXRecordNameFull      = "mail.test.tld"
+	SubZoneARecordFull    = "asub.dyn.test.tld"
 	DefaultTTL            = 60
 	AExisting             = "127.0.0.1"
 	AUpdated              = "1.2.3.4"
@@ -17,11 +21,14 @@ const (
 	AAAAUpdated           = "2001:db8::1"
 	TXTExisting           = "randomvalue"
 	TXTUpdated            = "changedrandomvalue"
+	MXUpdated             = "10 mx.test.tld."
 	RecordTypeA           = "A"
 	RecordTypeAAAA        = "AAAA"
 	RecordTypeTXT         = "TXT"
+	RecordTypeMX          = "MX"
 
 	ZoneID       = "1"
+	SubZoneID    = "2"
 	ARecordID    = "1"
 	AAAARecordID = "2"
 	TXTRecordID  = "3"
@@ -2,21 +2,109 @@ package libserver
 
 import (
 	"crypto/rand"
+	"crypto/x509"
 	"math/big"
 	"net"
 	"net/http/httptest"
+	"net/netip"
+	"time"
 
+	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/apitoken"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/app"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/challenge"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rfc2136"
 )
 
-func New(url string, ttl int) (server *httptest.Server, token, username, password string) {
+func New(url string, ttl int, opts ...func(*config.Config)) (server *httptest.Server, token, username, password string) {
+	cfg := newConfig(url, ttl, opts...)
+	return httptest.NewServer(newApp(cfg).Handler), cfg.Token, cfg.Auth.Users[0].Username, cfg.Auth.Users[0].Password
+}
+
+// NewMetrics works like New, but enables metrics and additionally returns a
+// server serving the metrics handler.
+func NewMetrics(url string, opts ...func(*config.Config)) (server, metricsServer *httptest.Server, token, username, password string) {
+	cfg := newConfig(url, DefaultTTL, append(opts, func(cfg *config.Config) {
+		cfg.Metrics = config.Metrics{Enabled: true}
+	})...)
+	a := newApp(cfg)
+	return httptest.NewServer(a.Handler), httptest.NewServer(a.Metrics),
+		cfg.Token, cfg.Auth.Users[0].Username, cfg.Auth.Users[0].Password
+}
+
+// NewReloadable works like New, but returns the app and its config, so
+// changed copies of the config can be reloaded.
+func NewReloadable(url string, opts ...func(*config.Config)) (server *httptest.Server, a *app.App, cfg *config.Config) {
+	cfg = newConfig(url, DefaultTTL, opts...)
+	a = newApp(cfg)
+	return httptest.NewServer(a.Handler), a, cfg
+}
+
+// NewTLS works like New, but serves the handler over HTTPS with the TLS
+// config of the app built from tlsCfg. Clients have to send the server name
+// of the certificate, otherwise the fallback certificate of httptest is
+// served.
+func NewTLS(url string, tlsCfg config.TLS, opts ...func(*config.Config)) (server *httptest.Server, token, username, password string) {
+	cfg := newConfig(url, DefaultTTL, append(opts, func(cfg *config.Config) {
+		cfg.TLS = tlsCfg
+	})...)
+	a := newApp(cfg)
+	server = httptest.NewUnstartedServer(a.Handler)
+	server.TLS = a.TLSConfig
+	server.StartTLS()
+	return server, cfg.Token, cfg.Auth.Users[0].Username, cfg.Auth.Users[0].Password
+}
+
+// NewChallengeExpiry works like New, but enables challenge expiry with
+// stateFile and lifetimeSeconds and additionally returns the reaper.
+func NewChallengeExpiry(
+	url, stateFile string, lifetimeSeconds int,
+) (server *httptest.Server, reaper *challenge.Reaper, token, username, password string) {
+	cfg := newConfig(url, DefaultTTL, func(cfg *config.Config) {
+		cfg.ChallengeExpiry = config.ChallengeExpiry{
+			Enabled:         true,
+			LifetimeSeconds: lifetimeSeconds,
+			IntervalSeconds: 60,
+			StateFile:       stateFile,
+		}
+	})
+	a := newApp(cfg)
+	return httptest.NewServer(a.Handler), a.Reaper, cfg.Token, cfg.Auth.Users[0].Username, cfg.Auth.Users[0].Password
+}
+
+// NewRFC2136 starts an RFC 2136 server on random local UDP and TCP ports.
+// The returned addresses are the UDP and TCP address of the server.
+func NewRFC2136(url string, keys ...config.TSIGKey) (server *rfc2136.Server, udpAddr, tcpAddr, token string) {
+	cfg := newConfig(url, DefaultTTL, func(cfg *config.Config) {
+		cfg.RFC2136 = config.RFC2136{Enabled: true, Keys: keys}
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+
+	server = newApp(cfg).RFC2136
+	started := make(chan struct{})
+	server.NotifyStartedFunc(func() { close(started) })
+	go func() {
+		defer GinkgoRecover()
+		Expect(server.Serve(pc, l)).To(Succeed())
+	}()
+	Eventually(started).Should(BeClosed())
+
+	return server, pc.LocalAddr().String(), l.Addr().String(), cfg.Token
+}
+
+func newConfig(url string, ttl int, opts ...func(*config.Config)) *config.Config {
 	const randLength = 10
-	token = randString(randLength)
-	username = randString(randLength)
-	password = randString(randLength)
+	token := randString(randLength)
+	username := randString(randLength)
+	password := randString(randLength)
 
 	cfg := &config.Config{
 		BaseURL: url + "/v1",
@@ -36,13 +124,16 @@ func New(url string, ttl int) (server *httptest.Server, token, username, passwor
 				Domains:  []string{"*"},
 			}},
 		},
-		Endpoints: config.Endpoints{Plain: true, Nic: true, AcmeDNS: true, HTTPReq: true, DirectAdmin: true},
+		Endpoints: allEndpoints(),
 		RecordTTL: ttl,
 		RateLimit: config.RateLimit{RPS: 1000, Burst: 1000, IdleSeconds: 600},
 		Lockout:   config.Lockout{MaxAttempts: 1000, DurationSeconds: 3600, WindowSeconds: 900},
 	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
 
-	return httptest.NewServer(app.New(cfg)), token, username, password
+	return cfg
 }
 
 func NewNoAllowedDomains(url string) *httptest.Server {
@@ -51,11 +142,172 @@ func NewNoAllowedDomains(url string) *httptest.Server {
 		Auth: config.Auth{
 			Method: config.AuthMethodAllowedDomains,
 		},
-		Endpoints: config.Endpoints{Plain: true, Nic: true, AcmeDNS: true, HTTPReq: true, DirectAdmin: true},
+		Endpoints: allEndpoints(),
 		RateLimit: config.RateLimit{RPS: 1000, Burst: 1000, IdleSeconds: 600},
 		Lockout:   config.Lockout{MaxAttempts: 1000, DurationSeconds: 3600, WindowSeconds: 900},
 	}
-	return httptest.NewServer(app.New(cfg))
+	return httptest.NewServer(newApp(cfg).Handler)
+}
+
+func newApp(cfg *config.Config) *app.App {
+	a, err := app.NewApp(cfg)
+	Expect(err).ToNot(HaveOccurred())
+	return a
+}
+
+func allEndpoints() config.Endpoints {
+	return config.Endpoints{
+		Plain:       true,
+		Nic:         true,
+		AcmeDNS:     true,
+		HTTPReq:     true,
+		DirectAdmin: true,
+		ExternalDNS: true,
+		Cloudflare:  true,
+		PowerDNS:    true,
+	}
+}
+
+func WithZoneDiscovery(cfg *config.Config) {
+	cfg.ZoneDiscovery = config.ZoneDiscovery{Enabled: true, RefreshSeconds: 300}
+}
+
+// WithUserToken sets the token hash of the user to the hash of token.
+func WithUserToken(token string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Auth.Users[0].TokenHash = apitoken.Hash(token)
+	}
+}
+
+// WithClientCert sets the auth method and identifies the user by client
+// certificates with names.
+func WithClientCert(method string, names ...string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Auth.Method = method
+		cfg.Auth.Users[0].CertNames = names
+	}
+}
+
+// WithClientCertHeader accepts client certificates forwarded in header by
+// trustedProxies, verified with clientCAs if not nil.
+func WithClientCertHeader(header string, clientCAs *x509.CertPool, trustedProxies ...netip.Prefix) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Auth.ClientCertHeader = header
+		cfg.ClientCAs = clientCAs
+		cfg.TrustedProxyPrefixes = trustedProxies
+	}
+}
+
+// WithAPIToken adds an API token with the hash of token for all domains,
+// modified by modify.
+func WithAPIToken(token string, modify ...func(*config.APIToken)) func(*config.Config) {
+	return func(cfg *config.Config) {
+		t := config.APIToken{
+			Hash:        apitoken.Hash(token),
+			Description: "test",
+			Domains:     []string{"*"},
+		}
+		for _, m := range modify {
+			m(&t)
+		}
+		cfg.Auth.APITokens = append(cfg.Auth.APITokens, t)
+	}
+}
+
+// WithJWTIssuer sets the auth method and adds an issuer verifying tokens for
+// audience with the keys of source. Its tokens matching claims may update all
+// domains on endpoints.
+func WithJWTIssuer(
+	method, issuer, audience string, source jwt.KeySource, claims map[string]string, endpoints ...string,
+) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Auth.Method = method
+		cfg.Auth.JWTIssuers = append(cfg.Auth.JWTIssuers, config.JWTIssuer{
+			Issuer:   issuer,
+			Audience: audience,
+			Rules: []config.JWTRule{{
+				Claims:    claims,
+				Domains:   []string{"*"},
+				Endpoints: endpoints,
+			}},
+			Verifier: jwt.NewVerifier(issuer, audience, source, time.Hour),
+		})
+	}
+}
+
+// WithRecordTypes allows the user and allowed domains to update records of
+// recordTypes.
+func WithRecordTypes(recordTypes ...string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Auth.Users[0].Restrictions.RecordTypes = recordTypes
+		cfg.Auth.AllowedDomainsRestrictions = map[string]config.Restrictions{
+			"*": {RecordTypes: recordTypes},
+		}
+	}
+}
+
+// WithUserRecordTypes sets the record types of the user.
+func WithUserRecordTypes(recordTypes ...string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Auth.Users[0].Restrictions.RecordTypes = recordTypes
+	}
+}
+
+// WithUserNames sets the name patterns of the user.
+func WithUserNames(names ...string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Auth.Users[0].Restrictions.Names = names
+	}
+}
+
+// WithUserDomains sets the domains of the user.
+func WithUserDomains(domains ...string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Auth.Users[0].Domains = domains
+	}
+}
+
+// WithDynDNSOffline sets the parking addresses of hosts going offline.
+func WithDynDNSOffline(ipv4, ipv6 string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.DynDNS = config.DynDNS{OfflineIPv4: ipv4, OfflineIPv6: ipv6}
+	}
+}
+
+// WithOwnership enables ownership mode, the user may take over rrsets
+// managed by hand if takeover is true.
+func WithOwnership(takeover bool) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Ownership = config.Ownership{Enabled: true}
+		cfg.Auth.Users[0].Takeover = takeover
+	}
+}
+
+// WithAPIBudgetReserve sets the requests reserved for requests of normal
+// priority.
+func WithAPIBudgetReserve(reserve int) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.APIBudget = config.APIBudget{Reserve: reserve}
+	}
+}
+
+// WithProject adds a project with token whose zones are served by the API
+// at url.
+func WithProject(url, token string, zones ...string) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Projects = append(cfg.Projects, config.Project{
+			Name:    "project",
+			Token:   token,
+			BaseURL: url + "/v1",
+			Zones:   zones,
+		})
+	}
+}
+
+// WithoutToken removes the top-level token, so only zones of projects can
+// be updated.
+func WithoutToken(cfg *config.Config) {
+	cfg.Token = ""
 }
 
 func randString(n int) string {
@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		api           *ghttp.Server
+		server        *httptest.Server
+		metricsServer *httptest.Server
+		token         string
+		username      string
+		password      string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		server, metricsServer, token, username, password = libserver.NewMetrics(api.URL())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		metricsServer.Close()
+		api.Close()
+	})
+
+	It("should expose request and Hetzner API metrics", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		Expect(doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		})).To(Equal(http.StatusOK))
+
+		body := getMetrics(ctx, metricsServer.URL)
+		Expect(body).To(ContainSubstring(`hetzner_dnsapi_proxy_requests_total{endpoint="plain",status="200"}`))
+		Expect(body).To(ContainSubstring(`hetzner_dnsapi_proxy_request_duration_seconds_count{endpoint="plain",status="200"}`))
+		Expect(body).To(ContainSubstring(`hetzner_dnsapi_proxy_hetzner_api_requests_total{code="200",operation="Zone.Get"}`))
+		Expect(body).To(ContainSubstring(
+			`hetzner_dnsapi_proxy_hetzner_api_requests_total{code="404",operation="Zone.GetRRSetByNameAndType"}`,
+		))
+		Expect(body).To(ContainSubstring(`hetzner_dnsapi_proxy_hetzner_api_request_duration_seconds_count{operation="Zone.CreateRRSet"}`))
+		Expect(body).To(ContainSubstring("hetzner_dnsapi_proxy_update_lock_wait_seconds_count"))
+	})
+
+	It("should expose the API budget by project", func(ctx context.Context) {
+		const reserve = 100
+		server.Close()
+		metricsServer.Close()
+		server, metricsServer, token, username, password = libserver.NewMetrics(api.URL(), libserver.WithAPIBudgetReserve(reserve))
+		api.AppendHandlers(
+			libcloudapi.WithRateLimit(libcloudapi.GetZone(token, libcloudapi.Zone()), 3600, reserve, time.Now().Add(time.Hour)),
+		)
+
+		Expect(doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		})).To(Equal(http.StatusServiceUnavailable))
+
+		body := getMetrics(ctx, metricsServer.URL)
+		Expect(body).To(ContainSubstring(`hetzner_dnsapi_proxy_hetzner_api_budget_remaining{project="default"} 100`))
+		Expect(body).To(ContainSubstring(`hetzner_dnsapi_proxy_hetzner_api_shed_total{project="default"}`))
+		Expect(api.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	It("should expose auth failures", func(ctx context.Context) {
+		Expect(doPlainRequest(ctx, server.URL+"/plain/update", username, "wrong", url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		})).To(Equal(http.StatusUnauthorized))
+
+		body := getMetrics(ctx, metricsServer.URL)
+		Expect(body).To(ContainSubstring(`hetzner_dnsapi_proxy_requests_total{endpoint="plain",status="401"}`))
+		Expect(body).To(ContainSubstring(`hetzner_dnsapi_proxy_auth_failures_total{method="` + config.AuthMethodBoth + `"}`))
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("should only serve metrics on the metrics handler", func(ctx context.Context) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/metrics", http.NoBody)
+		Expect(err).ToNot(HaveOccurred())
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Body.Close()).To(Succeed())
+		Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+	})
+})
+
+func getMetrics(ctx context.Context, serverURL string) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverURL+"/metrics", http.NoBody)
+	Expect(err).ToNot(HaveOccurred())
+	res, err := http.DefaultClient.Do(req)
+	Expect(err).ToNot(HaveOccurred())
+	defer func() { Expect(res.Body.Close()).To(Succeed()) }()
+	Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+	body, err := io.ReadAll(res.Body)
+	Expect(err).ToNot(HaveOccurred())
+	return string(body)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -115,11 +116,11 @@ var _ = Describe("NicUpdate", func() {
 		It("using client ip when myip is omitted", func(ctx context.Context) {
 			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
 
+			// The client ip equals the existing value, so only the TTL changes
 			api.AppendHandlers(
 				libcloudapi.GetZone(token, libcloudapi.Zone()),
 				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
 				libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), libcloudapi.ClientIPRRSetA()),
-				libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.ClientIPRRSetA()),
 			)
 
 			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
@@ -127,8 +128,216 @@ var _ = Describe("NicUpdate", func() {
 			})
 			Expect(status).To(Equal(http.StatusOK))
 			Expect(body).To(Equal("good " + libserver.AExisting))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("returning nochg when the record is unchanged", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA(), true),
+			)
+
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyMyIP:     []string{libserver.AUpdated},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("nochg " + libserver.AUpdated))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("skipping the TTL change when only the value changes", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			existing := libcloudapi.ExistingRRSetA()
+			existing.TTL = libcloudapi.UpdatedRRSetA().TTL
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), existing, true),
+				libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA()),
+			)
+
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyMyIP:     []string{libserver.AUpdated},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("good " + libserver.AUpdated))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+	})
+
+	Context("should succeed with multiple hostnames and ips", func() {
+		It("updating the A and AAAA record of a hostname", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			rrSetAAAA := libcloudapi.NewRRSetAAAA()
+			rrSetAAAA.Name = libserver.ARecordName
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSetAAAA, false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), rrSetAAAA),
+			)
+
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyMyIP:     []string{libserver.AUpdated},
+				keyMyIPv6:   []string{libserver.AAAAUpdated},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("good " + libserver.AUpdated + "," + libserver.AAAAUpdated))
+			Expect(api.ReceivedRequests()).To(HaveLen(6))
+		})
+
+		It("accepting both ips in myip", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			rrSetAAAA := libcloudapi.UpdatedRRSetAAAA()
+			rrSetAAAA.Name = libserver.ARecordName
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA(), true),
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSetAAAA, true),
+			)
+
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyMyIP:     []string{libserver.AUpdated + "," + libserver.AAAAUpdated},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("nochg " + libserver.AUpdated + "," + libserver.AAAAUpdated))
 			Expect(api.ReceivedRequests()).To(HaveLen(4))
 		})
+
+		It("answering each hostname in request order", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			rrSetA := libcloudapi.NewRRSetA()
+			rrSetA.Name = libserver.AAAARecordName
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA(), true),
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSetA, false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), rrSetA),
+			)
+
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull + "," + libserver.AAAARecordNameFull + "," + libserver.TLD},
+				keyMyIP:     []string{libserver.AUpdated},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("nochg " + libserver.AUpdated + "\ngood " + libserver.AUpdated + "\nnotfqdn"))
+			Expect(api.ReceivedRequests()).To(HaveLen(5))
+		})
+
+		It("answering hostnames the user may not update with nohost", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL,
+				libserver.WithUserDomains(libserver.AAAARecordNameFull))
+
+			rrSetA := libcloudapi.NewRRSetA()
+			rrSetA.Name = libserver.AAAARecordName
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSetA, false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), rrSetA),
+			)
+
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull + "," + libserver.AAAARecordNameFull},
+				keyMyIP:     []string{libserver.AUpdated},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("nohost\ngood " + libserver.AUpdated))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+	})
+
+	Context("should succeed going offline", func() {
+		It("deleting the A record", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+				libcloudapi.DeleteRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA()),
+			)
+
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyMyIP:     []string{libserver.AExisting},
+				keyOffline:  []string{"yes"},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("good"))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("deleting the A and AAAA record when no ip is given", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			rrSetAAAA := libcloudapi.ExistingRRSetAAAA()
+			rrSetAAAA.Name = libserver.ARecordName
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+				libcloudapi.DeleteRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA()),
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSetAAAA, false),
+			)
+
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyOffline:  []string{"YES"},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("good"))
+			Expect(api.ReceivedRequests()).To(HaveLen(5))
+		})
+
+		It("returning nochg when the record does not exist", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), false),
+			)
+
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyMyIP:     []string{libserver.AExisting},
+				keyOffline:  []string{"yes"},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("nochg"))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("switching the A record to the parking address", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL,
+				libserver.WithDynDNSOffline(libserver.AUpdated, ""))
+
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ClientIPRRSetA(), true),
+				libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA()),
+			)
+
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyMyIP:     []string{libserver.AExisting},
+				keyOffline:  []string{"yes"},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("good " + libserver.AUpdated))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
 	})
 
 	Context("should make no api calls and return a DynDNS2 error token", func() {
@@ -155,6 +364,26 @@ var _ = Describe("NicUpdate", func() {
 			Expect(body).To(Equal("notfqdn"))
 		})
 
+		It("notfqdn when myip contains two ipv4 addresses", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyMyIP:     []string{libserver.AUpdated + "," + libserver.AExisting},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("notfqdn"))
+		})
+
+		It("numhost when too many hostnames are given", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+				keyHostname: []string{strings.Repeat(libserver.ARecordNameFull+",", 21)},
+				keyMyIP:     []string{libserver.AUpdated},
+			})
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("numhost"))
+		})
+
 		It("notfqdn when hostname is malformed", func(ctx context.Context) {
 			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
 			status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
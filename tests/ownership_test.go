@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("Ownership", func() {
+	var (
+		api      *ghttp.Server
+		server   *httptest.Server
+		token    string
+		username string
+		password string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	update := func(ctx context.Context) int {
+		return doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		})
+	}
+
+	owned := func(rrSet schema.ZoneRRSet) schema.ZoneRRSet {
+		rrSet.Labels = map[string]string{"managed-by": "hetzner-dnsapi-proxy", "created-by": username}
+		return rrSet
+	}
+
+	It("should label created rrsets with the user", func(ctx context.Context) {
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithOwnership(false))
+
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), owned(libcloudapi.NewRRSetA())),
+		)
+
+		Expect(update(ctx)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("should update managed rrsets", func(ctx context.Context) {
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithOwnership(false))
+
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), managed(libcloudapi.ExistingRRSetA()), true),
+			libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA()),
+			libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA()),
+		)
+
+		Expect(update(ctx)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(4))
+	})
+
+	It("should refuse to update rrsets managed by hand", func(ctx context.Context) {
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithOwnership(false))
+
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+		)
+
+		Expect(update(ctx)).To(Equal(http.StatusForbidden))
+		Expect(api.ReceivedRequests()).To(HaveLen(2))
+	})
+
+	It("should refuse to clean up rrsets managed by hand", func(ctx context.Context) {
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithOwnership(false))
+
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetTXT(), true),
+		)
+
+		Expect(doHTTPReqRequest(
+			ctx, server.URL+"/httpreq/cleanup", username, password,
+			map[string]string{
+				keyFQDN:  libserver.TXTRecordNameFull,
+				keyValue: libserver.TXTExisting,
+			},
+		)).To(Equal(http.StatusForbidden))
+		Expect(api.ReceivedRequests()).To(HaveLen(2))
+	})
+
+	It("should answer nohost to DynDNS2 updates of rrsets managed by hand", func(ctx context.Context) {
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithOwnership(false))
+
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+		)
+
+		status, body := doNicRequest(ctx, server.URL+"/nic/update", username, password, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyMyIP:     []string{libserver.AUpdated},
+		})
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("nohost"))
+		Expect(api.ReceivedRequests()).To(HaveLen(2))
+	})
+
+	It("should take over rrsets managed by hand with takeover permission", func(ctx context.Context) {
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithOwnership(true))
+
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+			libcloudapi.UpdateRRSet(token, libcloudapi.Zone(), owned(libcloudapi.ExistingRRSetA())),
+			libcloudapi.ChangeRRSetTTL(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA()),
+			libcloudapi.SetRRSetRecords(token, libcloudapi.Zone(), libcloudapi.UpdatedRRSetA()),
+		)
+
+		Expect(update(ctx)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(5))
+	})
+})
@@ -150,6 +150,15 @@ var _ = Describe("Plain", func() {
 				keyIP:       []string{libserver.AUpdated},
 			})).To(Equal(http.StatusUnauthorized))
 		})
+
+		It("when the user is restricted to acme challenges", func(ctx context.Context) {
+			server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL,
+				libserver.WithUserNames("_acme-challenge.*"))
+			Expect(doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyIP:       []string{libserver.AUpdated},
+			})).To(Equal(http.StatusUnauthorized))
+		})
 	})
 })
 
@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+const powerDNSAPIKey = "powerdnsapikey"
+
+type powerDNSRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type powerDNSRRSet struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	TTL        int              `json:"ttl,omitempty"`
+	ChangeType string           `json:"changetype,omitempty"`
+	Records    []powerDNSRecord `json:"records"`
+}
+
+var _ = Describe("PowerDNS", func() {
+	var (
+		api    *ghttp.Server
+		server *httptest.Server
+		token  string
+
+		zonePath = "/api/v1/servers/localhost/zones/" + libserver.ZoneName + "."
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithUserToken(powerDNSAPIKey))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	Context("should succeed", func() {
+		It("listing api versions", func(ctx context.Context) {
+			statusCode, resBody := doPowerDNSRequest(ctx, http.MethodGet, server.URL+"/api", powerDNSAPIKey, nil)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(resBody).To(MatchJSON(`[{"url":"/api/v1","version":1}]`))
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("listing zones", func(ctx context.Context) {
+			api.AppendHandlers(libcloudapi.ListZones(token, libcloudapi.Zone()))
+
+			statusCode, resBody := doPowerDNSRequest(
+				ctx, http.MethodGet, server.URL+"/api/v1/servers/localhost/zones", powerDNSAPIKey, nil,
+			)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(resBody).To(MatchJSON(`[{
+				"id": "test.tld.",
+				"name": "test.tld.",
+				"kind": "Native",
+				"url": "/api/v1/servers/localhost/zones/test.tld.",
+				"serial": 0
+			}]`))
+			Expect(api.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("getting a zone", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.ListRRSets(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), libcloudapi.ExistingRRSetTXT()),
+			)
+
+			statusCode, resBody := doPowerDNSRequest(ctx, http.MethodGet, server.URL+zonePath, powerDNSAPIKey, nil)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			var zone struct {
+				RRSets []powerDNSRRSet `json:"rrsets"`
+			}
+			Expect(json.Unmarshal(resBody, &zone)).To(Succeed())
+			Expect(zone.RRSets).To(ConsistOf(
+				powerDNSRRSet{
+					Name:    libserver.ARecordNameFull + ".",
+					Type:    libserver.RecordTypeA,
+					TTL:     300,
+					Records: []powerDNSRecord{{Content: libserver.AExisting}},
+				},
+				powerDNSRRSet{
+					Name:    libserver.TXTRecordNameFull + ".",
+					Type:    libserver.RecordTypeTXT,
+					TTL:     300,
+					Records: []powerDNSRecord{{Content: strconv.Quote(libserver.TXTExisting)}},
+				},
+			))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("replacing an rrset with multiple records and ttl", func(ctx context.Context) {
+			ttl := 120
+			rrSet := libcloudapi.NewRRSetA()
+			rrSet.TTL = &ttl
+			rrSet.Records = []schema.ZoneRRSetRecord{{Value: libserver.AExisting}, {Value: libserver.AUpdated}}
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSet, false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), rrSet),
+			)
+
+			statusCode, _ := doPowerDNSRequest(ctx, http.MethodPatch, server.URL+zonePath, powerDNSAPIKey, []powerDNSRRSet{{
+				Name:       libserver.ARecordNameFull + ".",
+				Type:       libserver.RecordTypeA,
+				TTL:        ttl,
+				ChangeType: "REPLACE",
+				Records: []powerDNSRecord{
+					{Content: libserver.AExisting},
+					{Content: libserver.AUpdated},
+					{Content: "10.0.0.1", Disabled: true},
+				},
+			}})
+			Expect(statusCode).To(Equal(http.StatusNoContent))
+			Expect(api.ReceivedRequests()).To(HaveLen(4))
+		})
+
+		It("replacing a TXT rrset with quoted content", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT(), false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT()),
+			)
+
+			statusCode, _ := doPowerDNSRequest(ctx, http.MethodPatch, server.URL+zonePath, powerDNSAPIKey, []powerDNSRRSet{{
+				Name:       libserver.TXTRecordNameFull + ".",
+				Type:       libserver.RecordTypeTXT,
+				ChangeType: "REPLACE",
+				Records:    []powerDNSRecord{{Content: strconv.Quote(libserver.TXTUpdated)}},
+			}})
+			Expect(statusCode).To(Equal(http.StatusNoContent))
+			Expect(api.ReceivedRequests()).To(HaveLen(4))
+		})
+
+		It("deleting an rrset", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA(), true),
+				libcloudapi.DeleteRRSet(token, libcloudapi.Zone(), libcloudapi.ExistingRRSetA()),
+			)
+
+			statusCode, _ := doPowerDNSRequest(ctx, http.MethodPatch, server.URL+zonePath, powerDNSAPIKey, []powerDNSRRSet{{
+				Name:       libserver.ARecordNameFull + ".",
+				Type:       libserver.RecordTypeA,
+				ChangeType: "DELETE",
+			}})
+			Expect(statusCode).To(Equal(http.StatusNoContent))
+			Expect(api.ReceivedRequests()).To(HaveLen(4))
+		})
+	})
+
+	Context("should fail", func() {
+		DescribeTable("with 422 and only looking up the zone", func(ctx context.Context, rrSet powerDNSRRSet) {
+			api.AppendHandlers(libcloudapi.GetZone(token, libcloudapi.Zone()))
+
+			statusCode, resBody := doPowerDNSRequest(
+				ctx, http.MethodPatch, server.URL+zonePath, powerDNSAPIKey, []powerDNSRRSet{rrSet},
+			)
+			Expect(statusCode).To(Equal(http.StatusUnprocessableEntity))
+			Expect(resBody).To(ContainSubstring(`"error"`))
+			Expect(api.ReceivedRequests()).To(HaveLen(1))
+		},
+			Entry("when the name is out of zone", powerDNSRRSet{
+				Name:       "sub.example.com.",
+				Type:       libserver.RecordTypeA,
+				ChangeType: "REPLACE",
+				Records:    []powerDNSRecord{{Content: libserver.AUpdated}},
+			}),
+			Entry("when the type is unsupported", powerDNSRRSet{
+				Name:       libserver.ARecordNameFull + ".",
+				Type:       "NS",
+				ChangeType: "REPLACE",
+				Records:    []powerDNSRecord{{Content: "hydrogen.ns.hetzner.com."}},
+			}),
+			Entry("when the content is invalid", powerDNSRRSet{
+				Name:       libserver.ARecordNameFull + ".",
+				Type:       libserver.RecordTypeA,
+				ChangeType: "REPLACE",
+				Records:    []powerDNSRecord{{Content: libserver.AAAAUpdated}},
+			}),
+			Entry("when the changetype is unknown", powerDNSRRSet{
+				Name:       libserver.ARecordNameFull + ".",
+				Type:       libserver.RecordTypeA,
+				ChangeType: "EXTEND",
+				Records:    []powerDNSRecord{{Content: libserver.AUpdated}},
+			}),
+		)
+
+		It("with 401 and no api calls when the api key is invalid", func(ctx context.Context) {
+			statusCode, _ := doPowerDNSRequest(ctx, http.MethodGet, server.URL+zonePath, invalidValue, nil)
+			Expect(statusCode).To(Equal(http.StatusUnauthorized))
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+})
+
+func doPowerDNSRequest(
+	ctx context.Context, method, serverURL, apiKey string, rrSets []powerDNSRRSet,
+) (statusCode int, resBody []byte) {
+	var body io.Reader = http.NoBody
+	if rrSets != nil {
+		reqBody, err := json.Marshal(map[string]any{"rrsets": rrSets})
+		Expect(err).ToNot(HaveOccurred())
+		body = bytes.NewReader(reqBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, serverURL, body)
+	Expect(err).ToNot(HaveOccurred())
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-API-Key", apiKey)
+
+	c := &http.Client{}
+	res, err := c.Do(req)
+	Expect(err).ToNot(HaveOccurred())
+	resBody, err = io.ReadAll(res.Body)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(res.Body.Close()).To(Succeed())
+
+	return res.StatusCode, resBody
+}
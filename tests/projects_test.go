@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("Projects", func() {
+	const projectToken = "projecttoken"
+
+	var (
+		api        *ghttp.Server
+		projectAPI *ghttp.Server
+		server     *httptest.Server
+		token      string
+		username   string
+		password   string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		projectAPI = ghttp.NewServer()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		projectAPI.Close()
+		api.Close()
+	})
+
+	update := func(ctx context.Context, hostname string) int {
+		return doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+			keyHostname: []string{hostname},
+			keyIP:       []string{libserver.AUpdated},
+		})
+	}
+
+	It("should update zones of a project with its token", func(ctx context.Context) {
+		server, _, username, password = libserver.New(api.URL(), libserver.DefaultTTL,
+			libserver.WithProject(projectAPI.URL(), projectToken, libserver.ZoneName))
+
+		projectAPI.AppendHandlers(
+			libcloudapi.GetZone(projectToken, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(projectToken, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(projectToken, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		Expect(update(ctx, libserver.ARecordNameFull)).To(Equal(http.StatusOK))
+		Expect(projectAPI.ReceivedRequests()).To(HaveLen(3))
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("should update zones of no project with the top-level token", func(ctx context.Context) {
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL,
+			libserver.WithProject(projectAPI.URL(), projectToken, "other.tld"))
+
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		Expect(update(ctx, libserver.ARecordNameFull)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+		Expect(projectAPI.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("should match zone patterns", func(ctx context.Context) {
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithZoneDiscovery,
+			libserver.WithProject(projectAPI.URL(), projectToken, "*."+libserver.ZoneName))
+
+		projectAPI.AppendHandlers(
+			libcloudapi.ListZones(projectToken, libcloudapi.Zone(), libcloudapi.SubZone()),
+			libcloudapi.GetZone(projectToken, libcloudapi.SubZone()),
+			libcloudapi.GetRRSet(projectToken, libcloudapi.SubZone(), libcloudapi.NewSubZoneRRSetA(), false),
+			libcloudapi.CreateRRSet(projectToken, libcloudapi.SubZone(), libcloudapi.NewSubZoneRRSetA()),
+		)
+		api.AppendHandlers(
+			libcloudapi.ListZones(token, libcloudapi.Zone()),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		Expect(update(ctx, libserver.SubZoneARecordFull)).To(Equal(http.StatusOK))
+		Expect(update(ctx, libserver.ARecordNameFull)).To(Equal(http.StatusOK))
+		Expect(projectAPI.ReceivedRequests()).To(HaveLen(4))
+		Expect(api.ReceivedRequests()).To(HaveLen(4))
+	})
+
+	It("should fail for zones of no project without a top-level token", func(ctx context.Context) {
+		server, _, username, password = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithoutToken,
+			libserver.WithProject(projectAPI.URL(), projectToken, "other.tld"))
+
+		Expect(update(ctx, libserver.ARecordNameFull)).To(Equal(http.StatusInternalServerError))
+		Expect(projectAPI.ReceivedRequests()).To(BeEmpty())
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("should list the zones of all projects", func(ctx context.Context) {
+		server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithUserToken(powerDNSAPIKey),
+			libserver.WithProject(projectAPI.URL(), projectToken, libserver.SubZoneName))
+
+		projectAPI.AppendHandlers(libcloudapi.ListZones(projectToken, libcloudapi.SubZone()))
+		api.AppendHandlers(libcloudapi.ListZones(token, libcloudapi.Zone(), libcloudapi.SubZone()))
+
+		statusCode, resBody := doPowerDNSRequest(
+			ctx, http.MethodGet, server.URL+"/api/v1/servers/localhost/zones", powerDNSAPIKey, nil,
+		)
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(resBody).To(MatchJSON(`[{
+			"id": "dyn.test.tld.",
+			"name": "dyn.test.tld.",
+			"kind": "Native",
+			"url": "/api/v1/servers/localhost/zones/dyn.test.tld.",
+			"serial": 0
+		}, {
+			"id": "test.tld.",
+			"name": "test.tld.",
+			"kind": "Native",
+			"url": "/api/v1/servers/localhost/zones/test.tld.",
+			"serial": 0
+		}]`))
+		Expect(projectAPI.ReceivedRequests()).To(HaveLen(1))
+		Expect(api.ReceivedRequests()).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/app"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("Reload", func() {
+	var (
+		api    *ghttp.Server
+		server *httptest.Server
+		a      *app.App
+		cfg    *config.Config
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		server, a, cfg = libserver.NewReloadable(api.URL(), func(cfg *config.Config) {
+			cfg.Lockout.MaxAttempts = 2
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	update := func(ctx context.Context, username, password string) int {
+		return doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		})
+	}
+
+	expectUpdate := func(ctx context.Context, username, password string) {
+		api.AppendHandlers(
+			libcloudapi.GetZone(cfg.Token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(cfg.Token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(cfg.Token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+		Expect(update(ctx, username, password)).To(Equal(http.StatusOK))
+	}
+
+	It("should apply reloaded users", func(ctx context.Context) {
+		prev := cfg.Auth.Users[0]
+		next := *cfg
+		next.Auth.Users = []config.User{{Username: "reloaded", Password: "reloadedpassword", Domains: []string{"*"}}}
+		a.Reload(&next)
+
+		Expect(update(ctx, prev.Username, prev.Password)).To(Equal(http.StatusUnauthorized))
+		expectUpdate(ctx, "reloaded", "reloadedpassword")
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("should apply reloaded endpoints", func(ctx context.Context) {
+		next := *cfg
+		next.Endpoints.Plain = false
+		a.Reload(&next)
+
+		Expect(update(ctx, cfg.Auth.Users[0].Username, cfg.Auth.Users[0].Password)).To(Equal(http.StatusNotFound))
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("should keep the lockout state", func(ctx context.Context) {
+		user := cfg.Auth.Users[0]
+		for range 2 {
+			Expect(update(ctx, user.Username, "wrongpassword")).To(Equal(http.StatusUnauthorized))
+		}
+		Expect(update(ctx, user.Username, user.Password)).To(Equal(http.StatusTooManyRequests))
+
+		next := *cfg
+		a.Reload(&next)
+		Expect(update(ctx, user.Username, user.Password)).To(Equal(http.StatusTooManyRequests))
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("should apply the reloaded token to the challenge reaper", func(ctx context.Context) {
+		server.Close()
+		server, a, cfg = libserver.NewReloadable(api.URL(), func(cfg *config.Config) {
+			cfg.ChallengeExpiry = config.ChallengeExpiry{
+				Enabled:         true,
+				IntervalSeconds: 60,
+				StateFile:       filepath.Join(GinkgoT().TempDir(), "challenges.json"),
+			}
+		})
+
+		const reloadedToken = "reloadedtoken"
+		api.AppendHandlers(
+			libcloudapi.GetZone(cfg.Token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(cfg.Token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT(), false),
+			libcloudapi.CreateRRSet(cfg.Token, libcloudapi.Zone(), managed(libcloudapi.NewRRSetTXT())),
+			libcloudapi.GetZone(reloadedToken, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(reloadedToken, libcloudapi.Zone(), managed(libcloudapi.UpdatedRRSetTXT()), true),
+			libcloudapi.DeleteRRSet(reloadedToken, libcloudapi.Zone(), libcloudapi.UpdatedRRSetTXT()),
+		)
+
+		user := cfg.Auth.Users[0]
+		Expect(doHTTPReqRequest(
+			ctx, server.URL+"/httpreq/present", user.Username, user.Password,
+			map[string]string{
+				keyFQDN:  libserver.TXTRecordNameFull,
+				keyValue: libserver.TXTUpdated,
+			},
+		)).To(Equal(http.StatusOK))
+
+		next := *cfg
+		next.Token = reloadedToken
+		a.Reload(&next)
+		a.Reaper.Reap(ctx)
+		Expect(api.ReceivedRequests()).To(HaveLen(6))
+	})
+
+	It("should keep settings that require a restart", func() {
+		next := *cfg
+		next.ListenAddr = ":1234"
+		next.ChallengeExpiry = config.ChallengeExpiry{Enabled: true, StateFile: "/nonexistent"}
+		a.Reload(&next)
+
+		Expect(next.ListenAddr).To(Equal(cfg.ListenAddr))
+		Expect(next.ChallengeExpiry).To(Equal(cfg.ChallengeExpiry))
+	})
+})
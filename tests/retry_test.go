@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("Retry", func() {
+	var (
+		api      *ghttp.Server
+		server   *httptest.Server
+		token    string
+		username string
+		password string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	update := func(ctx context.Context) int {
+		return doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		})
+	}
+
+	DescribeTable("should retry transient errors", func(ctx context.Context, status int, code string) {
+		api.AppendHandlers(
+			libcloudapi.Error(status, code),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		Expect(update(ctx)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(4))
+	},
+		Entry("rate_limit_exceeded", http.StatusTooManyRequests, "rate_limit_exceeded"),
+		Entry("conflict", http.StatusConflict, "conflict"),
+		Entry("locked", http.StatusLocked, "locked"),
+		Entry("server errors", http.StatusServiceUnavailable, "unavailable"),
+	)
+
+	It("should honor Retry-After", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.Error(http.StatusTooManyRequests, "rate_limit_exceeded", http.Header{"Retry-After": []string{"1"}}),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		start := time.Now()
+		Expect(update(ctx)).To(Equal(http.StatusOK))
+		Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+		Expect(api.ReceivedRequests()).To(HaveLen(4))
+	})
+
+	It("should wait until RateLimit-Reset of a used up budget", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.Error(http.StatusTooManyRequests, "rate_limit_exceeded", http.Header{
+				"RateLimit-Remaining": []string{"0"},
+				"RateLimit-Reset":     []string{strconv.FormatInt(time.Now().Add(2*time.Second).Unix(), 10)},
+			}),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		start := time.Now()
+		Expect(update(ctx)).To(Equal(http.StatusOK))
+		Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+		Expect(api.ReceivedRequests()).To(HaveLen(4))
+	})
+
+	It("should ignore the rate limit headers of server errors", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.Error(http.StatusServiceUnavailable, "unavailable", http.Header{
+				"Retry-After":     []string{"3600"},
+				"RateLimit-Reset": []string{strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)},
+			}),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		Expect(update(ctx)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(4))
+	})
+
+	It("should retry requests with a body", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.Error(http.StatusConflict, "conflict"),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		Expect(update(ctx)).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(4))
+	})
+
+	It("should not retry POST requests on server errors", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.Error(http.StatusServiceUnavailable, "unavailable"),
+		)
+
+		Expect(update(ctx)).To(Equal(http.StatusInternalServerError))
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	DescribeTable("should not retry permanent errors", func(ctx context.Context, status int, code string) {
+		api.AppendHandlers(libcloudapi.Error(status, code))
+
+		Expect(update(ctx)).To(Equal(http.StatusInternalServerError))
+		Expect(api.ReceivedRequests()).To(HaveLen(1))
+	},
+		Entry("invalid_input", http.StatusBadRequest, "invalid_input"),
+		Entry("forbidden", http.StatusForbidden, "forbidden"),
+		Entry("uniqueness_error", http.StatusConflict, "uniqueness_error"),
+	)
+
+	It("should give up if the API asks to wait longer than the timeout", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.Error(http.StatusTooManyRequests, "rate_limit_exceeded", http.Header{"Retry-After": []string{"3600"}}),
+		)
+
+		Expect(update(ctx)).To(Equal(http.StatusInternalServerError))
+		Expect(api.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	It("should give up if the budget is reset later than the timeout", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.Error(http.StatusTooManyRequests, "rate_limit_exceeded", http.Header{
+				"RateLimit-Remaining": []string{"0"},
+				"RateLimit-Reset":     []string{strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)},
+			}),
+		)
+
+		Expect(update(ctx)).To(Equal(http.StatusInternalServerError))
+		Expect(api.ReceivedRequests()).To(HaveLen(1))
+	})
+})
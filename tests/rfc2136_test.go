@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rfc2136"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("RFC2136", func() {
+	const (
+		keyName   = "ddns-key."
+		keySecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+		badSecret = "d3JvbmdzZWNyZXR3cm9uZ3NlY3JldA=="
+	)
+
+	var (
+		api     *ghttp.Server
+		server  *rfc2136.Server
+		udpAddr string
+		tcpAddr string
+		token   string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		server, udpAddr, tcpAddr, token = libserver.NewRFC2136(api.URL(), config.TSIGKey{
+			Name:      keyName,
+			Algorithm: config.TSIGAlgorithmHMACSHA256,
+			Secret:    keySecret,
+			Domains:   []string{libserver.ZoneName, "*." + libserver.ZoneName},
+			Restrictions: config.Restrictions{
+				RecordTypes: []string{libserver.RecordTypeA, libserver.RecordTypeAAAA, libserver.RecordTypeTXT, libserver.RecordTypeMX},
+				Names:       []string{"*." + libserver.ZoneName},
+			},
+		})
+	})
+
+	AfterEach(func(ctx context.Context) {
+		Expect(server.Shutdown(ctx)).To(Succeed())
+		api.Close()
+	})
+
+	Context("should succeed", func() {
+		DescribeTable("adding a record", func(ctx context.Context, network string) {
+			api.AppendHandlers(
+				libcloudapi.AddRRSetRecords(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+			)
+
+			addr := udpAddr
+			if network == "tcp" {
+				addr = tcpAddr
+			}
+			m := newUpdate()
+			m.Insert([]dns.RR{newRR(libserver.ARecordNameFull + " 60 IN A " + libserver.AUpdated)})
+			Expect(doRFC2136Request(ctx, network, addr, m, keyName, keySecret)).To(Equal(dns.RcodeSuccess))
+			Expect(api.ReceivedRequests()).To(HaveLen(1))
+		},
+			Entry("via udp", "udp"),
+			Entry("via tcp", "tcp"),
+		)
+
+		It("adding multiple records to an rrset", func(ctx context.Context) {
+			existing := libcloudapi.NewRRSetTXT()
+			existing.Records = libcloudapi.ExistingRRSetTXT().Records
+			api.AppendHandlers(
+				libcloudapi.AddRRSetRecords(token, libcloudapi.Zone(), libcloudapi.NewRRSetTXT()),
+				libcloudapi.AddRRSetRecords(token, libcloudapi.Zone(), existing),
+			)
+
+			m := newUpdate()
+			m.Insert([]dns.RR{
+				newRR(libserver.TXTRecordNameFull + ` 60 IN TXT "` + libserver.TXTUpdated + `"`),
+				newRR(libserver.TXTRecordNameFull + ` 60 IN TXT "` + libserver.TXTExisting + `"`),
+			})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeSuccess))
+			Expect(api.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("adding an MX record", func(ctx context.Context) {
+			api.AppendHandlers(
+				libcloudapi.AddRRSetRecords(token, libcloudapi.Zone(), libcloudapi.NewRRSetMX()),
+			)
+
+			m := newUpdate()
+			m.Insert([]dns.RR{newRR(libserver.MXRecordNameFull + " 60 IN MX " + libserver.MXUpdated)})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeSuccess))
+			Expect(api.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("removing a record", func(ctx context.Context) {
+			rrSet := libcloudapi.AppendedRRSetTXT()
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSet, true),
+				libcloudapi.RemoveRRSetRecords(token, libcloudapi.Zone(), rrSet, []schema.ZoneRRSetRecord{
+					{Value: strconv.Quote(libserver.TXTExisting)},
+				}),
+			)
+
+			m := newUpdate()
+			m.Remove([]dns.RR{newRR(libserver.TXTRecordNameFull + ` 0 IN TXT "` + libserver.TXTExisting + `"`)})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeSuccess))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("deleting an rrset", func(ctx context.Context) {
+			rrSet := libcloudapi.ExistingRRSetA()
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), rrSet, true),
+				libcloudapi.DeleteRRSet(token, libcloudapi.Zone(), rrSet),
+			)
+
+			m := newUpdate()
+			m.RemoveRRset([]dns.RR{newRR(libserver.ARecordNameFull + " 0 IN A 0.0.0.0")})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeSuccess))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+	})
+
+	Context("should make no api calls and fail", func() {
+		AfterEach(func() {
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("with REFUSED when the update is unsigned", func(ctx context.Context) {
+			m := newUpdate()
+			m.Insert([]dns.RR{newRR(libserver.ARecordNameFull + " 60 IN A " + libserver.AUpdated)})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, "", "")).To(Equal(dns.RcodeRefused))
+		})
+
+		It("with NOTAUTH when the signature is invalid", func(ctx context.Context) {
+			m := newUpdate()
+			m.Insert([]dns.RR{newRR(libserver.ARecordNameFull + " 60 IN A " + libserver.AUpdated)})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, badSecret)).To(Equal(dns.RcodeNotAuth))
+		})
+
+		It("with REFUSED when the key is not allowed to update the name", func(ctx context.Context) {
+			m := &dns.Msg{}
+			m.SetUpdate("example.com.")
+			m.Insert([]dns.RR{newRR("sub.example.com. 60 IN A " + libserver.AUpdated)})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeRefused))
+		})
+
+		It("with REFUSED when the key is not allowed to update the record type", func(ctx context.Context) {
+			m := newUpdate()
+			m.Insert([]dns.RR{newRR(libserver.ARecordNameFull + " 60 IN CNAME target." + libserver.ZoneName + ".")})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeRefused))
+		})
+
+		It("with REFUSED when the name does not match the restrictions of the key", func(ctx context.Context) {
+			m := newUpdate()
+			m.Insert([]dns.RR{newRR(libserver.ZoneName + ". 60 IN A " + libserver.AUpdated)})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeRefused))
+		})
+
+		It("with NOTZONE when the name is outside of the zone", func(ctx context.Context) {
+			m := newUpdate()
+			m.Insert([]dns.RR{newRR("sub.example.com. 60 IN A " + libserver.AUpdated)})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeNotZone))
+		})
+
+		It("with REFUSED when the record type is unsupported", func(ctx context.Context) {
+			m := newUpdate()
+			m.Insert([]dns.RR{newRR(libserver.ARecordNameFull + " 60 IN NS ns." + libserver.ZoneName + ".")})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeRefused))
+		})
+
+		It("with NOTIMP when prerequisites are given", func(ctx context.Context) {
+			m := newUpdate()
+			m.NameUsed([]dns.RR{newRR(libserver.ARecordNameFull + " 0 IN A 0.0.0.0")})
+			m.Insert([]dns.RR{newRR(libserver.ARecordNameFull + " 60 IN A " + libserver.AUpdated)})
+			Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeNotImplemented))
+		})
+	})
+
+	It("should fail with SERVFAIL when the api returns an error", func(ctx context.Context) {
+		api.AppendHandlers(libcloudapi.Error(http.StatusBadRequest, "invalid_input"))
+
+		m := newUpdate()
+		m.Insert([]dns.RR{newRR(libserver.ARecordNameFull + " 60 IN A " + libserver.AUpdated)})
+		Expect(doRFC2136Request(ctx, "udp", udpAddr, m, keyName, keySecret)).To(Equal(dns.RcodeServerFailure))
+	})
+})
+
+func newUpdate() *dns.Msg {
+	m := &dns.Msg{}
+	m.SetUpdate(libserver.ZoneName + ".")
+	return m
+}
+
+func newRR(s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	Expect(err).ToNot(HaveOccurred())
+	return rr
+}
+
+func doRFC2136Request(ctx context.Context, network, addr string, m *dns.Msg, keyName, secret string) int {
+	c := &dns.Client{Net: network}
+	if keyName != "" {
+		c.TsigSecret = map[string]string{keyName: secret}
+		m.SetTsig(keyName, dns.HmacSHA256, 300, time.Now().Unix()) //nolint:mnd
+	}
+
+	conn, err := c.DialContext(ctx, addr)
+	Expect(err).ToNot(HaveOccurred())
+	defer func(conn net.Conn) {
+		Expect(conn.Close()).To(Succeed())
+	}(conn)
+
+	res, _, err := c.ExchangeWithConnContext(ctx, m, conn)
+	Expect(err).ToNot(HaveOccurred())
+	return res.Rcode
+}
@@ -18,6 +18,8 @@ const (
 	keyFQDN      = "fqdn"
 	keyHostname  = "hostname"
 	keyMyIP      = "myip"
+	keyMyIPv6    = "myipv6"
+	keyOffline   = "offline"
 	keyIP        = "ip"
 	invalidValue = "invalid"
 )
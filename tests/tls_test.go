@@ -0,0 +1,373 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+const serverName = "proxy." + libserver.ZoneName
+
+var _ = Describe("TLS", func() {
+	var (
+		api      *ghttp.Server
+		server   *httptest.Server
+		token    string
+		username string
+		password string
+
+		dir        string
+		serverCert *x509.Certificate
+		tlsCfg     config.TLS
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		dir = GinkgoT().TempDir()
+
+		var key *ecdsa.PrivateKey
+		serverCert, key = newCertificate(serverName, nil, nil)
+		tlsCfg = config.TLS{
+			Enabled:    true,
+			CertFile:   filepath.Join(dir, "cert.pem"),
+			KeyFile:    filepath.Join(dir, "key.pem"),
+			MinVersion: config.TLSVersion12,
+		}
+		writeCertificate(tlsCfg.CertFile, tlsCfg.KeyFile, serverCert, key)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	update := func(ctx context.Context, clientCerts ...tls.Certificate) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/plain/update", http.NoBody)
+		Expect(err).ToNot(HaveOccurred())
+		req.URL.RawQuery = url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		}.Encode()
+		req.SetBasicAuth(username, password)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(serverCert)
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			ServerName:   serverName,
+			RootCAs:      roots,
+			Certificates: clientCerts,
+			MinVersion:   tls.VersionTLS12,
+		}}}
+		res, err := c.Do(req)
+		if err == nil {
+			Expect(res.Body.Close()).To(Succeed())
+		}
+		return res, err
+	}
+
+	expectUpdate := func() {
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+	}
+
+	It("should serve requests with the configured certificate", func(ctx context.Context) {
+		server, token, username, password = libserver.NewTLS(api.URL(), tlsCfg)
+		expectUpdate()
+
+		res, err := update(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(res.TLS.PeerCertificates[0].Equal(serverCert)).To(BeTrue())
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("should reject clients below the minimum version", func(ctx context.Context) {
+		tlsCfg.MinVersion = config.TLSVersion13
+		server, _, username, password = libserver.NewTLS(api.URL(), tlsCfg)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/plain/update", http.NoBody)
+		Expect(err).ToNot(HaveOccurred())
+		roots := x509.NewCertPool()
+		roots.AddCert(serverCert)
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			ServerName: serverName,
+			RootCAs:    roots,
+			MinVersion: tls.VersionTLS12,
+			MaxVersion: tls.VersionTLS12,
+		}}}
+		_, err = c.Do(req) //nolint:bodyclose // no response on handshake errors
+		Expect(err).To(MatchError(ContainSubstring("protocol version")))
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+
+	Context("with a client CA", func() {
+		var (
+			ca    *x509.Certificate
+			caKey *ecdsa.PrivateKey
+		)
+
+		BeforeEach(func() {
+			ca, caKey = newCertificate("", nil, nil)
+			tlsCfg.ClientCA = filepath.Join(dir, "ca.pem")
+			writeCertificate(tlsCfg.ClientCA, filepath.Join(dir, "ca.key"), ca, caKey)
+		})
+
+		It("should accept clients with a certificate signed by the client CA", func(ctx context.Context) {
+			server, token, username, password = libserver.NewTLS(api.URL(), tlsCfg)
+			expectUpdate()
+
+			cert, key := newCertificate("client", ca, caKey)
+			res, err := update(ctx, tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("should reject clients without certificate", func(ctx context.Context) {
+			server, _, username, password = libserver.NewTLS(api.URL(), tlsCfg)
+
+			_, err := update(ctx) //nolint:bodyclose // no response on handshake errors
+			Expect(err).To(HaveOccurred())
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("should reject clients with a certificate of another CA", func(ctx context.Context) {
+			server, _, username, password = libserver.NewTLS(api.URL(), tlsCfg)
+
+			cert, key := newCertificate("client", nil, nil)
+			_, err := update(ctx, tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}) //nolint:bodyclose
+			Expect(err).To(HaveOccurred())
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+
+		Context("and auth method clientCert", func() {
+			BeforeEach(func() {
+				tlsCfg.ClientCertOptional = true
+			})
+
+			It("should authorize the user identified by the certificate", func(ctx context.Context) {
+				server, token, _, _ = libserver.NewTLS(api.URL(), tlsCfg, libserver.WithClientCert(config.AuthMethodClientCert, "router"))
+				expectUpdate()
+
+				cert, key := newCertificate("router", ca, caKey)
+				res, err := update(ctx, tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(api.ReceivedRequests()).To(HaveLen(3))
+			})
+
+			It("should deny certificates not identifying a user", func(ctx context.Context) {
+				server, _, _, _ = libserver.NewTLS(api.URL(), tlsCfg, libserver.WithClientCert(config.AuthMethodClientCert, "router"))
+
+				cert, key := newCertificate("other", ca, caKey)
+				res, err := update(ctx, tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(api.ReceivedRequests()).To(BeEmpty())
+			})
+
+			It("should deny clients without certificate", func(ctx context.Context) {
+				server, _, username, password = libserver.NewTLS(api.URL(), tlsCfg, libserver.WithClientCert(config.AuthMethodClientCert, "router"))
+
+				res, err := update(ctx)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(api.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		It("should authorize clients without certificate by password with auth method any", func(ctx context.Context) {
+			tlsCfg.ClientCertOptional = true
+			server, token, username, password = libserver.NewTLS(api.URL(), tlsCfg, libserver.WithClientCert(config.AuthMethodAny, "router"))
+			expectUpdate()
+
+			res, err := update(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+	})
+})
+
+var _ = Describe("Forwarded client certificates", func() {
+	const headerClientCert = "X-Client-Cert"
+
+	var (
+		api    *ghttp.Server
+		server *httptest.Server
+		token  string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	update := func(ctx context.Context, cert *x509.Certificate) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/plain/update", http.NoBody)
+		Expect(err).ToNot(HaveOccurred())
+		req.URL.RawQuery = url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		}.Encode()
+		req.Header.Set(headerClientCert, url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))))
+
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Body.Close()).To(Succeed())
+		return res
+	}
+
+	It("should authorize the user identified by a certificate of a trusted proxy", func(ctx context.Context) {
+		ca, caKey := newCertificate("", nil, nil)
+		server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL,
+			libserver.WithClientCert(config.AuthMethodClientCert, "router"),
+			libserver.WithClientCertHeader(headerClientCert, certPool(ca), netip.MustParsePrefix("127.0.0.1/32")),
+		)
+		api.AppendHandlers(
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		cert, _ := newCertificate("router", ca, caKey)
+		res := update(ctx, cert)
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("should ignore forwarded certificates not signed by the client CA", func(ctx context.Context) {
+		ca, _ := newCertificate("", nil, nil)
+		server, _, _, _ = libserver.New(api.URL(), libserver.DefaultTTL,
+			libserver.WithClientCert(config.AuthMethodClientCert, "router"),
+			libserver.WithClientCertHeader(headerClientCert, certPool(ca), netip.MustParsePrefix("127.0.0.1/32")),
+		)
+
+		cert, _ := newCertificate("router", nil, nil)
+		res := update(ctx, cert)
+		Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+
+	Context("without client CA", func() {
+		It("should not match the names of forwarded certificates", func(ctx context.Context) {
+			server, _, _, _ = libserver.New(api.URL(), libserver.DefaultTTL,
+				libserver.WithClientCert(config.AuthMethodClientCert, "router"),
+				libserver.WithClientCertHeader(headerClientCert, nil, netip.MustParsePrefix("127.0.0.1/32")),
+			)
+
+			cert, _ := newCertificate("router", nil, nil)
+			res := update(ctx, cert)
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(api.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("should authorize the user identified by the fingerprint of a forwarded certificate", func(ctx context.Context) {
+			cert, _ := newCertificate("router", nil, nil)
+			sum := sha256.Sum256(cert.Raw)
+			server, token, _, _ = libserver.New(api.URL(), libserver.DefaultTTL,
+				libserver.WithClientCert(config.AuthMethodClientCert),
+				libserver.WithClientCertHeader(headerClientCert, nil, netip.MustParsePrefix("127.0.0.1/32")),
+				func(cfg *config.Config) {
+					cfg.Auth.Users[0].CertFingerprints = []string{hex.EncodeToString(sum[:])}
+				},
+			)
+			api.AppendHandlers(
+				libcloudapi.GetZone(token, libcloudapi.Zone()),
+				libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+				libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+			)
+
+			res := update(ctx, cert)
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(api.ReceivedRequests()).To(HaveLen(3))
+		})
+	})
+
+	It("should ignore certificates forwarded by untrusted clients", func(ctx context.Context) {
+		server, _, _, _ = libserver.New(api.URL(), libserver.DefaultTTL,
+			libserver.WithClientCert(config.AuthMethodClientCert, "router"),
+			libserver.WithClientCertHeader(headerClientCert, nil, netip.MustParsePrefix("10.0.0.1/32")),
+		)
+
+		cert, _ := newCertificate("router", nil, nil)
+		res := update(ctx, cert)
+		Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		Expect(api.ReceivedRequests()).To(BeEmpty())
+	})
+})
+
+// certPool returns a pool of certs.
+func certPool(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+// newCertificate returns a certificate for name signed by parent and
+// parentKey. Without parent a self-signed CA certificate is returned.
+func newCertificate(name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if name != "" {
+		template.DNSNames = []string{name}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	return cert, key
+}
+
+// writeCertificate writes cert and key PEM encoded to certFile and keyFile.
+func writeCertificate(certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)).To(Succeed())
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libcloudapi"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/tests/libserver"
+)
+
+var _ = Describe("ZoneDiscovery", func() {
+	var (
+		api      *ghttp.Server
+		server   *httptest.Server
+		token    string
+		username string
+		password string
+	)
+
+	BeforeEach(func() {
+		api = ghttp.NewServer()
+		server, token, username, password = libserver.New(api.URL(), libserver.DefaultTTL, libserver.WithZoneDiscovery)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		api.Close()
+	})
+
+	It("should use the longest matching zone", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.ListZones(token, libcloudapi.Zone(), libcloudapi.SubZone()),
+			libcloudapi.GetZone(token, libcloudapi.SubZone()),
+			libcloudapi.GetRRSet(token, libcloudapi.SubZone(), libcloudapi.NewSubZoneRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.SubZone(), libcloudapi.NewSubZoneRRSetA()),
+		)
+
+		Expect(doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+			keyHostname: []string{libserver.SubZoneARecordFull},
+			keyIP:       []string{libserver.AUpdated},
+		})).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(4))
+	})
+
+	It("should fall back to publicsuffix when no zone matches", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.ListZones(token, libcloudapi.SubZone()),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		Expect(doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+			keyHostname: []string{libserver.ARecordNameFull},
+			keyIP:       []string{libserver.AUpdated},
+		})).To(Equal(http.StatusOK))
+		Expect(api.ReceivedRequests()).To(HaveLen(4))
+	})
+
+	It("should cache the zone list", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.ListZones(token, libcloudapi.Zone(), libcloudapi.SubZone()),
+			libcloudapi.GetZone(token, libcloudapi.SubZone()),
+			libcloudapi.GetRRSet(token, libcloudapi.SubZone(), libcloudapi.NewSubZoneRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.SubZone(), libcloudapi.NewSubZoneRRSetA()),
+			libcloudapi.GetZone(token, libcloudapi.SubZone()),
+			libcloudapi.GetRRSet(token, libcloudapi.SubZone(), libcloudapi.NewSubZoneRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.SubZone(), libcloudapi.NewSubZoneRRSetA()),
+		)
+
+		for range 2 {
+			Expect(doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+				keyHostname: []string{libserver.SubZoneARecordFull},
+				keyIP:       []string{libserver.AUpdated},
+			})).To(Equal(http.StatusOK))
+		}
+		Expect(api.ReceivedRequests()).To(HaveLen(7))
+	})
+
+	It("should not list the zones again right after a failed listing", func(ctx context.Context) {
+		api.AppendHandlers(
+			libcloudapi.Error(http.StatusForbidden, "forbidden"),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+			libcloudapi.GetZone(token, libcloudapi.Zone()),
+			libcloudapi.GetRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA(), false),
+			libcloudapi.CreateRRSet(token, libcloudapi.Zone(), libcloudapi.NewRRSetA()),
+		)
+
+		for range 2 {
+			Expect(doPlainRequest(ctx, server.URL+"/plain/update", username, password, url.Values{
+				keyHostname: []string{libserver.ARecordNameFull},
+				keyIP:       []string{libserver.AUpdated},
+			})).To(Equal(http.StatusOK))
+		}
+		Expect(api.ReceivedRequests()).To(HaveLen(7))
+	})
+})